@@ -21,9 +21,13 @@ package apier
 import (
 	"errors"
 	"fmt"
+	"github.com/cgrates/cgrates/cdrs"
+	"github.com/cgrates/cgrates/config"
 	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/mediator"
 	"github.com/cgrates/cgrates/scheduler"
 	"github.com/cgrates/cgrates/utils"
+	"sync"
 )
 
 const (
@@ -34,6 +38,12 @@ type ApierV1 struct {
 	StorDb engine.DataStorage
 	DataDb engine.DataStorage
 	Sched  *scheduler.Scheduler
+	Config *config.CGRConfig
+
+	Mediator       *mediator.Mediator
+	Cdrs           *cdrs.CDRS
+	rerateMux      sync.RWMutex // Only one rerate at a time
+	rerateProgress RerateProgress
 }
 
 type AttrDestination struct {
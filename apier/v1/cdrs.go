@@ -0,0 +1,306 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/cdrexporter"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const RERATE_LOG_INTERVAL = 1000 // Log the progress after this number of CDRs
+
+type AttrGetCdrs struct {
+	TimeStart         string // Answer time, inclusive, RFC3339 or unix timestamp
+	TimeEnd           string // Answer time, exclusive
+	Tenant            string
+	Account           string
+	DestinationPrefix string
+	MinCost           *float64 // Inclusive
+	MaxCost           *float64 // Exclusive
+	RunId             string   // Subject of the mediation run, defaults to the CDR subject
+	OrderBy           string   // <""|answer_time|duration|cost|account|destination>
+	OrderDesc         bool
+	Limit             int // 0 for no limit
+	Offset            int
+}
+
+// Lists the stored CDRs matching the filters together with their mediated cost
+func (self *ApierV1) GetCdrs(attrs AttrGetCdrs, reply *[]*utils.RatedCdr) error {
+	filter := &utils.CdrsFilter{Tenant: attrs.Tenant, Account: attrs.Account, DestinationPrefix: attrs.DestinationPrefix,
+		MinCost: attrs.MinCost, MaxCost: attrs.MaxCost, RunId: attrs.RunId, OrderBy: attrs.OrderBy, OrderDesc: attrs.OrderDesc,
+		Limit: attrs.Limit, Offset: attrs.Offset}
+	var err error
+	if filter.AnswerTimeStart, err = utils.ParseDate(attrs.TimeStart); err != nil {
+		return fmt.Errorf("%s:TimeStart", utils.ERR_INVALID_IE)
+	}
+	if filter.AnswerTimeEnd, err = utils.ParseDate(attrs.TimeEnd); err != nil {
+		return fmt.Errorf("%s:TimeEnd", utils.ERR_INVALID_IE)
+	}
+	cdrs, err := self.StorDb.GetRatedCdrs(filter)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), utils.ERR_INVALID_IE) {
+			return err
+		}
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if cdrs == nil {
+		cdrs = []*utils.RatedCdr{}
+	}
+	*reply = cdrs
+	return nil
+}
+
+type AttrRerateCdrs struct {
+	TimeStart   string // Answer time, inclusive, RFC3339 or unix timestamp
+	TimeEnd     string // Answer time, exclusive
+	Tenant      string
+	Account     string
+	ReqType     string
+	RatedStatus string // <""|*rated|*failed>
+	ApplyDebits bool   // Debit the balances of pseudoprepaid accounts with the difference to the old costs
+	DryRun      bool   // Only calculate the new costs, nothing is debited or stored
+}
+
+type CdrCostDiff struct {
+	CgrId   string
+	AccId   string
+	OldCost float64 // -1 when not rated before
+	NewCost float64 // -1 when rating failed
+	Error   string
+}
+
+type RerateCdrsReply struct {
+	Total     int
+	Rerated   int
+	Unchanged int
+	Failed    int
+	Diffs     []*CdrCostDiff // Populated on DryRun with the CDRs whose cost would change
+}
+
+type RerateProgress struct {
+	Running   bool
+	Total     int
+	Processed int
+	Failed    int
+	StartTime time.Time
+}
+
+// Recalculates with the rater the costs of the stored CDRs matching the filters and updates rated_cdrs
+func (self *ApierV1) RerateCdrs(attrs AttrRerateCdrs, reply *RerateCdrsReply) error {
+	if self.Mediator == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	filter := &utils.CdrsFilter{Tenant: attrs.Tenant, Account: attrs.Account, ReqType: attrs.ReqType, RatedStatus: attrs.RatedStatus}
+	var err error
+	if filter.AnswerTimeStart, err = utils.ParseDate(attrs.TimeStart); err != nil {
+		return fmt.Errorf("%s:TimeStart", utils.ERR_INVALID_IE)
+	}
+	if filter.AnswerTimeEnd, err = utils.ParseDate(attrs.TimeEnd); err != nil {
+		return fmt.Errorf("%s:TimeEnd", utils.ERR_INVALID_IE)
+	}
+	if !self.startRerate() {
+		return errors.New("RERATE_IN_PROGRESS")
+	}
+	defer self.stopRerate()
+	cdrs, err := self.StorDb.GetRatedCdrs(filter)
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	self.updateRerateProgress(len(cdrs), 0, 0)
+	engine.Logger.Info(fmt.Sprintf("<ApierV1> Rerating %d CDRs, dry run: %v", len(cdrs), attrs.DryRun))
+	rpl := RerateCdrsReply{Total: len(cdrs)}
+	for idx, cdr := range cdrs {
+		newCost := -1.0
+		errMsg := ""
+		if cc, err := self.Mediator.RerateCdr(cdr, cdr.Cost, attrs.ApplyDebits, attrs.DryRun); err != nil {
+			rpl.Failed += 1
+			errMsg = err.Error()
+		} else {
+			newCost = utils.Round(cc.Cost+cc.ConnectFee, 4, utils.ROUNDING_MIDDLE) // Precision of rated_cdrs.cost
+			if newCost == cdr.Cost {
+				rpl.Unchanged += 1
+			} else {
+				rpl.Rerated += 1
+			}
+		}
+		if attrs.DryRun && (newCost != cdr.Cost || errMsg != "") {
+			rpl.Diffs = append(rpl.Diffs, &CdrCostDiff{CgrId: cdr.CgrId, AccId: cdr.AccId, OldCost: cdr.Cost, NewCost: newCost, Error: errMsg})
+		}
+		self.updateRerateProgress(len(cdrs), idx+1, rpl.Failed)
+		if (idx+1)%RERATE_LOG_INTERVAL == 0 {
+			engine.Logger.Info(fmt.Sprintf("<ApierV1> Rerated %d out of %d CDRs, failed: %d", idx+1, len(cdrs), rpl.Failed))
+		}
+	}
+	engine.Logger.Info(fmt.Sprintf("<ApierV1> Rerating done, rerated: %d, unchanged: %d, failed: %d", rpl.Rerated, rpl.Unchanged, rpl.Failed))
+	*reply = rpl
+	return nil
+}
+
+// Progress of the running rerate or the totals of the last one
+func (self *ApierV1) GetRerateCdrsProgress(ignored string, reply *RerateProgress) error {
+	self.rerateMux.RLock()
+	defer self.rerateMux.RUnlock()
+	*reply = self.rerateProgress
+	return nil
+}
+
+func (self *ApierV1) startRerate() bool {
+	self.rerateMux.Lock()
+	defer self.rerateMux.Unlock()
+	if self.rerateProgress.Running {
+		return false
+	}
+	self.rerateProgress = RerateProgress{Running: true, StartTime: time.Now()}
+	return true
+}
+
+func (self *ApierV1) stopRerate() {
+	self.rerateMux.Lock()
+	defer self.rerateMux.Unlock()
+	self.rerateProgress.Running = false
+}
+
+func (self *ApierV1) updateRerateProgress(total, processed, failed int) {
+	self.rerateMux.Lock()
+	defer self.rerateMux.Unlock()
+	self.rerateProgress.Total = total
+	self.rerateProgress.Processed = processed
+	self.rerateProgress.Failed = failed
+}
+
+type AttrExportCdrs struct {
+	Template          string // Name of the [cdre_<name>] export template
+	TimeStart         string // Answer time, inclusive, RFC3339 or unix timestamp
+	TimeEnd           string // Answer time, exclusive
+	Tenant            string
+	Account           string
+	DestinationPrefix string
+	RunId             string // Subject of the mediation run, defaults to the CDR subject
+	SkipExported      bool   // Leave out the CDRs already exported
+}
+
+type ExportedCdrs struct {
+	ExportedFilePath string // Empty when no CDR was exported
+	NumberOfCdrs     int
+	SkippedCdrs      map[string]string // Left out of the export, cgrid to the reason
+}
+
+// Writes the rated CDRs matching the filters into a new file using the export template and marks them exported
+func (self *ApierV1) ExportCdrs(attrs AttrExportCdrs, reply *ExportedCdrs) error {
+	if missing := utils.MissingStructFields(&attrs, []string{"Template"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	var tplCfg *config.CdreTemplate
+	for _, cfgTpl := range self.Config.CdreTemplates {
+		if cfgTpl.Name == attrs.Template {
+			tplCfg = cfgTpl
+			break
+		}
+	}
+	if tplCfg == nil {
+		return fmt.Errorf("%s:Template", utils.ERR_NOT_FOUND)
+	}
+	filter := &utils.CdrsFilter{Tenant: attrs.Tenant, Account: attrs.Account, DestinationPrefix: attrs.DestinationPrefix, RunId: attrs.RunId,
+		RatedStatus: utils.CDR_RATED, NotExported: attrs.SkipExported, OrderBy: utils.CDR_ORDER_ANSWER_TIME}
+	var err error
+	if filter.AnswerTimeStart, err = utils.ParseDate(attrs.TimeStart); err != nil {
+		return fmt.Errorf("%s:TimeStart", utils.ERR_INVALID_IE)
+	}
+	if filter.AnswerTimeEnd, err = utils.ParseDate(attrs.TimeEnd); err != nil {
+		return fmt.Errorf("%s:TimeEnd", utils.ERR_INVALID_IE)
+	}
+	cdrs, err := self.StorDb.GetRatedCdrs(filter)
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if len(cdrs) == 0 {
+		*reply = ExportedCdrs{}
+		return nil
+	}
+	exportTime := time.Now()
+	fPath := path.Join(tplCfg.ExportDir, fmt.Sprintf("cdre_%s_%d.%s", tplCfg.Name, exportTime.UnixNano(), tplCfg.CdrFormat))
+	exported, skipped, err := writeCdrsFile(fPath, tplCfg, cdrs)
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	for cgrId, reason := range skipped {
+		engine.Logger.Warning(fmt.Sprintf("<ApierV1> CDR with cgrid %s left out of export %s: %s", cgrId, fPath, reason))
+	}
+	if len(exported) == 0 {
+		*reply = ExportedCdrs{SkippedCdrs: skipped}
+		return nil
+	}
+	if err := self.StorDb.SetCdrsExported(exported, exportTime); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<ApierV1> Exported CDRs to %s but could not mark them exported: %s", fPath, err.Error()))
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = ExportedCdrs{ExportedFilePath: fPath, NumberOfCdrs: len(exported), SkippedCdrs: skipped}
+	return nil
+}
+
+// Writes the CDRs, leaving out the ones the format cannot hold, returned with their reason. On error, or when
+// no CDR could be written, the file is removed so no partial export is left behind.
+func writeCdrsFile(fPath string, tplCfg *config.CdreTemplate, cdrs []*utils.RatedCdr) (exported []*utils.RatedCdr, skipped map[string]string, err error) {
+	fOut, err := os.Create(fPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if errClose := fOut.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil || len(exported) == 0 {
+			os.Remove(fPath)
+		}
+	}()
+	cdrWriter, err := cdrexporter.NewCdrWriter(fOut, tplCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	skipped = make(map[string]string)
+	for _, cdr := range cdrs {
+		if err = cdrWriter.Write(cdr); err != nil {
+			if errSkipped, isSkipped := err.(*cdrexporter.CdrSkippedError); isSkipped {
+				skipped[cdr.CgrId] = errSkipped.Reason
+				err = nil
+				continue
+			}
+			return nil, nil, err
+		}
+		exported = append(exported, cdr)
+	}
+	return exported, skipped, cdrWriter.Close()
+}
+
+// Number of duplicate CDRs the CDR server skipped since start
+func (self *ApierV1) GetCdrsDuplicates(ignored string, reply *int64) error {
+	if self.Cdrs == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	*reply = self.Cdrs.Duplicates()
+	return nil
+}
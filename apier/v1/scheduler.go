@@ -0,0 +1,101 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/scheduler"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Lists the action timings queued in the scheduler, ordered by their next start time
+func (self *ApierV1) GetScheduledActionTimings(ignored string, reply *[]*scheduler.ScheduledActionTiming) error {
+	if self.Sched == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	*reply = self.Sched.GetQueueSnapshot()
+	return nil
+}
+
+type AttrScheduledActionTiming struct {
+	ActionTimingId string
+}
+
+// Executes a queued action timing right away without waiting for its start time
+func (self *ApierV1) ExecuteScheduledActionTiming(attrs AttrScheduledActionTiming, reply *string) error {
+	if missing := utils.MissingStructFields(&attrs, []string{"ActionTimingId"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	if self.Sched == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	if err := self.Sched.ExecuteActionTiming(attrs.ActionTimingId); err != nil {
+		return err
+	}
+	*reply = OK
+	return nil
+}
+
+type AttrSetScheduledActionTimingPaused struct {
+	ActionTimingId string
+	Paused         bool
+}
+
+// Pauses or resumes one action timing without reloading the scheduler
+func (self *ApierV1) SetScheduledActionTimingPaused(attrs AttrSetScheduledActionTimingPaused, reply *string) error {
+	if missing := utils.MissingStructFields(&attrs, []string{"ActionTimingId"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	if self.Sched == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	if err := self.Sched.SetActionTimingPaused(self.DataDb, attrs.ActionTimingId, attrs.Paused); err != nil {
+		return err
+	}
+	*reply = OK
+	return nil
+}
+
+type AttrRemoveScheduledActionTimingAccount struct {
+	ActionTimingId string
+	Tenant         string
+	Account        string
+	Direction      string
+}
+
+// Removes one account from an action timing without reloading the scheduler
+func (self *ApierV1) RemoveScheduledActionTimingAccount(attrs AttrRemoveScheduledActionTimingAccount, reply *string) error {
+	if missing := utils.MissingStructFields(&attrs, []string{"ActionTimingId", "Tenant", "Account"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	if self.Sched == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	if attrs.Direction == "" {
+		attrs.Direction = engine.OUTBOUND
+	}
+	tag := fmt.Sprintf("%s:%s:%s", attrs.Direction, attrs.Tenant, attrs.Account)
+	if err := self.Sched.RemoveActionTimingAccount(self.DataDb, attrs.ActionTimingId, tag); err != nil {
+		return err
+	}
+	*reply = OK
+	return nil
+}
@@ -44,7 +44,7 @@ func (self *ApierV1) SetTPRate(attrs utils.TPRate, reply *string) error {
 		itrvlStrs := []string{rtSlot.RatedUnits, rtSlot.RateIncrements, rtSlot.GroupIntervalStart}
 		itrvls := make([]time.Duration, len(itrvlStrs))
 		for idxItrvl, itrvlStr := range itrvlStrs {
-			if itrvls[idxItrvl], errParse = time.ParseDuration(itrvlStr); errParse != nil {
+			if itrvls[idxItrvl], errParse = utils.ParseRateDuration(itrvlStr); errParse != nil {
 				return fmt.Errorf("%s:Parsing interval failed:%s", utils.ERR_SERVER_ERROR, errParse.Error())
 			}
 		}
@@ -33,6 +33,7 @@ type ApierTPTiming struct {
 	MonthDays string // semicolon separated list of month's days this timing is valid on, *none and *all supported
 	WeekDays  string // semicolon separated list of week day names this timing is valid on *none and *all supported
 	Time      string // String representing the time this timing starts on
+	CronExpr  string // Optional cron expression, when set it schedules the action timings instead of the fields above
 }
 
 // Creates a new timing within a tariff plan
@@ -45,7 +46,12 @@ func (self *ApierV1) SetTPTiming(attrs ApierTPTiming, reply *string) error {
 	} else if exists {
 		return errors.New(utils.ERR_DUPLICATE)
 	}
-	tm := engine.NewTiming(attrs.TimingId, attrs.Years, attrs.Months, attrs.MonthDays, attrs.WeekDays, attrs.Time)
+	if attrs.CronExpr != "" {
+		if _, err := utils.ParseCronExpr(attrs.CronExpr); err != nil {
+			return fmt.Errorf("%s:CronExpr:%s", utils.ERR_INVALID_IE, err.Error())
+		}
+	}
+	tm := engine.NewTiming(attrs.TimingId, attrs.Years, attrs.Months, attrs.MonthDays, attrs.WeekDays, attrs.Time, attrs.CronExpr)
 	if err := self.StorDb.SetTPTiming(attrs.TPid, tm); err != nil {
 		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
 	}
@@ -69,7 +75,7 @@ func (self *ApierV1) GetTPTiming(attrs AttrGetTPTiming, reply *ApierTPTiming) er
 		return errors.New(utils.ERR_NOT_FOUND)
 	} else {
 		*reply = ApierTPTiming{attrs.TPid, tm.Id, tm.Years.Serialize(";"),
-			tm.Months.Serialize(";"), tm.MonthDays.Serialize(";"), tm.WeekDays.Serialize(";"), tm.StartTime}
+			tm.Months.Serialize(";"), tm.MonthDays.Serialize(";"), tm.WeekDays.Serialize(";"), tm.StartTime, tm.CronExpr}
 	}
 	return nil
 }
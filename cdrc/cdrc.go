@@ -0,0 +1,364 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"github.com/howeyc/fsnotify"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CDRS_STORED_PATH = "/stored_json"
+	ACCID            = "accid"
+	REQTYPE          = "reqtype"
+	DIRECTION        = "direction"
+	TENANT           = "tenant"
+	TOR              = "tor"
+	ACCOUNT          = "account"
+	SUBJECT          = "subject"
+	DESTINATION      = "destination"
+	ANSWER_TIME      = "answer_time"
+	DURATION         = "duration"
+)
+
+// Where the parsed CDRs go, satisfied by the internal CDRS and by the http client towards a remote one
+type CdrProcessor interface {
+	ProcessCdr(utils.CDR) error
+}
+
+// Posts the CDRs to a remote CDRS
+type HttpCdrsClient struct {
+	url string
+}
+
+func NewHttpCdrsClient(address string) *HttpCdrsClient {
+	return &HttpCdrsClient{url: fmt.Sprintf("http://%s%s", address, CDRS_STORED_PATH)}
+}
+
+func (self *HttpCdrsClient) ProcessCdr(cdr utils.CDR) error {
+	storedCdr, err := utils.NewStoredCdr(cdr)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(storedCdr)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(self.url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reply, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("CDRS replied with status %d: %s", resp.StatusCode, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// Extracts one field out of a record: csv column index, fixed width start:length or ^constant
+type fieldTemplate struct {
+	constant      string
+	isConstant    bool
+	index         int
+	start, length int
+	fixedWidth    bool
+}
+
+func parseFieldTemplate(tpl, cdrFormat string) (*fieldTemplate, error) {
+	if strings.HasPrefix(tpl, "^") {
+		return &fieldTemplate{constant: tpl[1:], isConstant: true}, nil
+	}
+	if cdrFormat == utils.CDRC_FWV {
+		startLen := strings.Split(tpl, ":")
+		if len(startLen) != 2 {
+			return nil, fmt.Errorf("Fixed width template must be start:length, got: %s", tpl)
+		}
+		start, errStart := strconv.Atoi(startLen[0])
+		length, errLen := strconv.Atoi(startLen[1])
+		if errStart != nil || errLen != nil || start < 0 || length <= 0 {
+			return nil, fmt.Errorf("Invalid fixed width template: %s", tpl)
+		}
+		return &fieldTemplate{start: start, length: length, fixedWidth: true}, nil
+	}
+	idx, err := strconv.Atoi(tpl)
+	if err != nil || idx < 0 {
+		return nil, fmt.Errorf("Csv template must be a column index, got: %s", tpl)
+	}
+	return &fieldTemplate{index: idx}, nil
+}
+
+// Fixed width records come as one element holding the whole line
+func (self *fieldTemplate) value(record []string) (string, error) {
+	switch {
+	case self == nil:
+		return "", nil
+	case self.isConstant:
+		return self.constant, nil
+	case self.fixedWidth:
+		if self.start+self.length > len(record[0]) {
+			return "", fmt.Errorf("Line too short for field at %d:%d", self.start, self.length)
+		}
+		return strings.TrimSpace(record[0][self.start : self.start+self.length]), nil
+	}
+	if self.index >= len(record) {
+		return "", fmt.Errorf("Record has no column %d", self.index)
+	}
+	return strings.TrimSpace(record[self.index]), nil
+}
+
+// Watches one folder for CDR files, posts their records to CDRS and moves them to the archive or error folder
+type Cdrc struct {
+	src         *config.CdrcSource
+	cgrCfg      *config.CGRConfig
+	cdrs        CdrProcessor
+	fields      map[string]*fieldTemplate
+	extraFields map[string]*fieldTemplate
+}
+
+func NewCdrc(src *config.CdrcSource, cgrCfg *config.CGRConfig, cdrs CdrProcessor) (*Cdrc, error) {
+	if src.CdrFormat != utils.CDRC_CSV && src.CdrFormat != utils.CDRC_FWV {
+		return nil, fmt.Errorf("Unsupported CDR format for source %s: %s", src.Name, src.CdrFormat)
+	}
+	if src.CdrFormat == utils.CDRC_CSV && len([]rune(src.FieldSeparator)) != 1 {
+		return nil, fmt.Errorf("Field separator for source %s must be one character", src.Name)
+	}
+	for _, dir := range []string{src.CdrInDir, src.CdrArchiveDir, src.CdrErrorDir} {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("The path for source %s does not exist: %s", src.Name, dir)
+		}
+	}
+	self := &Cdrc{src: src, cgrCfg: cgrCfg, cdrs: cdrs, fields: make(map[string]*fieldTemplate), extraFields: make(map[string]*fieldTemplate)}
+	tpls := map[string]string{ACCID: src.AccIdField, REQTYPE: src.ReqTypeField, DIRECTION: src.DirectionField, TENANT: src.TenantField,
+		TOR: src.TORField, ACCOUNT: src.AccountField, SUBJECT: src.SubjectField, DESTINATION: src.DestinationField,
+		ANSWER_TIME: src.AnswerTimeField, DURATION: src.DurationField}
+	for _, mandatory := range []string{ACCID, ACCOUNT, DESTINATION, ANSWER_TIME, DURATION} {
+		if tpls[mandatory] == "" {
+			return nil, fmt.Errorf("Unconfigured %s field for source %s", mandatory, src.Name)
+		}
+	}
+	for fld, tpl := range tpls {
+		if tpl == "" { // Optional field, defaults apply
+			continue
+		}
+		ft, err := parseFieldTemplate(tpl, src.CdrFormat)
+		if err != nil {
+			return nil, fmt.Errorf("Source %s, field %s: %v", src.Name, fld, err)
+		}
+		self.fields[fld] = ft
+	}
+	for _, extraField := range src.ExtraFields {
+		nameTpl := strings.SplitN(extraField, ":", 2)
+		if len(nameTpl) != 2 || nameTpl[0] == "" {
+			return nil, fmt.Errorf("Source %s, extra fields must be name:template, got: %s", src.Name, extraField)
+		}
+		ft, err := parseFieldTemplate(nameTpl[1], src.CdrFormat)
+		if err != nil {
+			return nil, fmt.Errorf("Source %s, extra field %s: %v", src.Name, nameTpl[0], err)
+		}
+		self.extraFields[nameTpl[0]] = ft
+	}
+	return self, nil
+}
+
+// Processes the files already waiting in the folder, then the ones moved in later. Files must be moved
+// into the folder once complete since they are picked up as soon as they appear.
+func (self *Cdrc) Run() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err = watcher.Watch(self.src.CdrInDir); err != nil {
+		return err
+	}
+	engine.Logger.Info(fmt.Sprintf("<Cdrc> Monitoring %s for CDR files.", self.src.CdrInDir))
+	files, err := ioutil.ReadDir(self.src.CdrInDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			self.ProcessFile(path.Join(self.src.CdrInDir, file.Name()))
+		}
+	}
+	for {
+		select {
+		case ev := <-watcher.Event:
+			if ev.IsCreate() && !strings.HasPrefix(path.Base(ev.Name), ".") {
+				self.ProcessFile(ev.Name)
+			}
+		case err := <-watcher.Error:
+			engine.Logger.Err(fmt.Sprintf("<Cdrc> Inotify error: %s", err.Error()))
+		}
+	}
+}
+
+// Posts the records of the file to CDRS. The file goes to the error folder if any of its records failed.
+func (self *Cdrc) ProcessFile(filePath string) error {
+	engine.Logger.Info(fmt.Sprintf("<Cdrc> Parsing: %s", filePath))
+	failed := 0
+	records, err := self.readRecords(filePath)
+	for idx, record := range records {
+		storedCdr, errCdr := self.recordToStoredCdr(record)
+		if errCdr == nil {
+			errCdr = self.cdrs.ProcessCdr(storedCdr)
+		}
+		if errCdr != nil {
+			engine.Logger.Err(fmt.Sprintf("<Cdrc> File %s, record %d: %v", filePath, idx+1+self.src.HeaderLines, errCdr))
+			failed++
+		}
+	}
+	if err == nil && failed != 0 {
+		err = fmt.Errorf("%d out of %d records failed", failed, len(records))
+	}
+	destDir := self.src.CdrArchiveDir
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Cdrc> Could not process file %s: %v", filePath, err))
+		destDir = self.src.CdrErrorDir
+	}
+	if errMove := os.Rename(filePath, path.Join(destDir, path.Base(filePath))); errMove != nil {
+		engine.Logger.Err(fmt.Sprintf("<Cdrc> Could not move file %s: %v", filePath, errMove))
+		if err == nil {
+			err = errMove
+		}
+	}
+	return err
+}
+
+// Reads all the records out of the file, fixed width lines come as records with one element
+func (self *Cdrc) readRecords(filePath string) ([][]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records := make([][]string, 0)
+	if self.src.CdrFormat == utils.CDRC_FWV {
+		scanner := bufio.NewReader(file)
+		for lineNr := 0; ; lineNr++ {
+			line, err := scanner.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if lineNr >= self.src.HeaderLines && len(strings.TrimSpace(line)) != 0 {
+				records = append(records, []string{line})
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+		return records, nil
+	}
+	csvReader := csv.NewReader(bufio.NewReader(file))
+	csvReader.Comma = []rune(self.src.FieldSeparator)[0]
+	csvReader.FieldsPerRecord = -1
+	for lineNr := 0; ; lineNr++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if lineNr >= self.src.HeaderLines {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (self *Cdrc) recordToStoredCdr(record []string) (*utils.StoredCdr, error) {
+	vals := make(map[string]string, len(self.fields))
+	for fld, ft := range self.fields {
+		val, err := ft.value(record)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fld, err)
+		}
+		vals[fld] = val
+	}
+	if vals[ACCID] == "" {
+		return nil, errors.New("Empty accid")
+	}
+	storedCdr := &utils.StoredCdr{
+		CgrId:        utils.FSCgrId(vals[ACCID]),
+		AccId:        vals[ACCID],
+		ReqType:      utils.FirstNonEmpty(vals[REQTYPE], self.cgrCfg.DefaultReqType),
+		Direction:    utils.FirstNonEmpty(vals[DIRECTION], "*out"),
+		Tenant:       utils.FirstNonEmpty(vals[TENANT], self.cgrCfg.DefaultTenant),
+		TOR:          utils.FirstNonEmpty(vals[TOR], self.cgrCfg.DefaultTOR),
+		Account:      vals[ACCOUNT],
+		Subject:      utils.FirstNonEmpty(vals[SUBJECT], vals[ACCOUNT]),
+		Destination:  vals[DESTINATION],
+		FallbackSubj: self.cgrCfg.DefaultSubject,
+		ExtraFields:  make(map[string]string, len(self.extraFields)),
+	}
+	var err error
+	if storedCdr.AnswerTime, err = parseAnswerTime(vals[ANSWER_TIME]); err != nil {
+		return nil, fmt.Errorf("%s: %v", ANSWER_TIME, err)
+	}
+	if storedCdr.Duration, err = parseDuration(vals[DURATION]); err != nil {
+		return nil, fmt.Errorf("%s: %v", DURATION, err)
+	}
+	for fld, ft := range self.extraFields {
+		if storedCdr.ExtraFields[fld], err = ft.value(record); err != nil {
+			return nil, fmt.Errorf("%s: %v", fld, err)
+		}
+	}
+	return storedCdr, nil
+}
+
+// Accepts unix timestamps, RFC3339 and 2006-01-02 15:04:05 (UTC)
+func parseAnswerTime(s string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02 15:04:05", s)
+}
+
+// Duration in seconds out of plain seconds or duration strings like 1m30s
+func parseDuration(s string) (int64, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return secs, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(d.Seconds()), nil
+}
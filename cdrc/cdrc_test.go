@@ -0,0 +1,175 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"errors"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+type fakeCdrs struct {
+	cdrs []utils.CDR
+	fail string // accid refused by the server
+}
+
+func (fc *fakeCdrs) ProcessCdr(cdr utils.CDR) error {
+	if cdr.GetAccId() == fc.fail {
+		return errors.New("SERVER_ERROR")
+	}
+	fc.cdrs = append(fc.cdrs, cdr)
+	return nil
+}
+
+func newTestSource(t *testing.T, cdrFormat string) *config.CdrcSource {
+	tmpDir, err := ioutil.TempDir("", "cgr_cdrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := config.NewDefaultCdrcSource("test")
+	src.CdrFormat = cdrFormat
+	src.CdrInDir, src.CdrArchiveDir, src.CdrErrorDir = path.Join(tmpDir, "in"), path.Join(tmpDir, "archive"), path.Join(tmpDir, "error")
+	for _, dir := range []string{src.CdrInDir, src.CdrArchiveDir, src.CdrErrorDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+func TestCdrcCsv(t *testing.T) {
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	src := newTestSource(t, utils.CDRC_CSV)
+	defer os.RemoveAll(path.Dir(src.CdrInDir))
+	src.HeaderLines = 1
+	src.DirectionField = ""
+	src.TenantField = "^cgrates.org"
+	src.ExtraFields = []string{"supplier:10"}
+	fc := new(fakeCdrs)
+	cdrc, err := NewCdrc(src, cgrCfg, fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := `accid,reqtype,direction,tenant,tor,account,subject,destination,answer_time,duration,supplier
+dsafdsaf,postpaid,*out,ignored,call,1001,,+4986517174963,2013-11-07 08:42:26,1m30s,supplier1
+"dsafdsag",prepaid,*out,ignored,call,1002,1002,+4986517174964,1383813746,30,supplier2
+`
+	filePath := path.Join(src.CdrInDir, "cdrs1.csv")
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrc.ProcessFile(filePath); err != nil {
+		t.Error(err)
+	}
+	if len(fc.cdrs) != 2 {
+		t.Fatalf("Expected 2 CDRs, got: %d", len(fc.cdrs))
+	}
+	eCdr := &utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", ReqType: utils.POSTPAID, Direction: "*out",
+		Tenant: "cgrates.org", TOR: "call", Account: "1001", Subject: "1001", Destination: "+4986517174963",
+		AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 90, FallbackSubj: cgrCfg.DefaultSubject,
+		ExtraFields: map[string]string{"supplier": "supplier1"}}
+	if cdr := fc.cdrs[0].(*utils.StoredCdr); cdr.CgrId != eCdr.CgrId || cdr.Subject != eCdr.Subject || cdr.Tenant != eCdr.Tenant ||
+		!cdr.AnswerTime.Equal(eCdr.AnswerTime) || cdr.Duration != eCdr.Duration || cdr.ExtraFields["supplier"] != "supplier1" {
+		t.Errorf("Expecting: %+v, received: %+v", eCdr, cdr)
+	}
+	if cdr := fc.cdrs[1]; cdr.GetDuration() != 30 || cdr.GetReqType() != utils.PREPAID {
+		t.Errorf("Unexpected CDR: %+v", cdr)
+	}
+	if _, err := os.Stat(path.Join(src.CdrArchiveDir, "cdrs1.csv")); err != nil {
+		t.Error("File not archived: ", err)
+	}
+	// One failed record sends the file to the error folder
+	fc.fail = "dsafdsag"
+	filePath = path.Join(src.CdrInDir, "cdrs2.csv")
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrc.ProcessFile(filePath); err == nil {
+		t.Error("Expected error on failed record")
+	}
+	if _, err := os.Stat(path.Join(src.CdrErrorDir, "cdrs2.csv")); err != nil {
+		t.Error("File not moved to error folder: ", err)
+	}
+}
+
+func TestCdrcFixedWidth(t *testing.T) {
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	src := newTestSource(t, utils.CDRC_FWV)
+	defer os.RemoveAll(path.Dir(src.CdrInDir))
+	src.AccIdField = "0:8"
+	src.ReqTypeField = "^rated"
+	src.DirectionField = ""
+	src.TenantField = ""
+	src.TORField = ""
+	src.AccountField = "8:4"
+	src.SubjectField = ""
+	src.DestinationField = "12:14"
+	src.AnswerTimeField = "26:10"
+	src.DurationField = "36:5"
+	fc := new(fakeCdrs)
+	cdrc, err := NewCdrc(src, cgrCfg, fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "dsafdsaf1001+4986517174963138381374600090\n" +
+		"short\n"
+	filePath := path.Join(src.CdrInDir, "cdrs.fwv")
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrc.ProcessFile(filePath); err == nil {
+		t.Error("Expected error on the short line")
+	}
+	if len(fc.cdrs) != 1 {
+		t.Fatalf("Expected 1 CDR, got: %d", len(fc.cdrs))
+	}
+	cdr := fc.cdrs[0]
+	if aTime, _ := cdr.GetAnswerTime(); cdr.GetAccId() != "dsafdsaf" || cdr.GetAccount() != "1001" || cdr.GetSubject() != "1001" ||
+		cdr.GetDestination() != "+4986517174963" || aTime.Unix() != 1383813746 || cdr.GetDuration() != 90 ||
+		cdr.GetReqType() != utils.RATED || cdr.GetTenant() != cgrCfg.DefaultTenant || cdr.GetDirection() != "*out" {
+		t.Errorf("Unexpected CDR: %+v", cdr)
+	}
+	if _, err := os.Stat(path.Join(src.CdrErrorDir, "cdrs.fwv")); err != nil {
+		t.Error("File not moved to error folder: ", err)
+	}
+}
+
+func TestCdrcTemplates(t *testing.T) {
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	src := newTestSource(t, utils.CDRC_FWV)
+	defer os.RemoveAll(path.Dir(src.CdrInDir))
+	if _, err := NewCdrc(src, cgrCfg, new(fakeCdrs)); err == nil {
+		t.Error("Expected error on csv indexes used as fixed width templates")
+	}
+	src.CdrFormat = utils.CDRC_CSV
+	src.AccIdField = ""
+	if _, err := NewCdrc(src, cgrCfg, new(fakeCdrs)); err == nil {
+		t.Error("Expected error on missing accid template")
+	}
+	src.AccIdField = "0"
+	src.ExtraFields = []string{"supplier"}
+	if _, err := NewCdrc(src, cgrCfg, new(fakeCdrs)); err == nil {
+		t.Error("Expected error on extra field without template")
+	}
+}
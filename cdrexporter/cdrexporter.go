@@ -18,7 +18,145 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 
 package cdrexporter
 
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writes rated CDRs in one of the export formats
 type CdrWriter interface {
-	Write(cdr map[string]string) string
-	Close()
+	Write(cdr *utils.RatedCdr) error
+	Close() error
+}
+
+// Returned by the writers for a CDR they cannot export, nothing of it being written so the export can go on
+type CdrSkippedError struct {
+	CgrId  string
+	Reason string
+}
+
+func (err *CdrSkippedError) Error() string {
+	return fmt.Sprintf("CDR with cgrid <%s> skipped: %s", err.CgrId, err.Reason)
+}
+
+// Creates the writer for the format configured in the template
+func NewCdrWriter(writer io.Writer, cfg *config.CdreTemplate) (CdrWriter, error) {
+	tpl, err := NewExportTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.CdrFormat {
+	case utils.CDRE_CSV:
+		if len(cfg.FieldSeparator) != 1 {
+			return nil, fmt.Errorf("Invalid csv field separator: <%s>", cfg.FieldSeparator)
+		}
+		return NewCsvCdrWriter(writer, tpl, rune(cfg.FieldSeparator[0])), nil
+	case utils.CDRE_FWV:
+		return NewFwvCdrWriter(writer, tpl)
+	case utils.CDRE_JSONL:
+		return NewJsonlCdrWriter(writer, tpl), nil
+	}
+	return nil, fmt.Errorf("Unsupported CDR export format: <%s>", cfg.CdrFormat)
+}
+
+type exportField struct {
+	name  string
+	tpl   string // CDR field, extra field or ^value for constants
+	width int    // Only used by fixed width exports
+}
+
+// Numeric fields are aligned to the right by the fixed width exports and not quoted by the JSON ones
+func (fld *exportField) isNumeric() bool {
+	return fld.tpl == "duration" || fld.tpl == "cost"
+}
+
+// Ordered fields of an export together with their formatting
+type ExportTemplate struct {
+	fields               []*exportField
+	costRoundingDecimals int
+	costRoundingMethod   string
+	answerTimeLayout     string
+}
+
+func NewExportTemplate(cfg *config.CdreTemplate) (*ExportTemplate, error) {
+	if len(cfg.Fields) == 0 {
+		return nil, fmt.Errorf("No fields in export template <%s>", cfg.Name)
+	}
+	if len(cfg.FieldWidths) != 0 && len(cfg.FieldWidths) != len(cfg.Fields) {
+		return nil, fmt.Errorf("Inconsistent length of fields and field widths in export template <%s>", cfg.Name)
+	}
+	tpl := &ExportTemplate{costRoundingDecimals: cfg.CostRoundingDecimals, costRoundingMethod: cfg.CostRoundingMethod,
+		answerTimeLayout: cfg.AnswerTimeLayout}
+	for idx, fldCfg := range cfg.Fields {
+		nameTpl := strings.SplitN(fldCfg, ":", 2)
+		if len(nameTpl) != 2 || nameTpl[0] == "" || nameTpl[1] == "" {
+			return nil, fmt.Errorf("Invalid export field <%s>, expecting name:template", fldCfg)
+		}
+		fld := &exportField{name: nameTpl[0], tpl: nameTpl[1]}
+		if len(cfg.FieldWidths) != 0 {
+			fld.width = cfg.FieldWidths[idx]
+		}
+		tpl.fields = append(tpl.fields, fld)
+	}
+	return tpl, nil
+}
+
+// Names of the exported fields, in order
+func (tpl *ExportTemplate) FieldNames() []string {
+	names := make([]string, len(tpl.fields))
+	for idx, fld := range tpl.fields {
+		names[idx] = fld.name
+	}
+	return names
+}
+
+// Formatted values of the exported fields, in the order of the template
+func (tpl *ExportTemplate) Record(cdr *utils.RatedCdr) []string {
+	record := make([]string, len(tpl.fields))
+	for idx, fld := range tpl.fields {
+		record[idx] = tpl.fieldValue(fld, cdr)
+	}
+	return record
+}
+
+func (tpl *ExportTemplate) fieldValue(fld *exportField, cdr *utils.RatedCdr) string {
+	if strings.HasPrefix(fld.tpl, "^") {
+		return fld.tpl[1:]
+	}
+	switch fld.tpl {
+	case "cgrid":
+		return cdr.CgrId
+	case "accid":
+		return cdr.AccId
+	case "cdrhost":
+		return cdr.CdrHost
+	case "reqtype":
+		return cdr.ReqType
+	case "direction":
+		return cdr.Direction
+	case "tenant":
+		return cdr.Tenant
+	case "tor":
+		return cdr.TOR
+	case "account":
+		return cdr.Account
+	case "subject":
+		return cdr.Subject
+	case "destination":
+		return cdr.Destination
+	case "answer_time":
+		return cdr.AnswerTime.Format(tpl.answerTimeLayout)
+	case "duration":
+		return strconv.FormatInt(cdr.Duration, 10)
+	case "run_id":
+		return cdr.RunId
+	case "cost":
+		cost := utils.Round(cdr.Cost, tpl.costRoundingDecimals, tpl.costRoundingMethod)
+		return strconv.FormatFloat(cost, 'f', tpl.costRoundingDecimals, 64)
+	}
+	return cdr.ExtraFields[fld.tpl]
 }
@@ -20,25 +20,26 @@ package cdrexporter
 
 import (
 	"encoding/csv"
+	"github.com/cgrates/cgrates/utils"
 	"io"
 )
 
 type CsvCdrWriter struct {
 	writer *csv.Writer
+	tpl    *ExportTemplate
 }
 
-func NewCsvCdrWriter(writer io.Writer) *CsvCdrWriter {
-	return &CsvCdrWriter{csv.NewWriter(writer)}
+func NewCsvCdrWriter(writer io.Writer, tpl *ExportTemplate, separator rune) *CsvCdrWriter {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Comma = separator
+	return &CsvCdrWriter{csvWriter, tpl}
 }
 
-func (dcw *CsvCdrWriter) Write(cdr map[string]string) error {
-	var row []string
-	for _, v := range cdr {
-		row = append(row, v)
-	}
-	return dcw.writer.Write(row)
+func (dcw *CsvCdrWriter) Write(cdr *utils.RatedCdr) error {
+	return dcw.writer.Write(dcw.tpl.Record(cdr))
 }
 
-func (dcw *CsvCdrWriter) Close() {
+func (dcw *CsvCdrWriter) Close() error {
 	dcw.writer.Flush()
+	return dcw.writer.Error()
 }
@@ -20,14 +20,28 @@ package cdrexporter
 
 import (
 	"bytes"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
 	"strings"
 	"testing"
+	"time"
 )
 
+var testCdr = &utils.RatedCdr{StoredCdr: utils.StoredCdr{CgrId: "dbafe9c8614c785a65aabd116dd3959c3c56f7f6", AccId: "dsafdsaf", CdrHost: "192.168.1.1",
+	ReqType: "rated", Direction: "*out", Tenant: "cgrates.org", TOR: "call", Account: "1001", Subject: "1001", Destination: "1002",
+	AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 10, ExtraFields: map[string]string{"sip_call_id": "3a4d6b"}},
+	RunId: "1001", Cost: 1.01456}
+
 func TestCsv(t *testing.T) {
 	writer := &bytes.Buffer{}
-	csvCdrWriter := NewCsvCdrWriter(writer)
-	csvCdrWriter.Write(map[string]string{"First": "test", "Second": "the", "Third": "cdr"})
+	cfg := config.NewDefaultCdreTemplate("test")
+	cfg.Fields = []string{"First:^test", "Second:^the", "Third:^cdr"}
+	tpl, err := NewExportTemplate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvCdrWriter := NewCsvCdrWriter(writer, tpl, ',')
+	csvCdrWriter.Write(testCdr)
 	csvCdrWriter.Close()
 	expected := "test,the,cdr"
 	result := strings.TrimSpace(writer.String())
@@ -35,3 +49,22 @@ func TestCsv(t *testing.T) {
 		t.Errorf("Expected %s was %s.", expected, result)
 	}
 }
+
+func TestCsvTemplate(t *testing.T) {
+	writer := &bytes.Buffer{}
+	cfg := config.NewDefaultCdreTemplate("test")
+	cfg.FieldSeparator = ";"
+	cfg.CostRoundingDecimals = 2
+	cfg.AnswerTimeLayout = "2006-01-02 15:04:05"
+	cfg.Fields = append(cfg.Fields, "sip_call_id:sip_call_id", "carrier:^CARRIER1")
+	cdrWriter, err := NewCdrWriter(writer, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdrWriter.Write(testCdr)
+	cdrWriter.Close()
+	expected := "dbafe9c8614c785a65aabd116dd3959c3c56f7f6;dsafdsaf;rated;*out;cgrates.org;call;1001;1001;1002;2013-11-07 08:42:26;10;1.01;3a4d6b;CARRIER1\n"
+	if writer.String() != expected {
+		t.Errorf("Expected %s was %s.", expected, writer.String())
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/utils"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Writes one line per CDR with each field padded to its width
+type FwvCdrWriter struct {
+	writer io.Writer
+	tpl    *ExportTemplate
+}
+
+func NewFwvCdrWriter(writer io.Writer, tpl *ExportTemplate) (*FwvCdrWriter, error) {
+	for _, fld := range tpl.fields {
+		if fld.width <= 0 {
+			return nil, fmt.Errorf("Missing width of fixed width field <%s>", fld.name)
+		}
+	}
+	return &FwvCdrWriter{writer, tpl}, nil
+}
+
+// Values longer than their field are not truncated, the CDR is skipped instead
+func (fcw *FwvCdrWriter) Write(cdr *utils.RatedCdr) error {
+	record := fcw.tpl.Record(cdr)
+	var line []string
+	for idx, fld := range fcw.tpl.fields {
+		valLen := utf8.RuneCountInString(record[idx])
+		if valLen > fld.width {
+			return &CdrSkippedError{CgrId: cdr.CgrId, Reason: fmt.Sprintf("value <%s> of field <%s> longer than %d characters", record[idx], fld.name, fld.width)}
+		}
+		padding := strings.Repeat(" ", fld.width-valLen)
+		if fld.isNumeric() {
+			line = append(line, padding+record[idx])
+		} else {
+			line = append(line, record[idx]+padding)
+		}
+	}
+	_, err := io.WriteString(fcw.writer, strings.Join(line, "")+"\n")
+	return err
+}
+
+func (fcw *FwvCdrWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"bytes"
+	"github.com/cgrates/cgrates/config"
+	"testing"
+)
+
+func TestFwv(t *testing.T) {
+	writer := &bytes.Buffer{}
+	cfg := config.NewDefaultCdreTemplate("test")
+	cfg.CdrFormat = "fwv"
+	cfg.Fields = []string{"account:account", "destination:destination", "duration:duration", "cost:cost"}
+	cfg.FieldWidths = []int{6, 6, 4, 8}
+	cdrWriter, err := NewCdrWriter(writer, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrWriter.Write(testCdr); err != nil {
+		t.Error(err)
+	}
+	expected := "1001  1002    10  1.0146\n"
+	if writer.String() != expected {
+		t.Errorf("Expected <%s> was <%s>.", expected, writer.String())
+	}
+	cfg.FieldWidths = []int{6, 6, 4, 5}
+	cdrWriter, _ = NewCdrWriter(writer, cfg)
+	if err, isSkipped := cdrWriter.Write(testCdr).(*CdrSkippedError); !isSkipped || err.CgrId != testCdr.CgrId {
+		t.Error("Accepted value longer than its field: ", err)
+	}
+	if writer.String() != expected {
+		t.Errorf("Skipped CDR written: <%s>", writer.String())
+	}
+	cfg.FieldWidths = []int{}
+	if _, err := NewCdrWriter(writer, cfg); err == nil {
+		t.Error("Accepted fixed width fields without widths")
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cgrates/cgrates/utils"
+	"io"
+)
+
+// Writes one JSON object per line, keeping the order of the template fields
+type JsonlCdrWriter struct {
+	writer io.Writer
+	tpl    *ExportTemplate
+}
+
+func NewJsonlCdrWriter(writer io.Writer, tpl *ExportTemplate) *JsonlCdrWriter {
+	return &JsonlCdrWriter{writer, tpl}
+}
+
+func (jcw *JsonlCdrWriter) Write(cdr *utils.RatedCdr) error {
+	record := jcw.tpl.Record(cdr)
+	buf := new(bytes.Buffer)
+	buf.WriteString("{")
+	for idx, fld := range jcw.tpl.fields {
+		if idx != 0 {
+			buf.WriteString(",")
+		}
+		name, _ := json.Marshal(fld.name)
+		buf.Write(name)
+		buf.WriteString(":")
+		if fld.isNumeric() {
+			buf.WriteString(record[idx])
+		} else {
+			value, _ := json.Marshal(record[idx])
+			buf.Write(value)
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := jcw.writer.Write(buf.Bytes())
+	return err
+}
+
+func (jcw *JsonlCdrWriter) Close() error {
+	return nil
+}
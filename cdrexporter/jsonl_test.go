@@ -0,0 +1,42 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"bytes"
+	"github.com/cgrates/cgrates/config"
+	"testing"
+)
+
+func TestJsonl(t *testing.T) {
+	writer := &bytes.Buffer{}
+	cfg := config.NewDefaultCdreTemplate("test")
+	cfg.CdrFormat = "jsonl"
+	cfg.Fields = []string{"CallId:sip_call_id", "Account:account", "Duration:duration", "Cost:cost", "Source:^\"cgr\""}
+	cdrWriter, err := NewCdrWriter(writer, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdrWriter.Write(testCdr)
+	cdrWriter.Write(testCdr)
+	line := `{"CallId":"3a4d6b","Account":"1001","Duration":10,"Cost":1.0146,"Source":"\"cgr\""}` + "\n"
+	if writer.String() != line+line {
+		t.Errorf("Expected %s was %s.", line+line, writer.String())
+	}
+}
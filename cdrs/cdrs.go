@@ -19,31 +19,93 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package cdrs
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/cgrates/cgrates/config"
 	"github.com/cgrates/cgrates/engine"
 	"github.com/cgrates/cgrates/mediator"
+	"github.com/cgrates/cgrates/utils"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var (
 	cfg     *config.CGRConfig // Share the configuration with the rest of the package
 	storage engine.DataStorage
 	medi    *mediator.Mediator
+	mediClt *MediatorClient // Connection towards a remote mediator
+
+	dedupMux   sync.Mutex // Serializes the duplicate check with storing the CDR
+	duplicates int64      // Number of duplicate CDRs received
 )
 
+// CDRs sharing the cgrid but not the values of the dedup fields are distinct, their cgrid is then computed on both
+// so they are stored side by side. Returns the CDR unchanged without dedup fields.
+func dedupCdr(cdr utils.CDR) (utils.CDR, error) {
+	if len(cfg.CDRSDedupFields) == 0 {
+		return cdr, nil
+	}
+	storedCdr, err := utils.NewStoredCdr(cdr)
+	if err != nil {
+		return nil, err
+	}
+	idVals := []string{storedCdr.CgrId}
+	for _, fld := range cfg.CDRSDedupFields {
+		idVals = append(idVals, storedCdr.ExtraFields[fld])
+	}
+	storedCdr.CgrId = utils.FSCgrId(strings.Join(idVals, ";"))
+	return storedCdr, nil
+}
+
+// Stores the CDR unless received before, returning it as stored. Duplicates are counted and logged, the caller must skip them.
+func storeCdr(cdr utils.CDR) (storedCdr utils.CDR, isDuplicate bool, err error) {
+	if storedCdr, err = dedupCdr(cdr); err != nil {
+		return nil, false, err
+	}
+	dedupMux.Lock()
+	defer dedupMux.Unlock()
+	if exists, errExists := storage.ExistsCdr(storedCdr.GetCgrId()); errExists != nil {
+		engine.Logger.Err(fmt.Sprintf("<CDRS> Could not check for duplicates of CDR with cgrid: %s, error: %v", storedCdr.GetCgrId(), errExists))
+	} else if exists {
+		dups := atomic.AddInt64(&duplicates, 1)
+		engine.Logger.Warning(fmt.Sprintf("<CDRS> Skipping duplicate CDR with cgrid: %s, accid: %s, duplicates so far: %d", storedCdr.GetCgrId(), storedCdr.GetAccId(), dups))
+		return storedCdr, true, nil
+	}
+	return storedCdr, false, storage.SetCdr(storedCdr)
+}
+
+// Passes the CDR to the internal mediator or forwards it to the remote one
+func mediateCdr(cdr utils.CDR) error {
+	switch cfg.CDRSMediator {
+	case "":
+		return nil
+	case "internal":
+		return medi.MediateDBCDR(cdr, storage)
+	default:
+		return mediClt.Mediate(cdr)
+	}
+}
+
 func fsCdrHandler(w http.ResponseWriter, r *http.Request) {
 	body, _ := ioutil.ReadAll(r.Body)
 	if fsCdr, err := new(FSCdr).New(body); err == nil {
-		storage.SetCdr(fsCdr)
+		storedCdr, isDuplicate, errStore := storeCdr(fsCdr)
+		if isDuplicate {
+			return
+		} else if errStore != nil {
+			engine.Logger.Err(fmt.Sprintf("Could not store CDR entry: %v", errStore))
+		}
+		if storedCdr == nil {
+			storedCdr = fsCdr
+		}
 		go func() { //FS will not send us hangup_complete until we have send back the answer to CDR, so we need to handle mediation async
-			if cfg.CDRSMediator == "internal" {
-				medi.MediateDBCDR(fsCdr, storage)
-			} else {
-				//TODO: use the connection to mediator
+			if errMedi := mediateCdr(storedCdr); errMedi != nil {
+				engine.Logger.Err(fmt.Sprintf("Could not run mediation on CDR: %s", errMedi.Error()))
 			}
-		} ()
+		}()
 	} else {
 		engine.Logger.Err(fmt.Sprintf("Could not create CDR entry: %v", err))
 	}
@@ -52,20 +114,36 @@ func fsCdrHandler(w http.ResponseWriter, r *http.Request) {
 func cgrCdrHandler(w http.ResponseWriter, r *http.Request) {
 	body, _ := ioutil.ReadAll(r.Body)
 	if genCdr, err := new(GenCdr).New(body); err == nil {
-		storage.SetCdr(genCdr)
-		if cfg.CDRSMediator == "internal" {
-			errMedi := medi.MediateDBCDR(genCdr, storage)
-			if errMedi != nil {
-				engine.Logger.Err(fmt.Sprintf("Could not run mediation on CDR: %s", errMedi.Error()))
-			}
-		} else {
-			//TODO: use the connection to mediator
+		storedCdr, isDuplicate, errStore := storeCdr(genCdr)
+		if isDuplicate {
+			return
+		} else if errStore != nil {
+			engine.Logger.Err(fmt.Sprintf("Could not store CDR entry: %v", errStore))
+		}
+		if storedCdr == nil {
+			storedCdr = genCdr
+		}
+		if errMedi := mediateCdr(storedCdr); errMedi != nil {
+			engine.Logger.Err(fmt.Sprintf("Could not run mediation on CDR: %s", errMedi.Error()))
 		}
 	} else {
 		engine.Logger.Err(fmt.Sprintf("Could not create CDR entry: %v", err))
 	}
 }
 
+// Receives the CDRs already resolved by the CDR clients, answers with an error status if the CDR was not stored
+func storedCdrHandler(w http.ResponseWriter, r *http.Request) {
+	var storedCdr utils.StoredCdr
+	if err := json.NewDecoder(r.Body).Decode(&storedCdr); err != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not create CDR entry: %v", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := new(CDRS).ProcessCdr(&storedCdr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 type CDRS struct{}
 
 func New(s engine.DataStorage, m *mediator.Mediator, c *config.CGRConfig) *CDRS {
@@ -75,8 +153,34 @@ func New(s engine.DataStorage, m *mediator.Mediator, c *config.CGRConfig) *CDRS
 	return &CDRS{}
 }
 
+// Stores the CDR and passes it to mediation, duplicates are acknowledged without being mediated again
+func (cdrs *CDRS) ProcessCdr(cdr utils.CDR) error {
+	storedCdr, isDuplicate, err := storeCdr(cdr)
+	if err != nil {
+		return err
+	} else if isDuplicate {
+		return nil
+	}
+	if errMedi := mediateCdr(storedCdr); errMedi != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not run mediation on CDR: %s", errMedi.Error()))
+	}
+	return nil
+}
+
+// Number of duplicate CDRs received since start
+func (cdrs *CDRS) Duplicates() int64 {
+	return atomic.LoadInt64(&duplicates)
+}
+
+// Uses the given client to reach a remote mediator and starts resending the CDRs it has queued
+func (cdrs *CDRS) SetMediatorClient(mc *MediatorClient) {
+	mediClt = mc
+	go mc.RetryLoop(MEDIATOR_RETRY_INTERVAL)
+}
+
 func (cdrs *CDRS) StartCapturingCDRs() {
-	http.HandleFunc("/cgr_json", cgrCdrHandler) // Attach CGR CDR Handler
-	http.HandleFunc("/freeswitch_json", fsCdrHandler)  // Attach FreeSWITCH JSON CDR Handler
+	http.HandleFunc("/cgr_json", cgrCdrHandler)       // Attach CGR CDR Handler
+	http.HandleFunc("/freeswitch_json", fsCdrHandler) // Attach FreeSWITCH JSON CDR Handler
+	http.HandleFunc("/stored_json", storedCdrHandler) // Attach the handler of CDRs posted by CDR clients
 	http.ListenAndServe(cfg.CDRSListen, nil)
 }
@@ -0,0 +1,88 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrs
+
+import (
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/mediator"
+	"github.com/cgrates/cgrates/utils"
+	"testing"
+	"time"
+)
+
+// Keeps the CDRs in memory and counts the mediated ones
+type fakeCdrStorage struct {
+	engine.DataStorage
+	cdrs  map[string]int // cgrid to the times stored, more than once failing on the unique key
+	rated int
+}
+
+func (fs *fakeCdrStorage) SetCdr(cdr utils.CDR) error {
+	fs.cdrs[cdr.GetCgrId()]++
+	return nil
+}
+
+func (fs *fakeCdrStorage) ExistsCdr(cgrId string) (bool, error) {
+	return fs.cdrs[cgrId] != 0, nil
+}
+
+func (fs *fakeCdrStorage) SetRatedCdr(cdr utils.CDR, cc *engine.CallCost, extraInfo string) error {
+	fs.rated++
+	return nil
+}
+
+func TestCdrsDuplicates(t *testing.T) {
+	cdrsCfg, _ := config.NewDefaultCGRConfig()
+	cdrsCfg.CDRSMediator = "internal"
+	cdrsCfg.CDRSDedupFields = []string{"sip_call_id"}
+	storDb := &fakeCdrStorage{cdrs: make(map[string]int)}
+	medi, err := mediator.NewMediator(nil, storDb, cdrsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdrServer := New(storDb, medi, cdrsCfg)
+	cdr := &utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", ReqType: utils.RATED, Direction: "*out",
+		Tenant: "cgrates.org", TOR: "call", Account: "1001", Subject: "1001", Destination: "1002",
+		AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), ExtraFields: map[string]string{"sip_call_id": "call1"}}
+	for i := 0; i < 2; i++ { // Second one is a retry of the first
+		if err := cdrServer.ProcessCdr(cdr); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(storDb.cdrs) != 1 || storDb.rated != 1 {
+		t.Errorf("Duplicate stored or mediated, stored: %v, rated: %d", storDb.cdrs, storDb.rated)
+	}
+	if dups := cdrServer.Duplicates(); dups != 1 {
+		t.Errorf("Expected 1 duplicate, got: %d", dups)
+	}
+	// Same cgrid but different dedup field is a new CDR, stored under its own cgrid
+	cdr.ExtraFields = map[string]string{"sip_call_id": "call2"}
+	if err := cdrServer.ProcessCdr(cdr); err != nil {
+		t.Error(err)
+	}
+	if len(storDb.cdrs) != 2 || storDb.rated != 2 || cdrServer.Duplicates() != 1 {
+		t.Errorf("CDR not processed, stored: %v, rated: %d, duplicates: %d", storDb.cdrs, storDb.rated, cdrServer.Duplicates())
+	}
+	for cgrId, stored := range storDb.cdrs {
+		if stored != 1 || cgrId == cdr.CgrId {
+			t.Errorf("CDR stored under cgrid %s %d times", cgrId, stored)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	MEDIATOR_METHOD         = "MediatorV1.MediateCdr"
+	MEDIATOR_RETRY_INTERVAL = 10 * time.Second
+)
+
+// Forwards CDRs to a remote mediator, queueing them on disk while the mediator is not reachable
+type MediatorClient struct {
+	address    string
+	encoding   string // <json|gob>
+	reconnects int
+	queuePath  string // journal where undelivered CDRs survive restarts, empty to keep them in memory only
+	client     *rpc.Client
+	queue      []*utils.StoredCdr
+	mux        sync.Mutex    // protects the queue and its journal
+	sendMux    sync.Mutex    // one delivery at a time, protects the client
+	pending    chan struct{} // wakes up the delivery loop on new CDRs
+}
+
+func NewMediatorClient(address, encoding string, reconnects int, queuePath string) (*MediatorClient, error) {
+	mc := &MediatorClient{address: address, encoding: encoding, reconnects: reconnects, queuePath: queuePath, pending: make(chan struct{}, 1)}
+	if queuePath != "" { // fail now rather than on every CDR queued
+		if err := os.MkdirAll(path.Dir(queuePath), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := mc.loadQueue(); err != nil {
+		return nil, err
+	}
+	return mc, nil
+}
+
+// Queues the CDR for the mediator, RetryLoop delivering it in the background
+func (mc *MediatorClient) Mediate(cdr utils.CDR) error {
+	storedCdr, err := utils.NewStoredCdr(cdr)
+	if err != nil {
+		return err
+	}
+	mc.mux.Lock()
+	mc.queue = append(mc.queue, storedCdr)
+	err = mc.appendQueue(storedCdr)
+	mc.mux.Unlock()
+	select {
+	case mc.pending <- struct{}{}:
+	default: // already signaled
+	}
+	return err
+}
+
+// Sends the queued CDRs in order, stopping at the first connection error
+func (mc *MediatorClient) Retry() error {
+	mc.sendMux.Lock()
+	defer mc.sendMux.Unlock()
+	mc.mux.Lock()
+	batch := make([]*utils.StoredCdr, len(mc.queue))
+	copy(batch, mc.queue)
+	mc.mux.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	sent, err := mc.send(batch)
+	if sent != 0 {
+		mc.mux.Lock()
+		mc.queue = mc.queue[sent:] // only appended meanwhile, the batch is still at the head
+		if errSave := mc.saveQueue(); errSave != nil {
+			engine.Logger.Err(fmt.Sprintf("<CDRS> Could not save the mediator queue: %v", errSave))
+		}
+		mc.mux.Unlock()
+	}
+	return err
+}
+
+// Delivers the queued CDRs as they come, waiting the interval before retrying while the mediator is unreachable. Never returns.
+func (mc *MediatorClient) RetryLoop(interval time.Duration) {
+	for {
+		select {
+		case <-mc.pending:
+		case <-time.After(interval):
+		}
+		if err := mc.Retry(); err != nil {
+			engine.Logger.Warning(fmt.Sprintf("<CDRS> Mediator still unreachable, %d CDRs queued: %v", mc.QueueLen(), err))
+			time.Sleep(interval)
+		}
+	}
+}
+
+func (mc *MediatorClient) QueueLen() int {
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	return len(mc.queue)
+}
+
+// Sends the CDRs in order, returning how many were delivered before the first connection error. Must be called with sendMux held.
+func (mc *MediatorClient) send(cdrs []*utils.StoredCdr) (sent int, err error) {
+	for _, cdr := range cdrs {
+		if err = mc.call(cdr); err != nil {
+			if _, isServerErr := err.(rpc.ServerError); !isServerErr {
+				break
+			}
+			// The mediator received the CDR but could not rate it, resending will not help
+			engine.Logger.Err(fmt.Sprintf("<CDRS> Mediator could not process CDR with cgrid: %s, error: %v", cdr.CgrId, err))
+			err = nil
+		}
+		sent++
+	}
+	return
+}
+
+func (mc *MediatorClient) call(cdr *utils.StoredCdr) error {
+	if mc.client == nil {
+		if err := mc.connect(); err != nil {
+			return err
+		}
+	}
+	var reply string
+	err := mc.client.Call(MEDIATOR_METHOD, cdr, &reply)
+	if err == rpc.ErrShutdown {
+		mc.client.Close()
+		mc.client = nil
+		if err = mc.connect(); err != nil {
+			return err
+		}
+		err = mc.client.Call(MEDIATOR_METHOD, cdr, &reply)
+	}
+	if err != nil {
+		if _, isServerErr := err.(rpc.ServerError); !isServerErr {
+			mc.client.Close()
+			mc.client = nil
+		}
+	}
+	return err
+}
+
+// Dials the mediator, trying at least once whatever the configured reconnects
+func (mc *MediatorClient) connect() (err error) {
+	var client *rpc.Client
+	for i := 0; i == 0 || i < mc.reconnects; i++ {
+		if mc.encoding == "json" {
+			client, err = jsonrpc.Dial("tcp", mc.address)
+		} else {
+			client, err = rpc.Dial("tcp", mc.address)
+		}
+		if err == nil { //Connected so no need to reiterate
+			break
+		}
+		time.Sleep(time.Duration(i/2) * time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return fmt.Errorf("%s:could not connect to mediator at %s", utils.ERR_SERVER_ERROR, mc.address)
+	}
+	mc.client = client
+	return nil
+}
+
+// Reads the journal, one JSON encoded CDR per line. A crash while appending may leave the last one truncated.
+func (mc *MediatorClient) loadQueue() error {
+	if mc.queuePath == "" {
+		return nil
+	}
+	fd, err := os.Open(mc.queuePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fd.Close()
+	dec := json.NewDecoder(fd)
+	for {
+		cdr := new(utils.StoredCdr)
+		if err := dec.Decode(cdr); err == io.EOF {
+			break
+		} else if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<CDRS> Mediator queue %s ends with an unreadable CDR, ignoring it: %v", mc.queuePath, err))
+			break
+		}
+		mc.queue = append(mc.queue, cdr)
+	}
+	return nil
+}
+
+// Adds the CDR at the end of the journal. Must be called with the lock held.
+func (mc *MediatorClient) appendQueue(cdr *utils.StoredCdr) error {
+	if mc.queuePath == "" {
+		return nil
+	}
+	content, err := json.Marshal(cdr)
+	if err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(mc.queuePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = fd.Write(append(content, '\n'))
+	return err
+}
+
+// Rewrites the journal with the CDRs left to deliver, to a temporary file first so a crash does not leave
+// a truncated one behind. Must be called with the lock held.
+func (mc *MediatorClient) saveQueue() error {
+	if mc.queuePath == "" {
+		return nil
+	}
+	if len(mc.queue) == 0 {
+		if err := os.Remove(mc.queuePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var content bytes.Buffer
+	enc := json.NewEncoder(&content) // one CDR per line
+	for _, cdr := range mc.queue {
+		if err := enc.Encode(cdr); err != nil {
+			return err
+		}
+	}
+	tmpPath := mc.queuePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, mc.queuePath)
+}
@@ -0,0 +1,181 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrs
+
+import (
+	"github.com/cgrates/cgrates/utils"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMediatorV1 struct {
+	sync.Mutex
+	received []string
+}
+
+func (fm *fakeMediatorV1) MediateCdr(cdr utils.StoredCdr, reply *string) error {
+	fm.Lock()
+	defer fm.Unlock()
+	fm.received = append(fm.received, cdr.CgrId)
+	*reply = "OK"
+	return nil
+}
+
+func (fm *fakeMediatorV1) getReceived() []string {
+	fm.Lock()
+	defer fm.Unlock()
+	return append([]string{}, fm.received...)
+}
+
+func startFakeMediator(t *testing.T, address string) (*fakeMediatorV1, net.Listener) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm := new(fakeMediatorV1)
+	srv := rpc.NewServer()
+	srv.RegisterName("MediatorV1", fm)
+	go srv.Accept(l)
+	return fm, l
+}
+
+func TestMediatorClientQueue(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cgr_cdrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	queuePath := path.Join(tmpDir, "mediator_queue.json")
+	// Reserve an address nobody is listening on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	mc, err := NewMediatorClient(address, "gob", 1, queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdr := &utils.StoredCdr{CgrId: "cgrid1", AccId: "acc1", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 10}
+	if err := mc.Mediate(cdr); err != nil {
+		t.Error(err)
+	}
+	if err := mc.Retry(); err == nil {
+		t.Error("Expected error when mediator is down")
+	}
+	if mc.QueueLen() != 1 {
+		t.Errorf("Expected 1 queued CDR, got: %d", mc.QueueLen())
+	}
+	// A restarted CDRS picks up the queue from disk
+	mc, err = NewMediatorClient(address, "gob", 1, queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.QueueLen() != 1 || mc.queue[0].CgrId != "cgrid1" || !mc.queue[0].AnswerTime.Equal(cdr.AnswerTime) {
+		t.Errorf("Queue not restored: %+v", mc.queue)
+	}
+	fm, l := startFakeMediator(t, address)
+	if err := mc.Mediate(&utils.StoredCdr{CgrId: "cgrid2"}); err != nil {
+		t.Error(err)
+	}
+	if err := mc.Retry(); err != nil {
+		t.Error(err)
+	}
+	if received := fm.getReceived(); len(received) != 2 || received[0] != "cgrid1" || received[1] != "cgrid2" {
+		t.Errorf("Unexpected CDRs received: %v", received)
+	}
+	if mc.QueueLen() != 0 {
+		t.Errorf("Expected empty queue, got: %d", mc.QueueLen())
+	}
+	if _, err := os.Stat(queuePath); !os.IsNotExist(err) {
+		t.Error("Expected queue file removed once empty")
+	}
+	// Mediator restarts, the delivery loop reconnects on the next CDR
+	l.Close()
+	mc.client.Close()
+	fm, l = startFakeMediator(t, address)
+	defer l.Close()
+	go mc.RetryLoop(time.Hour)
+	if err := mc.Mediate(&utils.StoredCdr{CgrId: "cgrid3"}); err != nil {
+		t.Error(err)
+	}
+	for guard := 0; guard < 100 && len(fm.getReceived()) == 0; guard++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if received := fm.getReceived(); len(received) != 1 || received[0] != "cgrid3" {
+		t.Errorf("Unexpected CDRs received: %v", received)
+	}
+}
+
+func TestMediatorClientJournal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cgr_cdrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	queuePath := path.Join(tmpDir, "spool", "mediator_queue.json") // directory created by the client
+	mc, err := NewMediatorClient("127.0.0.1:1", "gob", 1, queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cgrId := range []string{"cgrid1", "cgrid2"} {
+		if err := mc.Mediate(&utils.StoredCdr{CgrId: cgrId}); err != nil {
+			t.Error(err)
+		}
+	}
+	// a crash while appending leaves the last CDR truncated
+	fd, err := os.OpenFile(queuePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.WriteString(`{"CgrId":"cgr`)
+	fd.Close()
+	if mc, err = NewMediatorClient("127.0.0.1:1", "gob", 1, queuePath); err != nil {
+		t.Fatal(err)
+	}
+	if mc.QueueLen() != 2 || mc.queue[0].CgrId != "cgrid1" || mc.queue[1].CgrId != "cgrid2" {
+		t.Errorf("Queue not restored from journal: %+v", mc.queue)
+	}
+}
+
+func TestMediatorClientNoReconnects(t *testing.T) {
+	fm, l := startFakeMediator(t, "127.0.0.1:0")
+	defer l.Close()
+	mc, err := NewMediatorClient(l.Addr().String(), "gob", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.Mediate(&utils.StoredCdr{CgrId: "cgrid1"}); err != nil {
+		t.Error(err)
+	}
+	if err := mc.Retry(); err != nil {
+		t.Error(err)
+	}
+	if received := fm.getReceived(); len(received) != 1 || received[0] != "cgrid1" {
+		t.Errorf("Unexpected CDRs received: %v", received)
+	}
+}
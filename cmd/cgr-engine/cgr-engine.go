@@ -24,15 +24,20 @@ import (
 	"fmt"
 	"github.com/cgrates/cgrates/apier/v1"
 	"github.com/cgrates/cgrates/balancer2go"
+	"github.com/cgrates/cgrates/cdrc"
+	"github.com/cgrates/cgrates/cdrexporter"
 	"github.com/cgrates/cgrates/cdrs"
 	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/diameter"
 	"github.com/cgrates/cgrates/engine"
 	"github.com/cgrates/cgrates/history"
 	"github.com/cgrates/cgrates/mediator"
+	"github.com/cgrates/cgrates/radius"
 	"github.com/cgrates/cgrates/scheduler"
 	"github.com/cgrates/cgrates/sessionmanager"
 	"github.com/cgrates/cgrates/utils"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/rpc"
 	"net/rpc/jsonrpc"
@@ -51,6 +56,8 @@ const (
 	REDIS    = "redis"
 	SAME     = "same"
 	FS       = "freeswitch"
+	KAMAILIO = "kamailio"
+	OSIPS    = "opensips"
 )
 
 var (
@@ -59,7 +66,9 @@ var (
 	bal      = balancer2go.NewBalancer()
 	exitChan = make(chan bool)
 	sm       sessionmanager.SessionManager
+	smApi    = sessionmanager.NewSessionsV1() // lists and disconnects the sessions over the rpc interfaces
 	medi     *mediator.Mediator
+	cdrSrv   *cdrs.CDRS
 	cfg      *config.CGRConfig
 	err      error
 )
@@ -94,7 +103,7 @@ func listenToRPCRequests(rpcResponder interface{}, apier *apier.ApierV1, rpcAddr
 	}
 }
 
-func startMediator(responder *engine.Responder, loggerDb engine.DataStorage) {
+func startMediator(responder *engine.Responder, loggerDb engine.DataStorage, apier *apier.ApierV1) {
 	var connector engine.Connector
 	if cfg.MediatorRater == INTERNAL {
 		connector = responder
@@ -129,6 +138,11 @@ func startMediator(responder *engine.Responder, loggerDb engine.DataStorage) {
 	if err != nil {
 		engine.Logger.Crit(fmt.Sprintf("Mediator config parsing error: %v", err))
 		exitChan <- true
+		return
+	}
+	apier.Mediator = medi
+	if cfg.MediatorListen != INTERNAL && cfg.MediatorListen != "" {
+		go listenMediatorV1(medi)
 	}
 
 	if cfg.MediatorCDRType == utils.FSCDR_FILE_CSV { //Mediator as standalone service for file CDRs
@@ -136,7 +150,36 @@ func startMediator(responder *engine.Responder, loggerDb engine.DataStorage) {
 	}
 }
 
-func startSessionManager(responder *engine.Responder, loggerDb engine.DataStorage) {
+// Serves the mediator api to remote CDR servers
+func listenMediatorV1(medi *mediator.Mediator) {
+	l, err := net.Listen("tcp", cfg.MediatorListen)
+	if err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<Mediator> Could not listen to %v: %v", cfg.MediatorListen, err))
+		exitChan <- true
+		return
+	}
+	defer l.Close()
+	engine.Logger.Info(fmt.Sprintf("<Mediator> Listening for incomming RPC requests on %v", l.Addr()))
+	srv := rpc.NewServer()
+	srv.Register(mediator.NewMediatorV1(medi))
+	var serveFunc func(io.ReadWriteCloser)
+	if cfg.RPCEncoding == JSON {
+		serveFunc = func(conn io.ReadWriteCloser) { srv.ServeCodec(jsonrpc.NewServerCodec(conn)) }
+	} else {
+		serveFunc = srv.ServeConn
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<Mediator> Accept error: %v", conn))
+			continue
+		}
+		engine.Logger.Info(fmt.Sprintf("<Mediator> New incoming connection: %v", conn.RemoteAddr()))
+		go serveFunc(conn)
+	}
+}
+
+func startSessionManager(responder *engine.Responder, getter, loggerDb engine.DataStorage) {
 	var connector engine.Connector
 	if cfg.SMRater == INTERNAL {
 		connector = responder
@@ -168,10 +211,35 @@ func startSessionManager(responder *engine.Responder, loggerDb engine.DataStorag
 		}
 		connector = &engine.RPCClientConnector{Client: client}
 	}
+	if cfg.SMListen != "" {
+		dp, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SMDebitInterval))
+		smv1 := sessionmanager.NewSessionManagerV1(loggerDb, connector, dp)
+		smApi.AddSessionManager(smv1)
+		go listenSessionManagerV1(smv1)
+	}
 	switch cfg.SMSwitchType {
 	case FS:
 		dp, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SMDebitInterval))
-		sm = sessionmanager.NewFSSessionManager(loggerDb, connector, dp)
+		fsm := sessionmanager.NewFSSessionManager(loggerDb, connector, dp)
+		fsm.SetSessionStorage(getter)
+		sm = fsm
+		smApi.AddSessionManager(sm)
+		errConn := sm.Connect(cfg)
+		if errConn != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> error: %s!", errConn))
+		}
+	case KAMAILIO:
+		dp, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SMDebitInterval))
+		sm = sessionmanager.NewKamailioSessionManager(loggerDb, connector, dp)
+		smApi.AddSessionManager(sm)
+		errConn := sm.Connect(cfg)
+		if errConn != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> error: %s!", errConn))
+		}
+	case OSIPS:
+		dp, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SMDebitInterval))
+		sm = sessionmanager.NewOpenSIPSSessionManager(loggerDb, connector, dp)
+		smApi.AddSessionManager(sm)
 		errConn := sm.Connect(cfg)
 		if errConn != nil {
 			engine.Logger.Err(fmt.Sprintf("<SessionManager> error: %s!", errConn))
@@ -183,7 +251,118 @@ func startSessionManager(responder *engine.Responder, loggerDb engine.DataStorag
 	exitChan <- true
 }
 
-func startCDRS(responder *engine.Responder, loggerDb engine.DataStorage) {
+// Serves the switch agnostic session api
+func listenSessionManagerV1(smv1 *sessionmanager.SessionManagerV1) {
+	smv1.Connect(cfg)
+	l, err := net.Listen("tcp", cfg.SMListen)
+	if err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<SessionManagerV1> Could not listen to %v: %v", cfg.SMListen, err))
+		exitChan <- true
+		return
+	}
+	defer l.Close()
+	engine.Logger.Info(fmt.Sprintf("<SessionManagerV1> Listening for incomming RPC requests on %v", l.Addr()))
+	// Own server so the session manager port does not expose the services of the default one
+	srv := rpc.NewServer()
+	srv.Register(smv1)
+	var serveFunc func(io.ReadWriteCloser)
+	if cfg.RPCEncoding == JSON {
+		serveFunc = func(conn io.ReadWriteCloser) { srv.ServeCodec(jsonrpc.NewServerCodec(conn)) }
+	} else {
+		serveFunc = srv.ServeConn
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManagerV1> Accept error: %v", conn))
+			continue
+		}
+		engine.Logger.Info(fmt.Sprintf("<SessionManagerV1> New incoming connection: %v", conn.RemoteAddr()))
+		go serveFunc(conn)
+	}
+}
+
+// Connector towards the rater used by the agents
+func agentConnector(responder *engine.Responder, raterAddr string, reconnects int) (engine.Connector, error) {
+	if raterAddr == INTERNAL {
+		return responder, nil
+	}
+	var client *rpc.Client
+	var err error
+	for i := 0; i < reconnects; i++ {
+		if cfg.RPCEncoding == JSON {
+			client, err = jsonrpc.Dial("tcp", raterAddr)
+		} else {
+			client, err = rpc.Dial("tcp", raterAddr)
+		}
+		if err == nil { //Connected so no need to reiterate
+			break
+		}
+		time.Sleep(time.Duration(i/2) * time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &engine.RPCClientConnector{Client: client}, nil
+}
+
+func startDiameterAgent(responder *engine.Responder, loggerDb engine.DataStorage) {
+	connector, err := agentConnector(responder, cfg.DiameterAgentRater, cfg.DiameterAgentRaterReconnects)
+	if err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<DiameterAgent> Could not connect to engine: %v", err))
+		exitChan <- true
+		return
+	}
+	da, err := diameter.NewDiameterAgent(cfg, connector, loggerDb)
+	if err != nil {
+		engine.Logger.Crit(err.Error())
+		exitChan <- true
+		return
+	}
+	smApi.AddSessionManager(da.SessionManager())
+	if err := da.ListenAndServe(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<DiameterAgent> Could not listen to %v: %v", cfg.DiameterAgentListen, err))
+	}
+	exitChan <- true
+}
+
+func startRadiusAgent(responder *engine.Responder, loggerDb engine.DataStorage) {
+	connector, err := agentConnector(responder, cfg.RadiusAgentRater, cfg.RadiusAgentRaterReconnects)
+	if err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<RadiusAgent> Could not connect to engine: %v", err))
+		exitChan <- true
+		return
+	}
+	var cdrProcessor radius.CdrProcessor
+	if cfg.RadiusAgentCdrs == INTERNAL {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Duration(i/2) * time.Second)
+			if cdrSrv != nil { // CDRS is up, no need to wait any longer
+				break
+			}
+		}
+		if cdrSrv == nil {
+			engine.Logger.Crit("<RadiusAgent> Could not connect to CDRS, exiting.")
+			exitChan <- true
+			return
+		}
+		cdrProcessor = cdrSrv
+	} else {
+		cdrProcessor = cdrc.NewHttpCdrsClient(cfg.RadiusAgentCdrs)
+	}
+	ra, err := radius.NewRadiusAgent(cfg, connector, loggerDb, cdrProcessor)
+	if err != nil {
+		engine.Logger.Crit(err.Error())
+		exitChan <- true
+		return
+	}
+	if err := ra.ListenAndServe(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<RadiusAgent> Could not listen: %v", err))
+	}
+	exitChan <- true
+}
+
+func startCDRS(responder *engine.Responder, loggerDb engine.DataStorage, apier *apier.ApierV1) {
 	if cfg.CDRSMediator == INTERNAL {
 		for i := 0; i < 3; i++ { // ToDo: If the right approach, make the reconnects configurable
 			time.Sleep(time.Duration(i/2) * time.Second)
@@ -197,10 +376,55 @@ func startCDRS(responder *engine.Responder, loggerDb engine.DataStorage) {
 		}
 	}
 	cs := cdrs.New(loggerDb, medi, cfg)
+	if cfg.CDRSMediator != INTERNAL && cfg.CDRSMediator != "" {
+		mc, err := cdrs.NewMediatorClient(cfg.CDRSMediator, cfg.RPCEncoding, cfg.CDRSMediatorReconnects, cfg.CDRSMediatorQueue)
+		if err != nil {
+			engine.Logger.Crit(fmt.Sprintf("<CDRS> Could not load the mediator queue: %v", err))
+			exitChan <- true
+			return
+		}
+		cs.SetMediatorClient(mc)
+	}
+	cdrSrv = cs
+	apier.Cdrs = cs
 	cs.StartCapturingCDRs()
 	exitChan <- true
 }
 
+func startCdrc() {
+	var cdrProcessor cdrc.CdrProcessor
+	if cfg.CdrcCdrs == INTERNAL {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Duration(i/2) * time.Second)
+			if cdrSrv != nil { // CDRS is up, no need to wait any longer
+				break
+			}
+		}
+		if cdrSrv == nil {
+			engine.Logger.Crit("<Cdrc> Could not connect to CDRS, exiting.")
+			exitChan <- true
+			return
+		}
+		cdrProcessor = cdrSrv
+	} else {
+		cdrProcessor = cdrc.NewHttpCdrsClient(cfg.CdrcCdrs)
+	}
+	for _, src := range cfg.CdrcSources {
+		cdrClient, err := cdrc.NewCdrc(src, cfg, cdrProcessor)
+		if err != nil {
+			engine.Logger.Crit(fmt.Sprintf("<Cdrc> %v", err))
+			exitChan <- true
+			return
+		}
+		go func(src *config.CdrcSource) {
+			if err := cdrClient.Run(); err != nil {
+				engine.Logger.Crit(fmt.Sprintf("<Cdrc> Could not monitor %s: %v", src.CdrInDir, err))
+				exitChan <- true
+			}
+		}(src)
+	}
+}
+
 func startHistoryScribe() {
 	var scribeServer history.Scribe
 
@@ -281,10 +505,32 @@ func checkConfigSanity() error {
 		engine.Logger.Crit("CDRS cannot connect to mediator, Mediator not enabled in configuration!")
 		return errors.New("Internal Mediator required by CDRS")
 	}
+	if cfg.CdrcEnabled && cfg.CdrcCdrs == INTERNAL && !cfg.CDRSEnabled {
+		engine.Logger.Crit("The CDR client cannot reach an internal CDRS, CDRS not enabled in configuration!")
+		return errors.New("Internal CDRS required by Cdrc")
+	}
+	if cfg.DiameterAgentEnabled && cfg.DiameterAgentRater == INTERNAL && !cfg.RaterEnabled {
+		engine.Logger.Crit("The diameter agent cannot reach an internal rater, Rater not enabled in configuration!")
+		return errors.New("Internal Rater required by DiameterAgent")
+	}
+	if cfg.RadiusAgentEnabled && cfg.RadiusAgentRater == INTERNAL && !cfg.RaterEnabled {
+		engine.Logger.Crit("The radius agent cannot reach an internal rater, Rater not enabled in configuration!")
+		return errors.New("Internal Rater required by RadiusAgent")
+	}
+	if cfg.RadiusAgentEnabled && cfg.RadiusAgentCdrs == INTERNAL && !cfg.CDRSEnabled {
+		engine.Logger.Crit("The radius agent cannot reach an internal CDRS, CDRS not enabled in configuration!")
+		return errors.New("Internal CDRS required by RadiusAgent")
+	}
 	if cfg.HistoryServerEnabled && cfg.HistoryServer == INTERNAL && !cfg.HistoryServerEnabled {
 		engine.Logger.Crit("The history agent is enabled and internal and history server is disabled!")
 		return errors.New("Improperly configured history service")
 	}
+	for _, cdreTpl := range cfg.CdreTemplates {
+		if _, err := cdrexporter.NewCdrWriter(ioutil.Discard, cdreTpl); err != nil {
+			engine.Logger.Crit(fmt.Sprintf("Invalid CDR export template <%s>: %v", cdreTpl.Name, err))
+			return errors.New("Improperly configured CDR export template")
+		}
+	}
 	return nil
 }
 
@@ -341,7 +587,7 @@ func main() {
 		go stopRaterSingnalHandler()
 	}
 	responder := &engine.Responder{ExitChan: exitChan}
-	apier := &apier.ApierV1{StorDb: loggerDb, DataDb: getter}
+	apier := &apier.ApierV1{StorDb: loggerDb, DataDb: getter, Config: cfg}
 	if cfg.RaterEnabled && !cfg.BalancerEnabled && cfg.RaterListen != INTERNAL {
 		engine.Logger.Info(fmt.Sprintf("Starting CGRateS Rater on %s.", cfg.RaterListen))
 		go listenToRPCRequests(responder, apier, cfg.RaterListen, cfg.RPCEncoding, getter, loggerDb)
@@ -360,7 +606,12 @@ func main() {
 	if cfg.SchedulerEnabled {
 		engine.Logger.Info("Starting CGRateS Scheduler.")
 		go func() {
-			sched := scheduler.NewScheduler()
+			catchUpWindow, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SchedulerCatchUpWindow))
+			sched := scheduler.NewScheduler(catchUpWindow)
+			if cfg.SchedulerLeaderLease > 0 {
+				leaderLease, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SchedulerLeaderLease))
+				sched.SetLeaderLease(getter, utils.GenUUID(), leaderLease)
+			}
 			go reloadSchedulerSingnalHandler(sched, getter)
 			apier.Sched = sched
 			sched.LoadActionTimings(getter)
@@ -368,21 +619,40 @@ func main() {
 		}()
 	}
 
+	if cfg.SMEnabled || cfg.DiameterAgentEnabled {
+		rpc.Register(smApi)
+	}
+
 	if cfg.SMEnabled {
 		engine.Logger.Info("Starting CGRateS SessionManager.")
-		go startSessionManager(responder, loggerDb)
+		go startSessionManager(responder, getter, loggerDb)
 		// close all sessions on shutdown
 		go shutdownSessionmanagerSingnalHandler()
 	}
 
 	if cfg.MediatorEnabled {
 		engine.Logger.Info("Starting CGRateS Mediator.")
-		go startMediator(responder, loggerDb)
+		go startMediator(responder, loggerDb, apier)
+	}
+
+	if cfg.DiameterAgentEnabled {
+		engine.Logger.Info("Starting CGRateS DiameterAgent.")
+		go startDiameterAgent(responder, loggerDb)
+	}
+
+	if cfg.RadiusAgentEnabled {
+		engine.Logger.Info("Starting CGRateS RadiusAgent.")
+		go startRadiusAgent(responder, loggerDb)
 	}
 
 	if cfg.CDRSEnabled {
 		engine.Logger.Info("Starting CGRateS CDR Server.")
-		go startCDRS(responder, loggerDb)
+		go startCDRS(responder, loggerDb, apier)
+	}
+
+	if cfg.CdrcEnabled {
+		engine.Logger.Info("Starting CGRateS CDR Client.")
+		go startCdrc()
 	}
 
 	if cfg.HistoryServerEnabled || cfg.HistoryAgentEnabled {
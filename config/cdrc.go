@@ -0,0 +1,140 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"github.com/cgrates/cgrates/utils"
+	"path"
+)
+
+// Prefix of the sections configuring the CDR client sources, eg: [cdrc_freeswitch]
+const CDRC_SOURCE_PREFIX = "cdrc_"
+
+// One folder watched by the CDR client together with the template used to parse its files.
+// Template fields are column indexes for csv, start:length for fixed width and ^value for constants.
+type CdrcSource struct {
+	Name             string
+	CdrFormat        string   // Format of the files: <csv|fwv>.
+	CdrInDir         string   // Absolute path towards the directory where the CDR files are dropped.
+	CdrArchiveDir    string   // Absolute path where the files are moved after being processed.
+	CdrErrorDir      string   // Absolute path where the files are moved when records could not be processed.
+	FieldSeparator   string   // Separator of the csv fields.
+	HeaderLines      int      // Number of lines to skip at the beginning of each file.
+	AccIdField       string   // Template of the accounting id.
+	ReqTypeField     string   // Template of the request type, empty for the default one.
+	DirectionField   string   // Template of the direction, empty for *out.
+	TenantField      string   // Template of the tenant, empty for the default one.
+	TORField         string   // Template of the tor, empty for the default one.
+	AccountField     string   // Template of the account.
+	SubjectField     string   // Template of the subject, empty to use the account.
+	DestinationField string   // Template of the destination.
+	AnswerTimeField  string   // Template of the answer time: unix timestamp, RFC3339 or 2006-01-02 15:04:05.
+	DurationField    string   // Template of the duration: seconds or duration string (eg: 1m30s).
+	ExtraFields      []string // Extra fields to store with the CDR as name:template pairs.
+}
+
+func NewDefaultCdrcSource(name string) *CdrcSource {
+	return &CdrcSource{
+		Name:             name,
+		CdrFormat:        utils.CDRC_CSV,
+		CdrInDir:         path.Join("/var/log/cgrates/cdrc", name, "in"),
+		CdrArchiveDir:    path.Join("/var/log/cgrates/cdrc", name, "archive"),
+		CdrErrorDir:      path.Join("/var/log/cgrates/cdrc", name, "error"),
+		FieldSeparator:   ",",
+		HeaderLines:      0,
+		AccIdField:       "0",
+		ReqTypeField:     "1",
+		DirectionField:   "2",
+		TenantField:      "3",
+		TORField:         "4",
+		AccountField:     "5",
+		SubjectField:     "6",
+		DestinationField: "7",
+		AnswerTimeField:  "8",
+		DurationField:    "9",
+		ExtraFields:      []string{},
+	}
+}
+
+// Loads the [cdrc_<name>] section over the defaults
+func loadCdrcSource(c *conf.ConfigFile, name string) (*CdrcSource, error) {
+	section := CDRC_SOURCE_PREFIX + name
+	if !c.HasSection(section) {
+		return nil, fmt.Errorf("Missing configuration section [%s]", section)
+	}
+	src := NewDefaultCdrcSource(name)
+	var hasOpt bool
+	var errParse error
+	if hasOpt = c.HasOption(section, "cdr_format"); hasOpt {
+		src.CdrFormat, _ = c.GetString(section, "cdr_format")
+	}
+	if hasOpt = c.HasOption(section, "cdr_in_dir"); hasOpt {
+		src.CdrInDir, _ = c.GetString(section, "cdr_in_dir")
+	}
+	if hasOpt = c.HasOption(section, "cdr_archive_dir"); hasOpt {
+		src.CdrArchiveDir, _ = c.GetString(section, "cdr_archive_dir")
+	}
+	if hasOpt = c.HasOption(section, "cdr_error_dir"); hasOpt {
+		src.CdrErrorDir, _ = c.GetString(section, "cdr_error_dir")
+	}
+	if hasOpt = c.HasOption(section, "field_separator"); hasOpt {
+		src.FieldSeparator, _ = c.GetString(section, "field_separator")
+	}
+	if hasOpt = c.HasOption(section, "header_lines"); hasOpt {
+		src.HeaderLines, _ = c.GetInt(section, "header_lines")
+	}
+	if hasOpt = c.HasOption(section, "accid_field"); hasOpt {
+		src.AccIdField, _ = c.GetString(section, "accid_field")
+	}
+	if hasOpt = c.HasOption(section, "reqtype_field"); hasOpt {
+		src.ReqTypeField, _ = c.GetString(section, "reqtype_field")
+	}
+	if hasOpt = c.HasOption(section, "direction_field"); hasOpt {
+		src.DirectionField, _ = c.GetString(section, "direction_field")
+	}
+	if hasOpt = c.HasOption(section, "tenant_field"); hasOpt {
+		src.TenantField, _ = c.GetString(section, "tenant_field")
+	}
+	if hasOpt = c.HasOption(section, "tor_field"); hasOpt {
+		src.TORField, _ = c.GetString(section, "tor_field")
+	}
+	if hasOpt = c.HasOption(section, "account_field"); hasOpt {
+		src.AccountField, _ = c.GetString(section, "account_field")
+	}
+	if hasOpt = c.HasOption(section, "subject_field"); hasOpt {
+		src.SubjectField, _ = c.GetString(section, "subject_field")
+	}
+	if hasOpt = c.HasOption(section, "destination_field"); hasOpt {
+		src.DestinationField, _ = c.GetString(section, "destination_field")
+	}
+	if hasOpt = c.HasOption(section, "answer_time_field"); hasOpt {
+		src.AnswerTimeField, _ = c.GetString(section, "answer_time_field")
+	}
+	if hasOpt = c.HasOption(section, "duration_field"); hasOpt {
+		src.DurationField, _ = c.GetString(section, "duration_field")
+	}
+	if hasOpt = c.HasOption(section, "extra_fields"); hasOpt {
+		if src.ExtraFields, errParse = ConfigSlice(c, section, "extra_fields"); errParse != nil {
+			return nil, errParse
+		}
+	}
+	return src, nil
+}
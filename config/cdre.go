@@ -0,0 +1,108 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"github.com/cgrates/cgrates/utils"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Prefix of the sections configuring the CDR export templates, eg: [cdre_billing]
+const CDRE_TEMPLATE_PREFIX = "cdre_"
+
+// Layout of the files written by the CDR exporter.
+// Fields are name:template pairs where template is a CDR field (eg: account, answer_time, cost), an extra field or ^value for constants.
+type CdreTemplate struct {
+	Name                 string
+	CdrFormat            string   // Format of the files: <csv|fwv|jsonl>.
+	ExportDir            string   // Absolute path where the exported files are written.
+	FieldSeparator       string   // Separator of the csv fields.
+	CostRoundingDecimals int      // Number of decimals the cost is rounded to.
+	CostRoundingMethod   string   // Rounding method of the cost: <*up|*middle|*down>.
+	AnswerTimeLayout     string   // Go layout of the answer time.
+	Fields               []string // Exported fields, in order, as name:template pairs.
+	FieldWidths          []int    // Widths of the fixed width fields, in the same order as the fields.
+}
+
+func NewDefaultCdreTemplate(name string) *CdreTemplate {
+	return &CdreTemplate{
+		Name:                 name,
+		CdrFormat:            utils.CDRE_CSV,
+		ExportDir:            path.Join("/var/log/cgrates/cdre", name),
+		FieldSeparator:       ",",
+		CostRoundingDecimals: 4,
+		CostRoundingMethod:   utils.ROUNDING_MIDDLE,
+		AnswerTimeLayout:     time.RFC3339,
+		Fields: []string{"cgrid:cgrid", "accid:accid", "reqtype:reqtype", "direction:direction", "tenant:tenant", "tor:tor",
+			"account:account", "subject:subject", "destination:destination", "answer_time:answer_time", "duration:duration", "cost:cost"},
+		FieldWidths: []int{},
+	}
+}
+
+// Loads the [cdre_<name>] section over the defaults
+func loadCdreTemplate(c *conf.ConfigFile, name string) (*CdreTemplate, error) {
+	section := CDRE_TEMPLATE_PREFIX + name
+	if !c.HasSection(section) {
+		return nil, fmt.Errorf("Missing configuration section [%s]", section)
+	}
+	tpl := NewDefaultCdreTemplate(name)
+	var hasOpt bool
+	var errParse error
+	if hasOpt = c.HasOption(section, "cdr_format"); hasOpt {
+		tpl.CdrFormat, _ = c.GetString(section, "cdr_format")
+	}
+	if hasOpt = c.HasOption(section, "export_dir"); hasOpt {
+		tpl.ExportDir, _ = c.GetString(section, "export_dir")
+	}
+	if hasOpt = c.HasOption(section, "field_separator"); hasOpt {
+		tpl.FieldSeparator, _ = c.GetString(section, "field_separator")
+	}
+	if hasOpt = c.HasOption(section, "cost_rounding_decimals"); hasOpt {
+		tpl.CostRoundingDecimals, _ = c.GetInt(section, "cost_rounding_decimals")
+	}
+	if hasOpt = c.HasOption(section, "cost_rounding_method"); hasOpt {
+		tpl.CostRoundingMethod, _ = c.GetString(section, "cost_rounding_method")
+	}
+	if hasOpt = c.HasOption(section, "answer_time_layout"); hasOpt {
+		tpl.AnswerTimeLayout, _ = c.GetString(section, "answer_time_layout")
+	}
+	if hasOpt = c.HasOption(section, "fields"); hasOpt {
+		if tpl.Fields, errParse = ConfigSlice(c, section, "fields"); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption(section, "field_widths"); hasOpt {
+		widths, errParse := ConfigSlice(c, section, "field_widths")
+		if errParse != nil {
+			return nil, errParse
+		}
+		for _, widthStr := range widths {
+			width, errConv := strconv.Atoi(widthStr)
+			if errConv != nil {
+				return nil, fmt.Errorf("Field widths in [%s] must be ints", section)
+			}
+			tpl.FieldWidths = append(tpl.FieldWidths, width)
+		}
+	}
+	return tpl, nil
+}
@@ -35,69 +35,125 @@ const (
 	REDIS    = "redis"
 	SAME     = "same"
 	FS       = "freeswitch"
+	KAMAILIO = "kamailio"
+	OSIPS    = "opensips"
 )
 
 // Holds system configuration, defaults are overwritten with values from config file if found
 type CGRConfig struct {
-	DataDBType               string
-	DataDBHost               string // The host to connect to. Values that start with / are for UNIX domain sockets.
-	DataDBPort               string // The port to bind to.
-	DataDBName               string // The name of the database to connect to.
-	DataDBUser               string // The user to sign in as.
-	DataDBPass               string // The user's password.
-	StorDBType               string // Should reflect the database type used to store logs
-	StorDBHost               string // The host to connect to. Values that start with / are for UNIX domain sockets.
-	StorDBPort               string // The port to bind to.
-	StorDBName               string // The name of the database to connect to.
-	StorDBUser               string // The user to sign in as.
-	StorDBPass               string // The user's password.
-	RPCEncoding              string // RPC encoding used on APIs: <gob|json>.
-	DefaultReqType           string // Use this request type if not defined on top
-	DefaultTOR               string // set default type of record
-	DefaultTenant            string // set default tenant
-	DefaultSubject           string // set default rating subject, useful in case of fallback
-	RoundingMethod           string // Rounding method for the end price: <*up|*middle|*down>
-	RoundingDecimals         int    // Number of decimals to round end prices at
-	RaterEnabled             bool   // start standalone server (no balancer)
-	RaterBalancer            string // balancer address host:port
-	RaterListen              string // listening address host:port
-	BalancerEnabled          bool
-	BalancerListen           string // Json RPC server address
-	SchedulerEnabled         bool
-	CDRSEnabled              bool     // Enable CDR Server service
-	CDRSListen               string   // CDRS's listening interface: <x.y.z.y:1234>.
-	CDRSExtraFields          []string //Extra fields to store in CDRs
-	CDRSMediator             string   // Address where to reach the Mediator. Empty for disabling mediation. <""|internal>
-	SMEnabled                bool
-	SMSwitchType             string
-	SMRater                  string   // address where to access rater. Can be internal, direct rater address or the address of a balancer
-	SMRaterReconnects        int      // Number of reconnect attempts to rater
-	SMDebitInterval          int      // the period to be debited in advanced during a call (in seconds)
-	MediatorEnabled          bool     // Starts Mediator service: <true|false>.
-	MediatorListen           string   // Mediator's listening interface: <internal>.
-	MediatorRater            string   // Address where to reach the Rater: <internal|x.y.z.y:1234>
-	MediatorRaterReconnects  int      // Number of reconnects to rater before giving up.
-	MediatorCDRType          string   // CDR type <freeswitch_http_json|freeswitch_file_csv>.
-	MediatorAccIdField       string   // Name of field identifying accounting id used during mediation. Use index number in case of .csv cdrs.
-	MediatorSubjectFields    []string // Name of subject fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorReqTypeFields    []string // Name of request type fields to be used during mediation. Use index number in case of .csv cdrs.
-	MediatorDirectionFields  []string // Name of direction fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorTenantFields     []string // Name of tenant fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorTORFields        []string // Name of tor fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorAccountFields    []string // Name of account fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorDestFields       []string // Name of destination fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorTimeAnswerFields []string // Name of time_start fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorDurationFields   []string // Name of duration fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorCDRInDir         string   // Absolute path towards the directory where the CDRs are kept (file stored CDRs).
-	MediatorCDROutDir        string   // Absolute path towards the directory where processed CDRs will be exported (file stored CDRs).
-	FreeswitchServer         string   // freeswitch address host:port
-	FreeswitchPass           string   // FS socket password
-	FreeswitchReconnects     int      // number of times to attempt reconnect after connect fails
-	HistoryAgentEnabled      bool     // Starts History as an agent: <true|false>.
-	HistoryServerEnabled     bool     // Starts History as server: <true|false>.
-	HistoryServer            string   // Address where to reach the master history server: <internal|x.y.z.y:1234>
-	HistoryListen            string   // History server listening interface: <internal|x.y.z.y:1234>
-	HistoryPath              string   // Location on disk where to store history files.
+	DataDBType                   string
+	DataDBHost                   string // The host to connect to. Values that start with / are for UNIX domain sockets.
+	DataDBPort                   string // The port to bind to.
+	DataDBName                   string // The name of the database to connect to.
+	DataDBUser                   string // The user to sign in as.
+	DataDBPass                   string // The user's password.
+	StorDBType                   string // Should reflect the database type used to store logs
+	StorDBHost                   string // The host to connect to. Values that start with / are for UNIX domain sockets.
+	StorDBPort                   string // The port to bind to.
+	StorDBName                   string // The name of the database to connect to.
+	StorDBUser                   string // The user to sign in as.
+	StorDBPass                   string // The user's password.
+	RPCEncoding                  string // RPC encoding used on APIs: <gob|json>.
+	DefaultReqType               string // Use this request type if not defined on top
+	DefaultTOR                   string // set default type of record
+	DefaultTenant                string // set default tenant
+	DefaultSubject               string // set default rating subject, useful in case of fallback
+	RoundingMethod               string // Rounding method for the end price: <*up|*middle|*down>
+	RoundingDecimals             int    // Number of decimals to round end prices at
+	RaterEnabled                 bool   // start standalone server (no balancer)
+	RaterBalancer                string // balancer address host:port
+	RaterListen                  string // listening address host:port
+	BalancerEnabled              bool
+	BalancerListen               string // Json RPC server address
+	SchedulerEnabled             bool
+	SchedulerCatchUpWindow       int      // Execute action timings missed during downtime if not older than this (in seconds), 0 to disable
+	SchedulerLeaderLease         int      // Lease on the dataDb leader lock (in seconds) when running several schedulers, 0 to disable
+	CDRSEnabled                  bool     // Enable CDR Server service
+	CDRSListen                   string   // CDRS's listening interface: <x.y.z.y:1234>.
+	CDRSExtraFields              []string //Extra fields to store in CDRs
+	CDRSMediator                 string   // Address where to reach the Mediator. Empty for disabling mediation. <""|internal|x.y.z.y:1234>
+	CDRSMediatorReconnects       int      // Number of reconnects to a remote mediator before queueing the CDR.
+	CDRSMediatorQueue            string   // File keeping the CDRs not yet delivered to a remote mediator, empty to keep them in memory only.
+	CDRSDedupFields              []string // Extra fields which together with the CgrId identify duplicate CDRs.
+	SMEnabled                    bool
+	SMSwitchType                 string
+	SMRater                      string   // address where to access rater. Can be internal, direct rater address or the address of a balancer
+	SMRaterReconnects            int      // Number of reconnect attempts to rater
+	SMDebitInterval              int      // the period to be debited in advanced during a call (in seconds)
+	SMListen                     string   // address where to serve the SessionManagerV1 api, empty to disable it
+	SMMaxCallsAccount            int      // maximum number of concurrent sessions per account, 0 for unlimited
+	SMMaxCallsTenant             int      // maximum number of concurrent sessions per tenant, 0 for unlimited
+	SMChannelSyncInterval        int      // seconds between checking the sessions against the switch's active channels, 0 to disable
+	SMMaxCallDuration            int      // seconds after which calls are disconnected, 0 for unlimited
+	SMLowBalanceThreshold        int      // warn prepaid sessions once when the balance covers less than these seconds, 0 to disable
+	MediatorEnabled              bool     // Starts Mediator service: <true|false>.
+	MediatorListen               string   // Address where to serve the mediator api to remote CDR servers, empty to disable it.
+	MediatorRater                string   // Address where to reach the Rater: <internal|x.y.z.y:1234>
+	MediatorRaterReconnects      int      // Number of reconnects to rater before giving up.
+	MediatorCDRType              string   // CDR type <freeswitch_http_json|freeswitch_file_csv>.
+	MediatorAccIdField           string   // Name of field identifying accounting id used during mediation. Use index number in case of .csv cdrs.
+	MediatorSubjectFields        []string // Name of subject fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorReqTypeFields        []string // Name of request type fields to be used during mediation. Use index number in case of .csv cdrs.
+	MediatorDirectionFields      []string // Name of direction fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorTenantFields         []string // Name of tenant fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorTORFields            []string // Name of tor fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorAccountFields        []string // Name of account fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorDestFields           []string // Name of destination fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorTimeAnswerFields     []string // Name of time_start fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorDurationFields       []string // Name of duration fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorCDRInDir             string   // Absolute path towards the directory where the CDRs are kept (file stored CDRs).
+	MediatorCDROutDir            string   // Absolute path towards the directory where processed CDRs will be exported (file stored CDRs).
+	FreeswitchServers            []string // freeswitch addresses host:port, one event socket connection for each
+	FreeswitchPass               string   // FS socket password
+	FreeswitchReconnects         int      // number of times to attempt reconnect after connect fails
+	FreeswitchLowBalancePrompt   string   // sound played to the calls running out of balance, empty only sets cgr_notify
+	KamailioEvApiAddr            string   // address of kamailio's evapi socket host:port
+	KamailioReconnects           int      // number of times to attempt reconnect after connect fails
+	OsipsListenUdp               string   // address where to listen for the opensips event datagrams
+	OsipsMiAddr                  string   // address of opensips' mi_datagram socket
+	OsipsEvSubsInterval          int      // refresh the event subscriptions at this interval (in seconds)
+	DiameterAgentEnabled         bool     // starts Diameter agent: <true|false>
+	DiameterAgentListen          string   // address where to listen for diameter peers
+	DiameterAgentRater           string   // address where to reach the Rater: <internal|x.y.z.y:1234>
+	DiameterAgentRaterReconnects int      // number of reconnects to rater before giving up
+	DiameterAgentDebitInterval   int      // seconds granted when the request does not specify the units
+	DiameterAgentOriginHost      string   // our Origin-Host
+	DiameterAgentOriginRealm     string   // our Origin-Realm
+	DiameterAgentVendorId        int      // our Vendor-Id
+	DiameterAgentProductName     string   // our Product-Name
+	DiameterAgentDirectionField  string   // avp path to the direction, empty for the default one
+	DiameterAgentTenantField     string   // avp path to the tenant, empty for the default one
+	DiameterAgentTORField        string   // avp path to the tor, empty for the default one
+	DiameterAgentAccountField    string   // avp path to the account, grouped avps separated by >
+	DiameterAgentSubjectField    string   // avp path to the subject, empty to use the account
+	DiameterAgentDestField       string   // avp path to the destination, grouped avps separated by >
+	RadiusAgentEnabled           bool     // starts RADIUS agent: <true|false>
+	RadiusAgentListenAuth        string   // address where to listen for authorization requests
+	RadiusAgentListenAcct        string   // address where to listen for accounting requests
+	RadiusAgentSecret            string   // secret shared with the NAS
+	RadiusAgentRater             string   // address where to reach the Rater: <internal|x.y.z.y:1234>
+	RadiusAgentRaterReconnects   int      // number of reconnects to rater before giving up
+	RadiusAgentCdrs              string   // address where to reach the CDR Server: <internal|x.y.z.y:1234>
+	RadiusAgentMaxSessionTime    int      // upper limit of the Session-Timeout returned (in seconds)
+	RadiusAgentDictionary        string   // path towards an extra dictionary in FreeRADIUS format, empty for the built-in attributes only
+	RadiusAgentDirectionField    string   // attribute holding the direction, empty for the default one
+	RadiusAgentTenantField       string   // attribute holding the tenant, empty for the default one
+	RadiusAgentTORField          string   // attribute holding the tor, empty for the default one
+	RadiusAgentReqTypeField      string   // attribute holding the request type, empty for the default one
+	RadiusAgentAccountField      string   // attribute holding the account
+	RadiusAgentSubjectField      string   // attribute holding the subject, empty to use the account
+	RadiusAgentDestField         string   // attribute holding the destination
+	HistoryAgentEnabled          bool     // Starts History as an agent: <true|false>.
+	HistoryServerEnabled         bool     // Starts History as server: <true|false>.
+	HistoryServer                string   // Address where to reach the master history server: <internal|x.y.z.y:1234>
+	HistoryListen                string   // History server listening interface: <internal|x.y.z.y:1234>
+	HistoryPath                  string   // Location on disk where to store history files.
+
+	CdrcEnabled bool          // Start the CDR client service: <true|false>.
+	CdrcCdrs    string        // Address where to reach the CDR Server: <internal|x.y.z.y:1234>.
+	CdrcSources []*CdrcSource // Folders watched for CDR files, each one configured in its own [cdrc_<name>] section.
+
+	CdreTemplates []*CdreTemplate // Layouts of the CDR exports, each one configured in its own [cdre_<name>] section.
 }
 
 func (self *CGRConfig) setDefaults() error {
@@ -126,12 +182,21 @@ func (self *CGRConfig) setDefaults() error {
 	self.BalancerEnabled = false
 	self.BalancerListen = "127.0.0.1:2013"
 	self.SchedulerEnabled = false
+	self.SchedulerCatchUpWindow = 0
+	self.SchedulerLeaderLease = 0
 	self.CDRSEnabled = false
 	self.CDRSListen = "127.0.0.1:2022"
 	self.CDRSExtraFields = []string{}
 	self.CDRSMediator = ""
+	self.CDRSMediatorReconnects = 3
+	self.CDRSMediatorQueue = "/var/spool/cgrates/cdrs/mediator_queue.json"
+	self.CDRSDedupFields = []string{}
+	self.CdrcEnabled = false
+	self.CdrcCdrs = INTERNAL
+	self.CdrcSources = []*CdrcSource{}
+	self.CdreTemplates = []*CdreTemplate{}
 	self.MediatorEnabled = false
-	self.MediatorListen = "127.0.0.1:2032"
+	self.MediatorListen = ""
 	self.MediatorRater = "127.0.0.1:2012"
 	self.MediatorRaterReconnects = 3
 	self.MediatorCDRType = utils.FSCDR_HTTP_JSON
@@ -152,9 +217,52 @@ func (self *CGRConfig) setDefaults() error {
 	self.SMRater = "127.0.0.1:2012"
 	self.SMRaterReconnects = 3
 	self.SMDebitInterval = 10
-	self.FreeswitchServer = "127.0.0.1:8021"
+	self.SMListen = ""
+	self.SMMaxCallsAccount = 0
+	self.SMMaxCallsTenant = 0
+	self.SMChannelSyncInterval = 0
+	self.SMMaxCallDuration = 0
+	self.SMLowBalanceThreshold = 0
+	self.FreeswitchServers = []string{"127.0.0.1:8021"}
 	self.FreeswitchPass = "ClueCon"
 	self.FreeswitchReconnects = 5
+	self.FreeswitchLowBalancePrompt = ""
+	self.KamailioEvApiAddr = "127.0.0.1:8448"
+	self.KamailioReconnects = 5
+	self.OsipsListenUdp = "127.0.0.1:2020"
+	self.OsipsMiAddr = "127.0.0.1:8020"
+	self.OsipsEvSubsInterval = 60
+	self.DiameterAgentEnabled = false
+	self.DiameterAgentListen = "127.0.0.1:3868"
+	self.DiameterAgentRater = "internal"
+	self.DiameterAgentRaterReconnects = 3
+	self.DiameterAgentDebitInterval = 10
+	self.DiameterAgentOriginHost = "CGR-DA"
+	self.DiameterAgentOriginRealm = "cgrates.org"
+	self.DiameterAgentVendorId = 0
+	self.DiameterAgentProductName = "CGRateS"
+	self.DiameterAgentDirectionField = ""
+	self.DiameterAgentTenantField = ""
+	self.DiameterAgentTORField = ""
+	self.DiameterAgentAccountField = "Subscription-Id>Subscription-Id-Data"
+	self.DiameterAgentSubjectField = "Subscription-Id>Subscription-Id-Data"
+	self.DiameterAgentDestField = "Service-Information>IMS-Information>Called-Party-Address"
+	self.RadiusAgentEnabled = false
+	self.RadiusAgentListenAuth = "127.0.0.1:1812"
+	self.RadiusAgentListenAcct = "127.0.0.1:1813"
+	self.RadiusAgentSecret = "CGRateS.org"
+	self.RadiusAgentRater = "internal"
+	self.RadiusAgentRaterReconnects = 3
+	self.RadiusAgentCdrs = INTERNAL
+	self.RadiusAgentMaxSessionTime = 3600
+	self.RadiusAgentDictionary = ""
+	self.RadiusAgentDirectionField = ""
+	self.RadiusAgentTenantField = ""
+	self.RadiusAgentTORField = ""
+	self.RadiusAgentReqTypeField = ""
+	self.RadiusAgentAccountField = "User-Name"
+	self.RadiusAgentSubjectField = "User-Name"
+	self.RadiusAgentDestField = "Called-Station-Id"
 	self.HistoryAgentEnabled = false
 	self.HistoryServerEnabled = false
 	self.HistoryServer = "127.0.0.1:2013"
@@ -267,6 +375,12 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("scheduler", "enabled"); hasOpt {
 		cfg.SchedulerEnabled, _ = c.GetBool("scheduler", "enabled")
 	}
+	if hasOpt = c.HasOption("scheduler", "catchup_window"); hasOpt {
+		cfg.SchedulerCatchUpWindow, _ = c.GetInt("scheduler", "catchup_window")
+	}
+	if hasOpt = c.HasOption("scheduler", "leader_lease"); hasOpt {
+		cfg.SchedulerLeaderLease, _ = c.GetInt("scheduler", "leader_lease")
+	}
 	if hasOpt = c.HasOption("cdrs", "enabled"); hasOpt {
 		cfg.CDRSEnabled, _ = c.GetBool("cdrs", "enabled")
 	}
@@ -281,6 +395,49 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("cdrs", "mediator"); hasOpt {
 		cfg.CDRSMediator, _ = c.GetString("cdrs", "mediator")
 	}
+	if hasOpt = c.HasOption("cdrs", "mediator_reconnects"); hasOpt {
+		cfg.CDRSMediatorReconnects, _ = c.GetInt("cdrs", "mediator_reconnects")
+	}
+	if hasOpt = c.HasOption("cdrs", "mediator_queue"); hasOpt {
+		cfg.CDRSMediatorQueue, _ = c.GetString("cdrs", "mediator_queue")
+	}
+	if hasOpt = c.HasOption("cdrs", "dedup_fields"); hasOpt {
+		if cfg.CDRSDedupFields, errParse = ConfigSlice(c, "cdrs", "dedup_fields"); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("cdrc", "enabled"); hasOpt {
+		cfg.CdrcEnabled, _ = c.GetBool("cdrc", "enabled")
+	}
+	if hasOpt = c.HasOption("cdrc", "cdrs"); hasOpt {
+		cfg.CdrcCdrs, _ = c.GetString("cdrc", "cdrs")
+	}
+	if hasOpt = c.HasOption("cdrc", "sources"); hasOpt {
+		srcNames, errParse := ConfigSlice(c, "cdrc", "sources")
+		if errParse != nil {
+			return nil, errParse
+		}
+		for _, srcName := range srcNames {
+			src, errSrc := loadCdrcSource(c, srcName)
+			if errSrc != nil {
+				return nil, errSrc
+			}
+			cfg.CdrcSources = append(cfg.CdrcSources, src)
+		}
+	}
+	if hasOpt = c.HasOption("cdre", "templates"); hasOpt {
+		tplNames, errParse := ConfigSlice(c, "cdre", "templates")
+		if errParse != nil {
+			return nil, errParse
+		}
+		for _, tplName := range tplNames {
+			tpl, errTpl := loadCdreTemplate(c, tplName)
+			if errTpl != nil {
+				return nil, errTpl
+			}
+			cfg.CdreTemplates = append(cfg.CdreTemplates, tpl)
+		}
+	}
 	if hasOpt = c.HasOption("mediator", "enabled"); hasOpt {
 		cfg.MediatorEnabled, _ = c.GetBool("mediator", "enabled")
 	}
@@ -365,8 +522,28 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("session_manager", "debit_interval"); hasOpt {
 		cfg.SMDebitInterval, _ = c.GetInt("session_manager", "debit_interval")
 	}
+	if hasOpt = c.HasOption("session_manager", "listen"); hasOpt {
+		cfg.SMListen, _ = c.GetString("session_manager", "listen")
+	}
+	if hasOpt = c.HasOption("session_manager", "max_calls_account"); hasOpt {
+		cfg.SMMaxCallsAccount, _ = c.GetInt("session_manager", "max_calls_account")
+	}
+	if hasOpt = c.HasOption("session_manager", "max_calls_tenant"); hasOpt {
+		cfg.SMMaxCallsTenant, _ = c.GetInt("session_manager", "max_calls_tenant")
+	}
+	if hasOpt = c.HasOption("session_manager", "channel_sync_interval"); hasOpt {
+		cfg.SMChannelSyncInterval, _ = c.GetInt("session_manager", "channel_sync_interval")
+	}
+	if hasOpt = c.HasOption("session_manager", "max_call_duration"); hasOpt {
+		cfg.SMMaxCallDuration, _ = c.GetInt("session_manager", "max_call_duration")
+	}
+	if hasOpt = c.HasOption("session_manager", "low_balance_threshold"); hasOpt {
+		cfg.SMLowBalanceThreshold, _ = c.GetInt("session_manager", "low_balance_threshold")
+	}
 	if hasOpt = c.HasOption("freeswitch", "server"); hasOpt {
-		cfg.FreeswitchServer, _ = c.GetString("freeswitch", "server")
+		if cfg.FreeswitchServers, errParse = ConfigSlice(c, "freeswitch", "server"); errParse != nil {
+			return nil, errParse
+		}
 	}
 	if hasOpt = c.HasOption("freeswitch", "passwd"); hasOpt {
 		cfg.FreeswitchPass, _ = c.GetString("freeswitch", "passwd")
@@ -374,6 +551,117 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("freeswitch", "reconnects"); hasOpt {
 		cfg.FreeswitchReconnects, _ = c.GetInt("freeswitch", "reconnects")
 	}
+	if hasOpt = c.HasOption("freeswitch", "low_balance_prompt"); hasOpt {
+		cfg.FreeswitchLowBalancePrompt, _ = c.GetString("freeswitch", "low_balance_prompt")
+	}
+	if hasOpt = c.HasOption("kamailio", "evapi_addr"); hasOpt {
+		cfg.KamailioEvApiAddr, _ = c.GetString("kamailio", "evapi_addr")
+	}
+	if hasOpt = c.HasOption("kamailio", "reconnects"); hasOpt {
+		cfg.KamailioReconnects, _ = c.GetInt("kamailio", "reconnects")
+	}
+	if hasOpt = c.HasOption("opensips", "listen_udp"); hasOpt {
+		cfg.OsipsListenUdp, _ = c.GetString("opensips", "listen_udp")
+	}
+	if hasOpt = c.HasOption("opensips", "mi_addr"); hasOpt {
+		cfg.OsipsMiAddr, _ = c.GetString("opensips", "mi_addr")
+	}
+	if hasOpt = c.HasOption("opensips", "events_subscribe_interval"); hasOpt {
+		cfg.OsipsEvSubsInterval, _ = c.GetInt("opensips", "events_subscribe_interval")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "enabled"); hasOpt {
+		cfg.DiameterAgentEnabled, _ = c.GetBool("diameter_agent", "enabled")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "listen"); hasOpt {
+		cfg.DiameterAgentListen, _ = c.GetString("diameter_agent", "listen")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "rater"); hasOpt {
+		cfg.DiameterAgentRater, _ = c.GetString("diameter_agent", "rater")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "rater_reconnects"); hasOpt {
+		cfg.DiameterAgentRaterReconnects, _ = c.GetInt("diameter_agent", "rater_reconnects")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "debit_interval"); hasOpt {
+		cfg.DiameterAgentDebitInterval, _ = c.GetInt("diameter_agent", "debit_interval")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "origin_host"); hasOpt {
+		cfg.DiameterAgentOriginHost, _ = c.GetString("diameter_agent", "origin_host")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "origin_realm"); hasOpt {
+		cfg.DiameterAgentOriginRealm, _ = c.GetString("diameter_agent", "origin_realm")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "vendor_id"); hasOpt {
+		cfg.DiameterAgentVendorId, _ = c.GetInt("diameter_agent", "vendor_id")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "product_name"); hasOpt {
+		cfg.DiameterAgentProductName, _ = c.GetString("diameter_agent", "product_name")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "direction_field"); hasOpt {
+		cfg.DiameterAgentDirectionField, _ = c.GetString("diameter_agent", "direction_field")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "tenant_field"); hasOpt {
+		cfg.DiameterAgentTenantField, _ = c.GetString("diameter_agent", "tenant_field")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "tor_field"); hasOpt {
+		cfg.DiameterAgentTORField, _ = c.GetString("diameter_agent", "tor_field")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "account_field"); hasOpt {
+		cfg.DiameterAgentAccountField, _ = c.GetString("diameter_agent", "account_field")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "subject_field"); hasOpt {
+		cfg.DiameterAgentSubjectField, _ = c.GetString("diameter_agent", "subject_field")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "destination_field"); hasOpt {
+		cfg.DiameterAgentDestField, _ = c.GetString("diameter_agent", "destination_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "enabled"); hasOpt {
+		cfg.RadiusAgentEnabled, _ = c.GetBool("radius_agent", "enabled")
+	}
+	if hasOpt = c.HasOption("radius_agent", "listen_auth"); hasOpt {
+		cfg.RadiusAgentListenAuth, _ = c.GetString("radius_agent", "listen_auth")
+	}
+	if hasOpt = c.HasOption("radius_agent", "listen_acct"); hasOpt {
+		cfg.RadiusAgentListenAcct, _ = c.GetString("radius_agent", "listen_acct")
+	}
+	if hasOpt = c.HasOption("radius_agent", "secret"); hasOpt {
+		cfg.RadiusAgentSecret, _ = c.GetString("radius_agent", "secret")
+	}
+	if hasOpt = c.HasOption("radius_agent", "rater"); hasOpt {
+		cfg.RadiusAgentRater, _ = c.GetString("radius_agent", "rater")
+	}
+	if hasOpt = c.HasOption("radius_agent", "rater_reconnects"); hasOpt {
+		cfg.RadiusAgentRaterReconnects, _ = c.GetInt("radius_agent", "rater_reconnects")
+	}
+	if hasOpt = c.HasOption("radius_agent", "cdrs"); hasOpt {
+		cfg.RadiusAgentCdrs, _ = c.GetString("radius_agent", "cdrs")
+	}
+	if hasOpt = c.HasOption("radius_agent", "max_session_time"); hasOpt {
+		cfg.RadiusAgentMaxSessionTime, _ = c.GetInt("radius_agent", "max_session_time")
+	}
+	if hasOpt = c.HasOption("radius_agent", "dictionary"); hasOpt {
+		cfg.RadiusAgentDictionary, _ = c.GetString("radius_agent", "dictionary")
+	}
+	if hasOpt = c.HasOption("radius_agent", "direction_field"); hasOpt {
+		cfg.RadiusAgentDirectionField, _ = c.GetString("radius_agent", "direction_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "tenant_field"); hasOpt {
+		cfg.RadiusAgentTenantField, _ = c.GetString("radius_agent", "tenant_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "tor_field"); hasOpt {
+		cfg.RadiusAgentTORField, _ = c.GetString("radius_agent", "tor_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "reqtype_field"); hasOpt {
+		cfg.RadiusAgentReqTypeField, _ = c.GetString("radius_agent", "reqtype_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "account_field"); hasOpt {
+		cfg.RadiusAgentAccountField, _ = c.GetString("radius_agent", "account_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "subject_field"); hasOpt {
+		cfg.RadiusAgentSubjectField, _ = c.GetString("radius_agent", "subject_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "destination_field"); hasOpt {
+		cfg.RadiusAgentDestField, _ = c.GetString("radius_agent", "destination_field")
+	}
 	if hasOpt = c.HasOption("history_agent", "enabled"); hasOpt {
 		cfg.HistoryAgentEnabled, _ = c.GetBool("history_agent", "enabled")
 	}
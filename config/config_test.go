@@ -59,12 +59,21 @@ func TestDefaults(t *testing.T) {
 	eCfg.BalancerEnabled = false
 	eCfg.BalancerListen = "127.0.0.1:2013"
 	eCfg.SchedulerEnabled = false
+	eCfg.SchedulerCatchUpWindow = 0
+	eCfg.SchedulerLeaderLease = 0
 	eCfg.CDRSEnabled = false
 	eCfg.CDRSListen = "127.0.0.1:2022"
 	eCfg.CDRSExtraFields = []string{}
 	eCfg.CDRSMediator = ""
+	eCfg.CDRSMediatorReconnects = 3
+	eCfg.CDRSMediatorQueue = "/var/spool/cgrates/cdrs/mediator_queue.json"
+	eCfg.CDRSDedupFields = []string{}
+	eCfg.CdrcEnabled = false
+	eCfg.CdrcCdrs = INTERNAL
+	eCfg.CdrcSources = []*CdrcSource{}
+	eCfg.CdreTemplates = []*CdreTemplate{}
 	eCfg.MediatorEnabled = false
-	eCfg.MediatorListen = "127.0.0.1:2032"
+	eCfg.MediatorListen = ""
 	eCfg.MediatorRater = "127.0.0.1:2012"
 	eCfg.MediatorRaterReconnects = 3
 	eCfg.MediatorCDRType = "freeswitch_http_json"
@@ -85,9 +94,52 @@ func TestDefaults(t *testing.T) {
 	eCfg.SMRater = "127.0.0.1:2012"
 	eCfg.SMRaterReconnects = 3
 	eCfg.SMDebitInterval = 10
-	eCfg.FreeswitchServer = "127.0.0.1:8021"
+	eCfg.SMListen = ""
+	eCfg.SMMaxCallsAccount = 0
+	eCfg.SMMaxCallsTenant = 0
+	eCfg.SMChannelSyncInterval = 0
+	eCfg.SMMaxCallDuration = 0
+	eCfg.SMLowBalanceThreshold = 0
+	eCfg.FreeswitchServers = []string{"127.0.0.1:8021"}
 	eCfg.FreeswitchPass = "ClueCon"
 	eCfg.FreeswitchReconnects = 5
+	eCfg.FreeswitchLowBalancePrompt = ""
+	eCfg.KamailioEvApiAddr = "127.0.0.1:8448"
+	eCfg.KamailioReconnects = 5
+	eCfg.OsipsListenUdp = "127.0.0.1:2020"
+	eCfg.OsipsMiAddr = "127.0.0.1:8020"
+	eCfg.OsipsEvSubsInterval = 60
+	eCfg.DiameterAgentEnabled = false
+	eCfg.DiameterAgentListen = "127.0.0.1:3868"
+	eCfg.DiameterAgentRater = "internal"
+	eCfg.DiameterAgentRaterReconnects = 3
+	eCfg.DiameterAgentDebitInterval = 10
+	eCfg.DiameterAgentOriginHost = "CGR-DA"
+	eCfg.DiameterAgentOriginRealm = "cgrates.org"
+	eCfg.DiameterAgentVendorId = 0
+	eCfg.DiameterAgentProductName = "CGRateS"
+	eCfg.DiameterAgentDirectionField = ""
+	eCfg.DiameterAgentTenantField = ""
+	eCfg.DiameterAgentTORField = ""
+	eCfg.DiameterAgentAccountField = "Subscription-Id>Subscription-Id-Data"
+	eCfg.DiameterAgentSubjectField = "Subscription-Id>Subscription-Id-Data"
+	eCfg.DiameterAgentDestField = "Service-Information>IMS-Information>Called-Party-Address"
+	eCfg.RadiusAgentEnabled = false
+	eCfg.RadiusAgentListenAuth = "127.0.0.1:1812"
+	eCfg.RadiusAgentListenAcct = "127.0.0.1:1813"
+	eCfg.RadiusAgentSecret = "CGRateS.org"
+	eCfg.RadiusAgentRater = "internal"
+	eCfg.RadiusAgentRaterReconnects = 3
+	eCfg.RadiusAgentCdrs = INTERNAL
+	eCfg.RadiusAgentMaxSessionTime = 3600
+	eCfg.RadiusAgentDictionary = ""
+	eCfg.RadiusAgentDirectionField = ""
+	eCfg.RadiusAgentTenantField = ""
+	eCfg.RadiusAgentTORField = ""
+	eCfg.RadiusAgentReqTypeField = ""
+	eCfg.RadiusAgentAccountField = "User-Name"
+	eCfg.RadiusAgentSubjectField = "User-Name"
+	eCfg.RadiusAgentDestField = "Called-Station-Id"
 	eCfg.HistoryAgentEnabled = false
 	eCfg.HistoryServer = "127.0.0.1:2013"
 	eCfg.HistoryServerEnabled = false
@@ -154,10 +206,23 @@ func TestConfigFromFile(t *testing.T) {
 	eCfg.BalancerEnabled = true
 	eCfg.BalancerListen = "test"
 	eCfg.SchedulerEnabled = true
+	eCfg.SchedulerCatchUpWindow = 99
+	eCfg.SchedulerLeaderLease = 99
 	eCfg.CDRSEnabled = true
 	eCfg.CDRSListen = "test"
 	eCfg.CDRSExtraFields = []string{"test"}
 	eCfg.CDRSMediator = "test"
+	eCfg.CDRSMediatorReconnects = 99
+	eCfg.CDRSMediatorQueue = "test"
+	eCfg.CDRSDedupFields = []string{"test"}
+	eCfg.CdrcEnabled = true
+	eCfg.CdrcCdrs = "test"
+	eCfg.CdrcSources = []*CdrcSource{&CdrcSource{Name: "test", CdrFormat: "test", CdrInDir: "test", CdrArchiveDir: "test", CdrErrorDir: "test",
+		FieldSeparator: "test", HeaderLines: 99, AccIdField: "test", ReqTypeField: "test", DirectionField: "test", TenantField: "test",
+		TORField: "test", AccountField: "test", SubjectField: "test", DestinationField: "test", AnswerTimeField: "test",
+		DurationField: "test", ExtraFields: []string{"test"}}}
+	eCfg.CdreTemplates = []*CdreTemplate{&CdreTemplate{Name: "test", CdrFormat: "test", ExportDir: "test", FieldSeparator: "test",
+		CostRoundingDecimals: 99, CostRoundingMethod: "test", AnswerTimeLayout: "test", Fields: []string{"test"}, FieldWidths: []int{99}}}
 	eCfg.MediatorEnabled = true
 	eCfg.MediatorListen = "test"
 	eCfg.MediatorRater = "test"
@@ -180,9 +245,52 @@ func TestConfigFromFile(t *testing.T) {
 	eCfg.SMRater = "test"
 	eCfg.SMRaterReconnects = 99
 	eCfg.SMDebitInterval = 99
-	eCfg.FreeswitchServer = "test"
+	eCfg.SMListen = "test"
+	eCfg.SMMaxCallsAccount = 99
+	eCfg.SMMaxCallsTenant = 99
+	eCfg.SMChannelSyncInterval = 99
+	eCfg.SMMaxCallDuration = 99
+	eCfg.SMLowBalanceThreshold = 99
+	eCfg.FreeswitchServers = []string{"test"}
 	eCfg.FreeswitchPass = "test"
 	eCfg.FreeswitchReconnects = 99
+	eCfg.FreeswitchLowBalancePrompt = "test"
+	eCfg.KamailioEvApiAddr = "test"
+	eCfg.KamailioReconnects = 99
+	eCfg.OsipsListenUdp = "test"
+	eCfg.OsipsMiAddr = "test"
+	eCfg.OsipsEvSubsInterval = 99
+	eCfg.DiameterAgentEnabled = true
+	eCfg.DiameterAgentListen = "test"
+	eCfg.DiameterAgentRater = "test"
+	eCfg.DiameterAgentRaterReconnects = 99
+	eCfg.DiameterAgentDebitInterval = 99
+	eCfg.DiameterAgentOriginHost = "test"
+	eCfg.DiameterAgentOriginRealm = "test"
+	eCfg.DiameterAgentVendorId = 99
+	eCfg.DiameterAgentProductName = "test"
+	eCfg.DiameterAgentDirectionField = "test"
+	eCfg.DiameterAgentTenantField = "test"
+	eCfg.DiameterAgentTORField = "test"
+	eCfg.DiameterAgentAccountField = "test"
+	eCfg.DiameterAgentSubjectField = "test"
+	eCfg.DiameterAgentDestField = "test"
+	eCfg.RadiusAgentEnabled = true
+	eCfg.RadiusAgentListenAuth = "test"
+	eCfg.RadiusAgentListenAcct = "test"
+	eCfg.RadiusAgentSecret = "test"
+	eCfg.RadiusAgentRater = "test"
+	eCfg.RadiusAgentRaterReconnects = 99
+	eCfg.RadiusAgentCdrs = "test"
+	eCfg.RadiusAgentMaxSessionTime = 99
+	eCfg.RadiusAgentDictionary = "test"
+	eCfg.RadiusAgentDirectionField = "test"
+	eCfg.RadiusAgentTenantField = "test"
+	eCfg.RadiusAgentTORField = "test"
+	eCfg.RadiusAgentReqTypeField = "test"
+	eCfg.RadiusAgentAccountField = "test"
+	eCfg.RadiusAgentSubjectField = "test"
+	eCfg.RadiusAgentDestField = "test"
 	eCfg.HistoryAgentEnabled = true
 	eCfg.HistoryServer = "test"
 	eCfg.HistoryServerEnabled = true
@@ -0,0 +1,72 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/sessionmanager"
+)
+
+func init() {
+	commands["disconnect_account_sessions"] = &CmdDisconnectAccountSessions{}
+}
+
+// Commander implementation
+type CmdDisconnectAccountSessions struct {
+	rpcMethod string
+	rpcParams *sessionmanager.AttrDisconnectAccountSessions
+	rpcResult int
+}
+
+// name should be exec's name
+func (self *CmdDisconnectAccountSessions) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] disconnect_account_sessions <tenant> <account>")
+}
+
+// set param defaults
+func (self *CmdDisconnectAccountSessions) defaults() error {
+	self.rpcMethod = "SessionsV1.DisconnectAccountSessions"
+	self.rpcParams = &sessionmanager.AttrDisconnectAccountSessions{}
+	return nil
+}
+
+// Parses command line args and builds CmdDisconnectAccountSessions value
+func (self *CmdDisconnectAccountSessions) FromArgs(args []string) error {
+	if len(args) != 4 {
+		return errors.New(self.Usage(""))
+	}
+	// Args look OK, set defaults before going further
+	self.defaults()
+	self.rpcParams.Tenant = args[2]
+	self.rpcParams.Account = args[3]
+	return nil
+}
+
+func (self *CmdDisconnectAccountSessions) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdDisconnectAccountSessions) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdDisconnectAccountSessions) RpcResult() interface{} {
+	return &self.rpcResult
+}
@@ -0,0 +1,71 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/apier/v1"
+)
+
+func init() {
+	commands["execute_scheduled_action"] = &CmdExecuteScheduledAction{}
+}
+
+// Commander implementation
+type CmdExecuteScheduledAction struct {
+	rpcMethod string
+	rpcParams *apier.AttrScheduledActionTiming
+	rpcResult string
+}
+
+// name should be exec's name
+func (self *CmdExecuteScheduledAction) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] execute_scheduled_action <actiontimingid>")
+}
+
+// set param defaults
+func (self *CmdExecuteScheduledAction) defaults() error {
+	self.rpcMethod = "ApierV1.ExecuteScheduledActionTiming"
+	self.rpcParams = &apier.AttrScheduledActionTiming{}
+	return nil
+}
+
+// Parses command line args and builds CmdExecuteScheduledAction value
+func (self *CmdExecuteScheduledAction) FromArgs(args []string) error {
+	if len(args) < 3 {
+		return errors.New(self.Usage(""))
+	}
+	// Args look OK, set defaults before going further
+	self.defaults()
+	self.rpcParams.ActionTimingId = args[2]
+	return nil
+}
+
+func (self *CmdExecuteScheduledAction) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdExecuteScheduledAction) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdExecuteScheduledAction) RpcResult() interface{} {
+	return &self.rpcResult
+}
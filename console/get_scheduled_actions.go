@@ -0,0 +1,64 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/scheduler"
+)
+
+func init() {
+	commands["get_scheduled_actions"] = &CmdGetScheduledActions{}
+}
+
+// Commander implementation
+type CmdGetScheduledActions struct {
+	rpcMethod string
+	rpcParams string
+	rpcResult []*scheduler.ScheduledActionTiming
+}
+
+// name should be exec's name
+func (self *CmdGetScheduledActions) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] get_scheduled_actions")
+}
+
+// set param defaults
+func (self *CmdGetScheduledActions) defaults() error {
+	self.rpcMethod = "ApierV1.GetScheduledActionTimings"
+	return nil
+}
+
+// Parses command line args and builds CmdGetScheduledActions value
+func (self *CmdGetScheduledActions) FromArgs(args []string) error {
+	self.defaults()
+	return nil
+}
+
+func (self *CmdGetScheduledActions) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdGetScheduledActions) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdGetScheduledActions) RpcResult() interface{} {
+	return &self.rpcResult
+}
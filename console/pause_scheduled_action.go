@@ -0,0 +1,74 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/apier/v1"
+)
+
+func init() {
+	commands["pause_scheduled_action"] = &CmdPauseScheduledAction{name: "pause_scheduled_action", paused: true}
+	commands["resume_scheduled_action"] = &CmdPauseScheduledAction{name: "resume_scheduled_action", paused: false}
+}
+
+// Commander implementation, handles both pausing and resuming
+type CmdPauseScheduledAction struct {
+	name      string
+	paused    bool
+	rpcMethod string
+	rpcParams *apier.AttrSetScheduledActionTimingPaused
+	rpcResult string
+}
+
+// name should be exec's name
+func (self *CmdPauseScheduledAction) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] %s <actiontimingid>", self.name)
+}
+
+// set param defaults
+func (self *CmdPauseScheduledAction) defaults() error {
+	self.rpcMethod = "ApierV1.SetScheduledActionTimingPaused"
+	self.rpcParams = &apier.AttrSetScheduledActionTimingPaused{Paused: self.paused}
+	return nil
+}
+
+// Parses command line args and builds CmdPauseScheduledAction value
+func (self *CmdPauseScheduledAction) FromArgs(args []string) error {
+	if len(args) < 3 {
+		return errors.New(self.Usage(""))
+	}
+	// Args look OK, set defaults before going further
+	self.defaults()
+	self.rpcParams.ActionTimingId = args[2]
+	return nil
+}
+
+func (self *CmdPauseScheduledAction) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdPauseScheduledAction) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdPauseScheduledAction) RpcResult() interface{} {
+	return &self.rpcResult
+}
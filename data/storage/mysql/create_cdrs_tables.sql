@@ -16,7 +16,10 @@ CREATE TABLE `cdrs_primary` (
   `time_answer` datetime NOT NULL,
   `duration` int(11) NOT NULL,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `cgrid` (`cgrid`)
+  UNIQUE KEY `cgrid` (`cgrid`),
+  KEY `answer_time` (`time_answer`),
+  KEY `account_answer_time` (`tenant`,`account`,`time_answer`),
+  KEY `destination` (`destination`)
 );
 
 --
@@ -8,6 +8,8 @@ CREATE TABLE `rated_cdrs` (
   `subject` varchar(64) NOT NULL,
   `cost` double(20,4) DEFAULT NULL,
   `extra_info` text,
+  `export_time` datetime DEFAULT NULL,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `costid` (`cgrid`,`subject`)
+  UNIQUE KEY `costid` (`cgrid`,`subject`),
+  KEY `cost` (`cost`)
 );
@@ -10,6 +10,7 @@ CREATE TABLE `tp_timings` (
   `month_days` varchar(255) NOT NULL,
   `week_days` varchar(255) NOT NULL,
   `time` varchar(16) NOT NULL,
+  `cron_expr` varchar(128) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   KEY `tpid` (`tpid`),
   KEY `tpid_tag` (`tpid`,`tag`),
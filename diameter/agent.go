@@ -0,0 +1,396 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package diameter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CC-Request-Type
+	CCR_INITIAL     = 1
+	CCR_UPDATE      = 2
+	CCR_TERMINATION = 3
+	CCR_EVENT       = 4
+	// Requested-Action
+	DIRECT_DEBITING = 0
+	CHECK_BALANCE   = 2
+	// Result-Code
+	DIAMETER_SUCCESS              = 2001
+	DIAMETER_COMMAND_UNSUPPORTED  = 3001
+	DIAMETER_CREDIT_LIMIT_REACHED = 4012
+	DIAMETER_UNKNOWN_SESSION_ID   = 5002
+	DIAMETER_MISSING_AVP          = 5005
+	DIAMETER_UNABLE_TO_COMPLY     = 5012
+)
+
+// Fields of the CallDescriptor populated out of the credit control requests
+var mappedFields = []string{"direction", "tenant", "tor", "account", "subject", "destination"}
+
+// Credit control session, Diameter Session-Id pointing to the one opened on the session manager
+type ccSession struct {
+	smSessionId string
+	used        float64 // seconds reported as used so far
+}
+
+// Diameter Credit-Control (RFC 4006) server charging through the session manager api
+type DiameterAgent struct {
+	cgrCfg     *config.CGRConfig
+	connector  engine.Connector
+	loggerDb   engine.DataStorage
+	smv1       *sessionmanager.SessionManagerV1
+	fieldPaths map[string]AvpPath
+	sessions   map[string]*ccSession
+	sync.Mutex // protects the sessions
+	listener   net.Listener
+}
+
+func NewDiameterAgent(cgrCfg *config.CGRConfig, connector engine.Connector, loggerDb engine.DataStorage) (*DiameterAgent, error) {
+	da := &DiameterAgent{cgrCfg: cgrCfg, connector: connector, loggerDb: loggerDb,
+		fieldPaths: make(map[string]AvpPath), sessions: make(map[string]*ccSession)}
+	for idx, cfgPath := range []string{cgrCfg.DiameterAgentDirectionField, cgrCfg.DiameterAgentTenantField, cgrCfg.DiameterAgentTORField,
+		cgrCfg.DiameterAgentAccountField, cgrCfg.DiameterAgentSubjectField, cgrCfg.DiameterAgentDestField} {
+		if len(cfgPath) == 0 { // Populated out of defaults
+			continue
+		}
+		avpPath, err := ParseAvpPath(cfgPath)
+		if err != nil {
+			return nil, fmt.Errorf("<DiameterAgent> Invalid %s field: %s", mappedFields[idx], err.Error())
+		}
+		da.fieldPaths[mappedFields[idx]] = avpPath
+	}
+	debitInterval, _ := time.ParseDuration(fmt.Sprintf("%vs", cgrCfg.DiameterAgentDebitInterval))
+	da.smv1 = sessionmanager.NewSessionManagerV1(loggerDb, connector, debitInterval)
+	da.smv1.Connect(cgrCfg)
+	return da, nil
+}
+
+// Session manager debiting the credit control sessions, to be listed with the other ones
+func (da *DiameterAgent) SessionManager() *sessionmanager.SessionManagerV1 {
+	return da.smv1
+}
+
+// Accepts Diameter peers till Shutdown
+func (da *DiameterAgent) ListenAndServe() error {
+	if err := da.listen(); err != nil {
+		return err
+	}
+	return da.serve()
+}
+
+func (da *DiameterAgent) listen() (err error) {
+	if da.listener, err = net.Listen("tcp", da.cgrCfg.DiameterAgentListen); err != nil {
+		return err
+	}
+	engine.Logger.Info(fmt.Sprintf("<DiameterAgent> Listening for diameter peers on %v", da.listener.Addr()))
+	return nil
+}
+
+func (da *DiameterAgent) serve() error {
+	for {
+		conn, err := da.listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Accept error: %v", err))
+			continue
+		}
+		engine.Logger.Info(fmt.Sprintf("<DiameterAgent> New peer connection: %v", conn.RemoteAddr()))
+		go da.handleConn(conn)
+	}
+}
+
+func (da *DiameterAgent) Shutdown() error {
+	if da.listener != nil {
+		return da.listener.Close()
+	}
+	return nil
+}
+
+// Answers the requests of one peer, in the order received
+func (da *DiameterAgent) handleConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		m, err := ReadMessage(conn)
+		if err != nil {
+			if err.Error() != "EOF" {
+				engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Error reading from %v: %v", conn.RemoteAddr(), err))
+			}
+			return
+		}
+		if !m.IsRequest() { // our watchdogs are not sent so we do not expect answers
+			continue
+		}
+		answer := da.processMessage(m, conn.LocalAddr())
+		if _, err := conn.Write(answer.Serialize()); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Error writing to %v: %v", conn.RemoteAddr(), err))
+			return
+		}
+		if m.CommandCode == DISCONNECT_PEER {
+			return
+		}
+	}
+}
+
+func (da *DiameterAgent) processMessage(m *Message, localAddr net.Addr) *Message {
+	answer := m.Answer()
+	switch m.CommandCode {
+	case CAPABILITIES_EXCHANGE:
+		answer.AddAvp(NewAvpUnsigned32(268, 0, DIAMETER_SUCCESS))
+		da.addOrigin(answer)
+		ip := net.ParseIP("127.0.0.1")
+		if tcpAddr, canCast := localAddr.(*net.TCPAddr); canCast {
+			ip = tcpAddr.IP
+		}
+		answer.AddAvp(NewAvpAddress(257, 0, ip),
+			NewAvpUnsigned32(266, 0, uint32(da.cgrCfg.DiameterAgentVendorId)),
+			&Avp{Code: 269, Data: []byte(da.cgrCfg.DiameterAgentProductName)}, // Product-Name must not have the mandatory flag
+			NewAvpUnsigned32(258, 0, APP_CREDIT_CONTROL))
+	case DEVICE_WATCHDOG, DISCONNECT_PEER:
+		answer.AddAvp(NewAvpUnsigned32(268, 0, DIAMETER_SUCCESS))
+		da.addOrigin(answer)
+	case CREDIT_CONTROL:
+		da.processCCR(m, answer)
+	default:
+		answer.Flags |= FLAG_ERROR
+		answer.AddAvp(NewAvpUnsigned32(268, 0, DIAMETER_COMMAND_UNSUPPORTED))
+		da.addOrigin(answer)
+	}
+	return answer
+}
+
+func (da *DiameterAgent) addOrigin(answer *Message) {
+	answer.AddAvp(NewAvpString(264, 0, da.cgrCfg.DiameterAgentOriginHost), NewAvpString(296, 0, da.cgrCfg.DiameterAgentOriginRealm))
+}
+
+// Charges the credit control request, populating the answer with the granted units
+func (da *DiameterAgent) processCCR(m *Message, answer *Message) {
+	var sessionId string
+	if avp := m.FindAvp(AvpPath{avpNames["Session-Id"]}); avp != nil {
+		sessionId = avp.String()
+		answer.AddAvp(NewAvpString(263, 0, sessionId))
+	}
+	da.addOrigin(answer)
+	answer.AddAvp(NewAvpUnsigned32(258, 0, APP_CREDIT_CONTROL))
+	var ccrType, ccrNr uint32
+	if avp := m.FindAvp(AvpPath{avpNames["CC-Request-Type"]}); avp != nil {
+		ccrType, _ = avp.Unsigned32()
+		answer.AddAvp(NewAvpUnsigned32(416, 0, ccrType))
+	}
+	if avp := m.FindAvp(AvpPath{avpNames["CC-Request-Number"]}); avp != nil {
+		ccrNr, _ = avp.Unsigned32()
+		answer.AddAvp(NewAvpUnsigned32(415, 0, ccrNr))
+	}
+	if sessionId == "" || ccrType == 0 {
+		answer.AddAvp(NewAvpUnsigned32(268, 0, DIAMETER_MISSING_AVP))
+		return
+	}
+	var granted float64
+	var resultCode uint32
+	switch ccrType {
+	case CCR_INITIAL:
+		granted, resultCode = da.initiateSession(sessionId, m)
+	case CCR_UPDATE:
+		granted, resultCode = da.updateSession(sessionId, m)
+	case CCR_TERMINATION:
+		resultCode = da.terminateSession(sessionId, m)
+	case CCR_EVENT:
+		granted, resultCode = da.chargeEvent(sessionId, m)
+	default:
+		resultCode = DIAMETER_UNABLE_TO_COMPLY
+	}
+	answer.AddAvp(NewAvpUnsigned32(268, 0, resultCode))
+	if resultCode != DIAMETER_SUCCESS || ccrType == CCR_TERMINATION {
+		return
+	}
+	gsu := NewAvpGrouped(431, 0, NewAvpUnsigned32(420, 0, uint32(granted)))
+	if mscc := m.FindAvp(AvpPath{avpNames["Multiple-Services-Credit-Control"]}); mscc != nil { // answer in the same form as asked
+		answer.AddAvp(NewAvpGrouped(456, 0, gsu, NewAvpUnsigned32(268, 0, resultCode)))
+	} else {
+		answer.AddAvp(gsu)
+	}
+}
+
+func (da *DiameterAgent) initiateSession(sessionId string, m *Message) (float64, uint32) {
+	attrs, err := da.sessionAttrs(m)
+	if err != nil {
+		return 0, DIAMETER_MISSING_AVP
+	}
+	var maxUsage float64
+	if err := da.smv1.AuthorizeSession(attrs, &maxUsage); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not authorize session %s: %v", sessionId, err))
+		return 0, DIAMETER_UNABLE_TO_COMPLY
+	}
+	attrs.Usage = da.requestedUnits(m)
+	if maxUsage < attrs.Usage {
+		attrs.Usage = maxUsage
+	}
+	if attrs.Usage <= 0 {
+		return 0, DIAMETER_CREDIT_LIMIT_REACHED
+	}
+	var smSessionId string
+	if err := da.smv1.InitiateSession(attrs, &smSessionId); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not initiate session %s: %v", sessionId, err))
+		return 0, DIAMETER_CREDIT_LIMIT_REACHED
+	}
+	da.Lock()
+	da.sessions[sessionId] = &ccSession{smSessionId: smSessionId}
+	da.Unlock()
+	return attrs.Usage, DIAMETER_SUCCESS
+}
+
+func (da *DiameterAgent) updateSession(sessionId string, m *Message) (float64, uint32) {
+	da.Lock()
+	ccs, exists := da.sessions[sessionId]
+	if exists {
+		ccs.used += unitsIn(m, "Used-Service-Unit")
+	}
+	da.Unlock()
+	if !exists {
+		return 0, DIAMETER_UNKNOWN_SESSION_ID
+	}
+	var granted float64
+	if err := da.smv1.UpdateSession(sessionmanager.AttrSession{SessionId: ccs.smSessionId, Usage: da.requestedUnits(m)}, &granted); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not update session %s: %v", sessionId, err))
+		return 0, DIAMETER_UNABLE_TO_COMPLY
+	}
+	if granted == 0 {
+		return 0, DIAMETER_CREDIT_LIMIT_REACHED
+	}
+	return granted, DIAMETER_SUCCESS
+}
+
+func (da *DiameterAgent) terminateSession(sessionId string, m *Message) uint32 {
+	da.Lock()
+	ccs, exists := da.sessions[sessionId]
+	delete(da.sessions, sessionId)
+	da.Unlock()
+	if !exists {
+		return DIAMETER_UNKNOWN_SESSION_ID
+	}
+	var reply string
+	if err := da.smv1.TerminateSession(sessionmanager.AttrSession{SessionId: ccs.smSessionId,
+		Usage: ccs.used + unitsIn(m, "Used-Service-Unit")}, &reply); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not terminate session %s: %v", sessionId, err))
+		return DIAMETER_UNABLE_TO_COMPLY
+	}
+	return DIAMETER_SUCCESS
+}
+
+// One time charging, debiting directly the requested units or checking the balance for them
+func (da *DiameterAgent) chargeEvent(sessionId string, m *Message) (float64, uint32) {
+	attrs, err := da.sessionAttrs(m)
+	if err != nil {
+		return 0, DIAMETER_MISSING_AVP
+	}
+	var action uint32 = DIRECT_DEBITING
+	if avp := m.FindAvp(AvpPath{avpNames["Requested-Action"]}); avp != nil {
+		action, _ = avp.Unsigned32()
+	}
+	usage := da.requestedUnits(m)
+	switch action {
+	case DIRECT_DEBITING:
+		cd := engine.CallDescriptor{Direction: attrs.Direction, Tenant: attrs.Tenant, TOR: attrs.TOR, Subject: attrs.Subject,
+			Account: attrs.Account, Destination: attrs.Destination, TimeStart: attrs.AnswerTime, FallbackSubject: da.cgrCfg.DefaultSubject}
+		cd.TimeEnd = cd.TimeStart.Add(time.Duration(usage) * time.Second)
+		cd.CallDuration = cd.TimeEnd.Sub(cd.TimeStart)
+		cd.Amount = usage
+		cc := &engine.CallCost{}
+		if err := da.connector.Debit(cd, cc); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not debit event %s: %v", sessionId, err))
+			return 0, DIAMETER_CREDIT_LIMIT_REACHED
+		}
+		if da.loggerDb != nil {
+			da.loggerDb.LogCallCost(sessionId, engine.SESSION_MANAGER_SOURCE, cc)
+		}
+		return usage, DIAMETER_SUCCESS
+	case CHECK_BALANCE:
+		var maxUsage float64
+		if err := da.smv1.AuthorizeSession(attrs, &maxUsage); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not check balance for %s: %v", sessionId, err))
+			return 0, DIAMETER_UNABLE_TO_COMPLY
+		}
+		if maxUsage < usage {
+			return 0, DIAMETER_CREDIT_LIMIT_REACHED
+		}
+		return usage, DIAMETER_SUCCESS
+	}
+	return 0, DIAMETER_UNABLE_TO_COMPLY
+}
+
+// Populates the session data out of the avps configured, the rest out of defaults
+func (da *DiameterAgent) sessionAttrs(m *Message) (sessionmanager.AttrSession, error) {
+	vals := make(map[string]string)
+	for fieldName, avpPath := range da.fieldPaths {
+		if avp := m.FindAvp(avpPath); avp != nil {
+			vals[fieldName] = avp.String()
+		}
+	}
+	attrs := sessionmanager.AttrSession{ReqType: utils.PREPAID, // online charging
+		Direction:   utils.FirstNonEmpty(vals["direction"], engine.OUTBOUND),
+		Tenant:      utils.FirstNonEmpty(vals["tenant"], da.cgrCfg.DefaultTenant),
+		TOR:         utils.FirstNonEmpty(vals["tor"], da.cgrCfg.DefaultTOR),
+		Account:     vals["account"],
+		Subject:     utils.FirstNonEmpty(vals["subject"], vals["account"]),
+		Destination: vals["destination"],
+		AnswerTime:  time.Now(),
+	}
+	if avp := m.FindAvp(AvpPath{avpNames["Event-Timestamp"]}); avp != nil {
+		if evTime, err := avp.Time(); err == nil {
+			attrs.AnswerTime = evTime
+		}
+	}
+	attrs.SetupTime = attrs.AnswerTime
+	if attrs.Account == "" || attrs.Destination == "" {
+		return attrs, errors.New(utils.ERR_MANDATORY_IE_MISSING)
+	}
+	return attrs, nil
+}
+
+// Seconds requested, debit interval if the client leaves it to us
+func (da *DiameterAgent) requestedUnits(m *Message) float64 {
+	if units := unitsIn(m, "Requested-Service-Unit"); units != 0 {
+		return units
+	}
+	return float64(da.cgrCfg.DiameterAgentDebitInterval)
+}
+
+// CC-Time inside the service unit avp, looked up in Multiple-Services-Credit-Control first
+func unitsIn(m *Message, serviceUnit string) float64 {
+	for _, path := range []AvpPath{
+		AvpPath{avpNames["Multiple-Services-Credit-Control"], avpNames[serviceUnit], avpNames["CC-Time"]},
+		AvpPath{avpNames[serviceUnit], avpNames["CC-Time"]}} {
+		if avp := m.FindAvp(path); avp != nil {
+			if units, err := avp.Unsigned32(); err == nil {
+				return float64(units)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package diameter
+
+import (
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Grants what is asked within the credit set
+type fakeConnector struct {
+	sync.Mutex
+	credit    float64 // seconds
+	maxDebits int
+	debits    int
+	refunded  float64
+}
+
+func (fc *fakeConnector) GetCost(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	return nil
+}
+
+func (fc *fakeConnector) Debit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.debits++
+	cc.Timespans = []*engine.TimeSpan{&engine.TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeEnd}}
+	return nil
+}
+
+func (fc *fakeConnector) MaxDebit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.maxDebits++
+	granted := cd.TimeEnd.Sub(cd.TimeStart).Seconds()
+	if granted > fc.credit {
+		granted = fc.credit
+	}
+	fc.credit -= granted
+	if granted > 0 {
+		cc.Timespans = []*engine.TimeSpan{&engine.TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeStart.Add(time.Duration(granted) * time.Second),
+			MinuteInfo: &engine.MinuteInfo{Quantity: granted}}}
+	}
+	return nil
+}
+
+func (fc *fakeConnector) DebitCents(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) DebitSeconds(cd engine.CallDescriptor, reply *float64) error {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.refunded -= cd.Amount
+	return nil
+}
+
+func (fc *fakeConnector) DebitSMS(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) GetMaxSessionTime(cd engine.CallDescriptor, reply *float64) error {
+	fc.Lock()
+	defer fc.Unlock()
+	*reply = fc.credit
+	return nil
+}
+
+// Local diameter client
+type testPeer struct {
+	t      *testing.T
+	conn   net.Conn
+	hopIds uint32
+}
+
+func (tp *testPeer) request(cmdCode, appId uint32, avps ...*Avp) *Message {
+	tp.hopIds++
+	m := &Message{Flags: FLAG_REQUEST | FLAG_PROXIABLE, CommandCode: cmdCode, ApplicationId: appId, HopByHopId: tp.hopIds, EndToEndId: tp.hopIds}
+	m.AddAvp(avps...)
+	if _, err := tp.conn.Write(m.Serialize()); err != nil {
+		tp.t.Fatal("Could not send request: ", err)
+	}
+	tp.conn.SetReadDeadline(time.Now().Add(time.Second))
+	answer, err := ReadMessage(tp.conn)
+	if err != nil {
+		tp.t.Fatal("Could not read answer: ", err)
+	}
+	if answer.IsRequest() || answer.CommandCode != cmdCode || answer.HopByHopId != tp.hopIds {
+		tp.t.Fatalf("Not our answer: %+v", answer)
+	}
+	return answer
+}
+
+func (tp *testPeer) ccr(sessionId string, reqType, reqNr uint32, avps ...*Avp) *Message {
+	return tp.request(CREDIT_CONTROL, APP_CREDIT_CONTROL, append([]*Avp{NewAvpString(263, 0, sessionId),
+		NewAvpString(264, 0, "client"), NewAvpString(296, 0, "test"), NewAvpString(283, 0, "cgrates.org"),
+		NewAvpUnsigned32(258, 0, APP_CREDIT_CONTROL), NewAvpUnsigned32(416, 0, reqType), NewAvpUnsigned32(415, 0, reqNr)}, avps...)...)
+}
+
+func resultCode(m *Message) uint32 {
+	if avp := m.FindAvp(AvpPath{avpNames["Result-Code"]}); avp != nil {
+		rc, _ := avp.Unsigned32()
+		return rc
+	}
+	return 0
+}
+
+func grantedTime(m *Message, inMSCC bool) uint32 {
+	path := AvpPath{avpNames["Granted-Service-Unit"], avpNames["CC-Time"]}
+	if inMSCC {
+		path = append(AvpPath{avpNames["Multiple-Services-Credit-Control"]}, path...)
+	}
+	if avp := m.FindAvp(path); avp != nil {
+		granted, _ := avp.Unsigned32()
+		return granted
+	}
+	return 0
+}
+
+func serviceUnits(code uint32, seconds uint32) *Avp {
+	return NewAvpGrouped(code, 0, NewAvpUnsigned32(420, 0, seconds))
+}
+
+func TestDiameterAgent(t *testing.T) {
+	daCfg, _ := config.NewCGRConfigBytes([]byte("[diameter_agent]\nlisten = 127.0.0.1:0\n"))
+	connector := &fakeConnector{credit: 100}
+	storage, _ := engine.NewMapStorage()
+	da, err := NewDiameterAgent(daCfg, connector, storage)
+	if err != nil {
+		t.Fatal("Cannot create agent: ", err)
+	}
+	if err := da.listen(); err != nil {
+		t.Fatal("Cannot listen: ", err)
+	}
+	go da.serve()
+	defer da.Shutdown()
+	conn, err := net.Dial("tcp", da.listener.Addr().String())
+	if err != nil {
+		t.Fatal("Could not connect to agent: ", err)
+	}
+	defer conn.Close()
+	peer := &testPeer{t: t, conn: conn}
+
+	cea := peer.request(CAPABILITIES_EXCHANGE, APP_COMMON_MESSAGES, NewAvpString(264, 0, "client"), NewAvpString(296, 0, "test"))
+	if resultCode(cea) != DIAMETER_SUCCESS || cea.FindAvp(AvpPath{avpNames["Origin-Host"]}).String() != "CGR-DA" {
+		t.Errorf("Unexpected CEA: %+v", cea)
+	}
+	if dwa := peer.request(DEVICE_WATCHDOG, APP_COMMON_MESSAGES); resultCode(dwa) != DIAMETER_SUCCESS {
+		t.Errorf("Unexpected DWA: %+v", dwa)
+	}
+
+	subscriber := NewAvpGrouped(443, 0, NewAvpUnsigned32(450, 0, 0), NewAvpString(444, 0, "1001"))
+	called := NewAvpGrouped(873, VENDOR_3GPP, NewAvpGrouped(876, VENDOR_3GPP, NewAvpString(832, VENDOR_3GPP, "1002")))
+	evTime := NewAvpTime(55, 0, time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC))
+	cca := peer.ccr("s1", CCR_INITIAL, 0, subscriber, called, evTime, serviceUnits(437, 30))
+	if resultCode(cca) != DIAMETER_SUCCESS || grantedTime(cca, false) != 30 {
+		t.Errorf("Unexpected CCA-I, result %d, granted %d", resultCode(cca), grantedTime(cca, false))
+	}
+	if sessions := da.SessionManager().Sessions(); len(sessions) != 1 {
+		t.Error("Credit control session not listed: ", sessions)
+	}
+	// Gy style, units inside Multiple-Services-Credit-Control
+	cca = peer.ccr("s1", CCR_UPDATE, 1, NewAvpGrouped(456, 0, serviceUnits(437, 60), serviceUnits(446, 30)))
+	if resultCode(cca) != DIAMETER_SUCCESS || grantedTime(cca, true) != 60 {
+		t.Errorf("Unexpected CCA-U, result %d, granted %d", resultCode(cca), grantedTime(cca, true))
+	}
+	cca = peer.ccr("s1", CCR_UPDATE, 2, serviceUnits(437, 60), serviceUnits(446, 60))
+	if resultCode(cca) != DIAMETER_SUCCESS || grantedTime(cca, false) != 10 {
+		t.Errorf("Expecting the credit left granted, result %d, granted %d", resultCode(cca), grantedTime(cca, false))
+	}
+	cca = peer.ccr("s1", CCR_UPDATE, 3, serviceUnits(437, 60), serviceUnits(446, 5))
+	if resultCode(cca) != DIAMETER_CREDIT_LIMIT_REACHED {
+		t.Errorf("Expecting credit limit reached, got %d", resultCode(cca))
+	}
+	cca = peer.ccr("s1", CCR_TERMINATION, 4, serviceUnits(446, 0))
+	if resultCode(cca) != DIAMETER_SUCCESS {
+		t.Errorf("Unexpected CCA-T result: %d", resultCode(cca))
+	}
+	if connector.refunded != 5 {
+		t.Error("Expecting the unused 5 seconds refunded, got: ", connector.refunded)
+	}
+	if cca = peer.ccr("s1", CCR_UPDATE, 5, serviceUnits(437, 60)); resultCode(cca) != DIAMETER_UNKNOWN_SESSION_ID {
+		t.Errorf("Expecting unknown session, got %d", resultCode(cca))
+	}
+	if cca = peer.ccr("s2", CCR_INITIAL, 0, called); resultCode(cca) != DIAMETER_MISSING_AVP {
+		t.Errorf("Expecting missing avp for no account, got %d", resultCode(cca))
+	}
+	if cca = peer.ccr("s3", CCR_INITIAL, 0, subscriber, called); resultCode(cca) != DIAMETER_CREDIT_LIMIT_REACHED {
+		t.Errorf("Expecting credit limit reached without credit, got %d", resultCode(cca))
+	}
+
+	cca = peer.ccr("e1", CCR_EVENT, 0, subscriber, called, NewAvpUnsigned32(436, 0, DIRECT_DEBITING), serviceUnits(437, 1))
+	if resultCode(cca) != DIAMETER_SUCCESS || grantedTime(cca, false) != 1 || connector.debits != 1 {
+		t.Errorf("Unexpected event answer, result %d, granted %d, debits %d", resultCode(cca), grantedTime(cca, false), connector.debits)
+	}
+	if cc, err := storage.GetCallCostLog("e1", engine.SESSION_MANAGER_SOURCE); err != nil || cc == nil {
+		t.Error("Event cost not logged: ", err)
+	}
+	if dpa := peer.request(DISCONNECT_PEER, APP_COMMON_MESSAGES, NewAvpUnsigned32(273, 0, 0)); resultCode(dpa) != DIAMETER_SUCCESS {
+		t.Errorf("Unexpected DPA: %+v", dpa)
+	}
+}
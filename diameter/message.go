@@ -0,0 +1,383 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package diameter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DIAMETER_VERSION = 1
+	HEADER_LEN       = 20
+	// command flags
+	FLAG_REQUEST   = 0x80
+	FLAG_PROXIABLE = 0x40
+	FLAG_ERROR     = 0x20
+	// avp flags
+	AVP_FLAG_VENDOR    = 0x80
+	AVP_FLAG_MANDATORY = 0x40
+	// command codes
+	CAPABILITIES_EXCHANGE = 257
+	CREDIT_CONTROL        = 272
+	DEVICE_WATCHDOG       = 280
+	DISCONNECT_PEER       = 282
+	// applications
+	APP_COMMON_MESSAGES = 0
+	APP_CREDIT_CONTROL  = 4
+	VENDOR_3GPP         = 10415
+	// encoding
+	NTP_OFFSET_SECONDS    = 2208988800 // seconds between 1900 and 1970, Diameter Time is NTP based
+	MAX_MESSAGE_LEN       = 1 << 20    // refuse anything bigger
+	AVP_HEADER_LEN        = 8
+	AVP_VENDOR_HEADER_LEN = 12
+)
+
+// Diameter message as defined in RFC 6733
+type Message struct {
+	Flags         uint8
+	CommandCode   uint32
+	ApplicationId uint32
+	HopByHopId    uint32
+	EndToEndId    uint32
+	Avps          []*Avp
+}
+
+// Attribute-Value pair, Data holds the encoded value without padding
+type Avp struct {
+	Code     uint32
+	Flags    uint8
+	VendorId uint32
+	Data     []byte
+}
+
+func (m *Message) IsRequest() bool {
+	return m.Flags&FLAG_REQUEST != 0
+}
+
+// Answer skeleton for a request, keeping the identifiers of the transaction
+func (m *Message) Answer() *Message {
+	return &Message{Flags: m.Flags &^ (FLAG_REQUEST | FLAG_ERROR), CommandCode: m.CommandCode, ApplicationId: m.ApplicationId,
+		HopByHopId: m.HopByHopId, EndToEndId: m.EndToEndId}
+}
+
+func (m *Message) AddAvp(avps ...*Avp) *Message {
+	m.Avps = append(m.Avps, avps...)
+	return m
+}
+
+// Returns the first avp found following the path of codes through grouped avps, nil if not found
+func (m *Message) FindAvp(path AvpPath) *Avp {
+	return findAvp(m.Avps, path)
+}
+
+func findAvp(avps []*Avp, path AvpPath) *Avp {
+	if len(path) == 0 {
+		return nil
+	}
+	for _, avp := range avps {
+		if avp.Code != path[0].Code || avp.VendorId != path[0].VendorId {
+			continue
+		}
+		if len(path) == 1 {
+			return avp
+		}
+		if grouped, err := avp.Grouped(); err == nil {
+			if found := findAvp(grouped, path[1:]); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Message) Serialize() []byte {
+	buf := new(bytes.Buffer)
+	for _, avp := range m.Avps {
+		buf.Write(avp.Serialize())
+	}
+	hdr := make([]byte, HEADER_LEN)
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(HEADER_LEN+buf.Len()))
+	hdr[0] = DIAMETER_VERSION
+	binary.BigEndian.PutUint32(hdr[4:8], m.CommandCode)
+	hdr[4] = m.Flags
+	binary.BigEndian.PutUint32(hdr[8:12], m.ApplicationId)
+	binary.BigEndian.PutUint32(hdr[12:16], m.HopByHopId)
+	binary.BigEndian.PutUint32(hdr[16:20], m.EndToEndId)
+	return append(hdr, buf.Bytes()...)
+}
+
+// Reads one message out of a stream
+func ReadMessage(r io.Reader) (*Message, error) {
+	hdr := make([]byte, HEADER_LEN)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != DIAMETER_VERSION {
+		return nil, fmt.Errorf("unsupported diameter version: %d", hdr[0])
+	}
+	msgLen := binary.BigEndian.Uint32(hdr[0:4]) & 0xffffff
+	if msgLen < HEADER_LEN || msgLen > MAX_MESSAGE_LEN {
+		return nil, fmt.Errorf("invalid message length: %d", msgLen)
+	}
+	body := make([]byte, msgLen-HEADER_LEN)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	m := &Message{Flags: hdr[4],
+		CommandCode:   binary.BigEndian.Uint32(hdr[4:8]) & 0xffffff,
+		ApplicationId: binary.BigEndian.Uint32(hdr[8:12]),
+		HopByHopId:    binary.BigEndian.Uint32(hdr[12:16]),
+		EndToEndId:    binary.BigEndian.Uint32(hdr[16:20])}
+	var err error
+	if m.Avps, err = decodeAvps(body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func decodeAvps(data []byte) (avps []*Avp, err error) {
+	for len(data) > 0 {
+		if len(data) < AVP_HEADER_LEN {
+			return nil, errors.New("truncated avp header")
+		}
+		avp := &Avp{Code: binary.BigEndian.Uint32(data[0:4]), Flags: data[4]}
+		avpLen := int(binary.BigEndian.Uint32(data[4:8]) & 0xffffff)
+		hdrLen := AVP_HEADER_LEN
+		if avp.Flags&AVP_FLAG_VENDOR != 0 {
+			hdrLen = AVP_VENDOR_HEADER_LEN
+		}
+		if avpLen < hdrLen || avpLen > len(data) {
+			return nil, fmt.Errorf("invalid length %d for avp %d", avpLen, avp.Code)
+		}
+		if hdrLen == AVP_VENDOR_HEADER_LEN {
+			avp.VendorId = binary.BigEndian.Uint32(data[8:12])
+		}
+		avp.Data = data[hdrLen:avpLen]
+		avps = append(avps, avp)
+		if padded := (avpLen + 3) &^ 3; padded < len(data) {
+			data = data[padded:]
+		} else {
+			data = nil
+		}
+	}
+	return
+}
+
+func (avp *Avp) Serialize() []byte {
+	hdrLen := AVP_HEADER_LEN
+	if avp.VendorId != 0 {
+		hdrLen = AVP_VENDOR_HEADER_LEN
+	}
+	avpLen := hdrLen + len(avp.Data)
+	buf := make([]byte, (avpLen+3)&^3)
+	binary.BigEndian.PutUint32(buf[0:4], avp.Code)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(avpLen))
+	buf[4] = avp.Flags
+	if avp.VendorId != 0 {
+		buf[4] |= AVP_FLAG_VENDOR
+		binary.BigEndian.PutUint32(buf[8:12], avp.VendorId)
+	}
+	copy(buf[hdrLen:], avp.Data)
+	return buf
+}
+
+func NewAvpUnsigned32(code, vendorId uint32, val uint32) *Avp {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, val)
+	return &Avp{Code: code, Flags: AVP_FLAG_MANDATORY, VendorId: vendorId, Data: data}
+}
+
+func NewAvpString(code, vendorId uint32, val string) *Avp {
+	return &Avp{Code: code, Flags: AVP_FLAG_MANDATORY, VendorId: vendorId, Data: []byte(val)}
+}
+
+func NewAvpTime(code, vendorId uint32, val time.Time) *Avp {
+	return NewAvpUnsigned32(code, vendorId, uint32(val.Unix()+NTP_OFFSET_SECONDS))
+}
+
+func NewAvpAddress(code, vendorId uint32, ip net.IP) *Avp {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &Avp{Code: code, Flags: AVP_FLAG_MANDATORY, VendorId: vendorId, Data: append([]byte{0, 1}, ip4...)}
+	}
+	return &Avp{Code: code, Flags: AVP_FLAG_MANDATORY, VendorId: vendorId, Data: append([]byte{0, 2}, ip.To16()...)}
+}
+
+func NewAvpGrouped(code, vendorId uint32, avps ...*Avp) *Avp {
+	buf := new(bytes.Buffer)
+	for _, avp := range avps {
+		buf.Write(avp.Serialize())
+	}
+	return &Avp{Code: code, Flags: AVP_FLAG_MANDATORY, VendorId: vendorId, Data: buf.Bytes()}
+}
+
+// Unsigned32, Integer32 and Enumerated values
+func (avp *Avp) Unsigned32() (uint32, error) {
+	if len(avp.Data) != 4 {
+		return 0, fmt.Errorf("avp %d is not a 32 bits value", avp.Code)
+	}
+	return binary.BigEndian.Uint32(avp.Data), nil
+}
+
+func (avp *Avp) Unsigned64() (uint64, error) {
+	if len(avp.Data) != 8 {
+		return 0, fmt.Errorf("avp %d is not a 64 bits value", avp.Code)
+	}
+	return binary.BigEndian.Uint64(avp.Data), nil
+}
+
+func (avp *Avp) Time() (time.Time, error) {
+	secs, err := avp.Unsigned32()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(secs)-NTP_OFFSET_SECONDS, 0), nil
+}
+
+func (avp *Avp) Grouped() ([]*Avp, error) {
+	return decodeAvps(avp.Data)
+}
+
+// Value as text, numbers are decoded according to the dictionary type of the avp
+func (avp *Avp) String() string {
+	switch avpTypes[AvpKey{avp.Code, avp.VendorId}] {
+	case TYPE_UNSIGNED32:
+		if val, err := avp.Unsigned32(); err == nil {
+			return strconv.FormatUint(uint64(val), 10)
+		}
+	case TYPE_UNSIGNED64:
+		if val, err := avp.Unsigned64(); err == nil {
+			return strconv.FormatUint(val, 10)
+		}
+	case TYPE_TIME:
+		if val, err := avp.Time(); err == nil {
+			return val.UTC().Format(time.RFC3339)
+		}
+	}
+	return string(avp.Data)
+}
+
+// Identifies an avp definition
+type AvpKey struct {
+	Code     uint32
+	VendorId uint32
+}
+
+// Avps to follow through the grouped ones till the one holding the value
+type AvpPath []AvpKey
+
+// Parses paths like Subscription-Id>Subscription-Id-Data, elements can also be given as code or code:vendor_id
+func ParseAvpPath(path string) (AvpPath, error) {
+	var avpPath AvpPath
+	for _, elm := range strings.Split(path, ">") {
+		elm = strings.TrimSpace(elm)
+		if key, found := avpNames[elm]; found {
+			avpPath = append(avpPath, key)
+			continue
+		}
+		codeVendor := strings.SplitN(elm, ":", 2)
+		code, err := strconv.ParseUint(codeVendor[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unknown avp <%s> in path <%s>", elm, path)
+		}
+		key := AvpKey{Code: uint32(code)}
+		if len(codeVendor) == 2 {
+			vendorId, err := strconv.ParseUint(codeVendor[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid vendor id in <%s>", elm)
+			}
+			key.VendorId = uint32(vendorId)
+		}
+		avpPath = append(avpPath, key)
+	}
+	return avpPath, nil
+}
+
+const (
+	TYPE_OCTETSTRING = iota
+	TYPE_UNSIGNED32
+	TYPE_UNSIGNED64
+	TYPE_TIME
+	TYPE_GROUPED
+)
+
+// Base protocol, credit control and the 3GPP avps used in charging
+var avpDictionary = []struct {
+	name    string
+	key     AvpKey
+	avpType int
+}{
+	{"User-Name", AvpKey{1, 0}, TYPE_OCTETSTRING},
+	{"Event-Timestamp", AvpKey{55, 0}, TYPE_TIME},
+	{"Host-IP-Address", AvpKey{257, 0}, TYPE_OCTETSTRING},
+	{"Auth-Application-Id", AvpKey{258, 0}, TYPE_UNSIGNED32},
+	{"Session-Id", AvpKey{263, 0}, TYPE_OCTETSTRING},
+	{"Origin-Host", AvpKey{264, 0}, TYPE_OCTETSTRING},
+	{"Supported-Vendor-Id", AvpKey{265, 0}, TYPE_UNSIGNED32},
+	{"Vendor-Id", AvpKey{266, 0}, TYPE_UNSIGNED32},
+	{"Firmware-Revision", AvpKey{267, 0}, TYPE_UNSIGNED32},
+	{"Result-Code", AvpKey{268, 0}, TYPE_UNSIGNED32},
+	{"Product-Name", AvpKey{269, 0}, TYPE_OCTETSTRING},
+	{"Disconnect-Cause", AvpKey{273, 0}, TYPE_UNSIGNED32},
+	{"Origin-State-Id", AvpKey{278, 0}, TYPE_UNSIGNED32},
+	{"Error-Message", AvpKey{281, 0}, TYPE_OCTETSTRING},
+	{"Destination-Realm", AvpKey{283, 0}, TYPE_OCTETSTRING},
+	{"Destination-Host", AvpKey{293, 0}, TYPE_OCTETSTRING},
+	{"Termination-Cause", AvpKey{295, 0}, TYPE_UNSIGNED32},
+	{"Origin-Realm", AvpKey{296, 0}, TYPE_OCTETSTRING},
+	{"CC-Request-Number", AvpKey{415, 0}, TYPE_UNSIGNED32},
+	{"CC-Request-Type", AvpKey{416, 0}, TYPE_UNSIGNED32},
+	{"CC-Time", AvpKey{420, 0}, TYPE_UNSIGNED32},
+	{"CC-Total-Octets", AvpKey{421, 0}, TYPE_UNSIGNED64},
+	{"Granted-Service-Unit", AvpKey{431, 0}, TYPE_GROUPED},
+	{"Rating-Group", AvpKey{432, 0}, TYPE_UNSIGNED32},
+	{"Requested-Action", AvpKey{436, 0}, TYPE_UNSIGNED32},
+	{"Requested-Service-Unit", AvpKey{437, 0}, TYPE_GROUPED},
+	{"Service-Identifier", AvpKey{439, 0}, TYPE_UNSIGNED32},
+	{"Subscription-Id", AvpKey{443, 0}, TYPE_GROUPED},
+	{"Subscription-Id-Data", AvpKey{444, 0}, TYPE_OCTETSTRING},
+	{"Used-Service-Unit", AvpKey{446, 0}, TYPE_GROUPED},
+	{"Validity-Time", AvpKey{448, 0}, TYPE_UNSIGNED32},
+	{"Subscription-Id-Type", AvpKey{450, 0}, TYPE_UNSIGNED32},
+	{"Multiple-Services-Credit-Control", AvpKey{456, 0}, TYPE_GROUPED},
+	{"Service-Context-Id", AvpKey{461, 0}, TYPE_OCTETSTRING},
+	{"Calling-Party-Address", AvpKey{831, VENDOR_3GPP}, TYPE_OCTETSTRING},
+	{"Called-Party-Address", AvpKey{832, VENDOR_3GPP}, TYPE_OCTETSTRING},
+	{"Service-Information", AvpKey{873, VENDOR_3GPP}, TYPE_GROUPED},
+	{"IMS-Information", AvpKey{876, VENDOR_3GPP}, TYPE_GROUPED},
+}
+
+var (
+	avpNames = make(map[string]AvpKey)
+	avpTypes = make(map[AvpKey]int)
+)
+
+func init() {
+	for _, def := range avpDictionary {
+		avpNames[def.name] = def.key
+		avpTypes[def.key] = def.avpType
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package diameter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMessageSerialization(t *testing.T) {
+	evTime := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	m := &Message{Flags: FLAG_REQUEST | FLAG_PROXIABLE, CommandCode: CREDIT_CONTROL, ApplicationId: APP_CREDIT_CONTROL, HopByHopId: 1, EndToEndId: 2}
+	m.AddAvp(NewAvpString(263, 0, "session1"),
+		NewAvpUnsigned32(416, 0, CCR_INITIAL),
+		NewAvpTime(55, 0, evTime),
+		NewAvpGrouped(443, 0, NewAvpUnsigned32(450, 0, 0), NewAvpString(444, 0, "1001")),
+		NewAvpGrouped(873, VENDOR_3GPP, NewAvpGrouped(876, VENDOR_3GPP, NewAvpString(832, VENDOR_3GPP, "1002"))))
+	rcv, err := ReadMessage(bytes.NewReader(m.Serialize()))
+	if err != nil {
+		t.Fatal("Could not read message back: ", err)
+	}
+	if !rcv.IsRequest() || rcv.CommandCode != CREDIT_CONTROL || rcv.ApplicationId != APP_CREDIT_CONTROL || rcv.HopByHopId != 1 || rcv.EndToEndId != 2 || len(rcv.Avps) != 5 {
+		t.Errorf("Unexpected message: %+v", rcv)
+	}
+	if avp := rcv.FindAvp(AvpPath{avpNames["Session-Id"]}); avp == nil || avp.String() != "session1" {
+		t.Error("Wrong Session-Id: ", avp)
+	}
+	if avp := rcv.FindAvp(AvpPath{avpNames["CC-Request-Type"]}); avp == nil || avp.String() != "1" {
+		t.Error("Wrong CC-Request-Type: ", avp)
+	}
+	if avp := rcv.FindAvp(AvpPath{avpNames["Event-Timestamp"]}); avp == nil {
+		t.Error("Event-Timestamp not found")
+	} else if rcvTime, _ := avp.Time(); !rcvTime.Equal(evTime) {
+		t.Error("Wrong Event-Timestamp: ", rcvTime)
+	}
+	for path, eVal := range map[string]string{
+		"Subscription-Id>Subscription-Id-Data":                      "1001",
+		"Service-Information>IMS-Information>Called-Party-Address":  "1002",
+		"873:10415>876:10415>832:10415":                             "1002",
+		"Service-Information>IMS-Information>Calling-Party-Address": "",
+	} {
+		avpPath, err := ParseAvpPath(path)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if avp := rcv.FindAvp(avpPath); eVal == "" && avp != nil {
+			t.Errorf("Not expecting to find %s: %v", path, avp)
+		} else if eVal != "" && (avp == nil || avp.String() != eVal) {
+			t.Errorf("Wrong value for %s: %v", path, avp)
+		}
+	}
+	if _, err := ParseAvpPath("Subscription-Id>Unknown-Avp"); err == nil {
+		t.Error("Expecting error on unknown avp")
+	}
+}
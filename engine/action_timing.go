@@ -19,6 +19,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package engine
 
 import (
+	"crypto/sha1"
 	"fmt"
 	"github.com/cgrates/cgrates/utils"
 	"sort"
@@ -40,6 +41,7 @@ type ActionTiming struct {
 	Timing                 *Interval
 	Weight                 float64
 	ActionsId              string
+	Paused                 bool // the scheduler skips the runs of paused timings
 	actions                Actions
 	stCache                time.Time // cached time of the next start
 	ActionsTag, TimingsTag string    // used only for loading
@@ -51,11 +53,24 @@ func (at *ActionTiming) GetNextStartTime() (t time.Time) {
 	if !at.stCache.IsZero() {
 		return at.stCache
 	}
+	at.stCache = at.getNextStartTimeAfter(time.Now())
+	return at.stCache
+}
+
+// computes the first start time not before the reference moment now
+func (at *ActionTiming) getNextStartTimeAfter(now time.Time) (t time.Time) {
 	i := at.Timing
 	if i == nil {
 		return
 	}
-	now := time.Now()
+	if i.CronExpr != "" {
+		ce, err := utils.ParseCronExpr(i.CronExpr)
+		if err != nil {
+			Logger.Err(fmt.Sprintf("Cannot parse action timing's cron expression %v: %v", i.CronExpr, err))
+			return
+		}
+		return ce.Next(now.Add(-time.Nanosecond)) // now itself is a valid start
+	}
 	y, m, d := now.Date()
 	z, _ := now.Zone()
 	if i.StartTime != "" && i.StartTime != ASAP {
@@ -64,7 +79,6 @@ func (at *ActionTiming) GetNextStartTime() (t time.Time) {
 		t, err = time.Parse(FORMAT, l)
 		if err != nil {
 			Logger.Err(fmt.Sprintf("Cannot parse action timing's StartTime %v", l))
-			at.stCache = t
 			return
 		}
 	}
@@ -79,7 +93,6 @@ func (at *ActionTiming) GetNextStartTime() (t time.Time) {
 			t = time.Date(t.Year(), t.Month(), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location()).AddDate(0, 0, j)
 			for _, wd := range i.WeekDays {
 				if t.Weekday() == wd && (t.Equal(now) || t.After(now)) {
-					at.stCache = t
 					return
 				}
 			}
@@ -88,7 +101,6 @@ func (at *ActionTiming) GetNextStartTime() (t time.Time) {
 	// monthdays
 	if i.MonthDays != nil && len(i.MonthDays) > 0 {
 		i.MonthDays.Sort()
-		now := time.Now()
 		month := now.Month()
 		x := sort.SearchInts(i.MonthDays, now.Day())
 		d = i.MonthDays[0]
@@ -117,7 +129,6 @@ func (at *ActionTiming) GetNextStartTime() (t time.Time) {
 MONTHS:
 	if i.Months != nil && len(i.Months) > 0 {
 		i.Months.Sort()
-		now := time.Now()
 		year := now.Year()
 		x := sort.Search(len(i.Months), func(x int) bool { return i.Months[x] >= now.Month() })
 		m = i.Months[0]
@@ -154,7 +165,6 @@ MONTHS:
 YEARS:
 	if i.Years != nil && len(i.Years) > 0 {
 		i.Years.Sort()
-		now := time.Now()
 		x := sort.Search(len(i.Years), func(x int) bool { return i.Years[x] >= now.Year() })
 		y = i.Years[0]
 		if x < len(i.Years) {
@@ -162,7 +172,6 @@ YEARS:
 				if t.Equal(now) || t.After(now) {
 					h, m, s := t.Clock()
 					t = time.Date(now.Year(), t.Month(), t.Day(), h, m, s, 0, time.Local)
-					at.stCache = t
 					return
 				}
 				if x+1 < len(i.Years) { // this year was found in the list so jump to next available year
@@ -191,7 +200,24 @@ YEARS:
 		h, min, s := t.Clock()
 		t = time.Date(y, t.Month(), t.Day(), h, min, s, 0, time.Local)
 	}
-	at.stCache = t
+	return
+}
+
+// Returns the start time of the latest run missed since the last execution within the catch-up window.
+// Returns zero time if no run was missed inside the window.
+func (at *ActionTiming) GetMissedStartTime(lastExecution time.Time, window time.Duration) (t time.Time) {
+	if window <= 0 || lastExecution.IsZero() || at.Timing == nil || at.IsOneTimeRun() {
+		return
+	}
+	now := time.Now()
+	// skip the run that set the last execution time
+	from := lastExecution.Add(time.Second)
+	if windowStart := now.Add(-window); from.Before(windowStart) {
+		from = windowStart
+	}
+	for st := at.getNextStartTimeAfter(from); !st.IsZero() && st.Before(now); st = at.getNextStartTimeAfter(st.Add(time.Second)) {
+		t = st
+	}
 	return
 }
 
@@ -199,11 +225,16 @@ func (at *ActionTiming) resetStartTimeCache() {
 	at.stCache = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
 }
 
+// Moves the timing to its next start time without executing the actions
+func (at *ActionTiming) SkipRun() {
+	at.resetStartTimeCache()
+}
+
 func (at *ActionTiming) SetActions(as Actions) {
 	at.actions = as
 }
 
-func (at *ActionTiming) getActions() (as []*Action, err error) {
+func (at *ActionTiming) GetActions() (as []*Action, err error) {
 	if at.actions == nil {
 		at.actions, err = storageGetter.GetActions(at.ActionsId)
 	}
@@ -211,9 +242,30 @@ func (at *ActionTiming) getActions() (as []*Action, err error) {
 	return at.actions, err
 }
 
+// Returns a copy of the action timing owning its actions, so it can be executed while the original is read elsewhere
+func (at *ActionTiming) Clone() (*ActionTiming, error) {
+	acts, err := at.GetActions()
+	if err != nil {
+		return nil, err
+	}
+	clone := *at
+	clone.UserBalanceIds = make([]string, len(at.UserBalanceIds))
+	copy(clone.UserBalanceIds, at.UserBalanceIds)
+	clone.actions = make(Actions, len(acts))
+	for idx, a := range acts {
+		ac := *a
+		if a.MinuteBucket != nil {
+			mb := *a.MinuteBucket
+			ac.MinuteBucket = &mb
+		}
+		clone.actions[idx] = &ac
+	}
+	return &clone, nil
+}
+
 func (at *ActionTiming) Execute() (err error) {
 	at.resetStartTimeCache()
-	aac, err := at.getActions()
+	aac, err := at.GetActions()
 	if err != nil {
 		Logger.Err(fmt.Sprintf("Failed to get actions for %s: %s", at.ActionsId, err))
 		return
@@ -243,10 +295,22 @@ func (at *ActionTiming) Execute() (err error) {
 			})
 		}
 	}
+	if at.ActionsId != "" { // remember the run so we can catch up on missed ones after a restart
+		if err := storageGetter.SetActionTimingExecution(at.ExecutionKey(), time.Now()); err != nil {
+			Logger.Warning(fmt.Sprintf("Could not save last execution time for action timing %s: %v", at.Tag, err))
+		}
+	}
 	go storageLogger.LogActionTiming(SCHED_SOURCE, at, aac)
 	return
 }
 
+// Identifies the timing across tariff plan reloads, unlike the Id which is generated on each load
+func (at *ActionTiming) ExecutionKey() string {
+	hasher := sha1.New()
+	hasher.Write([]byte(at.Tag + ";" + at.ActionsId))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
 // checks for *asap string as start time and replaces it wit an actual time in the newar future
 // returns true if the *asap string was found
 func (at *ActionTiming) CheckForASAP() bool {
@@ -261,7 +325,8 @@ func (at *ActionTiming) CheckForASAP() bool {
 
 // returns true if only the starting time was is filled in the Timing field
 func (at *ActionTiming) IsOneTimeRun() bool {
-	return len(at.Timing.Years) == 0 &&
+	return at.Timing.CronExpr == "" &&
+		len(at.Timing.Years) == 0 &&
 		len(at.Timing.Months) == 0 &&
 		len(at.Timing.MonthDays) == 0 &&
 		len(at.Timing.WeekDays) == 0 &&
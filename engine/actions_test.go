@@ -310,6 +310,96 @@ func TestActionTimingFirstMonthOfTheYear(t *testing.T) {
 	}
 }
 
+func TestActionTimingMissedStartTime(t *testing.T) {
+	at := &ActionTiming{Id: "test_missed", Timing: &Interval{
+		WeekDays:  WeekDays{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday},
+		StartTime: "00:00:00",
+	}}
+	now := time.Now()
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	lastExec := today.AddDate(0, 0, -2).Add(time.Minute)
+	expected := today
+	if st := at.GetMissedStartTime(lastExec, 72*time.Hour); !st.Equal(expected) {
+		t.Errorf("Expected %v was %v", expected, st)
+	}
+	if st := at.GetMissedStartTime(lastExec, time.Second); !st.IsZero() {
+		t.Error("Missed run outside catch-up window: ", st)
+	}
+	if st := at.GetMissedStartTime(now, 72*time.Hour); !st.IsZero() {
+		t.Error("Missed run after recent execution: ", st)
+	}
+	if st := at.GetMissedStartTime(lastExec, 0); !st.IsZero() {
+		t.Error("Missed run with catch-up disabled: ", st)
+	}
+}
+
+func TestActionTimingMissedStartTimeLatest(t *testing.T) {
+	at := &ActionTiming{Id: "test_missed_hourly", Timing: &Interval{CronExpr: "0 * * * *"}}
+	now := time.Now()
+	lastHour := now.Truncate(time.Hour)
+	if lastHour.Minute() != 0 { // zones with non hour offsets
+		t.Skip("local time zone not hour aligned")
+	}
+	// down for five hours, only the runs of the last two can be caught up
+	expected := lastHour
+	if expected.Equal(now) {
+		expected = expected.Add(-time.Hour)
+	}
+	if st := at.GetMissedStartTime(now.Add(-5*time.Hour), 2*time.Hour); !st.Equal(expected) {
+		t.Errorf("Expected %v was %v", expected, st)
+	}
+}
+
+func TestActionTimingCronExpr(t *testing.T) {
+	at := &ActionTiming{Timing: &Interval{CronExpr: "0 0 L * *"}}
+	if at.IsOneTimeRun() {
+		t.Error("Cron action timing considered one time run")
+	}
+	now := time.Date(2013, time.February, 10, 12, 0, 0, 0, time.Local)
+	expected := time.Date(2013, time.February, 28, 0, 0, 0, 0, time.Local)
+	if st := at.getNextStartTimeAfter(now); !st.Equal(expected) {
+		t.Errorf("Expected %v was %v", expected, st)
+	}
+	// the reference moment itself is a valid start
+	if st := at.getNextStartTimeAfter(expected); !st.Equal(expected) {
+		t.Errorf("Expected %v was %v", expected, st)
+	}
+	at.Timing.CronExpr = "0 0 * *"
+	if st := at.getNextStartTimeAfter(now); !st.IsZero() {
+		t.Error("Start time computed for invalid cron expression: ", st)
+	}
+}
+
+func TestActionTimingMissedStartTimeOneTimeRun(t *testing.T) {
+	at := &ActionTiming{Id: "test_missed_asap", Timing: &Interval{StartTime: ASAP}}
+	at.CheckForASAP()
+	if st := at.GetMissedStartTime(time.Now().AddDate(0, 0, -1), 72*time.Hour); !st.IsZero() {
+		t.Error("One time run should not be caught up: ", st)
+	}
+}
+
+func TestActionTimingExecutionSaved(t *testing.T) {
+	at := &ActionTiming{
+		Id:        "test_exec_saved",
+		Tag:       "test_exec_saved",
+		ActionsId: "test_exec_actions",
+		actions:   []*Action{&Action{ActionType: "*log", BalanceId: "test", Units: 1.1, MinuteBucket: &MinuteBucket{}}},
+	}
+	before := time.Now()
+	if err := at.Execute(); err != nil {
+		t.Error("Could not execute action timing: ", err)
+	}
+	lastExec, err := storageGetter.GetActionTimingExecution(at.ExecutionKey())
+	if err != nil || lastExec.Before(before) || lastExec.After(time.Now()) {
+		t.Errorf("Wrong last execution time: %v %v", lastExec, err)
+	}
+	reloaded := &ActionTiming{Id: "other_load_id", Tag: at.Tag, ActionsId: at.ActionsId, UserBalanceIds: []string{"*out:cgrates.org:new_account"}}
+	if reloaded.ExecutionKey() != at.ExecutionKey() {
+		t.Error("Execution key changed with the action timing id or its accounts")
+	}
+}
+
 func TestActionTimingCheckForASAP(t *testing.T) {
 	at := &ActionTiming{Timing: &Interval{StartTime: ASAP}}
 	if !at.CheckForASAP() {
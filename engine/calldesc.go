@@ -42,6 +42,7 @@ const (
 	RECURSION_MAX_DEPTH = 10
 	FALLBACK_SUBJECT    = "*any"
 	FALLBACK_SEP        = ";"
+	DATA_TOR            = "data" // usage in bytes, rated as one nanosecond per byte
 )
 
 var (
@@ -105,6 +106,22 @@ type CallDescriptor struct {
 	userBalance                           *UserBalance
 }
 
+// Converts the usage of the type of record (bytes for data, seconds otherwise) to the rated duration
+func UsageDuration(tor string, usage float64) time.Duration {
+	if tor == DATA_TOR {
+		return time.Duration(usage)
+	}
+	return time.Duration(usage * float64(time.Second))
+}
+
+// Converts the rated duration back to usage of the type of record
+func DurationUsage(tor string, d time.Duration) float64 {
+	if tor == DATA_TOR {
+		return float64(d)
+	}
+	return d.Seconds()
+}
+
 // Adds an activation period that applyes to current call descriptor.
 func (cd *CallDescriptor) AddActivationPeriod(aps ...*ActivationPeriod) {
 	cd.ActivationPeriods = append(cd.ActivationPeriods, aps...)
@@ -191,8 +208,8 @@ func (cd *CallDescriptor) splitInTimeSpans(firstSpan *TimeSpan) (timespans []*Ti
 		firstSpan = &TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeEnd, CallDuration: cd.CallDuration}
 	}
 	timespans = append(timespans, firstSpan)
-	// split on (free) minute buckets
-	if userBalance, err := cd.getUserBalance(); err == nil && userBalance != nil {
+	// split on (free) minute buckets, not covering data
+	if userBalance, err := cd.getUserBalance(); err == nil && userBalance != nil && cd.TOR != DATA_TOR {
 		_, _, bucketList := userBalance.getSecondsForPrefix(cd.Destination)
 		for _, mb := range bucketList {
 			for i := 0; i < len(timespans); i++ {
@@ -313,6 +330,9 @@ func (cd *CallDescriptor) GetMaxSessionTime(startTime time.Time) (seconds float6
 			return -1, nil
 		} else {
 			availableSeconds, availableCredit, _ = userBalance.getSecondsForPrefix(cd.Destination)
+			if cd.TOR == DATA_TOR { // amount in bytes, only paid from credit
+				availableSeconds = 0
+			}
 			Logger.Debug(fmt.Sprintf("available sec: %v credit: %v", availableSeconds, availableCredit))
 		}
 	} else {
@@ -327,7 +347,7 @@ func (cd *CallDescriptor) GetMaxSessionTime(startTime time.Time) (seconds float6
 	// therfore we get the cost for the whole period and then if there are not enough money we backout in steps of 10%.
 	maxSessionSeconds := cd.Amount
 	for i := 0; i < 10; i++ {
-		maxDuration, _ := time.ParseDuration(fmt.Sprintf("%vs", maxSessionSeconds-availableSeconds))
+		maxDuration := UsageDuration(cd.TOR, maxSessionSeconds-availableSeconds)
 		ts := &TimeSpan{TimeStart: startTime, TimeEnd: startTime.Add(maxDuration)}
 		timespans := cd.splitInTimeSpans(ts)
 
@@ -387,8 +407,7 @@ func (cd *CallDescriptor) MaxDebit(startTime time.Time) (cc *CallCost, err error
 		return new(CallCost), errors.New("no more credit")
 	}
 	if remainingSeconds > 0 { // for postpaying client returns -1
-		rs, _ := time.ParseDuration(fmt.Sprintf("%vs", remainingSeconds))
-		cd.TimeEnd = cd.TimeStart.Add(rs)
+		cd.TimeEnd = cd.TimeStart.Add(UsageDuration(cd.TOR, remainingSeconds))
 	}
 	return cd.Debit()
 }
@@ -408,9 +427,13 @@ func (cd *CallDescriptor) DebitCents() (left float64, err error) {
 /*
 Interface method used to add/substract an amount of units from user's sms balance.
 The amount filed has to be filled in call descriptor.
+Nothing is debited if the sms balance does not cover the amount.
 */
 func (cd *CallDescriptor) DebitSMS() (left float64, err error) {
 	if userBalance, err := cd.getUserBalance(); err == nil && userBalance != nil {
+		if left = userBalance.BalanceMap[SMS+OUTBOUND].GetTotalValue(); cd.Amount > 0 && left < cd.Amount {
+			return left, errors.New(utils.ERR_INSUFFICIENT_UNITS)
+		}
 		defer storageGetter.SetUserBalance(userBalance)
 		return userBalance.debitBalance(SMS, cd.Amount, true), nil
 	}
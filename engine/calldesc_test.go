@@ -20,6 +20,7 @@ package engine
 
 import (
 	"github.com/cgrates/cgrates/history"
+	"github.com/cgrates/cgrates/utils"
 	"log"
 	"testing"
 	"time"
@@ -221,6 +222,39 @@ func TestMaxSessionTimeNoCredit(t *testing.T) {
 	}
 }
 
+func TestDebitSMSInsufficientUnits(t *testing.T) {
+	storageGetter.SetUserBalance(&UserBalance{Id: "*out:vdf:sms", Type: UB_TYPE_PREPAID, BalanceMap: map[string]BalanceChain{SMS + OUTBOUND: BalanceChain{&Balance{Value: 1}}}})
+	cd := &CallDescriptor{Direction: "*out", TOR: "sms", Tenant: "vdf", Subject: "sms", Account: "sms", Destination: "0723", Amount: 1}
+	if left, err := cd.DebitSMS(); left != 0 || err != nil {
+		t.Errorf("Expected %v was %v (%v)", 0, left, err)
+	}
+	cd = &CallDescriptor{Direction: "*out", TOR: "sms", Tenant: "vdf", Subject: "sms", Account: "sms", Destination: "0723", Amount: 1}
+	if left, err := cd.DebitSMS(); left != 0 || err == nil || err.Error() != utils.ERR_INSUFFICIENT_UNITS {
+		t.Errorf("Expected insufficient units, was %v (%v)", left, err)
+	}
+	if ub, _ := storageGetter.GetUserBalance("*out:vdf:sms"); ub.BalanceMap[SMS+OUTBOUND].GetTotalValue() != 0 {
+		t.Error("Units debited without cover: ", ub.BalanceMap[SMS+OUTBOUND].GetTotalValue())
+	}
+}
+
+func TestGetCostData(t *testing.T) {
+	storageGetter.SetDestination(&Destination{Id: "INTERNET", Prefixes: []string{"internet"}})
+	storageGetter.SetRatingProfile(&RatingProfile{Id: "*out:vdf:data:*any", DestinationMap: map[string][]*ActivationPeriod{
+		"INTERNET": []*ActivationPeriod{&ActivationPeriod{ActivationTime: time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC),
+			Intervals: IntervalList{&Interval{RoundingMethod: utils.ROUNDING_UP, RoundingDecimals: 4,
+				Prices: PriceGroups{&Price{Value: 0.01, RateUnit: 1048576, RateIncrement: 102400}}}}}}}})
+	t1 := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	// 1.5MB, rated per MB in 100KB increments
+	cd := &CallDescriptor{Direction: "*out", TOR: DATA_TOR, Tenant: "vdf", Subject: "data", Destination: "internet", TimeStart: t1, TimeEnd: t1.Add(UsageDuration(DATA_TOR, 1572864))}
+	cc, err := cd.GetCost()
+	if err != nil || cc.Cost != 0.0157 {
+		t.Errorf("Expected %v was %v (%v)", 0.0157, cc, err)
+	}
+	if usage := DurationUsage(DATA_TOR, cc.Timespans[0].GetDuration()); usage != 1572864 {
+		t.Error("Wrong usage rated: ", usage)
+	}
+}
+
 /*********************************** BENCHMARKS ***************************************/
 func BenchmarkStorageGetting(b *testing.B) {
 	b.StopTimer()
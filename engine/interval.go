@@ -38,6 +38,7 @@ type Interval struct {
 	MonthDays          MonthDays
 	WeekDays           WeekDays
 	StartTime, EndTime string // ##:##:## format
+	CronExpr           string // used instead of the fields above to compute action timing runs
 	Weight, ConnectFee float64
 	Prices             PriceGroups // GroupInterval (start time): Price
 	RoundingMethod     string
@@ -196,10 +197,9 @@ func (i *Interval) Equal(o *Interval) bool {
 
 func (i *Interval) GetCost(duration, startSecond time.Duration) (cost float64) {
 	price, rateIncrement, rateUnit := i.GetPriceParameters(startSecond)
-	d := float64(duration.Seconds())
-	price /= rateUnit.Seconds()
-	ri := rateIncrement.Seconds()
-	cost = math.Ceil(d/ri) * ri * price
+	// whole nanoseconds keep the increments exact for data volumes too
+	increments := math.Ceil(float64(duration) / float64(rateIncrement))
+	cost = increments * float64(rateIncrement) / float64(rateUnit) * price
 	return utils.Round(cost, i.RoundingDecimals, i.RoundingMethod)
 }
 
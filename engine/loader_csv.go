@@ -195,7 +195,7 @@ func (csvr *CSVReader) LoadDestinations() (err error) {
 }
 
 func (csvr *CSVReader) LoadTimings() (err error) {
-	csvReader, fp, err := csvr.readerFunc(csvr.timingsFn, csvr.sep, utils.TIMINGS_NRCOLS)
+	csvReader, fp, err := csvr.readerFunc(csvr.timingsFn, csvr.sep, -1) // the cron expression column is optional
 	if err != nil {
 		log.Print("Could not load timings file: ", err)
 		// allow writing of the other values
@@ -205,8 +205,17 @@ func (csvr *CSVReader) LoadTimings() (err error) {
 		defer fp.Close()
 	}
 	for record, err := csvReader.Read(); err == nil; record, err = csvReader.Read() {
+		if len(record) < utils.TIMINGS_NRCOLS || len(record) > utils.TIMINGS_NRCOLS+1 {
+			return errors.New(fmt.Sprintf("Timing: Wrong number of fields on record: %v", record))
+		}
 		tag := record[0]
-		csvr.timings[tag] = NewTiming(record...)
+		tm := NewTiming(record...)
+		if tm.CronExpr != "" {
+			if _, err := utils.ParseCronExpr(tm.CronExpr); err != nil {
+				return errors.New(fmt.Sprintf("Timing: Could not parse cron expression for tag %v: %v", tag, err))
+			}
+		}
+		csvr.timings[tag] = tm
 	}
 	return
 }
@@ -431,6 +440,7 @@ func (csvr *CSVReader) LoadActionTimings() (err error) {
 				MonthDays: t.MonthDays,
 				WeekDays:  t.WeekDays,
 				StartTime: t.StartTime,
+				CronExpr:  t.CronExpr,
 			},
 			ActionsId: record[1],
 		}
@@ -43,6 +43,7 @@ WORKDAYS_00,*any,*any,*any,1;2;3;4;5,00:00:00
 WORKDAYS_18,*any,*any,*any,1;2;3;4;5,18:00:00
 WEEKENDS,*any,*any,*any,6;7,00:00:00
 ONE_TIME_RUN,2012,,,,*asap
+LAST_DAY,*any,*any,*any,*any,00:00:00,0 0 L * *
 `
 	rates = `
 R1,0,0.2,60s,1s,0,*middle,2,10
@@ -123,7 +124,7 @@ func TestLoadDestinations(t *testing.T) {
 }
 
 func TestLoadTimimgs(t *testing.T) {
-	if len(csvr.timings) != 4 {
+	if len(csvr.timings) != 5 {
 		t.Error("Failed to load timings: ", csvr.timings)
 	}
 }
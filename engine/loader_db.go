@@ -293,6 +293,7 @@ func (dbr *DbReader) LoadActionTimings() (err error) {
 					MonthDays: t.MonthDays,
 					WeekDays:  t.WeekDays,
 					StartTime: t.StartTime,
+					CronExpr:  t.CronExpr,
 				},
 				ActionsId: at.ActionsId,
 			}
@@ -392,6 +393,7 @@ func (dbr *DbReader) LoadAccountActionsByTag(tag string) error {
 					MonthDays: t.MonthDays,
 					WeekDays:  t.WeekDays,
 					StartTime: t.StartTime,
+					CronExpr:  t.CronExpr,
 				},
 				ActionsId: at.ActionsId,
 			}
@@ -66,17 +66,17 @@ func NewRate(tag, connectFee, price, ratedUnits, rateIncrements, groupInterval,
 		log.Printf("Error parsing price from: %v", price)
 		return
 	}
-	gi, err := time.ParseDuration(groupInterval)
+	gi, err := utils.ParseRateDuration(groupInterval)
 	if err != nil {
 		log.Printf("Error parsing group interval from: %v", price)
 		return
 	}
-	ru, err := time.ParseDuration(ratedUnits)
+	ru, err := utils.ParseRateDuration(ratedUnits)
 	if err != nil {
 		log.Printf("Error parsing rated units from: %v", ratedUnits)
 		return
 	}
-	ri, err := time.ParseDuration(rateIncrements)
+	ri, err := utils.ParseRateDuration(rateIncrements)
 	if err != nil {
 		log.Printf("Error parsing rates increments from: %v", rateIncrements)
 		return
@@ -120,6 +120,7 @@ type Timing struct {
 	MonthDays MonthDays
 	WeekDays  WeekDays
 	StartTime string
+	CronExpr  string // when set it is used instead of the other fields to schedule action timings
 }
 
 func NewTiming(timingInfo ...string) (rt *Timing) {
@@ -130,6 +131,9 @@ func NewTiming(timingInfo ...string) (rt *Timing) {
 	rt.MonthDays.Parse(timingInfo[3], ";")
 	rt.WeekDays.Parse(timingInfo[4], ";")
 	rt.StartTime = timingInfo[5]
+	if len(timingInfo) > 6 {
+		rt.CronExpr = strings.TrimSpace(timingInfo[6])
+	}
 	return
 }
 
@@ -216,12 +220,12 @@ var FileValidators = map[string]*FileLineRegexValidator{
 	utils.DESTINATIONS_CSV: &FileLineRegexValidator{utils.DESTINATIONS_NRCOLS,
 		regexp.MustCompile(`(?:\w+\s*,\s*){1}(?:\+?\d+.?\d*){1}$`),
 		"Tag([0-9A-Za-z_]),Prefix([0-9])"},
-	utils.TIMINGS_CSV: &FileLineRegexValidator{utils.TIMINGS_NRCOLS,
-		regexp.MustCompile(`(?:\w+\s*,\s*){1}(?:\*any\s*,\s*|(?:\d{1,4};?)+\s*,\s*|\s*,\s*){4}(?:\d{2}:\d{2}:\d{2}|\*asap){1}$`),
-		"Tag([0-9A-Za-z_]),Years([0-9;]|*all|<empty>),Months([0-9;]|*all|<empty>),MonthDays([0-9;]|*all|<empty>),WeekDays([0-9;]|*all|<empty>),Time([0-9:]|*asap)"},
+	utils.TIMINGS_CSV: &FileLineRegexValidator{-1, // variable since the cron expression column is optional
+		regexp.MustCompile(`(?:\w+\s*,\s*){1}(?:\*any\s*,\s*|(?:\d{1,4};?)+\s*,\s*|\s*,\s*){4}(?:\d{2}:\d{2}:\d{2}|\*asap){1}(?:\s*,[\w\s\*/\-;#@\?]*)?$`),
+		"Tag([0-9A-Za-z_]),Years([0-9;]|*all|<empty>),Months([0-9;]|*all|<empty>),MonthDays([0-9;]|*all|<empty>),WeekDays([0-9;]|*all|<empty>),Time([0-9:]|*asap),CronExpr(<cron expression>|<empty>)"},
 	utils.RATES_CSV: &FileLineRegexValidator{utils.RATES_NRCOLS,
-		regexp.MustCompile(`(?:\w+\s*,\s*){1}(?:\d+\.?\d*,){2}(?:\d+s*,){3}(?:\*\w+,){1}(?:\d+\.?\d*,?){2}$`),
-		"Tag([0-9A-Za-z_]),ConnectFee([0-9.]),Rate([0-9.]),RateUnit([0-9.]),RateIncrementStart([0-9.])"},
+		regexp.MustCompile(`(?:\w+\s*,\s*){1}(?:\d+\.?\d*,){2}(?:\d+[\dsmhKMGB\.]*,){3}(?:\*\w+,){1}(?:\d+\.?\d*,?){2}$`),
+		"Tag([0-9A-Za-z_]),ConnectFee([0-9.]),Rate([0-9.]),RateUnit([0-9.smh]|[0-9KMGB]),RateIncrement([0-9.smh]|[0-9KMGB]),GroupIntervalStart([0-9.smh]|[0-9KMGB])"},
 	utils.DESTINATION_RATES_CSV: &FileLineRegexValidator{utils.DESTINATION_RATES_NRCOLS,
 		regexp.MustCompile(`(?:\w+\s*,?\s*){3}$`),
 		"Tag([0-9A-Za-z_]),DestinationsTag([0-9A-Za-z_]),RateTag([0-9A-Za-z_])"},
@@ -342,6 +342,7 @@ type Connector interface {
 	MaxDebit(CallDescriptor, *CallCost) error
 	DebitCents(CallDescriptor, *float64) error
 	DebitSeconds(CallDescriptor, *float64) error
+	DebitSMS(CallDescriptor, *float64) error
 	GetMaxSessionTime(CallDescriptor, *float64) error
 }
 
@@ -366,6 +367,9 @@ func (rcc *RPCClientConnector) DebitCents(cd CallDescriptor, resp *float64) erro
 func (rcc *RPCClientConnector) DebitSeconds(cd CallDescriptor, resp *float64) error {
 	return rcc.Client.Call("Responder.DebitSeconds", cd, resp)
 }
+func (rcc *RPCClientConnector) DebitSMS(cd CallDescriptor, resp *float64) error {
+	return rcc.Client.Call("Responder.DebitSMS", cd, resp)
+}
 func (rcc *RPCClientConnector) GetMaxSessionTime(cd CallDescriptor, resp *float64) error {
 	return rcc.Client.Call("Responder.GetMaxSessionTime", cd, resp)
 }
@@ -31,6 +31,9 @@ import (
 
 const (
 	ACTION_TIMING_PREFIX      = "atm_"
+	ACTION_TIMING_EXEC_PREFIX = "ate_"
+	LEASE_PREFIX              = "lea_"
+	SESSION_RECORD_PREFIX     = "ses_"
 	RATING_PROFILE_PREFIX     = "rpf_"
 	ACTION_PREFIX             = "act_"
 	USER_BALANCE_PREFIX       = "ubl_"
@@ -113,9 +116,20 @@ type DataStorage interface {
 	GetActionTimings(string) (ActionTimings, error)
 	SetActionTimings(string, ActionTimings) error
 	GetAllActionTimings() (map[string]ActionTimings, error)
+	GetActionTimingExecution(string) (time.Time, error)
+	SetActionTimingExecution(string, time.Time) error
+	AcquireLease(name, owner string, ttl time.Duration) (bool, error)
+	SetSessionRecord(*SessionRecord) error
+	GetSessionRecords() ([]*SessionRecord, error)
+	RemoveSessionRecord(uuid string) error
 	SetCdr(utils.CDR) error
+	ExistsCdr(cgrId string) (bool, error)
+	ExistsRatedCdr(cgrId string) (bool, error)
 	SetRatedCdr(utils.CDR, *CallCost, string) error
+	SetRatedCdrError(utils.CDR, string) error
 	GetAllRatedCdr() ([]utils.CDR, error)
+	GetRatedCdrs(*utils.CdrsFilter) ([]*utils.RatedCdr, error)
+	SetCdrsExported([]*utils.RatedCdr, time.Time) error
 	//GetAllActionTimingsLogs() (map[string]ActionsTimings, error)
 	LogCallCost(uuid, source string, cc *CallCost) error
 	LogError(uuid, source, errstr string) error
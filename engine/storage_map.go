@@ -24,12 +24,15 @@ import (
 	"github.com/cgrates/cgrates/history"
 	"github.com/cgrates/cgrates/utils"
 	"strings"
+	"sync"
 	"time"
 )
 
 type MapStorage struct {
-	dict map[string][]byte
-	ms   Marshaler
+	dict       map[string][]byte
+	ms         Marshaler
+	leaseMux   sync.Mutex // makes lease checks atomic when the storage is shared
+	sessionMux sync.Mutex // session records are written out of the debit loops
 }
 
 func NewMapStorage() (DataStorage, error) {
@@ -299,6 +302,64 @@ func (ms *MapStorage) GetAllActionTimings() (ats map[string]ActionTimings, err e
 	return
 }
 
+func (ms *MapStorage) GetActionTimingExecution(atId string) (t time.Time, err error) {
+	if values, ok := ms.dict[ACTION_TIMING_EXEC_PREFIX+atId]; ok {
+		t, err = time.Parse(time.RFC3339Nano, string(values))
+	} else {
+		return t, errors.New("not found")
+	}
+	return
+}
+
+func (ms *MapStorage) SetActionTimingExecution(atId string, t time.Time) (err error) {
+	ms.dict[ACTION_TIMING_EXEC_PREFIX+atId] = []byte(t.Format(time.RFC3339Nano))
+	return
+}
+
+func (ms *MapStorage) AcquireLease(name, owner string, ttl time.Duration) (bool, error) {
+	ms.leaseMux.Lock()
+	defer ms.leaseMux.Unlock()
+	now := time.Now()
+	if value, ok := ms.dict[LEASE_PREFIX+name]; ok {
+		if curOwner, expires := unmarshalLease(string(value)); curOwner != owner && expires.After(now) {
+			return false, nil
+		}
+	}
+	ms.dict[LEASE_PREFIX+name] = []byte(marshalLease(owner, now.Add(ttl)))
+	return true, nil
+}
+
+func (ms *MapStorage) SetSessionRecord(sr *SessionRecord) error {
+	ms.sessionMux.Lock()
+	defer ms.sessionMux.Unlock()
+	result, err := ms.ms.Marshal(sr)
+	ms.dict[SESSION_RECORD_PREFIX+sr.Uuid] = result
+	return err
+}
+
+func (ms *MapStorage) GetSessionRecords() (srs []*SessionRecord, err error) {
+	ms.sessionMux.Lock()
+	defer ms.sessionMux.Unlock()
+	for key, value := range ms.dict {
+		if !strings.HasPrefix(key, SESSION_RECORD_PREFIX) {
+			continue
+		}
+		sr := new(SessionRecord)
+		if err = ms.ms.Unmarshal(value, sr); err != nil {
+			return nil, err
+		}
+		srs = append(srs, sr)
+	}
+	return
+}
+
+func (ms *MapStorage) RemoveSessionRecord(uuid string) error {
+	ms.sessionMux.Lock()
+	defer ms.sessionMux.Unlock()
+	delete(ms.dict, SESSION_RECORD_PREFIX+uuid)
+	return nil
+}
+
 func (ms *MapStorage) LogCallCost(uuid, source string, cc *CallCost) error {
 	result, err := ms.ms.Marshal(cc)
 	ms.dict[LOG_CALL_COST_PREFIX+source+"_"+uuid] = result
@@ -349,14 +410,34 @@ func (ms *MapStorage) SetCdr(utils.CDR) error {
 	return nil
 }
 
+func (ms *MapStorage) ExistsCdr(cgrId string) (bool, error) {
+	return false, nil
+}
+
+func (ms *MapStorage) ExistsRatedCdr(cgrId string) (bool, error) {
+	return false, nil
+}
+
 func (ms *MapStorage) SetRatedCdr(cdr utils.CDR, cc *CallCost, extraInfo string) error {
 	return errors.New(utils.ERR_NOT_IMPLEMENTED)
 }
 
+func (ms *MapStorage) SetRatedCdrError(cdr utils.CDR, extraInfo string) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (ms *MapStorage) GetAllRatedCdr() ([]utils.CDR, error) {
 	return nil, nil
 }
 
+func (ms *MapStorage) GetRatedCdrs(*utils.CdrsFilter) ([]*utils.RatedCdr, error) {
+	return nil, errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
+func (ms *MapStorage) SetCdrsExported([]*utils.RatedCdr, time.Time) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (ms *MapStorage) GetTpDestinations(tpid, tag string) ([]*Destination, error) {
 	return nil, nil
 }
@@ -98,6 +98,22 @@ type AtKeyValue struct {
 	Value ActionTimings
 }
 
+type AtExecEntry struct {
+	Id            string `bson:"_id,omitempty"`
+	LastExecution time.Time
+}
+
+type LeaseEntry struct {
+	Id      string `bson:"_id,omitempty"`
+	Owner   string
+	Expires time.Time
+}
+
+type SessionRecordEntry struct {
+	Id            string `bson:"_id,omitempty"`
+	SessionRecord *SessionRecord
+}
+
 type LogCostEntry struct {
 	Id       string `bson:"_id,omitempty"`
 	CallCost *CallCost
@@ -353,6 +369,60 @@ func (ms *MongoStorage) GetAllActionTimings() (ats map[string]ActionTimings, err
 	return
 }
 
+func (ms *MongoStorage) GetActionTimingExecution(atId string) (t time.Time, err error) {
+	result := new(AtExecEntry)
+	err = ms.db.C("actiontimingexecs").Find(bson.M{"_id": atId}).One(result)
+	return result.LastExecution, err
+}
+
+func (ms *MongoStorage) SetActionTimingExecution(atId string, t time.Time) (err error) {
+	_, err = ms.db.C("actiontimingexecs").Upsert(bson.M{"_id": atId}, &AtExecEntry{atId, t})
+	return
+}
+
+// Renews our own or an expired lease, inserts it if missing; the unique _id makes concurrent inserts fail
+func (ms *MongoStorage) AcquireLease(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	entry := &LeaseEntry{name, owner, now.Add(ttl)}
+	err := ms.db.C("leases").Update(bson.M{"_id": name, "$or": []bson.M{bson.M{"owner": owner}, bson.M{"expires": bson.M{"$lt": now}}}}, entry)
+	if err == nil {
+		return true, nil
+	}
+	if err != mgo.ErrNotFound {
+		return false, err
+	}
+	if err = ms.db.C("leases").Insert(entry); err != nil {
+		if mgo.IsDup(err) { // held by somebody else
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (ms *MongoStorage) SetSessionRecord(sr *SessionRecord) (err error) {
+	_, err = ms.db.C("sessions").Upsert(bson.M{"_id": sr.Uuid}, &SessionRecordEntry{sr.Uuid, sr})
+	return
+}
+
+func (ms *MongoStorage) GetSessionRecords() (srs []*SessionRecord, err error) {
+	result := SessionRecordEntry{}
+	iter := ms.db.C("sessions").Find(nil).Iter()
+	for iter.Next(&result) {
+		srs = append(srs, result.SessionRecord)
+		result = SessionRecordEntry{}
+	}
+	err = iter.Close()
+	return
+}
+
+func (ms *MongoStorage) RemoveSessionRecord(uuid string) (err error) {
+	if err = ms.db.C("sessions").Remove(bson.M{"_id": uuid}); err == mgo.ErrNotFound {
+		return nil
+	}
+	return
+}
+
 func (ms *MongoStorage) LogCallCost(uuid, source string, cc *CallCost) error {
 	return ms.db.C("cclog").Insert(&LogCostEntry{uuid, cc, source})
 }
@@ -380,14 +450,34 @@ func (ms *MongoStorage) SetCdr(utils.CDR) error {
 	return nil
 }
 
+func (ms *MongoStorage) ExistsCdr(cgrId string) (bool, error) {
+	return false, nil
+}
+
+func (ms *MongoStorage) ExistsRatedCdr(cgrId string) (bool, error) {
+	return false, nil
+}
+
 func (ms *MongoStorage) SetRatedCdr(cdr utils.CDR, cc *CallCost, extraInfo string) error {
 	return errors.New(utils.ERR_NOT_IMPLEMENTED)
 }
 
+func (ms *MongoStorage) SetRatedCdrError(cdr utils.CDR, extraInfo string) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (ms *MongoStorage) GetAllRatedCdr() ([]utils.CDR, error) {
 	return nil, nil
 }
 
+func (ms *MongoStorage) GetRatedCdrs(*utils.CdrsFilter) ([]*utils.RatedCdr, error) {
+	return nil, errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
+func (ms *MongoStorage) SetCdrsExported([]*utils.RatedCdr, time.Time) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (ms *MongoStorage) GetDestinations(tpid string) ([]*Destination, error) {
 	return nil, nil
 }
@@ -335,6 +335,74 @@ func (rs *RedisStorage) GetAllActionTimings() (ats map[string]ActionTimings, err
 	return
 }
 
+func (rs *RedisStorage) GetActionTimingExecution(atId string) (t time.Time, err error) {
+	var values string
+	if values, err = rs.db.Get(ACTION_TIMING_EXEC_PREFIX + atId); err == nil {
+		t, err = time.Parse(time.RFC3339Nano, values)
+	}
+	return
+}
+
+func (rs *RedisStorage) SetActionTimingExecution(atId string, t time.Time) (err error) {
+	_, err = rs.db.Set(ACTION_TIMING_EXEC_PREFIX+atId, t.Format(time.RFC3339Nano))
+	return
+}
+
+// Takes the lease if free or renews it if already ours, returns the owner after the call.
+// Runs as one script so the owner check and the renewal cannot interleave with another contender.
+const leaseScript = `local cur = redis.call('GET', KEYS[1])
+if cur == false or cur == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return {ARGV[1]}
+end
+return {cur}`
+
+// The key holds the owner and expires together with the lease
+func (rs *RedisStorage) AcquireLease(name, owner string, ttl time.Duration) (bool, error) {
+	ttlMs := int64(ttl / time.Millisecond)
+	if ttlMs < 1 {
+		ttlMs = 1
+	}
+	curOwner, err := rs.db.Eval(leaseScript, 1, LEASE_PREFIX+name, owner, ttlMs)
+	if err != nil {
+		return false, err
+	}
+	return len(curOwner) == 1 && curOwner[0] == owner, nil
+}
+
+func (rs *RedisStorage) SetSessionRecord(sr *SessionRecord) (err error) {
+	result, err := rs.ms.Marshal(sr)
+	if err != nil {
+		return
+	}
+	_, err = rs.db.Set(SESSION_RECORD_PREFIX+sr.Uuid, result)
+	return
+}
+
+func (rs *RedisStorage) GetSessionRecords() (srs []*SessionRecord, err error) {
+	keys, err := rs.db.Keys(SESSION_RECORD_PREFIX + "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		values, err := rs.db.Get(key)
+		if err != nil { // removed meanwhile
+			continue
+		}
+		sr := new(SessionRecord)
+		if err = rs.ms.Unmarshal([]byte(values), sr); err != nil {
+			return nil, err
+		}
+		srs = append(srs, sr)
+	}
+	return
+}
+
+func (rs *RedisStorage) RemoveSessionRecord(uuid string) (err error) {
+	_, err = rs.db.Del(SESSION_RECORD_PREFIX + uuid)
+	return
+}
+
 func (rs *RedisStorage) LogCallCost(uuid, source string, cc *CallCost) (err error) {
 	var result []byte
 	result, err = rs.ms.Marshal(cc)
@@ -388,14 +456,34 @@ func (rs *RedisStorage) SetCdr(utils.CDR) error {
 	return nil
 }
 
+func (rs *RedisStorage) ExistsCdr(cgrId string) (bool, error) {
+	return false, nil
+}
+
+func (rs *RedisStorage) ExistsRatedCdr(cgrId string) (bool, error) {
+	return false, nil
+}
+
 func (rs *RedisStorage) SetRatedCdr(cdr utils.CDR, cc *CallCost, extraInfo string) error {
 	return errors.New(utils.ERR_NOT_IMPLEMENTED)
 }
 
+func (rs *RedisStorage) SetRatedCdrError(cdr utils.CDR, extraInfo string) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (rs *RedisStorage) GetAllRatedCdr() ([]utils.CDR, error) {
 	return nil, nil
 }
 
+func (rs *RedisStorage) GetRatedCdrs(*utils.CdrsFilter) ([]*utils.RatedCdr, error) {
+	return nil, errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
+func (rs *RedisStorage) SetCdrsExported([]*utils.RatedCdr, time.Time) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (rs *RedisStorage) GetTpDestinations(tpid, tag string) ([]*Destination, error) {
 	return nil, nil
 }
@@ -21,8 +21,10 @@ package engine
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cgrates/cgrates/utils"
+	"strings"
 	"time"
 )
 
@@ -81,9 +83,9 @@ func (self *SQLStorage) GetTPIds() ([]string, error) {
 }
 
 func (self *SQLStorage) SetTPTiming(tpid string, tm *Timing) error {
-	if _, err := self.Db.Exec(fmt.Sprintf("INSERT INTO %s (tpid, tag, years, months, month_days, week_days, time) VALUES('%s','%s','%s','%s','%s','%s','%s')",
+	if _, err := self.Db.Exec(fmt.Sprintf("INSERT INTO %s (tpid, tag, years, months, month_days, week_days, time, cron_expr) VALUES('%s','%s','%s','%s','%s','%s','%s','%s')",
 		utils.TBL_TP_TIMINGS, tpid, tm.Id, tm.Years.Serialize(";"), tm.Months.Serialize(";"), tm.MonthDays.Serialize(";"),
-		tm.WeekDays.Serialize(";"), tm.StartTime)); err != nil {
+		tm.WeekDays.Serialize(";"), tm.StartTime, tm.CronExpr)); err != nil {
 		return err
 	}
 	return nil
@@ -99,16 +101,16 @@ func (self *SQLStorage) ExistsTPTiming(tpid, tmId string) (bool, error) {
 }
 
 func (self *SQLStorage) GetTPTiming(tpid, tmId string) (*Timing, error) {
-	var years, months, monthDays, weekDays, time string
-	err := self.Db.QueryRow(fmt.Sprintf("SELECT years, months, month_days, week_days, time FROM %s WHERE tpid='%s' AND tag='%s' LIMIT 1",
-		utils.TBL_TP_TIMINGS, tpid, tmId)).Scan(&years, &months, &monthDays, &weekDays, &time)
+	var years, months, monthDays, weekDays, time, cronExpr string
+	err := self.Db.QueryRow(fmt.Sprintf("SELECT years, months, month_days, week_days, time, cron_expr FROM %s WHERE tpid='%s' AND tag='%s' LIMIT 1",
+		utils.TBL_TP_TIMINGS, tpid, tmId)).Scan(&years, &months, &monthDays, &weekDays, &time, &cronExpr)
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, nil
 	case err != nil:
 		return nil, err
 	}
-	return NewTiming(tmId, years, months, monthDays, weekDays, time), nil
+	return NewTiming(tmId, years, months, monthDays, weekDays, time, cronExpr), nil
 }
 
 func (self *SQLStorage) GetTPTimingIds(tpid string) ([]string, error) {
@@ -816,6 +818,26 @@ func (self *SQLStorage) GetAllActionTimings() (ats map[string]ActionTimings, err
 	return
 }
 
+func (self *SQLStorage) GetActionTimingExecution(string) (t time.Time, err error) { return }
+
+func (self *SQLStorage) SetActionTimingExecution(string, time.Time) (err error) { return }
+
+func (self *SQLStorage) AcquireLease(string, string, time.Duration) (bool, error) {
+	return false, errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
+func (self *SQLStorage) SetSessionRecord(*SessionRecord) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
+func (self *SQLStorage) GetSessionRecords() ([]*SessionRecord, error) {
+	return nil, errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
+func (self *SQLStorage) RemoveSessionRecord(string) error {
+	return errors.New(utils.ERR_NOT_IMPLEMENTED)
+}
+
 func (self *SQLStorage) LogCallCost(uuid, source string, cc *CallCost) (err error) {
 	//ToDo: Add cgrid to logCallCost
 	if self.Db == nil {
@@ -826,8 +848,9 @@ func (self *SQLStorage) LogCallCost(uuid, source string, cc *CallCost) (err erro
 	if err != nil {
 		Logger.Err(fmt.Sprintf("Error marshalling timespans to json: %v", err))
 	}
-	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid, accid, direction, tenant, tor, account, subject, destination, cost, connect_fee, timespans, source )VALUES ('%s', '%s','%s', '%s', '%s', '%s', '%s', '%s', %f, %f, '%s','%s')",
-		utils.TBL_COST_DETAILS,
+	// Rerating logs the costs again, replacing the previous ones
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid, accid, direction, tenant, tor, account, subject, destination, cost, connect_fee, timespans, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE accid=VALUES(accid), direction=VALUES(direction), tenant=VALUES(tenant), tor=VALUES(tor), account=VALUES(account), destination=VALUES(destination), cost=VALUES(cost), connect_fee=VALUES(connect_fee), timespans=VALUES(timespans), source=VALUES(source)",
+		utils.TBL_COST_DETAILS),
 		utils.FSCgrId(uuid),
 		uuid,
 		cc.Direction,
@@ -838,8 +861,8 @@ func (self *SQLStorage) LogCallCost(uuid, source string, cc *CallCost) (err erro
 		cc.Destination,
 		cc.Cost,
 		cc.ConnectFee,
-		tss,
-		source))
+		string(tss),
+		source)
 	if err != nil {
 		Logger.Err(fmt.Sprintf("failed to execute insert statement: %v", err))
 	}
@@ -904,14 +927,40 @@ func (self *SQLStorage) SetCdr(cdr utils.CDR) (err error) {
 	return
 }
 
+// Checks for a stored CDR with the cgrid
+func (self *SQLStorage) ExistsCdr(cgrId string) (bool, error) {
+	var exists int
+	err := self.Db.QueryRow("SELECT 1 FROM cdrs_primary WHERE cgrid=? LIMIT 1", cgrId).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Checks for a CDR with the cgrid rated successfully
+func (self *SQLStorage) ExistsRatedCdr(cgrId string) (bool, error) {
+	var exists int
+	err := self.Db.QueryRow("SELECT 1 FROM rated_cdrs WHERE cgrid=? AND cost>=0 LIMIT 1", cgrId).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (self *SQLStorage) SetRatedCdr(cdr utils.CDR, cc *CallCost, extraInfo string) (err error) {
 	// ToDo: Add here source and subject
-	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid, subject, cost, extra_info) VALUES ('%s', '%s', %f, '%s')",
-		utils.TBL_RATED_CDRS,
+	// Rerating overwrites the previous cost, a changed one to be exported again. MySQL assigns in order so the
+	// export time is reset comparing with the old cost.
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid, subject, cost, extra_info) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE export_time=IF(cost<=>VALUES(cost), export_time, NULL), cost=VALUES(cost), extra_info=VALUES(extra_info)",
+		utils.TBL_RATED_CDRS),
 		cdr.GetCgrId(),
 		cdr.GetSubject(),
 		cc.Cost+cc.ConnectFee,
-		extraInfo))
+		extraInfo)
 	if err != nil {
 		Logger.Err(fmt.Sprintf("failed to execute cdr insert statement: %v", err))
 	}
@@ -919,10 +968,176 @@ func (self *SQLStorage) SetRatedCdr(cdr utils.CDR, cc *CallCost, extraInfo strin
 	return
 }
 
+// Records the rating error, a CDR rated before keeps its cost while the ones not rated yet are marked failed
+func (self *SQLStorage) SetRatedCdrError(cdr utils.CDR, extraInfo string) (err error) {
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid, subject, cost, extra_info) VALUES (?, ?, -1, ?) ON DUPLICATE KEY UPDATE extra_info=VALUES(extra_info)",
+		utils.TBL_RATED_CDRS),
+		cdr.GetCgrId(),
+		cdr.GetSubject(),
+		extraInfo)
+	if err != nil {
+		Logger.Err(fmt.Sprintf("failed to execute rated cdr error statement: %v", err))
+	}
+	return
+}
+
 func (self *SQLStorage) GetAllRatedCdr() ([]utils.CDR, error) {
 	return nil, nil
 }
 
+// Columns the stored CDRs can be ordered by
+var cdrsOrderColumns = map[string]string{
+	utils.CDR_ORDER_ANSWER_TIME: "cdrs_primary.time_answer",
+	utils.CDR_ORDER_DURATION:    "cdrs_primary.duration",
+	utils.CDR_ORDER_COST:        utils.TBL_RATED_CDRS + ".cost",
+	utils.CDR_ORDER_ACCOUNT:     "cdrs_primary.account",
+	utils.CDR_ORDER_DESTINATION: "cdrs_primary.destination",
+}
+
+// Escapes the LIKE wildcards so the destination prefix is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Builds the query selecting the stored CDRs matching the filter, joined with their extra fields and mediated cost,
+// together with the arguments of its placeholders
+func cdrsFilterQuery(filter *utils.CdrsFilter) (string, []interface{}, error) {
+	args := []interface{}{}
+	runCondition := "cdrs_primary.subject=" + utils.TBL_RATED_CDRS + ".subject"
+	if filter.RunId != "" {
+		runCondition = utils.TBL_RATED_CDRS + ".subject=?"
+		args = append(args, filter.RunId)
+	}
+	q := fmt.Sprintf("SELECT cdrs_primary.cgrid, cdrs_primary.accid, cdrs_primary.cdrhost, cdrs_primary.reqtype, cdrs_primary.direction, cdrs_primary.tenant, cdrs_primary.tor, cdrs_primary.account, cdrs_primary.subject, cdrs_primary.destination, UNIX_TIMESTAMP(cdrs_primary.time_answer), cdrs_primary.duration, cdrs_extra.extra_fields, %[1]s.subject, %[1]s.cost, %[1]s.extra_info FROM cdrs_primary LEFT JOIN cdrs_extra ON cdrs_primary.cgrid=cdrs_extra.cgrid LEFT JOIN %[1]s ON cdrs_primary.cgrid=%[1]s.cgrid AND %[2]s", utils.TBL_RATED_CDRS, runCondition)
+	conditions := []string{}
+	if !filter.AnswerTimeStart.IsZero() {
+		conditions = append(conditions, "cdrs_primary.time_answer>=FROM_UNIXTIME(?)")
+		args = append(args, filter.AnswerTimeStart.Unix())
+	}
+	if !filter.AnswerTimeEnd.IsZero() {
+		conditions = append(conditions, "cdrs_primary.time_answer<FROM_UNIXTIME(?)")
+		args = append(args, filter.AnswerTimeEnd.Unix())
+	}
+	if filter.Tenant != "" {
+		conditions = append(conditions, "cdrs_primary.tenant=?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.Account != "" {
+		conditions = append(conditions, "cdrs_primary.account=?")
+		args = append(args, filter.Account)
+	}
+	if filter.ReqType != "" {
+		conditions = append(conditions, "cdrs_primary.reqtype=?")
+		args = append(args, filter.ReqType)
+	}
+	if filter.DestinationPrefix != "" {
+		conditions = append(conditions, "cdrs_primary.destination LIKE ?")
+		args = append(args, likeEscaper.Replace(filter.DestinationPrefix)+"%")
+	}
+	if filter.MinCost != nil {
+		conditions = append(conditions, utils.TBL_RATED_CDRS+".cost>=?")
+		args = append(args, *filter.MinCost)
+	}
+	if filter.MaxCost != nil {
+		conditions = append(conditions, utils.TBL_RATED_CDRS+".cost<?")
+		args = append(args, *filter.MaxCost)
+	}
+	if filter.NotExported {
+		conditions = append(conditions, fmt.Sprintf("%s.export_time IS NULL", utils.TBL_RATED_CDRS))
+	}
+	switch filter.RatedStatus {
+	case "":
+	case utils.CDR_RATED:
+		conditions = append(conditions, fmt.Sprintf("%s.cost>=0", utils.TBL_RATED_CDRS))
+	case utils.CDR_FAILED:
+		conditions = append(conditions, fmt.Sprintf("(%[1]s.cost IS NULL OR %[1]s.cost<0)", utils.TBL_RATED_CDRS))
+	default:
+		return "", nil, fmt.Errorf("%s:RatedStatus", utils.ERR_INVALID_IE)
+	}
+	if len(conditions) != 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if filter.OrderBy != "" {
+		column, hasColumn := cdrsOrderColumns[filter.OrderBy]
+		if !hasColumn {
+			return "", nil, fmt.Errorf("%s:OrderBy", utils.ERR_INVALID_IE)
+		}
+		q += " ORDER BY " + column
+		if filter.OrderDesc {
+			q += " DESC"
+		}
+		q += ", cdrs_primary.id" // Stable pages when the ordered values repeat
+	}
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return "", nil, fmt.Errorf("%s:Limit", utils.ERR_INVALID_IE)
+	}
+	if filter.Limit != 0 {
+		q += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	} else if filter.Offset != 0 {
+		q += " LIMIT 18446744073709551615" // MySQL accepts OFFSET only together with LIMIT
+	}
+	if filter.Offset != 0 {
+		q += fmt.Sprintf(" OFFSET %d", filter.Offset)
+	}
+	return q, args, nil
+}
+
+// Stored CDRs matching the filter, joined with their extra fields and mediated cost
+func (self *SQLStorage) GetRatedCdrs(filter *utils.CdrsFilter) ([]*utils.RatedCdr, error) {
+	q, args, err := cdrsFilterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := self.Db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cdrs []*utils.RatedCdr
+	for rows.Next() {
+		cdr := &utils.RatedCdr{Cost: -1}
+		var answerTime int64
+		var extraFields, runId, extraInfo sql.NullString
+		var cost sql.NullFloat64
+		if err := rows.Scan(&cdr.CgrId, &cdr.AccId, &cdr.CdrHost, &cdr.ReqType, &cdr.Direction, &cdr.Tenant, &cdr.TOR,
+			&cdr.Account, &cdr.Subject, &cdr.Destination, &answerTime, &cdr.Duration, &extraFields, &runId, &cost, &extraInfo); err != nil {
+			return nil, err
+		}
+		cdr.AnswerTime = time.Unix(answerTime, 0)
+		if extraFields.Valid && extraFields.String != "" {
+			if err := json.Unmarshal([]byte(extraFields.String), &cdr.ExtraFields); err != nil {
+				return nil, err
+			}
+		}
+		if cost.Valid {
+			cdr.Cost = cost.Float64
+		}
+		cdr.RunId = runId.String
+		cdr.ExtraInfo = extraInfo.String
+		cdrs = append(cdrs, cdr)
+	}
+	return cdrs, rows.Err()
+}
+
+// Marks the mediated costs of the CDRs as exported so they can be skipped by the next exports
+func (self *SQLStorage) SetCdrsExported(cdrs []*utils.RatedCdr, exportTime time.Time) error {
+	for start := 0; start < len(cdrs); start += 1000 { // Keep the statements small on large exports
+		end := start + 1000
+		if end > len(cdrs) {
+			end = len(cdrs)
+		}
+		costIds := make([]string, end-start)
+		args := []interface{}{exportTime.Unix()}
+		for idx, cdr := range cdrs[start:end] {
+			costIds[idx] = "(?,?)"
+			args = append(args, cdr.CgrId, cdr.RunId)
+		}
+		if _, err := self.Db.Exec(fmt.Sprintf("UPDATE %s SET export_time=FROM_UNIXTIME(?) WHERE (cgrid,subject) IN (%s)",
+			utils.TBL_RATED_CDRS, strings.Join(costIds, ",")), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (self *SQLStorage) GetTpDestinations(tpid, tag string) ([]*Destination, error) {
 	var dests []*Destination
 	q := fmt.Sprintf("SELECT * FROM %s WHERE tpid='%s'", utils.TBL_TP_DESTINATIONS, tpid)
@@ -1031,11 +1246,11 @@ func (self *SQLStorage) GetTpTimings(tpid, tag string) (map[string]*Timing, erro
 	defer rows.Close()
 	for rows.Next() {
 		var id int
-		var tpid, tag, years, months, month_days, week_days, start_time string
-		if err := rows.Scan(&id, &tpid, &tag, &years, &months, &month_days, &week_days, &start_time); err != nil {
+		var tpid, tag, years, months, month_days, week_days, start_time, cron_expr string
+		if err := rows.Scan(&id, &tpid, &tag, &years, &months, &month_days, &week_days, &start_time, &cron_expr); err != nil {
 			return nil, err
 		}
-		tms[tag] = NewTiming(tag, years, months, month_days, week_days, start_time)
+		tms[tag] = NewTiming(tag, years, months, month_days, week_days, start_time, cron_expr)
 	}
 	return tms, nil
 }
@@ -19,6 +19,9 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package engine
 
 import (
+	"github.com/cgrates/cgrates/utils"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -245,3 +248,77 @@ func BenchmarkMarshallerBincStoreRestore(b *testing.B) {
 		ms.Unmarshal(result, ub1)
 	}
 }
+
+func TestMapStorageAcquireLease(t *testing.T) {
+	storage, _ := NewMapStorage()
+	if ok, err := storage.AcquireLease("test", "owner1", time.Minute); err != nil || !ok {
+		t.Error("Could not acquire free lease: ", ok, err)
+	}
+	if ok, _ := storage.AcquireLease("test", "owner2", time.Minute); ok {
+		t.Error("Acquired lease held by somebody else")
+	}
+	if ok, _ := storage.AcquireLease("test", "owner1", -time.Minute); !ok {
+		t.Error("Could not renew own lease")
+	}
+	if ok, _ := storage.AcquireLease("test", "owner2", time.Minute); !ok {
+		t.Error("Could not take over expired lease")
+	}
+}
+
+func TestMapStorageSessionRecords(t *testing.T) {
+	storage, _ := NewMapStorage()
+	t1 := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	sr := &SessionRecord{Uuid: "uuid1", ReqType: "prepaid",
+		CallDescriptor: &CallDescriptor{Direction: OUTBOUND, Tenant: "cgrates.org", Account: "1001", Destination: "1002", TimeStart: t1},
+		CallCosts:      []*CallCost{&CallCost{Account: "1001", Timespans: []*TimeSpan{&TimeSpan{TimeStart: t1, TimeEnd: t1.Add(10 * time.Second)}}}}}
+	if err := storage.SetSessionRecord(sr); err != nil {
+		t.Fatal("Could not store session record: ", err)
+	}
+	srs, err := storage.GetSessionRecords()
+	if err != nil || len(srs) != 1 {
+		t.Fatal("Unexpected session records: ", srs, err)
+	}
+	if rcv := srs[0]; rcv.Uuid != "uuid1" || rcv.ReqType != "prepaid" || rcv.CallDescriptor.Account != "1001" || !rcv.CallDescriptor.TimeStart.Equal(t1) ||
+		len(rcv.CallCosts) != 1 || !rcv.CallCosts[0].Timespans[0].TimeEnd.Equal(t1.Add(10*time.Second)) {
+		t.Errorf("Unexpected session record: %+v", rcv)
+	}
+	if err := storage.RemoveSessionRecord("uuid1"); err != nil {
+		t.Error("Could not remove session record: ", err)
+	}
+	if srs, _ := storage.GetSessionRecords(); len(srs) != 0 {
+		t.Error("Session record not removed: ", srs)
+	}
+}
+
+func TestCdrsFilterQuery(t *testing.T) {
+	minCost := 0.5
+	filter := &utils.CdrsFilter{Tenant: "cgrates.org", Account: "1001' OR '1'='1", DestinationPrefix: "+49_86%", RunId: "rif",
+		MinCost: &minCost, OrderBy: utils.CDR_ORDER_COST, OrderDesc: true, Limit: 10, Offset: 20}
+	q, args, err := cdrsFilterQuery(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"rated_cdrs.subject=?", "cdrs_primary.tenant=?", "cdrs_primary.account=?",
+		"cdrs_primary.destination LIKE ?", "rated_cdrs.cost>=?", "ORDER BY rated_cdrs.cost DESC, cdrs_primary.id LIMIT 10 OFFSET 20"} {
+		if !strings.Contains(q, expected) {
+			t.Errorf("Expected <%s> in query: %s", expected, q)
+		}
+	}
+	if strings.Contains(q, "1001") {
+		t.Error("Filter value in query: ", q)
+	}
+	// in the order of the placeholders, the wildcards of the prefix matched literally
+	eArgs := []interface{}{"rif", "cgrates.org", "1001' OR '1'='1", `+49\_86\%%`, 0.5}
+	if !reflect.DeepEqual(args, eArgs) {
+		t.Errorf("Expected args %v, received: %v", eArgs, args)
+	}
+	if q, args, _ := cdrsFilterQuery(&utils.CdrsFilter{}); strings.Contains(q, "WHERE") || strings.Contains(q, "LIMIT") || len(args) != 0 {
+		t.Error("Unexpected conditions in unfiltered query: ", q, args)
+	}
+	if q, _, _ := cdrsFilterQuery(&utils.CdrsFilter{Offset: 5}); !strings.HasSuffix(q, "LIMIT 18446744073709551615 OFFSET 5") {
+		t.Error("Unexpected offset without limit: ", q)
+	}
+	if _, _, err := cdrsFilterQuery(&utils.CdrsFilter{OrderBy: "cgrid; DROP TABLE cdrs_primary"}); err == nil {
+		t.Error("Accepted unknown order column")
+	}
+}
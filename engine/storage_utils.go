@@ -22,6 +22,8 @@ import (
 	"errors"
 	"github.com/cgrates/cgrates/utils"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Various helpers to deal with database
@@ -53,3 +55,16 @@ func ConfigureDatabase(db_type, host, port, name, user, pass string) (db DataSto
 	}
 	return db, nil
 }
+
+// Leases are stored as owner|expiry so a single value tells both who holds it and until when
+func marshalLease(owner string, expires time.Time) string {
+	return owner + "|" + expires.Format(time.RFC3339Nano)
+}
+
+func unmarshalLease(value string) (owner string, expires time.Time) {
+	if idx := strings.LastIndex(value, "|"); idx != -1 {
+		owner = value[:idx]
+		expires, _ = time.Parse(time.RFC3339Nano, value[idx+1:])
+	}
+	return
+}
@@ -166,7 +166,8 @@ func (self *Mediator) TrackCDRFiles() (err error) {
 // Retrive the cost from logging database
 func (self *Mediator) getCostsFromDB(cdr utils.CDR) (cc *engine.CallCost, err error) {
 	for i := 0; i < 3; i++ { // Mechanism to avoid concurrency between SessionManager writing the costs and mediator picking them up
-		cc, err = self.storDb.GetCallCostLog(cdr.GetCgrId(), engine.SESSION_MANAGER_SOURCE) //ToDo: What are we getting when there is no log?
+		// Session managers log the costs under the call uuid, the cgrid of the CDR can include the dedup fields
+		cc, err = self.storDb.GetCallCostLog(utils.FSCgrId(cdr.GetAccId()), engine.SESSION_MANAGER_SOURCE) //ToDo: What are we getting when there is no log?
 		if cc != nil { // There were no errors, chances are that we got what we are looking for
 			break
 		}
@@ -175,14 +176,25 @@ func (self *Mediator) getCostsFromDB(cdr utils.CDR) (cc *engine.CallCost, err er
 	return
 }
 
-// Retrive the cost from engine
-func (self *Mediator) getCostsFromRater(cdr utils.CDR) (*engine.CallCost, error) {
-	cc := &engine.CallCost{}
-	d, err := time.ParseDuration(strconv.FormatInt(cdr.GetDuration(), 10) + "s")
+// Retrive the cost from engine and log it
+func (self *Mediator) getCostsFromRater(cdr utils.CDR, debit bool) (*engine.CallCost, error) {
+	cc, err := self.rateCdr(cdr, debit)
 	if err != nil {
-		return nil, err
+		self.storDb.LogError(cdr.GetCgrId(), engine.MEDIATOR_SOURCE, err.Error())
+	} else if cdr.GetDuration() != 0 { // Failed calls have nothing to log
+		// If the mediator calculated a price it will write it to logdb
+		if errLog := self.storDb.LogCallCost(cdr.GetCgrId(), engine.MEDIATOR_SOURCE, cc); errLog != nil {
+			engine.Logger.Err(fmt.Sprintf("<Mediator> Could not log the costs of cgrid: <%s>, err: <%s>", cdr.GetCgrId(), errLog.Error()))
+		}
 	}
-	if d.Seconds() == 0 { // failed call,  returning empty callcost, no error
+	return cc, err
+}
+
+// Calculates the cost with the rater, debiting pseudoprepaid balances only when asked to
+func (self *Mediator) rateCdr(cdr utils.CDR, debit bool) (*engine.CallCost, error) {
+	cc := &engine.CallCost{}
+	d := engine.UsageDuration(cdr.GetTOR(), float64(cdr.GetDuration())) // bytes for data CDRs
+	if d == 0 { // failed call,  returning empty callcost, no error
 		return cc, nil
 	}
 	t1, err := cdr.GetAnswerTime()
@@ -198,20 +210,35 @@ func (self *Mediator) getCostsFromRater(cdr utils.CDR) (*engine.CallCost, error)
 		Destination: cdr.GetDestination(),
 		TimeStart:   t1,
 		TimeEnd:     t1.Add(d)}
-	if cdr.GetReqType() == utils.PSEUDOPREPAID {
+	if debit && cdr.GetReqType() == utils.PSEUDOPREPAID {
 		err = self.connector.Debit(cd, cc)
 	} else {
 		err = self.connector.GetCost(cd, cc)
 	}
-	if err != nil {
-		self.storDb.LogError(cdr.GetCgrId(), engine.MEDIATOR_SOURCE, err.Error())
-	} else {
-		// If the mediator calculated a price it will write it to logdb
-		self.storDb.LogCallCost(cdr.GetCgrId(), engine.MEDIATOR_SOURCE, cc)
-	}
 	return cc, err
 }
 
+// Charges the difference between the new cost and the one charged before, refunding it when negative
+func (self *Mediator) debitCostDiff(cdr utils.CDR, oldCost float64, cc *engine.CallCost) error {
+	if oldCost < 0 { // not charged before
+		oldCost = 0
+	}
+	diff := utils.Round(cc.Cost+cc.ConnectFee-oldCost, 4, utils.ROUNDING_MIDDLE) // Precision of rated_cdrs.cost
+	if diff == 0 {
+		return nil
+	}
+	cd := engine.CallDescriptor{
+		Direction:   "*out",
+		Tenant:      cdr.GetTenant(),
+		TOR:         cdr.GetTOR(),
+		Subject:     cdr.GetSubject(),
+		Account:     cdr.GetAccount(),
+		Destination: cdr.GetDestination(),
+		Amount:      diff}
+	var reply float64
+	return self.connector.DebitCents(cd, &reply)
+}
+
 // Parse the files and get cost for every record
 func (self *Mediator) MediateCSVCDR(cdrfn string) (err error) {
 	flag.Parse()
@@ -256,7 +283,7 @@ func (self *Mediator) MediateCSVCDR(cdrfn string) (err error) {
 				// Should be previously calculated and stored in DB
 				cc, errCost = self.getCostsFromDB(csvCDR)
 			} else {
-				cc, errCost = self.getCostsFromRater(csvCDR)
+				cc, errCost = self.getCostsFromRater(csvCDR, true)
 			}
 			cost := "-1"
 			if errCost != nil || cc == nil {
@@ -281,7 +308,7 @@ func (self *Mediator) MediateDBCDR(cdr utils.CDR, db engine.DataStorage) error {
 		// Should be previously calculated and stored in DB
 		qryCC, errCost = self.getCostsFromDB(cdr)
 	} else {
-		qryCC, errCost = self.getCostsFromRater(cdr)
+		qryCC, errCost = self.getCostsFromRater(cdr, true)
 	}
 	if errCost != nil || qryCC == nil {
 		engine.Logger.Err(fmt.Sprintf("<Mediator> Could not calculate price for cgrid: <%s>, err: <%s>, cost: <%v>", cdr.GetCgrId(), errCost.Error(), qryCC))
@@ -295,3 +322,28 @@ func (self *Mediator) MediateDBCDR(cdr utils.CDR, db engine.DataStorage) error {
 	}
 	return self.storDb.SetRatedCdr(cdr, cc, extraInfo)
 }
+
+// Recalculates the cost of an already stored CDR with the rater, ignoring the cost logged by SessionManager.
+// On debit the pseudoprepaid balances are charged the difference to the old cost, -1 if not rated before.
+// On dryRun nothing is debited or stored, the new cost is only returned.
+func (self *Mediator) RerateCdr(cdr utils.CDR, oldCost float64, debit, dryRun bool) (*engine.CallCost, error) {
+	if dryRun {
+		return self.rateCdr(cdr, false)
+	}
+	cc, errCost := self.getCostsFromRater(cdr, false)
+	if errCost == nil && debit && cdr.GetReqType() == utils.PSEUDOPREPAID {
+		errCost = self.debitCostDiff(cdr, oldCost, cc)
+	}
+	if errCost != nil {
+		engine.Logger.Err(fmt.Sprintf("<Mediator> Could not rerate cgrid: <%s>, err: <%s>", cdr.GetCgrId(), errCost.Error()))
+		// the cost stored is the one charged, keep it so a rerun debits the difference to it
+		if err := self.storDb.SetRatedCdrError(cdr, errCost.Error()); err != nil {
+			return nil, err
+		}
+		return nil, errCost
+	}
+	if err := self.storDb.SetRatedCdr(cdr, cc, ""); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
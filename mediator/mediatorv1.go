@@ -0,0 +1,52 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mediator
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Exposes the mediator over rpc so CDRS can forward CDRs to a remote one
+type MediatorV1 struct {
+	medi *Mediator
+}
+
+func NewMediatorV1(medi *Mediator) *MediatorV1 {
+	return &MediatorV1{medi: medi}
+}
+
+// Rates and stores the received CDR, reply is OK when the rated CDR was stored.
+// CDRs rated already are the resends of deliveries not confirmed to the CDRS and are not rated again.
+func (self *MediatorV1) MediateCdr(cdr utils.StoredCdr, reply *string) error {
+	if cdr.CgrId == "" {
+		return fmt.Errorf("%s:CgrId", utils.ERR_MANDATORY_IE_MISSING)
+	}
+	if rated, err := self.medi.storDb.ExistsRatedCdr(cdr.CgrId); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	} else if rated {
+		*reply = "OK"
+		return nil
+	}
+	if err := self.medi.MediateDBCDR(&cdr, self.medi.storDb); err != nil {
+		return err
+	}
+	*reply = "OK"
+	return nil
+}
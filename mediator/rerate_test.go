@@ -0,0 +1,205 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mediator
+
+import (
+	"errors"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"testing"
+	"time"
+)
+
+type fakeConnector struct {
+	engine.Connector
+	cost          float64
+	costs, debits int
+	debitedCents  []float64
+	debitErr      error
+	lastCd        engine.CallDescriptor
+}
+
+func (fc *fakeConnector) GetCost(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.costs++
+	fc.lastCd = cd
+	cc.Cost = fc.cost
+	return nil
+}
+
+func (fc *fakeConnector) Debit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.debits++
+	cc.Cost = fc.cost
+	return nil
+}
+
+func (fc *fakeConnector) DebitCents(cd engine.CallDescriptor, reply *float64) error {
+	if fc.debitErr != nil {
+		return fc.debitErr
+	}
+	fc.debitedCents = append(fc.debitedCents, cd.Amount)
+	return nil
+}
+
+type fakeRatedStorage struct {
+	engine.DataStorage
+	rated  map[string]float64
+	errors map[string]string
+}
+
+func (fs *fakeRatedStorage) SetRatedCdr(cdr utils.CDR, cc *engine.CallCost, extraInfo string) error {
+	fs.rated[cdr.GetCgrId()] = cc.Cost + cc.ConnectFee
+	return nil
+}
+
+func (fs *fakeRatedStorage) SetRatedCdrError(cdr utils.CDR, extraInfo string) error {
+	if _, rated := fs.rated[cdr.GetCgrId()]; !rated {
+		fs.rated[cdr.GetCgrId()] = -1
+	}
+	fs.errors[cdr.GetCgrId()] = extraInfo
+	return nil
+}
+
+func (fs *fakeRatedStorage) ExistsRatedCdr(cgrId string) (bool, error) {
+	cost, rated := fs.rated[cgrId]
+	return rated && cost >= 0, nil
+}
+
+func (fs *fakeRatedStorage) LogCallCost(uuid, source string, cc *engine.CallCost) error {
+	return nil
+}
+
+func (fs *fakeRatedStorage) LogError(uuid, source, errstr string) error {
+	return nil
+}
+
+func TestRerateCdr(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	connector := &fakeConnector{cost: 1.5}
+	storDb := &fakeRatedStorage{rated: make(map[string]float64)}
+	medi, err := NewMediator(connector, storDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdr := &utils.StoredCdr{CgrId: "cgrid1", ReqType: utils.PSEUDOPREPAID, Direction: "*out", Tenant: "cgrates.org", TOR: "0",
+		Account: "1001", Subject: "1001", Destination: "1002", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 10}
+	if cc, err := medi.RerateCdr(cdr, -1, false, true); err != nil {
+		t.Error(err)
+	} else if cc.Cost != 1.5 {
+		t.Errorf("Unexpected cost: %v", cc.Cost)
+	}
+	if len(storDb.rated) != 0 || connector.debits != 0 || len(connector.debitedCents) != 0 {
+		t.Errorf("Dry run had side effects, rated: %v, debits: %d", storDb.rated, connector.debits)
+	}
+	if _, err := medi.RerateCdr(cdr, -1, false, false); err != nil {
+		t.Error(err)
+	}
+	if storDb.rated["cgrid1"] != 1.5 || connector.debits != 0 || len(connector.debitedCents) != 0 {
+		t.Errorf("Unexpected rerate, rated: %v, debits: %d", storDb.rated, connector.debits)
+	}
+	// only the difference to the cost charged before is debited
+	connector.cost = 2
+	if _, err := medi.RerateCdr(cdr, 1.5, true, false); err != nil {
+		t.Error(err)
+	}
+	if storDb.rated["cgrid1"] != 2 || connector.debits != 0 || len(connector.debitedCents) != 1 || connector.debitedCents[0] != 0.5 {
+		t.Errorf("Unexpected rerate with debits, rated: %v, debited: %v", storDb.rated, connector.debitedCents)
+	}
+	if _, err := medi.RerateCdr(cdr, 2, true, false); err != nil {
+		t.Error(err)
+	}
+	if len(connector.debitedCents) != 1 {
+		t.Errorf("Unchanged cost debited: %v", connector.debitedCents)
+	}
+	// cheaper now, the difference is refunded
+	connector.cost = 1
+	if _, err := medi.RerateCdr(cdr, 2, true, false); err != nil {
+		t.Error(err)
+	}
+	if len(connector.debitedCents) != 2 || connector.debitedCents[1] != -1 {
+		t.Errorf("Difference not refunded: %v", connector.debitedCents)
+	}
+}
+
+func TestRateDataCdr(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	connector := &fakeConnector{cost: 0.1}
+	medi, err := NewMediator(connector, &fakeRatedStorage{rated: make(map[string]float64)}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerTime := time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC)
+	cdr := &utils.StoredCdr{CgrId: "cgrid_data", ReqType: utils.POSTPAID, Direction: "*out", Tenant: "cgrates.org", TOR: engine.DATA_TOR,
+		Account: "1001", Subject: "1001", Destination: "internet", AnswerTime: answerTime, Duration: 1024}
+	if _, err := medi.rateCdr(cdr, false); err != nil {
+		t.Fatal(err)
+	}
+	// one nanosecond per byte
+	if !connector.lastCd.TimeEnd.Equal(answerTime.Add(1024)) || connector.lastCd.TOR != engine.DATA_TOR {
+		t.Errorf("Wrong call descriptor for data CDR: %+v", connector.lastCd)
+	}
+}
+
+func TestMediatorV1SkipsRatedCdr(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	connector := &fakeConnector{cost: 1.5}
+	storDb := &fakeRatedStorage{rated: make(map[string]float64)}
+	medi, err := NewMediator(connector, storDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediV1 := NewMediatorV1(medi)
+	cdr := utils.StoredCdr{CgrId: "cgrid1", ReqType: utils.PSEUDOPREPAID, Direction: "*out", Tenant: "cgrates.org", TOR: "0",
+		Account: "1001", Subject: "1001", Destination: "1002", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 10}
+	var reply string
+	for i := 0; i < 2; i++ { // resent after the reply got lost
+		if err := mediV1.MediateCdr(cdr, &reply); err != nil || reply != "OK" {
+			t.Error("Unexpected reply: ", reply, err)
+		}
+	}
+	if connector.debits != 1 || storDb.rated["cgrid1"] != 1.5 {
+		t.Errorf("Resent CDR debited again, debits: %d, rated: %v", connector.debits, storDb.rated)
+	}
+}
+
+func TestRerateCdrDebitFailed(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	connector := &fakeConnector{cost: 2, debitErr: errors.New("SERVER_ERROR")}
+	storDb := &fakeRatedStorage{rated: map[string]float64{"cgrid1": 1.5}, errors: make(map[string]string)}
+	medi, err := NewMediator(connector, storDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdr := &utils.StoredCdr{CgrId: "cgrid1", ReqType: utils.PSEUDOPREPAID, Direction: "*out", Tenant: "cgrates.org", TOR: "0",
+		Account: "1001", Subject: "1001", Destination: "1002", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 10}
+	if _, err := medi.RerateCdr(cdr, storDb.rated["cgrid1"], true, false); err == nil {
+		t.Error("Expecting the debit error")
+	}
+	if storDb.rated["cgrid1"] != 1.5 || storDb.errors["cgrid1"] != "SERVER_ERROR" {
+		t.Errorf("Charged cost not kept on failure, rated: %v, errors: %v", storDb.rated, storDb.errors)
+	}
+	// rerun once the rater is back, only the difference is debited
+	connector.debitErr = nil
+	if _, err := medi.RerateCdr(cdr, storDb.rated["cgrid1"], true, false); err != nil {
+		t.Error(err)
+	}
+	if storDb.rated["cgrid1"] != 2 || len(connector.debitedCents) != 1 || connector.debitedCents[0] != 0.5 {
+		t.Errorf("Unexpected rerun, rated: %v, debited: %v", storDb.rated, connector.debitedCents)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package radius
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Acct-Status-Type
+	ACCT_START   = "1"
+	ACCT_STOP    = "2"
+	ACCT_INTERIM = "3"
+	// retransmitted stops are recognized for this long
+	STOP_MEMORY = time.Minute
+)
+
+// Fields of the CallDescriptor populated out of the request attributes
+var mappedFields = []string{"direction", "tenant", "tor", "reqtype", "account", "subject", "destination"}
+
+// Where the stop records go, satisfied by the internal CDRS and by the http client towards a remote one
+type CdrProcessor interface {
+	ProcessCdr(utils.CDR) error
+}
+
+// Accounting session, charged as interim updates come in
+type acctSession struct {
+	sync.Mutex
+	cdr       *RadiusCdr
+	charged   float64 // seconds debited so far
+	callCosts []*engine.CallCost
+}
+
+// RADIUS server authorizing on the account balance and charging the accounting records
+type RadiusAgent struct {
+	cgrCfg     *config.CGRConfig
+	connector  engine.Connector
+	loggerDb   engine.DataStorage // where the session costs are logged for the mediator
+	cdrs       CdrProcessor
+	dict       *Dictionary
+	fieldAttrs map[string]string // mapped field -> attribute name
+	sessions   map[string]*acctSession
+	stopped    map[string]time.Time // sessions stopped recently
+	sync.Mutex                      // protects sessions and stopped
+	authConn   *net.UDPConn
+	acctConn   *net.UDPConn
+}
+
+func NewRadiusAgent(cgrCfg *config.CGRConfig, connector engine.Connector, loggerDb engine.DataStorage, cdrs CdrProcessor) (*RadiusAgent, error) {
+	ra := &RadiusAgent{cgrCfg: cgrCfg, connector: connector, loggerDb: loggerDb, cdrs: cdrs, dict: NewDictionary(),
+		fieldAttrs: make(map[string]string), sessions: make(map[string]*acctSession), stopped: make(map[string]time.Time)}
+	if cgrCfg.RadiusAgentDictionary != "" {
+		if err := ra.dict.LoadFile(cgrCfg.RadiusAgentDictionary); err != nil {
+			return nil, fmt.Errorf("<RadiusAgent> Cannot load dictionary: %s", err.Error())
+		}
+	}
+	for idx, attrName := range []string{cgrCfg.RadiusAgentDirectionField, cgrCfg.RadiusAgentTenantField, cgrCfg.RadiusAgentTORField,
+		cgrCfg.RadiusAgentReqTypeField, cgrCfg.RadiusAgentAccountField, cgrCfg.RadiusAgentSubjectField, cgrCfg.RadiusAgentDestField} {
+		if len(attrName) == 0 { // Populated out of defaults
+			continue
+		}
+		if ra.dict.AttributeByName(attrName) == nil {
+			return nil, fmt.Errorf("<RadiusAgent> Unknown attribute %s for %s field", attrName, mappedFields[idx])
+		}
+		ra.fieldAttrs[mappedFields[idx]] = attrName
+	}
+	return ra, nil
+}
+
+// Serves authorization and accounting requests till Shutdown
+func (ra *RadiusAgent) ListenAndServe() error {
+	if err := ra.listen(); err != nil {
+		return err
+	}
+	go ra.serve(ra.authConn, ra.processAccessRequest)
+	ra.serve(ra.acctConn, ra.processAccountingRequest)
+	return nil
+}
+
+func (ra *RadiusAgent) listen() error {
+	for _, lsn := range []struct {
+		addr string
+		conn **net.UDPConn
+	}{{ra.cgrCfg.RadiusAgentListenAuth, &ra.authConn}, {ra.cgrCfg.RadiusAgentListenAcct, &ra.acctConn}} {
+		udpAddr, err := net.ResolveUDPAddr("udp", lsn.addr)
+		if err != nil {
+			return err
+		}
+		if *lsn.conn, err = net.ListenUDP("udp", udpAddr); err != nil {
+			return err
+		}
+		engine.Logger.Info(fmt.Sprintf("<RadiusAgent> Listening for radius requests on %v", (*lsn.conn).LocalAddr()))
+	}
+	return nil
+}
+
+func (ra *RadiusAgent) serve(conn *net.UDPConn, process func(*Packet, []byte) []byte) {
+	for {
+		buf := make([]byte, MAX_PACKET_LEN)
+		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error reading request: %v", err))
+			continue
+		}
+		go func(data []byte, remoteAddr *net.UDPAddr) {
+			req, err := ParsePacket(data)
+			if err != nil {
+				engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Invalid packet from %v: %v", remoteAddr, err))
+				return
+			}
+			if reply := process(req, data); reply != nil {
+				if _, err := conn.WriteToUDP(reply, remoteAddr); err != nil {
+					engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error replying to %v: %v", remoteAddr, err))
+				}
+			}
+		}(buf[:n], remoteAddr)
+	}
+}
+
+func (ra *RadiusAgent) Shutdown() error {
+	for _, conn := range []*net.UDPConn{ra.authConn, ra.acctConn} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	return nil
+}
+
+// Accepts prepaid requests having credit, Session-Timeout limiting them to it
+func (ra *RadiusAgent) processAccessRequest(req *Packet, data []byte) []byte {
+	if req.Code != ACCESS_REQUEST {
+		return nil
+	}
+	cdr := ra.buildCdr(req)
+	if cdr.account == "" || cdr.destination == "" {
+		return ra.reject(req, sessionmanager.MISSING_PARAMETER)
+	}
+	if cdr.reqType != utils.PREPAID {
+		return req.Reply(ACCESS_ACCEPT, ra.cgrCfg.RadiusAgentSecret)
+	}
+	cd := ra.callDescriptor(cdr, time.Now())
+	cd.Amount = float64(ra.cgrCfg.RadiusAgentMaxSessionTime)
+	var maxSessionTime float64
+	if err := ra.connector.GetMaxSessionTime(*cd, &maxSessionTime); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Could not get max session time for %s: %v", cdr.account, err))
+		return ra.reject(req, sessionmanager.SYSTEM_ERROR)
+	}
+	if maxSessionTime == -1 { // unlimited
+		return req.Reply(ACCESS_ACCEPT, ra.cgrCfg.RadiusAgentSecret)
+	}
+	if maxSessionTime < 1 {
+		return ra.reject(req, sessionmanager.INSUFFICIENT_FUNDS)
+	}
+	sessionTimeout, _ := ra.dict.NewAttribute("Session-Timeout", strconv.Itoa(int(maxSessionTime)))
+	return req.Reply(ACCESS_ACCEPT, ra.cgrCfg.RadiusAgentSecret, sessionTimeout)
+}
+
+func (ra *RadiusAgent) reject(req *Packet, reason string) []byte {
+	replyMsg, _ := ra.dict.NewAttribute("Reply-Message", reason)
+	return req.Reply(ACCESS_REJECT, ra.cgrCfg.RadiusAgentSecret, replyMsg)
+}
+
+// Charges the session time reported, stop records are stored as CDRs
+func (ra *RadiusAgent) processAccountingRequest(req *Packet, data []byte) []byte {
+	if req.Code != ACCOUNTING_REQUEST {
+		return nil
+	}
+	if !ValidAccountingRequest(data, ra.cgrCfg.RadiusAgentSecret) {
+		engine.Logger.Warning(fmt.Sprintf("<RadiusAgent> Dropping accounting request %d with invalid authenticator", req.Identifier))
+		return nil
+	}
+	accId := ra.dict.Value(req, "Acct-Session-Id")
+	if accId == "" {
+		engine.Logger.Err("<RadiusAgent> Accounting request without Acct-Session-Id")
+		return nil // no answer lets the NAS know we could not record it
+	}
+	statusType := ra.dict.Value(req, "Acct-Status-Type")
+	sessionTime, _ := strconv.ParseFloat(ra.dict.Value(req, "Acct-Session-Time"), 64)
+	ra.Lock()
+	if _, stopped := ra.stopped[accId]; stopped { // retransmission, already accounted
+		ra.Unlock()
+		return req.Reply(ACCOUNTING_RESPONSE, ra.cgrCfg.RadiusAgentSecret)
+	}
+	s, exists := ra.sessions[accId]
+	if !exists { // interim or stop without start in case of restarts
+		s = &acctSession{cdr: ra.buildCdr(req)}
+		ra.sessions[accId] = s
+	}
+	if statusType == ACCT_STOP {
+		delete(ra.sessions, accId)
+		ra.stopped[accId] = time.Now()
+		for stoppedId, stopTime := range ra.stopped {
+			if time.Since(stopTime) > STOP_MEMORY {
+				delete(ra.stopped, stoppedId)
+			}
+		}
+	}
+	ra.Unlock()
+	switch statusType {
+	case ACCT_START:
+	case ACCT_INTERIM:
+		if err := ra.charge(s, sessionTime); err != nil {
+			return nil // NAS will retransmit
+		}
+	case ACCT_STOP:
+		if err := ra.charge(s, sessionTime); err != nil {
+			ra.Lock()
+			delete(ra.stopped, accId) // accept the retransmission
+			ra.sessions[accId] = s
+			ra.Unlock()
+			return nil
+		}
+		ra.storeCdr(s, sessionTime)
+	default:
+		engine.Logger.Warning(fmt.Sprintf("<RadiusAgent> Unsupported Acct-Status-Type %s for session %s", statusType, accId))
+	}
+	return req.Reply(ACCOUNTING_RESPONSE, ra.cgrCfg.RadiusAgentSecret)
+}
+
+// Debits the session time not charged yet
+func (ra *RadiusAgent) charge(s *acctSession, sessionTime float64) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.cdr.reqType != utils.PREPAID && s.cdr.reqType != utils.POSTPAID { // left to the mediator
+		return nil
+	}
+	if sessionTime <= s.charged {
+		return nil
+	}
+	cd := ra.callDescriptor(s.cdr, s.cdr.answerTime.Add(time.Duration(s.charged)*time.Second))
+	cd.TimeEnd = s.cdr.answerTime.Add(time.Duration(sessionTime) * time.Second)
+	cd.CallDuration = cd.TimeEnd.Sub(s.cdr.answerTime)
+	cd.LoopIndex = float64(len(s.callCosts))
+	cd.Amount = sessionTime - s.charged
+	cc := &engine.CallCost{}
+	if err := ra.connector.Debit(*cd, cc); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Could not debit session %s: %v", s.cdr.accId, err))
+		return err
+	}
+	s.callCosts = append(s.callCosts, cc)
+	s.charged = sessionTime
+	return nil
+}
+
+// Logs the costs where the mediator looks for them and sends the CDR to the CDRS
+func (ra *RadiusAgent) storeCdr(s *acctSession, sessionTime float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.cdr.duration = int64(sessionTime)
+	if len(s.callCosts) != 0 && ra.loggerDb != nil {
+		firstCC := s.callCosts[0]
+		for _, cc := range s.callCosts[1:] {
+			firstCC.Merge(cc)
+		}
+		if err := ra.loggerDb.LogCallCost(s.cdr.cgrId, engine.SESSION_MANAGER_SOURCE, firstCC); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Could not log costs for session %s: %v", s.cdr.accId, err))
+		}
+	}
+	if ra.cdrs == nil {
+		engine.Logger.Err("<RadiusAgent> No connection to CDRS, cannot store CDR")
+		return
+	}
+	if err := ra.cdrs.ProcessCdr(s.cdr); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Could not process CDR for session %s: %v", s.cdr.accId, err))
+	}
+}
+
+func (ra *RadiusAgent) callDescriptor(cdr *RadiusCdr, timeStart time.Time) *engine.CallDescriptor {
+	return &engine.CallDescriptor{Direction: cdr.direction, Tenant: cdr.tenant, TOR: cdr.tor, Subject: cdr.subject,
+		Account: cdr.account, Destination: cdr.destination, TimeStart: timeStart, FallbackSubject: cdr.fallbackSubj}
+}
+
+// Populates the CDR out of the attributes mapped, the rest out of defaults
+func (ra *RadiusAgent) buildCdr(req *Packet) *RadiusCdr {
+	vals := make(map[string]string)
+	for fieldName, attrName := range ra.fieldAttrs {
+		vals[fieldName] = ra.dict.Value(req, attrName)
+	}
+	cdr := &RadiusCdr{accId: ra.dict.Value(req, "Acct-Session-Id"),
+		cdrHost:      utils.FirstNonEmpty(ra.dict.Value(req, "NAS-IP-Address"), ra.dict.Value(req, "NAS-Identifier")),
+		direction:    utils.FirstNonEmpty(vals["direction"], engine.OUTBOUND),
+		tenant:       utils.FirstNonEmpty(vals["tenant"], ra.cgrCfg.DefaultTenant),
+		tor:          utils.FirstNonEmpty(vals["tor"], ra.cgrCfg.DefaultTOR),
+		reqType:      utils.FirstNonEmpty(vals["reqtype"], ra.cgrCfg.DefaultReqType),
+		account:      vals["account"],
+		subject:      utils.FirstNonEmpty(vals["subject"], vals["account"]),
+		destination:  vals["destination"],
+		fallbackSubj: ra.cgrCfg.DefaultSubject,
+		extraFields:  make(map[string]string, len(ra.cgrCfg.CDRSExtraFields))}
+	cdr.cgrId = utils.FSCgrId(cdr.accId)
+	for _, field := range ra.cgrCfg.CDRSExtraFields {
+		cdr.extraFields[field] = ra.dict.Value(req, field)
+	}
+	// The session started as much before the moment of the record as it lasted
+	eventTime, err := parseRadiusDate(ra.dict.Value(req, "Event-Timestamp"))
+	if err != nil {
+		delay, _ := strconv.Atoi(ra.dict.Value(req, "Acct-Delay-Time"))
+		eventTime = time.Now().Add(-time.Duration(delay) * time.Second)
+	}
+	sessionTime, _ := strconv.Atoi(ra.dict.Value(req, "Acct-Session-Time"))
+	cdr.answerTime = eventTime.Add(-time.Duration(sessionTime) * time.Second)
+	return cdr
+}
@@ -0,0 +1,210 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package radius
+
+import (
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeConnector struct {
+	sync.Mutex
+	maxSessionTime float64
+	debited        float64 // seconds
+}
+
+func (fc *fakeConnector) GetCost(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	return nil
+}
+
+func (fc *fakeConnector) Debit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.debited += cd.TimeEnd.Sub(cd.TimeStart).Seconds()
+	cc.Timespans = []*engine.TimeSpan{&engine.TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeEnd}}
+	return nil
+}
+
+func (fc *fakeConnector) MaxDebit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	return nil
+}
+
+func (fc *fakeConnector) DebitCents(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) DebitSeconds(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) DebitSMS(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) GetMaxSessionTime(cd engine.CallDescriptor, reply *float64) error {
+	fc.Lock()
+	defer fc.Unlock()
+	*reply = fc.maxSessionTime
+	return nil
+}
+
+func (fc *fakeConnector) getDebited() float64 {
+	fc.Lock()
+	defer fc.Unlock()
+	return fc.debited
+}
+
+// Keeps the CDRs stored
+type cdrRecorder struct {
+	sync.Mutex
+	cdrs []utils.CDR
+}
+
+func (cr *cdrRecorder) ProcessCdr(cdr utils.CDR) error {
+	cr.Lock()
+	defer cr.Unlock()
+	cr.cdrs = append(cr.cdrs, cdr)
+	return nil
+}
+
+// Plays the NAS
+type testNas struct {
+	t      *testing.T
+	dict   *Dictionary
+	conn   *net.UDPConn
+	secret string
+	ids    uint8
+}
+
+func (nas *testNas) send(code uint8, attrs map[string]string) *Packet {
+	nas.ids++
+	req := &Packet{Code: code, Identifier: nas.ids, Authenticator: [AUTHENTICATOR_LEN]byte{nas.ids}}
+	for name, value := range attrs {
+		attr, err := nas.dict.NewAttribute(name, value)
+		if err != nil {
+			nas.t.Fatal(err)
+		}
+		req.AddAttribute(attr)
+	}
+	if code == ACCOUNTING_REQUEST {
+		req.Authenticator = AccountingRequestAuthenticator(req.Encode(), nas.secret)
+	}
+	if _, err := nas.conn.Write(req.Encode()); err != nil {
+		nas.t.Fatal("Cannot send request: ", err)
+	}
+	nas.conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, MAX_PACKET_LEN)
+	n, err := nas.conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	reply, err := ParsePacket(buf[:n])
+	if err != nil || reply.Identifier != req.Identifier {
+		nas.t.Fatal("Not our reply: ", reply, err)
+	}
+	return reply
+}
+
+func TestRadiusAgent(t *testing.T) {
+	raCfg, _ := config.NewCGRConfigBytes([]byte("[radius_agent]\nlisten_auth = 127.0.0.1:0\nlisten_acct = 127.0.0.1:0\nsecret = testing123\n"))
+	raCfg.DefaultReqType = utils.PREPAID
+	raCfg.CDRSExtraFields = []string{"Calling-Station-Id"}
+	connector := &fakeConnector{maxSessionTime: 120}
+	storage, _ := engine.NewMapStorage()
+	cdrDb := &cdrRecorder{}
+	ra, err := NewRadiusAgent(raCfg, connector, storage, cdrDb)
+	if err != nil {
+		t.Fatal("Cannot create agent: ", err)
+	}
+	if err := ra.listen(); err != nil {
+		t.Fatal("Cannot listen: ", err)
+	}
+	go ra.serve(ra.authConn, ra.processAccessRequest)
+	go ra.serve(ra.acctConn, ra.processAccountingRequest)
+	defer ra.Shutdown()
+	authConn, _ := net.DialUDP("udp", nil, ra.authConn.LocalAddr().(*net.UDPAddr))
+	defer authConn.Close()
+	acctConn, _ := net.DialUDP("udp", nil, ra.acctConn.LocalAddr().(*net.UDPAddr))
+	defer acctConn.Close()
+	dict := NewDictionary()
+	authNas := &testNas{t: t, dict: dict, conn: authConn, secret: "testing123"}
+	acctNas := &testNas{t: t, dict: dict, conn: acctConn, secret: "testing123"}
+
+	reply := authNas.send(ACCESS_REQUEST, map[string]string{"User-Name": "1001", "Called-Station-Id": "1002"})
+	if reply == nil || reply.Code != ACCESS_ACCEPT || dict.Value(reply, "Session-Timeout") != "120" {
+		t.Errorf("Unexpected access reply: %+v", reply)
+	}
+	reply = authNas.send(ACCESS_REQUEST, map[string]string{"User-Name": "1001"})
+	if reply == nil || reply.Code != ACCESS_REJECT || dict.Value(reply, "Reply-Message") != sessionmanager.MISSING_PARAMETER {
+		t.Errorf("Unexpected access reply for missing destination: %+v", reply)
+	}
+	connector.Lock()
+	connector.maxSessionTime = 0
+	connector.Unlock()
+	reply = authNas.send(ACCESS_REQUEST, map[string]string{"User-Name": "1001", "Called-Station-Id": "1002"})
+	if reply == nil || reply.Code != ACCESS_REJECT || dict.Value(reply, "Reply-Message") != sessionmanager.INSUFFICIENT_FUNDS {
+		t.Errorf("Unexpected access reply without credit: %+v", reply)
+	}
+
+	evTime := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	acct := func(statusType string, sessionTime int) *Packet {
+		return acctNas.send(ACCOUNTING_REQUEST, map[string]string{"Acct-Status-Type": statusType, "Acct-Session-Id": "rad1",
+			"User-Name": "1001", "Called-Station-Id": "1002", "Calling-Station-Id": "1003", "NAS-IP-Address": "10.0.0.1",
+			"Acct-Session-Time": strconv.Itoa(sessionTime), "Event-Timestamp": strconv.FormatInt(evTime.Add(time.Duration(sessionTime)*time.Second).Unix(), 10)})
+	}
+	if reply = acct(ACCT_START, 0); reply == nil || reply.Code != ACCOUNTING_RESPONSE {
+		t.Errorf("Unexpected start reply: %+v", reply)
+	}
+	if reply = acct(ACCT_INTERIM, 60); reply == nil || connector.getDebited() != 60 {
+		t.Errorf("Interim not charged, reply: %+v, debited: %v", reply, connector.getDebited())
+	}
+	if reply = acct(ACCT_STOP, 75); reply == nil || connector.getDebited() != 75 {
+		t.Errorf("Stop not charged, reply: %+v, debited: %v", reply, connector.getDebited())
+	}
+	if reply = acct(ACCT_STOP, 75); reply == nil || connector.getDebited() != 75 {
+		t.Errorf("Retransmitted stop charged, reply: %+v, debited: %v", reply, connector.getDebited())
+	}
+	cdrDb.Lock()
+	defer cdrDb.Unlock()
+	if len(cdrDb.cdrs) != 1 {
+		t.Fatal("Expecting one CDR, got: ", cdrDb.cdrs)
+	}
+	cdr := cdrDb.cdrs[0]
+	if answerTime, _ := cdr.GetAnswerTime(); cdr.GetAccId() != "rad1" || cdr.GetCgrId() != utils.FSCgrId("rad1") || cdr.GetAccount() != "1001" ||
+		cdr.GetDestination() != "1002" || cdr.GetDuration() != 75 || !answerTime.Equal(evTime) || cdr.GetCdrHost() != "10.0.0.1" ||
+		cdr.GetExtraFields()["Calling-Station-Id"] != "1003" {
+		t.Errorf("Unexpected CDR: %+v", cdr)
+	}
+	if cc, err := storage.GetCallCostLog(utils.FSCgrId("rad1"), engine.SESSION_MANAGER_SOURCE); err != nil || cc == nil || len(cc.Timespans) == 0 ||
+		cc.Timespans[len(cc.Timespans)-1].TimeEnd.Sub(cc.Timespans[0].TimeStart) != 75*time.Second {
+		t.Error("Costs not logged for mediation: ", cc, err)
+	}
+	// Invalid authenticator gets no answer
+	acctNas.secret = "wrong"
+	if reply = acct(ACCT_START, 0); reply != nil {
+		t.Error("Not expecting reply on invalid authenticator: ", reply)
+	}
+}
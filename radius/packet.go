@@ -0,0 +1,374 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package radius
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// packet codes
+	ACCESS_REQUEST      = 1
+	ACCESS_ACCEPT       = 2
+	ACCESS_REJECT       = 3
+	ACCOUNTING_REQUEST  = 4
+	ACCOUNTING_RESPONSE = 5
+	// lengths
+	HEADER_LEN        = 20
+	AUTHENTICATOR_LEN = 16
+	MAX_PACKET_LEN    = 4096
+	// attributes handled by the protocol itself
+	VENDOR_SPECIFIC       = 26
+	MESSAGE_AUTHENTICATOR = 80
+	// attribute types
+	TYPE_STRING  = "string"
+	TYPE_OCTETS  = "octets"
+	TYPE_INTEGER = "integer"
+	TYPE_IPADDR  = "ipaddr"
+	TYPE_DATE    = "date"
+)
+
+// RADIUS packet as defined in RFC 2865
+type Packet struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [AUTHENTICATOR_LEN]byte
+	Attributes    []*Attribute
+}
+
+// Attribute, vendor specific ones are decoded out of Vendor-Specific with VendorId populated
+type Attribute struct {
+	VendorId uint32
+	Type     uint8
+	Value    []byte
+}
+
+// Decodes a packet, vendor specific attributes included
+func ParsePacket(data []byte) (*Packet, error) {
+	if len(data) < HEADER_LEN {
+		return nil, errors.New("packet too short")
+	}
+	pktLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if pktLen < HEADER_LEN || pktLen > len(data) || pktLen > MAX_PACKET_LEN {
+		return nil, fmt.Errorf("invalid packet length: %d", pktLen)
+	}
+	p := &Packet{Code: data[0], Identifier: data[1]}
+	copy(p.Authenticator[:], data[4:HEADER_LEN])
+	for attrs := data[HEADER_LEN:pktLen]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, errors.New("malformed attribute")
+		}
+		attrType, value := attrs[0], attrs[2:attrs[1]]
+		attrs = attrs[attrs[1]:]
+		if attrType != VENDOR_SPECIFIC || len(value) < 6 {
+			p.Attributes = append(p.Attributes, &Attribute{Type: attrType, Value: value})
+			continue
+		}
+		vendorId := binary.BigEndian.Uint32(value[0:4])
+		for vsas := value[4:]; len(vsas) > 0; {
+			if len(vsas) < 2 || vsas[1] < 2 || int(vsas[1]) > len(vsas) {
+				return nil, errors.New("malformed vendor specific attribute")
+			}
+			p.Attributes = append(p.Attributes, &Attribute{VendorId: vendorId, Type: vsas[0], Value: vsas[2:vsas[1]]})
+			vsas = vsas[vsas[1]:]
+		}
+	}
+	return p, nil
+}
+
+// Encodes the packet as it is, authenticators are to be set before
+func (p *Packet) Encode() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{p.Code, p.Identifier, 0, 0})
+	buf.Write(p.Authenticator[:])
+	for _, attr := range p.Attributes {
+		if attr.VendorId == 0 {
+			buf.Write([]byte{attr.Type, uint8(len(attr.Value) + 2)})
+			buf.Write(attr.Value)
+			continue
+		}
+		buf.Write([]byte{VENDOR_SPECIFIC, uint8(len(attr.Value) + 8)})
+		binary.Write(buf, binary.BigEndian, attr.VendorId)
+		buf.Write([]byte{attr.Type, uint8(len(attr.Value) + 2)})
+		buf.Write(attr.Value)
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	return data
+}
+
+func (p *Packet) AddAttribute(attrs ...*Attribute) *Packet {
+	p.Attributes = append(p.Attributes, attrs...)
+	return p
+}
+
+// First attribute of the type, nil if missing
+func (p *Packet) Attribute(vendorId uint32, attrType uint8) *Attribute {
+	for _, attr := range p.Attributes {
+		if attr.VendorId == vendorId && attr.Type == attrType {
+			return attr
+		}
+	}
+	return nil
+}
+
+// Reply to a request, authenticated with the shared secret.
+// If the request was protected with Message-Authenticator, the reply will be too.
+func (p *Packet) Reply(code uint8, secret string, attrs ...*Attribute) []byte {
+	reply := &Packet{Code: code, Identifier: p.Identifier, Authenticator: p.Authenticator, Attributes: attrs}
+	if p.Attribute(0, MESSAGE_AUTHENTICATOR) != nil {
+		msgAuth := &Attribute{Type: MESSAGE_AUTHENTICATOR, Value: make([]byte, md5.Size)}
+		reply.AddAttribute(msgAuth)
+		mac := hmac.New(md5.New, []byte(secret))
+		mac.Write(reply.Encode())
+		msgAuth.Value = mac.Sum(nil)
+	}
+	data := reply.Encode()
+	hash := md5.New()
+	hash.Write(data)
+	hash.Write([]byte(secret))
+	copy(data[4:HEADER_LEN], hash.Sum(nil))
+	return data
+}
+
+// Checks the Request Authenticator of an accounting request (RFC 2866), data being the packet as received
+func ValidAccountingRequest(data []byte, secret string) bool {
+	if len(data) < HEADER_LEN {
+		return false
+	}
+	hash := md5.New()
+	hash.Write(data[:4])
+	hash.Write(make([]byte, AUTHENTICATOR_LEN))
+	hash.Write(data[HEADER_LEN:])
+	hash.Write([]byte(secret))
+	return hmac.Equal(hash.Sum(nil), data[4:HEADER_LEN])
+}
+
+// Authenticator for accounting requests, out of the encoded packet. Used by clients.
+func AccountingRequestAuthenticator(data []byte, secret string) (auth [AUTHENTICATOR_LEN]byte) {
+	hash := md5.New()
+	hash.Write(data[:4])
+	hash.Write(make([]byte, AUTHENTICATOR_LEN))
+	hash.Write(data[HEADER_LEN:])
+	hash.Write([]byte(secret))
+	copy(auth[:], hash.Sum(nil))
+	return
+}
+
+// Attribute definition
+type AttributeDef struct {
+	Name     string
+	VendorId uint32
+	Type     uint8
+	DataType string
+}
+
+// Translates between attribute names and their encoding
+type Dictionary struct {
+	byName map[string]*AttributeDef
+	byType map[uint32]map[uint8]*AttributeDef // indexed on vendor id and type
+}
+
+// Dictionary with the RFC 2865/2866 attributes used in charging
+func NewDictionary() *Dictionary {
+	dict := &Dictionary{byName: make(map[string]*AttributeDef), byType: make(map[uint32]map[uint8]*AttributeDef)}
+	for _, def := range []*AttributeDef{
+		&AttributeDef{"User-Name", 0, 1, TYPE_STRING},
+		&AttributeDef{"User-Password", 0, 2, TYPE_OCTETS},
+		&AttributeDef{"NAS-IP-Address", 0, 4, TYPE_IPADDR},
+		&AttributeDef{"NAS-Port", 0, 5, TYPE_INTEGER},
+		&AttributeDef{"Service-Type", 0, 6, TYPE_INTEGER},
+		&AttributeDef{"Framed-IP-Address", 0, 8, TYPE_IPADDR},
+		&AttributeDef{"Reply-Message", 0, 18, TYPE_STRING},
+		&AttributeDef{"Class", 0, 25, TYPE_OCTETS},
+		&AttributeDef{"Session-Timeout", 0, 27, TYPE_INTEGER},
+		&AttributeDef{"Called-Station-Id", 0, 30, TYPE_STRING},
+		&AttributeDef{"Calling-Station-Id", 0, 31, TYPE_STRING},
+		&AttributeDef{"NAS-Identifier", 0, 32, TYPE_STRING},
+		&AttributeDef{"Acct-Status-Type", 0, 40, TYPE_INTEGER},
+		&AttributeDef{"Acct-Delay-Time", 0, 41, TYPE_INTEGER},
+		&AttributeDef{"Acct-Input-Octets", 0, 42, TYPE_INTEGER},
+		&AttributeDef{"Acct-Output-Octets", 0, 43, TYPE_INTEGER},
+		&AttributeDef{"Acct-Session-Id", 0, 44, TYPE_STRING},
+		&AttributeDef{"Acct-Session-Time", 0, 46, TYPE_INTEGER},
+		&AttributeDef{"Acct-Terminate-Cause", 0, 49, TYPE_INTEGER},
+		&AttributeDef{"Event-Timestamp", 0, 55, TYPE_DATE},
+		&AttributeDef{"NAS-Port-Type", 0, 61, TYPE_INTEGER},
+		&AttributeDef{"Message-Authenticator", 0, 80, TYPE_OCTETS},
+	} {
+		dict.addAttribute(def)
+	}
+	return dict
+}
+
+func (dict *Dictionary) addAttribute(def *AttributeDef) {
+	dict.byName[def.Name] = def
+	if _, hasVendor := dict.byType[def.VendorId]; !hasVendor {
+		dict.byType[def.VendorId] = make(map[uint8]*AttributeDef)
+	}
+	dict.byType[def.VendorId][def.Type] = def
+}
+
+// Loads attribute definitions out of a FreeRADIUS formatted dictionary file (VENDOR, BEGIN-VENDOR, END-VENDOR and ATTRIBUTE lines)
+func (dict *Dictionary) LoadFile(fPath string) error {
+	fd, err := os.Open(fPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	vendors := make(map[string]uint32)
+	var crtVendor uint32
+	scanner := bufio.NewScanner(fd)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "VENDOR":
+			if len(fields) < 3 {
+				return fmt.Errorf("%s:%d: invalid VENDOR line", fPath, lineNr)
+			}
+			vendorId, err := strconv.ParseUint(fields[2], 10, 32)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid vendor id: %s", fPath, lineNr, fields[2])
+			}
+			vendors[fields[1]] = uint32(vendorId)
+		case "BEGIN-VENDOR":
+			vendorId, known := uint32(0), false
+			if len(fields) > 1 {
+				vendorId, known = vendors[fields[1]]
+			}
+			if !known {
+				return fmt.Errorf("%s:%d: unknown vendor", fPath, lineNr)
+			}
+			crtVendor = vendorId
+		case "END-VENDOR":
+			crtVendor = 0
+		case "ATTRIBUTE":
+			if len(fields) < 4 {
+				return fmt.Errorf("%s:%d: invalid ATTRIBUTE line", fPath, lineNr)
+			}
+			attrType, err := strconv.ParseUint(fields[2], 10, 8)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid attribute type: %s", fPath, lineNr, fields[2])
+			}
+			def := &AttributeDef{Name: fields[1], VendorId: crtVendor, Type: uint8(attrType), DataType: fields[3]}
+			if len(fields) > 4 { // vendor given on the attribute line
+				if vendorId, known := vendors[fields[4]]; known {
+					def.VendorId = vendorId
+				}
+			}
+			dict.addAttribute(def)
+		}
+	}
+	return scanner.Err()
+}
+
+func (dict *Dictionary) AttributeByName(name string) *AttributeDef {
+	return dict.byName[name]
+}
+
+// Value of the named attribute in the packet as text, empty if missing
+func (dict *Dictionary) Value(p *Packet, name string) string {
+	def := dict.byName[name]
+	if def == nil {
+		return ""
+	}
+	attr := p.Attribute(def.VendorId, def.Type)
+	if attr == nil {
+		return ""
+	}
+	return def.decode(attr.Value)
+}
+
+// Builds an attribute out of its name and text value
+func (dict *Dictionary) NewAttribute(name, value string) (*Attribute, error) {
+	def := dict.byName[name]
+	if def == nil {
+		return nil, fmt.Errorf("unknown attribute: %s", name)
+	}
+	encoded, err := def.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return &Attribute{VendorId: def.VendorId, Type: def.Type, Value: encoded}, nil
+}
+
+func (def *AttributeDef) decode(value []byte) string {
+	switch def.DataType {
+	case TYPE_INTEGER:
+		if len(value) == 4 {
+			return strconv.FormatUint(uint64(binary.BigEndian.Uint32(value)), 10)
+		}
+	case TYPE_DATE:
+		if len(value) == 4 {
+			return strconv.FormatUint(uint64(binary.BigEndian.Uint32(value)), 10) // unix timestamp
+		}
+	case TYPE_IPADDR:
+		if len(value) == 4 {
+			return net.IP(value).String()
+		}
+	}
+	return string(value)
+}
+
+func (def *AttributeDef) encode(value string) ([]byte, error) {
+	switch def.DataType {
+	case TYPE_INTEGER, TYPE_DATE:
+		intVal, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value <%s> for %s", value, def.Name)
+		}
+		encoded := make([]byte, 4)
+		binary.BigEndian.PutUint32(encoded, uint32(intVal))
+		return encoded, nil
+	case TYPE_IPADDR:
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid value <%s> for %s", value, def.Name)
+		}
+		return []byte(ip), nil
+	}
+	return []byte(value), nil
+}
+
+// Time out of a date attribute value
+func parseRadiusDate(value string) (time.Time, error) {
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, 0), nil
+}
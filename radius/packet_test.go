@@ -0,0 +1,81 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package radius
+
+import (
+	"crypto/md5"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestPacketEncoding(t *testing.T) {
+	dict := NewDictionary()
+	vendorDict := path.Join(os.TempDir(), "cgr_radius_dictionary")
+	if err := ioutil.WriteFile(vendorDict, []byte(`# test vendor
+VENDOR		Cisco		9
+BEGIN-VENDOR	Cisco
+ATTRIBUTE	Cisco-AVPair	1	string
+END-VENDOR	Cisco
+ATTRIBUTE	Test-Counter	240	integer
+`), 0644); err != nil {
+		t.Fatal("Cannot write dictionary: ", err)
+	}
+	defer os.Remove(vendorDict)
+	if err := dict.LoadFile(vendorDict); err != nil {
+		t.Fatal("Cannot load dictionary: ", err)
+	}
+	req := &Packet{Code: ACCESS_REQUEST, Identifier: 7, Authenticator: [AUTHENTICATOR_LEN]byte{1, 2, 3}}
+	for name, value := range map[string]string{"User-Name": "1001", "NAS-IP-Address": "10.0.0.1", "Cisco-AVPair": "h323-call-origin=answer", "Test-Counter": "12"} {
+		attr, err := dict.NewAttribute(name, value)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		req.AddAttribute(attr)
+	}
+	rcv, err := ParsePacket(req.Encode())
+	if err != nil {
+		t.Fatal("Cannot parse packet: ", err)
+	}
+	if rcv.Code != ACCESS_REQUEST || rcv.Identifier != 7 || rcv.Authenticator != req.Authenticator || len(rcv.Attributes) != 4 {
+		t.Errorf("Unexpected packet: %+v", rcv)
+	}
+	for name, eValue := range map[string]string{"User-Name": "1001", "NAS-IP-Address": "10.0.0.1", "Cisco-AVPair": "h323-call-origin=answer", "Test-Counter": "12", "Called-Station-Id": ""} {
+		if value := dict.Value(rcv, name); value != eValue {
+			t.Errorf("Expecting %s for %s, received: %s", eValue, name, value)
+		}
+	}
+	if _, err := dict.NewAttribute("Session-Timeout", "abc"); err == nil {
+		t.Error("Expecting error on invalid integer")
+	}
+	// Response authenticator as of RFC 2865: MD5(Code+ID+Length+RequestAuth+Attributes+Secret)
+	reply := rcv.Reply(ACCESS_ACCEPT, "secret")
+	check := make([]byte, len(reply))
+	copy(check, reply)
+	copy(check[4:HEADER_LEN], req.Authenticator[:])
+	if eAuth := md5.Sum(append(check, []byte("secret")...)); string(eAuth[:]) != string(reply[4:HEADER_LEN]) {
+		t.Error("Wrong response authenticator")
+	}
+	acctReq := &Packet{Code: ACCOUNTING_REQUEST, Identifier: 8}
+	acctReq.Authenticator = AccountingRequestAuthenticator(acctReq.Encode(), "secret")
+	if !ValidAccountingRequest(acctReq.Encode(), "secret") || ValidAccountingRequest(acctReq.Encode(), "other") {
+		t.Error("Accounting authenticator not properly checked")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package radius
+
+import (
+	"time"
+)
+
+// CDR built out of the accounting records of a session
+type RadiusCdr struct {
+	cgrId, accId, cdrHost                    string
+	direction, subject, account, destination string
+	tor, tenant, reqType, fallbackSubj       string
+	answerTime                               time.Time
+	duration                                 int64 // seconds
+	extraFields                              map[string]string
+}
+
+func (rCdr *RadiusCdr) GetCgrId() string {
+	return rCdr.cgrId
+}
+func (rCdr *RadiusCdr) GetAccId() string {
+	return rCdr.accId
+}
+func (rCdr *RadiusCdr) GetCdrHost() string {
+	return rCdr.cdrHost
+}
+func (rCdr *RadiusCdr) GetDirection() string {
+	return rCdr.direction
+}
+func (rCdr *RadiusCdr) GetOrigId() string {
+	return rCdr.accId
+}
+func (rCdr *RadiusCdr) GetSubject() string {
+	return rCdr.subject
+}
+func (rCdr *RadiusCdr) GetAccount() string {
+	return rCdr.account
+}
+func (rCdr *RadiusCdr) GetDestination() string {
+	return rCdr.destination
+}
+func (rCdr *RadiusCdr) GetTOR() string {
+	return rCdr.tor
+}
+func (rCdr *RadiusCdr) GetTenant() string {
+	return rCdr.tenant
+}
+func (rCdr *RadiusCdr) GetReqType() string {
+	return rCdr.reqType
+}
+func (rCdr *RadiusCdr) GetAnswerTime() (time.Time, error) {
+	return rCdr.answerTime, nil
+}
+func (rCdr *RadiusCdr) GetDuration() int64 {
+	return rCdr.duration
+}
+func (rCdr *RadiusCdr) GetFallbackSubj() string {
+	return rCdr.fallbackSubj
+}
+func (rCdr *RadiusCdr) GetExtraFields() map[string]string {
+	return rCdr.extraFields
+}
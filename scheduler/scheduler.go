@@ -19,43 +19,72 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package scheduler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
 	"sort"
+	"sync"
 	"time"
 )
 
 type Scheduler struct {
-	queue       engine.ActionTimingPriotityList
-	timer       *time.Timer
-	restartLoop chan bool
+	queue         engine.ActionTimingPriotityList
+	atKeys        map[string]string // action timing id to the key it is stored under
+	timer         *time.Timer
+	restartLoop   chan bool
+	catchUpWindow time.Duration      // runs missed while down are executed if not older than this, 0 disables catch-up
+	leaseStorage  engine.DataStorage // where the leader lease is kept, nil when running standalone
+	leaseOwner    string
+	leaseTTL      time.Duration
+	leader        bool
+	sync.Mutex    // protects the queue from concurrent api access
 }
 
-func NewScheduler() *Scheduler {
-	return &Scheduler{restartLoop: make(chan bool)}
+const LEADER_LEASE = "scheduler"
+
+func NewScheduler(catchUpWindow time.Duration) *Scheduler {
+	return &Scheduler{restartLoop: make(chan bool), catchUpWindow: catchUpWindow, atKeys: make(map[string]string)}
+}
+
+// Makes the scheduler execute action timings only while holding the leader lease in storage,
+// so several engines can share the same dataDb with one of them acting and the others on standby
+func (s *Scheduler) SetLeaderLease(storage engine.DataStorage, owner string, ttl time.Duration) {
+	s.leaseStorage = storage
+	s.leaseOwner = owner
+	s.leaseTTL = ttl
 }
 
 func (s *Scheduler) Loop() {
+	if s.leaseStorage != nil {
+		go s.keepLease()
+	}
 	for {
-		for len(s.queue) == 0 { //hang here if empty
+		for s.queueLen() == 0 { //hang here if empty
 			<-s.restartLoop
 		}
+		s.Lock()
 		a0 := s.queue[0]
 		now := time.Now()
 		if a0.GetNextStartTime().Equal(now) || a0.GetNextStartTime().Before(now) {
 			engine.Logger.Debug(fmt.Sprintf("%v - %v", a0.Tag, a0.Timing))
-			go a0.Execute()
+			if s.mayExecute(a0) {
+				s.execute(a0)
+			}
+			a0.SkipRun()
 			s.queue = append(s.queue, a0)
 			s.queue = s.queue[1:]
 			sort.Sort(s.queue)
+			s.Unlock()
 		} else {
+			s.Unlock()
 			d := a0.GetNextStartTime().Sub(now)
 			engine.Logger.Info(fmt.Sprintf("Timer set to wait for %v", d))
 			s.timer = time.NewTimer(d)
 			select {
 			case <-s.timer.C:
 				// timer has expired
-				engine.Logger.Info(fmt.Sprintf("Time for action on %v", s.queue[0]))
+				engine.Logger.Info(fmt.Sprintf("Time for action on %v", a0))
 			case <-s.restartLoop:
 				// nothing to do, just continue the loop
 			}
@@ -63,13 +92,75 @@ func (s *Scheduler) Loop() {
 	}
 }
 
+// Executes a copy of the queued action timing in background, the queued one is only touched under the scheduler lock
+func (s *Scheduler) execute(at *engine.ActionTiming) {
+	exec, err := at.Clone()
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("Failed to get actions for %s: %s", at.ActionsId, err))
+		return
+	}
+	go exec.Execute()
+}
+
+func (s *Scheduler) mayExecute(at *engine.ActionTiming) bool {
+	if at.Paused {
+		engine.Logger.Info(fmt.Sprintf("Skipping paused action timing %v", at.Id))
+		return false
+	}
+	if !s.isLeader() {
+		engine.Logger.Debug(fmt.Sprintf("Not leader, leaving action timing %v to the active scheduler", at.Id))
+		return false
+	}
+	return true
+}
+
+// Acquires or renews the leader lease, always true when running standalone
+func (s *Scheduler) isLeader() bool {
+	if s.leaseStorage == nil {
+		return true
+	}
+	leader, err := s.leaseStorage.AcquireLease(LEADER_LEASE, s.leaseOwner, s.leaseTTL)
+	if err != nil {
+		engine.Logger.Warning(fmt.Sprintf("Cannot acquire scheduler lease: %v", err))
+		leader = false // better skip a run than execute it twice
+	}
+	if leader != s.leader {
+		if leader {
+			engine.Logger.Info(fmt.Sprintf("Scheduler %v is now the active one", s.leaseOwner))
+		} else {
+			engine.Logger.Info(fmt.Sprintf("Scheduler %v is now on standby", s.leaseOwner))
+		}
+		s.leader = leader
+	}
+	return leader
+}
+
+// Renews the lease while leader, takes it over once expired while on standby
+func (s *Scheduler) keepLease() {
+	for {
+		s.Lock()
+		s.isLeader()
+		s.Unlock()
+		time.Sleep(s.leaseTTL / 3)
+	}
+}
+
+func (s *Scheduler) queueLen() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.queue)
+}
+
 func (s *Scheduler) LoadActionTimings(storage engine.DataStorage) {
 	actionTimings, err := storage.GetAllActionTimings()
 	if err != nil {
 		engine.Logger.Warning(fmt.Sprintf("Cannot get action timings: %v", err))
 	}
+	s.Lock()
+	defer s.Unlock()
 	// recreate the queue
 	s.queue = engine.ActionTimingPriotityList{}
+	s.atKeys = make(map[string]string)
 	for key, ats := range actionTimings {
 		toBeSaved := false
 		isAsap := false
@@ -78,11 +169,17 @@ func (s *Scheduler) LoadActionTimings(storage engine.DataStorage) {
 			isAsap = at.CheckForASAP()
 			toBeSaved = toBeSaved || isAsap
 			if at.IsOneTimeRun() {
+				if !s.isLeader() { // leave it in storage for the active scheduler to run
+					newAts = append(newAts, at)
+					continue
+				}
 				engine.Logger.Info(fmt.Sprintf("Time for one time action on %v", key))
 				go at.Execute()
 				// do not append it to the newAts list to be saved
 			} else {
+				s.catchUp(storage, at)
 				s.queue = append(s.queue, at)
+				s.atKeys[at.Id] = key
 				newAts = append(newAts, at)
 			}
 		}
@@ -93,9 +190,145 @@ func (s *Scheduler) LoadActionTimings(storage engine.DataStorage) {
 	sort.Sort(s.queue)
 }
 
+// Executes once the run missed while the engine was down, if any
+func (s *Scheduler) catchUp(storage engine.DataStorage, at *engine.ActionTiming) {
+	if s.catchUpWindow <= 0 || at.Paused || !s.isLeader() {
+		return
+	}
+	lastExec, err := storage.GetActionTimingExecution(at.ExecutionKey())
+	if err != nil { // never executed, nothing to catch up
+		return
+	}
+	if missed := at.GetMissedStartTime(lastExec, s.catchUpWindow); !missed.IsZero() {
+		engine.Logger.Info(fmt.Sprintf("Catching up action timing %v missed at %v", at.Tag, missed))
+		at.Execute()
+	}
+}
+
 func (s *Scheduler) Restart() {
 	s.restartLoop <- true
 	if s.timer != nil {
 		s.timer.Stop()
 	}
 }
+
+type ScheduledActionTiming struct {
+	Id            string
+	Tag           string
+	NextStartTime time.Time
+	Weight        float64
+	Paused        bool
+	Accounts      []string
+	ActionsId     string
+	Actions       engine.Actions
+}
+
+// Returns a snapshot of the queue, ordered by next start time
+func (s *Scheduler) GetQueueSnapshot() []*ScheduledActionTiming {
+	s.Lock()
+	defer s.Unlock()
+	sats := make([]*ScheduledActionTiming, len(s.queue))
+	for idx, at := range s.queue {
+		sat := &ScheduledActionTiming{
+			Id:            at.Id,
+			Tag:           at.Tag,
+			NextStartTime: at.GetNextStartTime(),
+			Weight:        at.Weight,
+			Paused:        at.Paused,
+			Accounts:      make([]string, len(at.UserBalanceIds)),
+			ActionsId:     at.ActionsId,
+		}
+		copy(sat.Accounts, at.UserBalanceIds)
+		if clone, err := at.Clone(); err == nil {
+			sat.Actions, _ = clone.GetActions()
+		}
+		sats[idx] = sat
+	}
+	return sats
+}
+
+// Executes the queued action timing right away, its schedule is not changed.
+// Only the lookup runs under the scheduler lock, the execution works on a copy like the loop does.
+func (s *Scheduler) ExecuteActionTiming(atId string) error {
+	s.Lock()
+	at := s.getQueued(atId)
+	if at == nil {
+		s.Unlock()
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	exec, err := at.Clone()
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+	engine.Logger.Info(fmt.Sprintf("Executing on request action timing %v", atId))
+	return exec.Execute()
+}
+
+// Pauses or resumes the action timing, the state is saved in storage so it survives reloads
+func (s *Scheduler) SetActionTimingPaused(storage engine.DataStorage, atId string, paused bool) error {
+	return s.updateActionTiming(storage, atId, func(at *engine.ActionTiming) {
+		at.Paused = paused
+	})
+}
+
+// Removes the account from the action timing both in queue and storage
+func (s *Scheduler) RemoveActionTimingAccount(storage engine.DataStorage, atId, ubId string) error {
+	found := false
+	s.Lock()
+	if at := s.getQueued(atId); at != nil {
+		for _, id := range at.UserBalanceIds {
+			if id == ubId {
+				found = true
+				break
+			}
+		}
+	}
+	s.Unlock()
+	if !found {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	return s.updateActionTiming(storage, atId, func(at *engine.ActionTiming) {
+		ubIds := make([]string, 0, len(at.UserBalanceIds))
+		for _, id := range at.UserBalanceIds {
+			if id != ubId {
+				ubIds = append(ubIds, id)
+			}
+		}
+		at.UserBalanceIds = ubIds
+	})
+}
+
+// Applies the update on both the stored and the queued action timing
+func (s *Scheduler) updateActionTiming(storage engine.DataStorage, atId string, update func(*engine.ActionTiming)) error {
+	s.Lock()
+	defer s.Unlock()
+	queued := s.getQueued(atId)
+	if queued == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	key := s.atKeys[atId]
+	ats, err := storage.GetActionTimings(key)
+	if err != nil {
+		return err
+	}
+	for _, at := range ats {
+		if at.Id == atId {
+			update(at)
+		}
+	}
+	if err := storage.SetActionTimings(key, ats); err != nil {
+		return err
+	}
+	update(queued)
+	return nil
+}
+
+func (s *Scheduler) getQueued(atId string) *engine.ActionTiming {
+	for _, at := range s.queue {
+		if at.Id == atId {
+			return at
+		}
+	}
+	return nil
+}
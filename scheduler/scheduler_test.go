@@ -0,0 +1,193 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package scheduler
+
+import (
+	"github.com/cgrates/cgrates/engine"
+	"testing"
+	"time"
+)
+
+func getTestSchedulerStorage() engine.DataStorage {
+	storage, _ := engine.NewMapStorage()
+	ats := engine.ActionTimings{
+		&engine.ActionTiming{
+			Id:             "at_monthly",
+			Tag:            "MONTHLY",
+			UserBalanceIds: []string{"*out:cgrates.org:1001", "*out:cgrates.org:1002"},
+			Timing:         &engine.Interval{MonthDays: engine.MonthDays{1}, StartTime: "00:00:00"},
+			Weight:         10,
+			ActionsId:      "TOPUP_10",
+		},
+		&engine.ActionTiming{
+			Id:             "at_weekly",
+			Tag:            "WEEKLY",
+			UserBalanceIds: []string{"*out:cgrates.org:1001"},
+			Timing:         &engine.Interval{WeekDays: engine.WeekDays{time.Monday}, StartTime: "00:00:00"},
+			Weight:         10,
+			ActionsId:      "TOPUP_10",
+		},
+	}
+	storage.SetActionTimings("STANDARD_PLAN", ats)
+	return storage
+}
+
+func TestSchedulerGetQueue(t *testing.T) {
+	storage := getTestSchedulerStorage()
+	s := NewScheduler(0)
+	s.LoadActionTimings(storage)
+	queue := s.GetQueueSnapshot()
+	if len(queue) != 2 {
+		t.Fatal("Wrong queue length: ", queue)
+	}
+	if queue[0].NextStartTime.After(queue[1].NextStartTime) {
+		t.Error("Queue not ordered by next start time: ", queue)
+	}
+	if err := s.ExecuteActionTiming("not_queued"); err == nil {
+		t.Error("Executed unknown action timing")
+	}
+}
+
+func TestSchedulerPauseActionTiming(t *testing.T) {
+	storage := getTestSchedulerStorage()
+	s := NewScheduler(0)
+	s.LoadActionTimings(storage)
+	if err := s.SetActionTimingPaused(storage, "at_weekly", true); err != nil {
+		t.Fatal("Could not pause action timing: ", err)
+	}
+	if at := s.getQueued("at_weekly"); at == nil || !at.Paused {
+		t.Error("Queued action timing not paused: ", at)
+	}
+	// pausing survives reloads
+	s.LoadActionTimings(storage)
+	if at := s.getQueued("at_weekly"); at == nil || !at.Paused {
+		t.Error("Paused state lost on reload: ", at)
+	}
+	if at := s.getQueued("at_monthly"); at == nil || at.Paused {
+		t.Error("Wrong action timing paused: ", at)
+	}
+	if err := s.SetActionTimingPaused(storage, "at_weekly", false); err != nil {
+		t.Fatal("Could not resume action timing: ", err)
+	}
+	s.LoadActionTimings(storage)
+	if at := s.getQueued("at_weekly"); at == nil || at.Paused {
+		t.Error("Action timing not resumed: ", at)
+	}
+}
+
+func TestSchedulerRemoveActionTimingAccount(t *testing.T) {
+	storage := getTestSchedulerStorage()
+	s := NewScheduler(0)
+	s.LoadActionTimings(storage)
+	if err := s.RemoveActionTimingAccount(storage, "at_monthly", "*out:cgrates.org:1003"); err == nil {
+		t.Error("Removed account not part of the action timing")
+	}
+	if err := s.RemoveActionTimingAccount(storage, "at_monthly", "*out:cgrates.org:1001"); err != nil {
+		t.Fatal("Could not remove account: ", err)
+	}
+	if at := s.getQueued("at_monthly"); len(at.UserBalanceIds) != 1 || at.UserBalanceIds[0] != "*out:cgrates.org:1002" {
+		t.Error("Account not removed from queue: ", at.UserBalanceIds)
+	}
+	ats, _ := storage.GetActionTimings("STANDARD_PLAN")
+	for _, at := range ats {
+		switch at.Id {
+		case "at_monthly":
+			if len(at.UserBalanceIds) != 1 || at.UserBalanceIds[0] != "*out:cgrates.org:1002" {
+				t.Error("Account not removed from storage: ", at.UserBalanceIds)
+			}
+		case "at_weekly":
+			if len(at.UserBalanceIds) != 1 {
+				t.Error("Account removed from the wrong action timing: ", at.UserBalanceIds)
+			}
+		}
+	}
+}
+
+// Lease kept against a manual clock so the test does not depend on sleeps
+type clockLeaseStorage struct {
+	engine.DataStorage
+	now     time.Time
+	owner   string
+	expires time.Time
+}
+
+func (cls *clockLeaseStorage) AcquireLease(name, owner string, ttl time.Duration) (bool, error) {
+	if cls.owner != owner && cls.expires.After(cls.now) {
+		return false, nil
+	}
+	cls.owner = owner
+	cls.expires = cls.now.Add(ttl)
+	return true, nil
+}
+
+func TestSchedulerLeaderLease(t *testing.T) {
+	storage := &clockLeaseStorage{DataStorage: getTestSchedulerStorage(), now: time.Now()}
+	s1 := NewScheduler(0)
+	s1.SetLeaderLease(storage, "sched1", 50*time.Millisecond)
+	s1.LoadActionTimings(storage)
+	s2 := NewScheduler(0)
+	s2.SetLeaderLease(storage, "sched2", 50*time.Millisecond)
+	s2.LoadActionTimings(storage)
+	at1, at2 := s1.getQueued("at_weekly"), s2.getQueued("at_weekly")
+	if !s1.mayExecute(at1) {
+		t.Error("First scheduler should be the active one")
+	}
+	if s2.mayExecute(at2) {
+		t.Error("Standby scheduler executed while the lease is held")
+	}
+	// renewing keeps the lease with the active scheduler
+	storage.now = storage.now.Add(30 * time.Millisecond)
+	if !s1.mayExecute(at1) {
+		t.Error("Active scheduler lost the lease while renewing it")
+	}
+	storage.now = storage.now.Add(30 * time.Millisecond)
+	if s2.mayExecute(at2) {
+		t.Error("Standby scheduler took over a renewed lease")
+	}
+	// active one goes away, standby takes over once the lease expires
+	storage.now = storage.now.Add(60 * time.Millisecond)
+	if !s2.mayExecute(at2) {
+		t.Error("Standby scheduler did not take over the expired lease")
+	}
+	if s1.mayExecute(at1) {
+		t.Error("Both schedulers active")
+	}
+}
+
+func TestSchedulerSnapshotWhileExecuting(t *testing.T) {
+	storage := getTestSchedulerStorage()
+	storage.SetActions("TOPUP_10", engine.Actions{&engine.Action{ActionType: "*log", BalanceId: engine.CREDIT, Units: 10}})
+	engine.SetDataStorage(storage)
+	s := NewScheduler(0)
+	s.LoadActionTimings(storage)
+	done := make(chan error)
+	go func() {
+		done <- s.ExecuteActionTiming("at_weekly")
+	}()
+	for i := 0; i < 10; i++ {
+		for _, sat := range s.GetQueueSnapshot() {
+			if len(sat.Actions) != 1 || sat.NextStartTime.IsZero() {
+				t.Errorf("Wrong snapshot: %+v", sat)
+			}
+		}
+	}
+	if err := <-done; err != nil {
+		t.Error("Could not execute action timing: ", err)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const FS_API_TIMEOUT = 5 * time.Second
+
+// Runs one api command over a short lived event socket connection and returns its output.
+// Needed since the events connection does not give us back the api replies.
+func fsApiCommand(addr, passwd, cmd string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, FS_API_TIMEOUT)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(FS_API_TIMEOUT))
+	rdr := textproto.NewReader(bufio.NewReader(conn))
+	hdrs, err := rdr.ReadMIMEHeader()
+	if err != nil {
+		return "", err
+	}
+	if hdrs.Get("Content-Type") != "auth/request" {
+		return "", fmt.Errorf("unexpected greeting from FreeSWITCH: %v", hdrs)
+	}
+	if _, err = fmt.Fprintf(conn, "auth %s\n\n", passwd); err != nil {
+		return "", err
+	}
+	if hdrs, err = rdr.ReadMIMEHeader(); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(hdrs.Get("Reply-Text"), "+OK") {
+		return "", errors.New("FreeSWITCH authentication failed")
+	}
+	if _, err = fmt.Fprintf(conn, "api %s\n\n", cmd); err != nil {
+		return "", err
+	}
+	if hdrs, err = rdr.ReadMIMEHeader(); err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(hdrs.Get("Content-Length"))
+	if err != nil {
+		return "", fmt.Errorf("invalid api reply length: %v", err)
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(rdr.R, body); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(string(body), "-ERR") {
+		return "", errors.New(strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
+
+// Queries FreeSWITCH for the uuids of the channels it is currently handling
+func fsActiveChannels(addr, passwd string) (map[string]bool, error) {
+	out, err := fsApiCommand(addr, passwd, "show channels as json")
+	if err != nil {
+		return nil, err
+	}
+	var channels struct {
+		RowCount int                      `json:"row_count"`
+		Rows     []map[string]interface{} `json:"rows"`
+	}
+	if err := json.Unmarshal([]byte(out), &channels); err != nil {
+		return nil, fmt.Errorf("cannot parse channels list: %v", err)
+	}
+	uuids := make(map[string]bool, len(channels.Rows))
+	for _, row := range channels.Rows {
+		if uuid, ok := row["uuid"].(string); ok {
+			uuids[uuid] = true
+		}
+	}
+	return uuids, nil
+}
@@ -55,8 +55,28 @@ const (
 	INSUFFICIENT_FUNDS = "-INSUFFICIENT_FUNDS"
 	MISSING_PARAMETER  = "-MISSING_PARAMETER"
 	SYSTEM_ERROR       = "-SYSTEM_ERROR"
+	MAX_CALLS          = "-MAX_CALLS"
+	MAX_DURATION       = "-MAX_DURATION"
 	MANAGER_REQUEST    = "+MANAGER_REQUEST"
+	LOW_BALANCE        = "-LOW_BALANCE"
 	USERNAME           = "Caller-Username"
+	// Message events, charging headers can be set from the chatplan
+	MESSAGE         = "MESSAGE"
+	SMS_SEND        = "CUSTOM SMS::SEND_MESSAGE"
+	MSG_UUID        = "Event-UUID"
+	MSG_TIME        = "Event-Date-Timestamp"
+	MSG_PROTO       = "proto"
+	MSG_FROM        = "from"
+	MSG_TO          = "to"
+	MSG_FROM_USER   = "from_user"
+	MSG_TO_USER     = "to_user"
+	MSG_BODY        = "_body"
+	MSG_ACCOUNT     = "cgr_account"
+	MSG_SUBJECT     = "cgr_subject"
+	MSG_DESTINATION = "cgr_destination"
+	MSG_REQTYPE     = "cgr_reqtype"
+	MSG_TOR         = "cgr_tor"
+	MSG_TENANT      = "cgr_tenant"
 )
 
 // Nice printing for the event object.
@@ -139,3 +159,33 @@ func (fsev FSEvent) GetEndTime() (t time.Time, err error) {
 	t = time.Unix(0, st*1000)
 	return
 }
+
+// Message body, sent after the headers
+func fsMessageBody(body string) string {
+	if idx := strings.Index(body, "\n\n"); idx != -1 {
+		return strings.TrimSpace(body[idx+2:])
+	}
+	return ""
+}
+
+func (fsev FSEvent) GetMsgUUID() string {
+	return utils.FirstNonEmpty(fsev[MSG_UUID], fsev[UUID])
+}
+func (fsev FSEvent) GetMsgSubject() string {
+	return utils.FirstNonEmpty(fsev[MSG_SUBJECT], fsev[MSG_FROM_USER])
+}
+func (fsev FSEvent) GetMsgAccount() string {
+	return utils.FirstNonEmpty(fsev[MSG_ACCOUNT], fsev[MSG_FROM_USER])
+}
+func (fsev FSEvent) GetMsgDestination() string {
+	return utils.FirstNonEmpty(fsev[MSG_DESTINATION], fsev[MSG_TO_USER])
+}
+func (fsev FSEvent) GetMsgTOR() string {
+	return utils.FirstNonEmpty(fsev[MSG_TOR], SMS_TOR)
+}
+func (fsev FSEvent) GetMsgTenant() string {
+	return utils.FirstNonEmpty(fsev[MSG_TENANT], cfg.DefaultTenant)
+}
+func (fsev FSEvent) GetMsgReqType() string {
+	return utils.FirstNonEmpty(fsev[MSG_REQTYPE], cfg.DefaultReqType)
+}
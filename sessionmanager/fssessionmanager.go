@@ -19,7 +19,6 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package sessionmanager
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"github.com/cgrates/cgrates/config"
@@ -27,118 +26,348 @@ import (
 	"github.com/cgrates/cgrates/utils"
 	"github.com/cgrates/fsock"
 	"log/syslog"
-	"net"
+	"math"
 	"strings"
+	"sync"
 	"time"
 )
 
 var cfg *config.CGRConfig // Share the configuration with the rest of the package
 
-// The freeswitch session manager type holding a buffer for the network connection
-// and the active sessions
+// Seconds the switch waits over the granted time before hanging up, covering the delay of the next debit
+const HARD_TIMEOUT_GRACE = 2
+
+// Seconds looked ahead at most for the time still authorized when refreshing the hangup timeout
+const HARD_TIMEOUT_HORIZON = 3600
+
+// Prefix of the scheduler group the hangup timeout of a call is kept under, followed by the call's uuid
+const HANGUP_SCHED_GROUP = "cgr_hangup_"
+
+// The freeswitch session manager type holding the event socket connections towards
+// the FreeSWITCH nodes and the active sessions
 type FSSessionManager struct {
-	conn        net.Conn
-	buf         *bufio.Reader
-	sessions    []*Session
+	nodes       map[string]*fsock.FSock // event socket connections indexed on node address
+	nodesMux    sync.RWMutex
+	sessions    *SessionRegistry
 	connector   engine.Connector
 	debitPeriod time.Duration
 	loggerDB    engine.DataStorage
+	sessionDb   engine.DataStorage // keeps the active sessions for recovery after restarts
 }
 
 func NewFSSessionManager(storage engine.DataStorage, connector engine.Connector, debitPeriod time.Duration) *FSSessionManager {
-	return &FSSessionManager{loggerDB: storage, connector: connector, debitPeriod: debitPeriod}
+	return &FSSessionManager{nodes: make(map[string]*fsock.FSock), sessions: NewSessionRegistry(), loggerDB: storage, connector: connector, debitPeriod: debitPeriod}
+}
+
+// Sets the storage where the active sessions are kept
+func (sm *FSSessionManager) SetSessionStorage(db engine.DataStorage) {
+	sm.sessionDb = db
 }
 
-// Connects to the freeswitch mod_event_socket server and starts
-// listening for events.
+// Connects to all the configured freeswitch mod_event_socket servers and starts
+// listening for events. Returns when none of them delivers events anymore.
 func (sm *FSSessionManager) Connect(cgrCfg *config.CGRConfig) (err error) {
 	cfg = cgrCfg // make config global
-	eventFilters := map[string]string{"Call-Direction": "inbound"}
-	if fsock.FS, err = fsock.NewFSock(cfg.FreeswitchServer, cfg.FreeswitchPass, cfg.FreeswitchReconnects, sm.createHandlers(), eventFilters, engine.Logger.(*syslog.Writer)); err != nil {
+	if len(cfg.FreeswitchServers) == 0 {
+		return errors.New("No FreeSWITCH server configured")
+	}
+	var wg sync.WaitGroup
+	for _, node := range cfg.FreeswitchServers {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			if err := sm.connectNode(node); err != nil {
+				engine.Logger.Err(fmt.Sprintf("<SessionManager> FreeSWITCH %s: %v", node, err))
+			}
+		}(node)
+	}
+	wg.Wait()
+	return errors.New("stopped reading events")
+}
+
+// Connects to one freeswitch node, reconnects being handled by its own socket
+func (sm *FSSessionManager) connectNode(node string) error {
+	eventFilters := map[string]string{"Call-Direction": "inbound", "Event-Name": MESSAGE, "Event-Subclass": "SMS::SEND_MESSAGE"}
+	fs, err := fsock.NewFSock(node, cfg.FreeswitchPass, cfg.FreeswitchReconnects, sm.createHandlers(node), eventFilters, engine.Logger.(*syslog.Writer))
+	if err != nil {
 		return err
-	} else if !fsock.FS.Connected() {
+	} else if !fs.Connected() {
 		return errors.New("Cannot connect to FreeSWITCH")
 	}
-	fsock.FS.ReadEvents()
+	sm.nodesMux.Lock()
+	sm.nodes[node] = fs
+	sm.nodesMux.Unlock()
+	sm.recoverSessions(node)
+	stopSync := make(chan bool)
+	go sm.syncLoop(node, stopSync)
+	fs.ReadEvents()
+	close(stopSync)
+	sm.nodesMux.Lock()
+	delete(sm.nodes, node)
+	sm.nodesMux.Unlock()
 	return errors.New("stopped reading events")
 }
 
-func (sm *FSSessionManager) createHandlers() (handlers map[string][]func(string)) {
+// Returns the connection towards the node, nil if not connected
+func (sm *FSSessionManager) getNode(node string) *fsock.FSock {
+	sm.nodesMux.RLock()
+	defer sm.nodesMux.RUnlock()
+	return sm.nodes[node]
+}
+
+// Sends the api command to the node
+func (sm *FSSessionManager) sendApiCmd(node, cmd string) error {
+	fs := sm.getNode(node)
+	if fs == nil {
+		return fmt.Errorf("not connected to FreeSWITCH %s", node)
+	}
+	return fs.SendApiCmd(cmd)
+}
+
+func (sm *FSSessionManager) createHandlers(node string) (handlers map[string][]func(string)) {
 	hb := func(body string) {
 		ev := new(FSEvent).New(body)
-		sm.OnHeartBeat(ev)
+		sm.OnHeartBeat(ev, node)
 	}
 	cp := func(body string) {
 		ev := new(FSEvent).New(body)
-		sm.OnChannelPark(ev)
+		sm.OnChannelPark(ev, node)
 	}
 	ca := func(body string) {
 		ev := new(FSEvent).New(body)
-		sm.OnChannelAnswer(ev)
+		sm.OnChannelAnswer(ev, node)
 	}
 	ch := func(body string) {
 		ev := new(FSEvent).New(body)
 		sm.OnChannelHangupComplete(ev)
 	}
+	msg := func(body string) {
+		ev := new(FSEvent).New(body).(FSEvent)
+		ev[MSG_BODY] = fsMessageBody(body)
+		sm.OnMessage(ev, node)
+	}
 	return map[string][]func(string){
 		"HEARTBEAT":               []func(string){hb},
 		"CHANNEL_PARK":            []func(string){cp},
 		"CHANNEL_ANSWER":          []func(string){ca},
 		"CHANNEL_HANGUP_COMPLETE": []func(string){ch},
+		MESSAGE:                   []func(string){msg},
+		SMS_SEND:                  []func(string){msg},
 	}
 }
 
 // Searches and return the session with the specifed uuid
 func (sm *FSSessionManager) GetSession(uuid string) *Session {
-	for _, s := range sm.sessions {
-		if s.uuid == uuid {
-			return s
-		}
-	}
-	return nil
+	return sm.sessions.Get(uuid)
+}
+
+// Returns the active sessions
+func (sm *FSSessionManager) Sessions() []*Session {
+	return sm.sessions.All()
 }
 
-// Disconnects a session by sending hangup command to freeswitch
+// Disconnects a session by sending hangup command to the freeswitch node handling it
 func (sm *FSSessionManager) DisconnectSession(s *Session, notify string) {
 	engine.Logger.Debug(fmt.Sprintf("Session: %+v", s.uuid))
-	err := fsock.FS.SendApiCmd(fmt.Sprintf("uuid_setvar %s cgr_notify %s\n\n", s.uuid, notify))
+	fs := sm.getNode(s.node)
+	if fs == nil {
+		engine.Logger.Err(fmt.Sprintf("could not disconnect session %s, not connected to freeswitch %s", s.uuid, s.node))
+		return
+	}
+	err := fs.SendApiCmd(fmt.Sprintf("uuid_setvar %s cgr_notify %s\n\n", s.uuid, notify))
 	if err != nil {
 		engine.Logger.Err(fmt.Sprintf("could not send disconect api notification to freeswitch: %v", err))
 	}
-	err = fsock.FS.SendMsgCmd(s.uuid, map[string]string{"call-command": "hangup", "hangup-cause": "MANAGER_REQUEST"}) // without + sign
+	err = fs.SendMsgCmd(s.uuid, map[string]string{"call-command": "hangup", "hangup-cause": "MANAGER_REQUEST"}) // without + sign
 	if err != nil {
 		engine.Logger.Err(fmt.Sprintf("could not send disconect msg to freeswitch: %v", err))
 	}
 	return
 }
 
+// Sets the notification on the channel and plays the configured prompt to the caller
+func (sm *FSSessionManager) WarnSession(s *Session, notify string) {
+	if err := sm.sendApiCmd(s.node, fmt.Sprintf("uuid_setvar %s cgr_notify %s\n\n", s.uuid, notify)); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not send warning notification to freeswitch: %v", err))
+	}
+	if cfg.FreeswitchLowBalancePrompt == "" || notify != LOW_BALANCE {
+		return
+	}
+	if err := sm.sendApiCmd(s.node, fmt.Sprintf("uuid_broadcast %s %s aleg\n\n", s.uuid, cfg.FreeswitchLowBalancePrompt)); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not play warning prompt on freeswitch: %v", err))
+	}
+}
+
 // Remove session from sessin list
 func (sm *FSSessionManager) RemoveSession(s *Session) {
-	for i, ss := range sm.sessions {
-		if ss == s {
-			sm.sessions = append(sm.sessions[:i], sm.sessions[i+1:]...)
+	sm.sessions.Remove(s)
+	if sm.sessionDb != nil {
+		if err := sm.sessionDb.RemoveSessionRecord(s.uuid); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not remove session record %s: %v", s.uuid, err))
+		}
+	}
+}
+
+// Stores the session together with its costs so far and the time it was last seen alive, allowing recovery after restart
+func (sm *FSSessionManager) persistSession(s *Session, lastSeen time.Time) {
+	if sm.sessionDb == nil || sm.GetSession(s.uuid) == nil { // already closed
+		return
+	}
+	sr := &engine.SessionRecord{Uuid: s.uuid, ReqType: s.reqType, Node: s.node, CallDescriptor: s.callDescriptor, CallCosts: s.callCosts(), LastSeen: lastSeen}
+	if err := sm.sessionDb.SetSessionRecord(sr); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not store session record %s: %v", s.uuid, err))
+	}
+}
+
+// Reconciles the sessions stored before restart with the channels still active on the FreeSWITCH node.
+// Live ones are resumed, the others are settled as ended when last seen alive.
+func (sm *FSSessionManager) recoverSessions(node string) {
+	if sm.sessionDb == nil {
+		return
+	}
+	srs, err := sm.sessionDb.GetSessionRecords()
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not read stored sessions: %v", err))
+		return
+	}
+	nodeSrs := make([]*engine.SessionRecord, 0)
+	for _, sr := range srs {
+		// records without node were stored when only one server was supported
+		if sr.Node == node || (sr.Node == "" && node == cfg.FreeswitchServers[0]) {
+			nodeSrs = append(nodeSrs, sr)
+		}
+	}
+	if len(nodeSrs) == 0 {
+		return
+	}
+	active, err := fsActiveChannels(node, cfg.FreeswitchPass)
+	if err != nil { // keep the records for the next attempt
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not query FreeSWITCH channels, sessions not recovered: %v", err))
+		return
+	}
+	for _, sr := range nodeSrs {
+		s := &Session{uuid: sr.Uuid,
+			reqType:        sr.ReqType,
+			node:           node,
+			callDescriptor: sr.CallDescriptor,
+			sessionManager: sm,
+			stopDebit:      make(chan bool, 2),
+			CallCosts:      sr.CallCosts}
+		if active[sr.Uuid] {
+			engine.Logger.Info(fmt.Sprintf("<SessionManager> Recovered session %s", sr.Uuid))
+			sm.sessions.Add(s)
+			if sr.ReqType == utils.PREPAID {
+				go s.resumeDebitLoop()
+			}
+			continue
+		}
+		engine.Logger.Info(fmt.Sprintf("<SessionManager> Closing session %s, channel gone during restart", sr.Uuid))
+		if sm.loggerDB != nil {
+			sm.loggerDB.LogError(sr.Uuid, engine.SESSION_MANAGER_SOURCE, fmt.Sprintf("channel ended while session manager was down, closed at %v", sr.LastSeen))
+		}
+		ev := s.endEvent(sr.LastSeen) // records without it end at the session start
+		s.settle(sm.connector, ev)
+		s.Close(ev)
+	}
+}
+
+// Periodically checks the node's sessions against its active channels and the maximum call duration
+func (sm *FSSessionManager) syncLoop(node string, stop chan bool) {
+	if cfg.SMChannelSyncInterval <= 0 && cfg.SMMaxCallDuration <= 0 {
+		return
+	}
+	interval := sm.debitPeriod
+	if cfg.SMChannelSyncInterval > 0 {
+		interval, _ = time.ParseDuration(fmt.Sprintf("%vs", cfg.SMChannelSyncInterval))
+	}
+	lastSync := time.Now()
+	for {
+		select {
+		case <-stop:
 			return
+		case <-time.After(interval):
+		}
+		if synced := sm.syncSessions(node, lastSync, time.Now()); !synced.IsZero() {
+			lastSync = synced
 		}
 	}
 }
 
-// Sends the transfer command to unpark the call to freeswitch
-func (sm *FSSessionManager) unparkCall(uuid, call_dest_nb, notify string) {
-	err := fsock.FS.SendApiCmd(fmt.Sprintf("uuid_setvar %s cgr_notify %s\n\n", uuid, notify))
+// Closes the sessions of the node whose channels are gone, ending them at lastSync when they were last seen alive,
+// and disconnects the ones over the maximum call duration. Returns the time of the channels query, zero if it failed.
+func (sm *FSSessionManager) syncSessions(node string, lastSync, now time.Time) (synced time.Time) {
+	var sessions []*Session // taken before querying so the ones started meanwhile are not considered gone
+	for _, s := range sm.sessions.All() {
+		if s.node == node {
+			sessions = append(sessions, s)
+		}
+	}
+	if cfg.SMChannelSyncInterval > 0 {
+		active, err := fsActiveChannels(node, cfg.FreeswitchPass)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not sync channels with FreeSWITCH %s: %v", node, err))
+		} else {
+			synced = now
+			alive := make([]*Session, 0, len(sessions))
+			for _, s := range sessions {
+				if active[s.uuid] {
+					if s.reqType == utils.POSTPAID { // prepaid ones are stored on each debit
+						sm.persistSession(s, now)
+					}
+					alive = append(alive, s)
+					continue
+				}
+				sm.closeStaleSession(s, lastSync)
+			}
+			sessions = alive
+		}
+	}
+	if cfg.SMMaxCallDuration > 0 {
+		maxDuration, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SMMaxCallDuration))
+		for _, s := range sessions {
+			if now.Sub(s.callDescriptor.TimeStart) >= maxDuration {
+				engine.Logger.Info(fmt.Sprintf("<SessionManager> Session %s over maximum call duration, disconnecting", s.uuid))
+				sm.DisconnectSession(s, MAX_DURATION)
+			}
+		}
+	}
+	return
+}
+
+// Closes a session whose hangup was never received, as if it ended at lastSeen
+func (sm *FSSessionManager) closeStaleSession(s *Session, lastSeen time.Time) {
+	if !sm.sessions.Remove(s) { // hangup received meanwhile, claimed so it is not settled twice
+		return
+	}
+	if lastSeen.Before(s.callDescriptor.TimeStart) { // answered after the last sync
+		lastSeen = s.callDescriptor.TimeStart
+	}
+	engine.Logger.Info(fmt.Sprintf("<SessionManager> Closing stale session %s, channel not found on FreeSWITCH %s", s.uuid, s.node))
+	if sm.loggerDB != nil {
+		sm.loggerDB.LogError(s.uuid, engine.SESSION_MANAGER_SOURCE, fmt.Sprintf("channel gone without hangup, closed at %v", lastSeen))
+	}
+	ev := s.endEvent(lastSeen)
+	s.settle(sm.connector, ev)
+	s.Close(ev)
+}
+
+// Sends the transfer command to unpark the call to the freeswitch node
+func (sm *FSSessionManager) unparkCall(node, uuid, call_dest_nb, notify string) {
+	err := sm.sendApiCmd(node, fmt.Sprintf("uuid_setvar %s cgr_notify %s\n\n", uuid, notify))
 	if err != nil {
 		engine.Logger.Err("could not send unpark api notification to freeswitch")
 	}
-	err = fsock.FS.SendApiCmd(fmt.Sprintf("uuid_transfer %s %s\n\n", uuid, call_dest_nb))
+	err = sm.sendApiCmd(node, fmt.Sprintf("uuid_transfer %s %s\n\n", uuid, call_dest_nb))
 	if err != nil {
 		engine.Logger.Err("could not send unpark api call to freeswitch")
 	}
 }
 
-func (sm *FSSessionManager) OnHeartBeat(ev Event) {
-	engine.Logger.Info("freeswitch ♥")
+func (sm *FSSessionManager) OnHeartBeat(ev Event, node string) {
+	engine.Logger.Info(fmt.Sprintf("freeswitch %s ♥", node))
 }
 
-func (sm *FSSessionManager) OnChannelPark(ev Event) {
+func (sm *FSSessionManager) OnChannelPark(ev Event, node string) {
 	engine.Logger.Info("freeswitch park")
 	startTime, err := ev.GetStartTime(PARK_TIME)
 	if err != nil {
@@ -150,10 +379,17 @@ func (sm *FSSessionManager) OnChannelPark(ev Event) {
 		return
 	}
 	if ev.MissingParameter() {
-		sm.unparkCall(ev.GetUUID(), ev.GetCallDestNr(), MISSING_PARAMETER)
+		sm.unparkCall(node, ev.GetUUID(), ev.GetCallDestNr(), MISSING_PARAMETER)
 		engine.Logger.Err(fmt.Sprintf("Missing parameter for %s", ev.GetUUID()))
 		return
 	}
+	// counted in the limits from now on, the session taking its place once answered
+	notify, prepaidCalls := sm.sessions.Reserve(ev.GetUUID(), ev.GetReqType(), ev.GetTenant(), ev.GetAccount(), cfg.SMMaxCallsAccount, cfg.SMMaxCallsTenant)
+	if notify != "" {
+		engine.Logger.Info(fmt.Sprintf("Concurrent calls limit reached, not transferring the call %s.", ev.GetUUID()))
+		sm.unparkCall(node, ev.GetUUID(), ev.GetCallDestNr(), notify)
+		return
+	}
 	cd := engine.CallDescriptor{
 		Direction:       ev.GetDirection(),
 		Tenant:          ev.GetTenant(),
@@ -168,176 +404,180 @@ func (sm *FSSessionManager) OnChannelPark(ev Event) {
 	err = sm.connector.GetMaxSessionTime(cd, &remainingSeconds)
 	if err != nil {
 		engine.Logger.Err(fmt.Sprintf("Could not get max session time for %s: %v", ev.GetUUID(), err))
-		sm.unparkCall(ev.GetUUID(), ev.GetCallDestNr(), SYSTEM_ERROR)
+		sm.sessions.Release(ev.GetUUID())
+		sm.unparkCall(node, ev.GetUUID(), ev.GetCallDestNr(), SYSTEM_ERROR)
 		return
 	}
+	remainingSeconds = splitSessionTime(remainingSeconds, prepaidCalls)
 	engine.Logger.Info(fmt.Sprintf("Remaining seconds: %v", remainingSeconds))
 	if remainingSeconds == 0 {
 		engine.Logger.Info(fmt.Sprintf("Not enough credit for trasferring the call %s for %s.", ev.GetUUID(), cd.GetKey()))
-		sm.unparkCall(ev.GetUUID(), ev.GetCallDestNr(), INSUFFICIENT_FUNDS)
+		sm.sessions.Release(ev.GetUUID())
+		sm.unparkCall(node, ev.GetUUID(), ev.GetCallDestNr(), INSUFFICIENT_FUNDS)
 		return
 	}
-	sm.unparkCall(ev.GetUUID(), ev.GetCallDestNr(), AUTH_OK)
+	if remainingSeconds > 0 { // the switch hangs up the call unless the debits refresh the timeout
+		err = sm.sendApiCmd(node, fmt.Sprintf("uuid_setvar %s api_on_answer %s\n\n", ev.GetUUID(), schedHangupCmd(ev.GetUUID(), int(math.Ceil(remainingSeconds)))))
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("could not set the hangup timeout for %s: %v", ev.GetUUID(), err))
+		}
+	}
+	sm.unparkCall(node, ev.GetUUID(), ev.GetCallDestNr(), AUTH_OK)
 }
 
-func (sm *FSSessionManager) OnChannelAnswer(ev Event) {
+func (sm *FSSessionManager) OnChannelAnswer(ev Event, node string) {
 	engine.Logger.Info("<SessionManager> FreeSWITCH answer.")
 	// Make sure cgr_type is enforced even if not set by FreeSWITCH
-	if err := fsock.FS.SendApiCmd(fmt.Sprintf("uuid_setvar %s cgr_reqtype %s\n\n", ev.GetUUID(), ev.GetReqType())); err != nil {
+	if err := sm.sendApiCmd(node, fmt.Sprintf("uuid_setvar %s cgr_reqtype %s\n\n", ev.GetUUID(), ev.GetReqType())); err != nil {
 		engine.Logger.Err(fmt.Sprintf("Error on attempting to overwrite cgr_type in chan variables: %v", err))
 	}
 	s := NewSession(ev, sm)
 	if s != nil {
-		sm.sessions = append(sm.sessions, s)
+		s.node = node
+		// postpaid calls are not parked, their limits are checked once answered
+		if s.reqType == utils.POSTPAID {
+			if notify, _ := sm.sessions.Reserve(s.uuid, s.reqType, s.callDescriptor.Tenant, s.callDescriptor.Account, cfg.SMMaxCallsAccount, cfg.SMMaxCallsTenant); notify != "" {
+				engine.Logger.Info(fmt.Sprintf("Concurrent calls limit reached, disconnecting the call %s.", s.uuid))
+				sm.DisconnectSession(s, notify)
+				return
+			}
+		}
+		sm.sessions.Add(s)
+		sm.persistSession(s, time.Now())
 	}
 }
 
 func (sm *FSSessionManager) OnChannelHangupComplete(ev Event) {
 	engine.Logger.Info("<SessionManager> FreeSWITCH hangup.")
+	sm.sessions.Release(ev.GetUUID()) // not answered
 	s := sm.GetSession(ev.GetUUID())
-	if s == nil { // Not handled by us
+	if s == nil || !sm.sessions.Remove(s) { // Not handled by us or closed meanwhile as stale
 		return
 	}
 	defer s.Close(ev) // Stop loop and save the costs deducted so far to database
-	if ev.GetReqType() == utils.POSTPAID {
-		startTime, err := ev.GetStartTime(START_TIME)
-		if err != nil {
-			engine.Logger.Crit("Error parsing postpaid call start time from event")
-			return
-		}
-		endTime, err := ev.GetEndTime()
+	s.settle(sm.connector, ev)
+}
+
+// Charges the message and replies to the switch with the result
+func (sm *FSSessionManager) OnMessage(ev FSEvent, node string) {
+	engine.Logger.Info("<SessionManager> FreeSWITCH message.")
+	reqType := ev.GetMsgReqType()
+	if reqType != utils.PREPAID && reqType != utils.POSTPAID { // not charged by us
+		return
+	}
+	uuid := utils.FirstNonEmpty(ev.GetMsgUUID(), utils.GenUUID())
+	notify := MISSING_PARAMETER
+	if ev.GetMsgAccount() != "" && ev.GetMsgSubject() != "" && ev.GetMsgDestination() != "" && ev.GetMsgTenant() != "" {
+		timeStart, err := ev.GetStartTime(MSG_TIME)
 		if err != nil {
-			engine.Logger.Crit("Error parsing postpaid call start time from event")
-			return
+			timeStart = time.Now()
 		}
 		cd := engine.CallDescriptor{
 			Direction:       ev.GetDirection(),
-			Tenant:          ev.GetTenant(),
-			TOR:             ev.GetTOR(),
-			Subject:         ev.GetSubject(),
-			Account:         ev.GetAccount(),
-			LoopIndex:       0,
-			CallDuration:    endTime.Sub(startTime),
-			Destination:     ev.GetDestination(),
-			TimeStart:       startTime,
-			TimeEnd:         endTime,
-			FallbackSubject: ev.GetFallbackSubj(),
-		}
-		cc := &engine.CallCost{}
-		err = sm.connector.Debit(cd, cc)
-		if err != nil {
-			engine.Logger.Err(fmt.Sprintf("Error making the general debit for postpaid call: %v", ev.GetUUID()))
-			return
+			Tenant:          ev.GetMsgTenant(),
+			TOR:             ev.GetMsgTOR(),
+			Subject:         ev.GetMsgSubject(),
+			Account:         ev.GetMsgAccount(),
+			Destination:     ev.GetMsgDestination(),
+			TimeStart:       timeStart,
+			FallbackSubject: ev.GetFallbackSubj()}
+		var cc *engine.CallCost
+		if cc, notify = chargeMessage(sm.connector, cd, reqType); cc != nil && sm.loggerDB != nil {
+			if err := sm.loggerDB.LogCallCost(uuid, engine.SESSION_MANAGER_SOURCE, cc); err != nil {
+				engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not save message %s cost: %v", uuid, err))
+			}
 		}
-		s.CallCosts = append(s.CallCosts, cc)
-		return
+	} else {
+		engine.Logger.Err(fmt.Sprintf("Missing parameter for message %s", uuid))
 	}
+	sm.replyMessage(node, ev, notify)
+}
 
-	if s == nil || len(s.CallCosts) == 0 {
-		return // why would we have 0 callcosts
-	}
-	lastCC := s.CallCosts[len(s.CallCosts)-1]
-	// put credit back
-	var hangupTime time.Time
-	var err error
-	if hangupTime, err = ev.GetEndTime(); err != nil {
-		engine.Logger.Err("Error parsing answer event hangup time, using time.Now!")
-		hangupTime = time.Now()
-	}
-	end := lastCC.Timespans[len(lastCC.Timespans)-1].TimeEnd
-	refoundDuration := end.Sub(hangupTime).Seconds()
-	cost := 0.0
-	seconds := 0.0
-	engine.Logger.Info(fmt.Sprintf("Refund duration: %v", refoundDuration))
-	for i := len(lastCC.Timespans) - 1; i >= 0; i-- {
-		ts := lastCC.Timespans[i]
-		tsDuration := ts.GetDuration().Seconds()
-		if refoundDuration <= tsDuration {
-			// find procentage
-			procentage := (refoundDuration * 100) / tsDuration
-			tmpCost := (procentage * ts.Cost) / 100
-			ts.Cost -= tmpCost
-			cost += tmpCost
-			if ts.MinuteInfo != nil {
-				// DestinationPrefix and Price take from lastCC and above caclulus
-				seconds += (procentage * ts.MinuteInfo.Quantity) / 100
-			}
-			// set the end time to now
-			ts.TimeEnd = hangupTime
-			break // do not go to other timespans
-		} else {
-			cost += ts.Cost
-			if ts.MinuteInfo != nil {
-				seconds += ts.MinuteInfo.Quantity
-			}
-			// remove the timestamp entirely
-			lastCC.Timespans = lastCC.Timespans[:i]
-			// continue to the next timespan with what is left to refound
-			refoundDuration -= tsDuration
-		}
+// Delivers the authorized message to its destination, otherwise sends the notification back to the sender
+func (sm *FSSessionManager) replyMessage(node string, ev FSEvent, notify string) {
+	cmd := chatCmd(ev[MSG_PROTO], ev[MSG_FROM], ev[MSG_TO], ev[MSG_BODY])
+	if notify != AUTH_OK {
+		cmd = chatCmd(ev[MSG_PROTO], ev[MSG_TO], ev[MSG_FROM], notify)
 	}
-	if cost > 0 {
-		cd := &engine.CallDescriptor{
-			Direction:   lastCC.Direction,
-			Tenant:      lastCC.Tenant,
-			TOR:         lastCC.TOR,
-			Subject:     lastCC.Subject,
-			Account:     lastCC.Account,
-			Destination: lastCC.Destination,
-			Amount:      -cost,
-			// FallbackSubject: lastCC.FallbackSubject, // ToDo: check how to best add it
-		}
-		var response float64
-		err := sm.connector.DebitCents(*cd, &response)
-		if err != nil {
-			engine.Logger.Err(fmt.Sprintf("Debit cents failed: %v", err))
-		}
+	if err := sm.sendApiCmd(node, cmd); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not send message reply to freeswitch: %v", err))
 	}
-	if seconds > 0 {
-		cd := &engine.CallDescriptor{
-			Direction:   lastCC.Direction,
-			TOR:         lastCC.TOR,
-			Tenant:      lastCC.Tenant,
-			Subject:     lastCC.Subject,
-			Account:     lastCC.Account,
-			Destination: lastCC.Destination,
-			Amount:      -seconds,
-			// FallbackSubject: lastCC.FallbackSubject, // ToDo: check how to best add it
+}
+
+// Line breaks would end the api command and pipes separate its arguments, neither can come from the message
+var chatArgReplacer = strings.NewReplacer("\r", " ", "\n", " ", "|", " ")
+
+// The api command sending the message through the chat interface
+func chatCmd(proto, from, to, body string) string {
+	return fmt.Sprintf("chat %s|%s|%s|%s\n\n", chatArgReplacer.Replace(proto), chatArgReplacer.Replace(from),
+		chatArgReplacer.Replace(to), chatArgReplacer.Replace(body))
+}
+
+func (sm *FSSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor, index float64) {
+	if cc := debitLoopAction(sm, sm.connector, s, cd, index); cc != nil {
+		remainingSeconds, err := sm.remainingSessionTime(s, cd)
+		if err != nil { // carried till the end of this debit only
+			engine.Logger.Err(fmt.Sprintf("Could not get max session time for %s: %v", s.uuid, err))
 		}
-		var response float64
-		err := sm.connector.DebitSeconds(*cd, &response)
-		if err != nil {
-			engine.Logger.Err(fmt.Sprintf("Debit seconds failed: %v", err))
+		sm.refreshHardTimeout(s, hardTimeout(cc, remainingSeconds))
+	}
+	sm.persistSession(s, time.Now())
+}
+
+// Seconds the session is authorized for after the debit of cd, shared with the other prepaid sessions of the account
+// as on park. Looks ahead till the maximum call duration if configured, HARD_TIMEOUT_HORIZON otherwise.
+func (sm *FSSessionManager) remainingSessionTime(s *Session, cd *engine.CallDescriptor) (float64, error) {
+	horizon := float64(HARD_TIMEOUT_HORIZON)
+	if cfg.SMMaxCallDuration > 0 {
+		horizon = float64(cfg.SMMaxCallDuration) - cd.TimeEnd.Sub(s.callDescriptor.TimeStart).Seconds()
+		if horizon <= 0 {
+			return 0, nil
 		}
 	}
-	lastCC.Cost -= cost
-	engine.Logger.Info(fmt.Sprintf("Rambursed %v cents, %v seconds", cost, seconds))
+	nextCd := engine.CallDescriptor{
+		Direction:       cd.Direction,
+		Tenant:          cd.Tenant,
+		TOR:             cd.TOR,
+		Subject:         cd.Subject,
+		Account:         cd.Account,
+		Destination:     cd.Destination,
+		Amount:          horizon,
+		TimeStart:       cd.TimeEnd,
+		FallbackSubject: cd.FallbackSubject}
+	var remainingSeconds float64
+	if err := sm.connector.GetMaxSessionTime(nextCd, &remainingSeconds); err != nil {
+		return 0, err
+	}
+	return splitSessionTime(remainingSeconds, sm.sessions.PrepaidCalls(cd.Tenant, cd.Account, s)), nil
+}
 
+// Seconds the switch carries the call after the debit granting cc, with the remaining seconds authorized afterwards.
+// Negative if unlimited.
+func hardTimeout(cc *engine.CallCost, remainingSeconds float64) int {
+	if remainingSeconds < 0 {
+		return -1
+	}
+	granted := cc.Timespans[len(cc.Timespans)-1].TimeEnd.Sub(cc.Timespans[0].TimeStart).Seconds()
+	return int(math.Ceil(granted+remainingSeconds)) + HARD_TIMEOUT_GRACE
 }
 
-func (sm *FSSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor, index float64) {
-	cc := &engine.CallCost{}
-	cd.LoopIndex = index
-	cd.Amount = sm.debitPeriod.Seconds()
-	cd.CallDuration += time.Duration(cd.Amount) * time.Second
-	err := sm.connector.MaxDebit(*cd, cc)
-	if err != nil {
-		engine.Logger.Err(fmt.Sprintf("Could not complete debit opperation: %v", err))
-		// disconnect session
-		s.sessionManager.DisconnectSession(s, SYSTEM_ERROR)
-	}
-	nbts := len(cc.Timespans)
-	remainingSeconds := 0.0
-	engine.Logger.Debug(fmt.Sprintf("Result of MaxDebit call: %v", cc))
-	if nbts > 0 {
-		remainingSeconds = cc.Timespans[nbts-1].TimeEnd.Sub(cc.Timespans[0].TimeStart).Seconds()
-	}
-	if remainingSeconds == 0 || err != nil {
-		engine.Logger.Info(fmt.Sprintf("No credit left: Disconnect %v", s))
-		sm.DisconnectSession(s, INSUFFICIENT_FUNDS)
+// Schedules the hangup under its own group, sched_hangup using the call's uuid shared with the other tasks of the call
+func schedHangupCmd(uuid string, seconds int) string {
+	return fmt.Sprintf("sched_api +%d %s uuid_kill %s alloted_timeout", seconds, HANGUP_SCHED_GROUP+uuid, uuid)
+}
+
+// Replaces the scheduled hangup of the call, the switch ending it if no other debit succeeds meanwhile.
+// A negative timeout only cancels it.
+func (sm *FSSessionManager) refreshHardTimeout(s *Session, seconds int) {
+	if err := sm.sendApiCmd(s.node, fmt.Sprintf("sched_del %s\n\n", HANGUP_SCHED_GROUP+s.uuid)); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not cancel the hangup timeout for %s: %v", s.uuid, err))
+	}
+	if seconds < 0 {
 		return
 	}
-	s.CallCosts = append(s.CallCosts, cc)
+	if err := sm.sendApiCmd(s.node, schedHangupCmd(s.uuid, seconds)+"\n\n"); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not refresh the hangup timeout for %s: %v", s.uuid, err))
+	}
 }
 
 func (sm *FSSessionManager) GetDebitPeriod() time.Duration {
@@ -349,21 +589,31 @@ func (sm *FSSessionManager) GetDbLogger() engine.DataStorage {
 }
 
 func (sm *FSSessionManager) Shutdown() (err error) {
-	if fsock.FS == nil || !fsock.FS.Connected() {
+	sm.nodesMux.RLock()
+	nodes := make([]*fsock.FSock, 0, len(sm.nodes))
+	for _, fs := range sm.nodes {
+		if fs.Connected() {
+			nodes = append(nodes, fs)
+		}
+	}
+	sm.nodesMux.RUnlock()
+	if len(nodes) == 0 {
 		return errors.New("Cannot shutdown sessions, fsock not connected")
 	}
 	engine.Logger.Info("Shutting down all sessions...")
 	cmdKillPrepaid := "hupall MANAGER_REQUEST cgr_reqtype prepaid"
 	cmdKillPostpaid := "hupall MANAGER_REQUEST cgr_reqtype postpaid"
-	for _, cmd := range []string{cmdKillPrepaid, cmdKillPostpaid} {
-		if err = fsock.FS.SendApiCmd(cmd); err != nil {
-			engine.Logger.Err(fmt.Sprintf("Error on calls shutdown: %s", err))
-			return
+	for _, fs := range nodes {
+		for _, cmd := range []string{cmdKillPrepaid, cmdKillPostpaid} {
+			if err = fs.SendApiCmd(cmd); err != nil {
+				engine.Logger.Err(fmt.Sprintf("Error on calls shutdown: %s", err))
+				return
+			}
 		}
 	}
-	for guard := 0; len(sm.sessions) > 0 && guard < 20; guard++ {
+	for guard := 0; sm.sessions.Len() > 0 && guard < 20; guard++ {
 		time.Sleep(100 * time.Millisecond) // wait for the hungup event to be fired
-		engine.Logger.Info(fmt.Sprintf("<SessionManager> Shutdown waiting on sessions: %v", sm.sessions))
+		engine.Logger.Info(fmt.Sprintf("<SessionManager> Shutdown waiting on sessions: %v", sm.Sessions()))
 	}
 	return
 }
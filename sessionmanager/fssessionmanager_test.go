@@ -19,10 +19,149 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package sessionmanager
 
 import (
-// "github.com/cgrates/cgrates/timespans"
-// "testing"
+	"bufio"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"math"
+	"net"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
 )
 
+// Logger storage safe for the asynchronous cost saving, keeping the logged errors
+type lockedLogger struct {
+	engine.DataStorage
+	sync.Mutex
+	errors map[string]string
+}
+
+func (ll *lockedLogger) LogError(uuid, source, errstr string) error {
+	ll.Lock()
+	defer ll.Unlock()
+	if ll.errors == nil {
+		ll.errors = make(map[string]string)
+	}
+	ll.errors[uuid] = errstr
+	return nil
+}
+
+func (ll *lockedLogger) getError(uuid string) string {
+	ll.Lock()
+	defer ll.Unlock()
+	return ll.errors[uuid]
+}
+
+func (ll *lockedLogger) LogCallCost(uuid, source string, cc *engine.CallCost) error {
+	ll.Lock()
+	defer ll.Unlock()
+	return ll.DataStorage.LogCallCost(uuid, source, cc)
+}
+
+func (ll *lockedLogger) GetCallCostLog(uuid, source string) (*engine.CallCost, error) {
+	ll.Lock()
+	defer ll.Unlock()
+	return ll.DataStorage.GetCallCostLog(uuid, source)
+}
+
+// Answers one api command the way FreeSWITCH's event socket does
+func fakeFSApi(t *testing.T, lsn net.Listener, passwd, reply string) {
+	conn, err := lsn.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	rdr := textproto.NewReader(bufio.NewReader(conn))
+	fmt.Fprint(conn, "Content-Type: auth/request\n\n")
+	if line, _ := rdr.ReadLine(); line != "auth "+passwd {
+		fmt.Fprint(conn, "Content-Type: command/reply\nReply-Text: -ERR invalid\n\n")
+		return
+	}
+	rdr.ReadLine()
+	fmt.Fprint(conn, "Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+	if line, _ := rdr.ReadLine(); line != "api show channels as json" {
+		t.Error("Unexpected api command: ", line)
+	}
+	rdr.ReadLine()
+	fmt.Fprintf(conn, "Content-Type: api/response\nContent-Length: %d\n\n%s", len(reply), reply)
+}
+
+func TestFSApiCommandAuthFailure(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot start fake event socket: ", err)
+	}
+	defer lsn.Close()
+	go fakeFSApi(t, lsn, "ClueCon", "")
+	if _, err := fsActiveChannels(lsn.Addr().String(), "wrong"); err == nil {
+		t.Error("Expected authentication error")
+	}
+}
+
+func TestFSSessionManagerRecoverSessions(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot start fake event socket: ", err)
+	}
+	defer lsn.Close()
+	go fakeFSApi(t, lsn, "ClueCon", `{"row_count":1,"rows":[{"uuid":"live","direction":"inbound"}]}`)
+	node := lsn.Addr().String()
+	cfg, _ = config.NewCGRConfigBytes([]byte(fmt.Sprintf("[freeswitch]\nserver = %s,127.0.0.2:8021\npasswd = ClueCon\n", node)))
+	sessionDb, _ := engine.NewMapStorage()
+	mapLogger, _ := engine.NewMapStorage()
+	logger := &lockedLogger{DataStorage: mapLogger}
+	lastEnd := time.Now().Add(-3500 * time.Millisecond)
+	for uuid, srNode := range map[string]string{"live": node, "gone": "", "other": "127.0.0.2:8021"} {
+		sessionDb.SetSessionRecord(&engine.SessionRecord{Uuid: uuid, ReqType: utils.PREPAID, Node: srNode,
+			CallDescriptor: &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", Account: "1001", Destination: "1002",
+				TimeStart: lastEnd.Add(-10 * time.Second)},
+			CallCosts: []*engine.CallCost{&engine.CallCost{Account: "1001", Cost: 1,
+				Timespans: []*engine.TimeSpan{&engine.TimeSpan{TimeStart: lastEnd.Add(-10 * time.Second), TimeEnd: lastEnd, Cost: 1}}}},
+			LastSeen: lastEnd.Add(-4 * time.Second)})
+	}
+	sessionDb.SetSessionRecord(&engine.SessionRecord{Uuid: "gone_postpaid", ReqType: utils.POSTPAID, Node: node,
+		CallDescriptor: &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", Account: "1001", Destination: "1002",
+			TimeStart: lastEnd.Add(-10 * time.Second)}, LastSeen: lastEnd})
+	connector := &fakeConnector{}
+	sm := NewFSSessionManager(logger, connector, time.Second)
+	sm.SetSessionStorage(sessionDb)
+	sm.recoverSessions(node)
+	s := sm.GetSession("live")
+	if sm.sessions.Len() != 1 || s == nil || s.node != node {
+		t.Fatal("Live session not recovered: ", sm.Sessions())
+	}
+	defer func() { s.stopDebit <- true }()
+	// the debits missed while down are made at once
+	if !waitFor(func() bool { return connector.getMaxDebits() >= 4 }) {
+		t.Error("Recovered session did not catch up with debits: ", connector.getMaxDebits())
+	}
+	if !waitFor(func() bool { cc, _ := logger.GetCallCostLog("gone", engine.SESSION_MANAGER_SOURCE); return cc != nil }) {
+		t.Error("Costs of ended session not saved")
+	}
+	// the prepaid one is refunded after the time it was last seen, the postpaid one charged till then
+	if cc, _ := logger.GetCallCostLog("gone", engine.SESSION_MANAGER_SOURCE); cc == nil || math.Abs(cc.Cost-0.6) > 1e-9 {
+		t.Error("Ended prepaid session not refunded: ", cc)
+	}
+	connector.Lock()
+	debits := connector.debits
+	connector.Unlock()
+	if debits != 1 {
+		t.Error("Ended postpaid session not charged: ", debits)
+	}
+	srs, _ := sessionDb.GetSessionRecords()
+	if len(srs) != 2 { // the one of the other node is left for its own connection
+		t.Error("Unexpected session records after recovery: ", srs)
+	}
+	for _, sr := range srs {
+		if sr.Uuid == "gone" {
+			t.Error("Record of ended session not removed")
+		}
+	}
+}
+
 /*func TestConnect(t *testing.T) {
 	sm := &FSSessionManager{}
 	sm.Connect(&SessionDelegate{&timespans.Responder{}}, "localhost:8021", "ClueCon")
@@ -34,3 +173,155 @@ import (
 	//log.Print(ev)
 	//}
 }*/
+
+func TestFSSessionManagerSyncSessions(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot start fake event socket: ", err)
+	}
+	defer lsn.Close()
+	go fakeFSApi(t, lsn, "ClueCon", `{"row_count":1,"rows":[{"uuid":"alive"}]}`)
+	node := lsn.Addr().String()
+	cfg, _ = config.NewCGRConfigBytes([]byte(fmt.Sprintf("[freeswitch]\nserver = %s\npasswd = ClueCon\n[session_manager]\nchannel_sync_interval = 1\nmax_call_duration = 60\n", node)))
+	mapLogger, _ := engine.NewMapStorage()
+	logger := &lockedLogger{DataStorage: mapLogger}
+	connector := &fakeConnector{}
+	sm := NewFSSessionManager(logger, connector, 10*time.Second)
+	now := time.Now()
+	lastSync := now.Add(-5 * time.Second)
+	for _, uuid := range []string{"alive", "stale", "elsewhere"} {
+		s := &Session{uuid: uuid, reqType: utils.POSTPAID, node: node, sessionManager: sm, stopDebit: make(chan bool, 2),
+			callDescriptor: &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", Account: "1001", Destination: "1002",
+				TimeStart: now.Add(-30 * time.Second)}}
+		if uuid == "elsewhere" {
+			s.node = "127.0.0.2:8021"
+		}
+		sm.sessions.Add(s)
+	}
+	stale := sm.GetSession("stale")
+	if synced := sm.syncSessions(node, lastSync, now); !synced.Equal(now) {
+		t.Error("Unexpected sync time: ", synced)
+	}
+	if sm.GetSession("stale") != nil {
+		t.Error("Stale session not closed")
+	}
+	if sm.GetSession("alive") == nil || sm.GetSession("elsewhere") == nil {
+		t.Error("Closed sessions still active: ", sm.Sessions())
+	}
+	if logger.getError("stale") == "" {
+		t.Error("Stale session close not logged as error")
+	}
+	// postpaid sessions are debited till they were last seen
+	if !waitFor(func() bool { cc, _ := logger.GetCallCostLog("stale", engine.SESSION_MANAGER_SOURCE); return cc != nil }) {
+		t.Error("Costs of stale session not saved")
+	}
+	// the hangup arriving late does not settle it a second time
+	sm.OnChannelHangupComplete(stale.endEvent(now))
+	sm.closeStaleSession(stale, lastSync)
+	connector.Lock()
+	debits := connector.debits
+	connector.Unlock()
+	if debits != 1 {
+		t.Error("Stale session settled more than once: ", debits)
+	}
+	// channels query failing keeps the sessions and the last sync time
+	if synced := sm.syncSessions(node, lastSync, now); !synced.IsZero() || sm.sessions.Len() != 2 {
+		t.Error("Unexpected sync result without channels list: ", synced, sm.Sessions())
+	}
+}
+
+func TestSessionEndEvent(t *testing.T) {
+	start := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	s := &Session{uuid: "uuid1", reqType: utils.PREPAID,
+		callDescriptor: &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", TOR: "call", Account: "1001", Subject: "1001", Destination: "1002", TimeStart: start}}
+	ev := s.endEvent(start.Add(90 * time.Second))
+	if end, _ := ev.GetEndTime(); !end.Equal(start.Add(90*time.Second)) || ev.GetUUID() != "uuid1" || ev.GetReqType() != utils.PREPAID || ev.GetAccount() != "1001" {
+		t.Errorf("Unexpected end event: %+v", ev)
+	}
+	if end, _ := s.endEvent(start.Add(-time.Second)).GetEndTime(); !end.Equal(start) {
+		t.Error("End before the session start: ", end)
+	}
+}
+
+func TestFSSessionManagerOnMessage(t *testing.T) {
+	cfg, _ = config.NewCGRConfigBytes([]byte("[global]\ndefault_reqtype = prepaid\n"))
+	body := `Event-Name: CUSTOM
+Event-Subclass: SMS::SEND_MESSAGE
+Event-UUID: 5e3a7a4e-bd6b-11e3-9ee5-b5e4e3f5c9a3
+Event-Date-Timestamp: 1396984800000000
+proto: sip
+from: 1001@192.168.56.66
+from_user: 1001
+to: 1002@192.168.56.66
+to_user: 1002
+
+Hello there`
+	ev := new(FSEvent).New(body).(FSEvent)
+	ev[MSG_BODY] = fsMessageBody(body)
+	if ev[MSG_BODY] != "Hello there" || ev.GetMsgAccount() != "1001" || ev.GetMsgDestination() != "1002" || ev.GetMsgTOR() != SMS_TOR || ev.GetMsgReqType() != utils.PREPAID {
+		t.Errorf("Message not parsed correctly: %+v", ev)
+	}
+	mapLogger, _ := engine.NewMapStorage()
+	connector := &fakeConnector{smsUnits: 1}
+	sm := NewFSSessionManager(mapLogger, connector, 10*time.Second)
+	sm.OnMessage(ev, "127.0.0.1:8021")
+	if cc, err := mapLogger.GetCallCostLog("5e3a7a4e-bd6b-11e3-9ee5-b5e4e3f5c9a3", engine.SESSION_MANAGER_SOURCE); err != nil || cc == nil || cc.Account != "1001" || cc.TOR != SMS_TOR {
+		t.Error("Message cost not logged: ", cc, err)
+	}
+	if connector.smsUnits != 0 {
+		t.Error("Sms units not debited: ", connector.smsUnits)
+	}
+	ev[MSG_UUID] = "5e3a7a4e-bd6b-11e3-9ee5-b5e4e3f5c9a4"
+	sm.OnMessage(ev, "127.0.0.1:8021") // no units nor credit left
+	if cc, _ := mapLogger.GetCallCostLog("5e3a7a4e-bd6b-11e3-9ee5-b5e4e3f5c9a4", engine.SESSION_MANAGER_SOURCE); cc != nil {
+		t.Error("Cost logged for rejected message: ", cc)
+	}
+}
+
+func TestFSChatCmd(t *testing.T) {
+	if cmd := chatCmd("sip", "1001@192.168.56.66", "1002@192.168.56.66", "Hello\n\napi hupall|x\r"); cmd != "chat sip|1001@192.168.56.66|1002@192.168.56.66|Hello  api hupall x \n\n" {
+		t.Errorf("Message content not escaped: %q", cmd)
+	}
+}
+
+func TestFSSchedHangupCmd(t *testing.T) {
+	if cmd := schedHangupCmd("e3133bf7-dcde-4daf-9663-9a79ffcef5ad", 12); cmd != "sched_api +12 cgr_hangup_e3133bf7-dcde-4daf-9663-9a79ffcef5ad uuid_kill e3133bf7-dcde-4daf-9663-9a79ffcef5ad alloted_timeout" {
+		t.Errorf("Unexpected hangup command: %q", cmd)
+	}
+}
+
+func TestFSHardTimeout(t *testing.T) {
+	start := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	cc := &engine.CallCost{Timespans: []*engine.TimeSpan{&engine.TimeSpan{TimeStart: start, TimeEnd: start.Add(6 * time.Second)},
+		&engine.TimeSpan{TimeStart: start.Add(6 * time.Second), TimeEnd: start.Add(9500 * time.Millisecond)}}}
+	if timeout := hardTimeout(cc, 0); timeout != 10+HARD_TIMEOUT_GRACE {
+		t.Error("Unexpected hangup timeout: ", timeout)
+	}
+	if timeout := hardTimeout(cc, 60); timeout != 70+HARD_TIMEOUT_GRACE {
+		t.Error("Remaining authorized time not added: ", timeout)
+	}
+	if timeout := hardTimeout(cc, -1); timeout >= 0 {
+		t.Error("Unexpected hangup timeout for unlimited session: ", timeout)
+	}
+}
+
+func TestFSRemainingSessionTime(t *testing.T) {
+	cfg, _ = config.NewCGRConfigBytes([]byte("[session_manager]\nmax_call_duration = 60\n"))
+	connector := &fakeConnector{maxSessionTime: 40}
+	sm := NewFSSessionManager(nil, connector, 10*time.Second)
+	start := time.Now()
+	for _, uuid := range []string{"uuid1", "uuid2"} {
+		sm.sessions.Add(&Session{uuid: uuid, reqType: utils.PREPAID, callDescriptor: &engine.CallDescriptor{Direction: engine.OUTBOUND,
+			Tenant: "cgrates.org", Account: "1001", Destination: "1002", TimeStart: start}})
+	}
+	cd := &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", Account: "1001", Destination: "1002",
+		TimeStart: start, TimeEnd: start.Add(10 * time.Second)}
+	// shared with the other prepaid session of the account
+	if remaining, err := sm.remainingSessionTime(sm.GetSession("uuid1"), cd); err != nil || remaining != 20 {
+		t.Error("Unexpected remaining session time: ", remaining, err)
+	}
+	cd.TimeEnd = start.Add(60 * time.Second)
+	if remaining, _ := sm.remainingSessionTime(sm.GetSession("uuid1"), cd); remaining != 0 {
+		t.Error("Session authorized over the maximum call duration: ", remaining)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dialog identifiers kamailio needs to end a call
+type kamDialog struct {
+	hEntry, hId string
+}
+
+// The kamailio session manager, connects to the evapi socket and exchanges
+// netstring framed json messages with the kamailio script
+type KamailioSessionManager struct {
+	conn        net.Conn
+	buf         *bufio.Reader
+	writeMux    sync.Mutex // evapi messages must not interleave
+	sessions    *SessionRegistry
+	dialogs     map[string]kamDialog // session uuid to its dialog
+	dialogsMux  sync.RWMutex         // the debit loops disconnect sessions concurrently with the event handlers
+	connector   engine.Connector
+	debitPeriod time.Duration
+	loggerDB    engine.DataStorage
+}
+
+func NewKamailioSessionManager(storage engine.DataStorage, connector engine.Connector, debitPeriod time.Duration) *KamailioSessionManager {
+	return &KamailioSessionManager{sessions: NewSessionRegistry(), loggerDB: storage, connector: connector, debitPeriod: debitPeriod, dialogs: make(map[string]kamDialog)}
+}
+
+// Connects to kamailio's evapi socket and handles the events, reconnecting when the connection drops
+func (sm *KamailioSessionManager) Connect(cgrCfg *config.CGRConfig) (err error) {
+	cfg = cgrCfg // make config global
+	for {
+		if err = sm.dial(cgrCfg.KamailioEvApiAddr, cgrCfg.KamailioReconnects); err != nil {
+			return err
+		}
+		err = sm.readEvents()
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Kamailio evapi connection lost: %v", err))
+	}
+}
+
+func (sm *KamailioSessionManager) dial(addr string, reconnects int) (err error) {
+	var conn net.Conn
+	for i := 0; i < reconnects; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(time.Duration(i/2) * time.Second)
+	}
+	if conn == nil {
+		return fmt.Errorf("Cannot connect to Kamailio evapi at %s: %v", addr, err)
+	}
+	sm.writeMux.Lock()
+	sm.conn = conn
+	sm.buf = bufio.NewReader(conn)
+	sm.writeMux.Unlock()
+	engine.Logger.Info(fmt.Sprintf("<SessionManager> Connected to Kamailio evapi at %s", addr))
+	return nil
+}
+
+func (sm *KamailioSessionManager) readEvents() error {
+	for {
+		body, err := readNetstring(sm.buf)
+		if err != nil {
+			sm.conn.Close()
+			return err
+		}
+		sm.handleEvent(body)
+	}
+}
+
+func (sm *KamailioSessionManager) handleEvent(body string) {
+	ev := new(KamEvent).New(body).(KamEvent)
+	switch ev.GetName() {
+	case KAM_AUTH_REQUEST:
+		sm.OnAuthRequest(ev)
+	case KAM_CALL_START:
+		sm.OnCallStart(ev)
+	case KAM_CALL_END:
+		sm.OnCallEnd(ev)
+	default:
+		engine.Logger.Warning(fmt.Sprintf("<SessionManager> Unhandled Kamailio event: %s", body))
+	}
+}
+
+// Netstrings are the default evapi framing: <length>:<data>,
+func readNetstring(rd *bufio.Reader) (string, error) {
+	lenStr, err := rd.ReadString(':')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(lenStr[:len(lenStr)-1])
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid netstring length: %s", lenStr)
+	}
+	data := make([]byte, length+1) // includes the trailing comma
+	if _, err := io.ReadFull(rd, data); err != nil {
+		return "", err
+	}
+	if data[length] != ',' {
+		return "", errors.New("netstring not terminated by comma")
+	}
+	return string(data[:length]), nil
+}
+
+func (sm *KamailioSessionManager) send(msg map[string]string) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	sm.writeMux.Lock()
+	defer sm.writeMux.Unlock()
+	if sm.conn == nil {
+		return errors.New("not connected to Kamailio")
+	}
+	_, err = fmt.Fprintf(sm.conn, "%d:%s,", len(body), body)
+	return err
+}
+
+// Returns the active sessions
+func (sm *KamailioSessionManager) Sessions() []*Session {
+	return sm.sessions.All()
+}
+
+// Searches and return the session with the specifed uuid
+func (sm *KamailioSessionManager) GetSession(uuid string) *Session {
+	return sm.sessions.Get(uuid)
+}
+
+// Asks kamailio to end the dialog of the session
+func (sm *KamailioSessionManager) DisconnectSession(s *Session, notify string) {
+	sm.dialogsMux.RLock()
+	dlg, hasDlg := sm.dialogs[s.uuid]
+	sm.dialogsMux.RUnlock()
+	if !hasDlg {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> No Kamailio dialog for session %s", s.uuid))
+		return
+	}
+	if err := sm.send(map[string]string{KAM_EVENT: KAM_DISCONNECT, KAM_H_ENTRY: dlg.hEntry, KAM_H_ID: dlg.hId, "reason": notify}); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not send disconect msg to kamailio: %v", err))
+	}
+}
+
+// Sends the warning for the session's dialog to kamailio
+func (sm *KamailioSessionManager) WarnSession(s *Session, notify string) {
+	sm.dialogsMux.RLock()
+	dlg, hasDlg := sm.dialogs[s.uuid]
+	sm.dialogsMux.RUnlock()
+	if !hasDlg {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> No Kamailio dialog for session %s", s.uuid))
+		return
+	}
+	if err := sm.send(map[string]string{KAM_EVENT: KAM_WARNING, KAM_H_ENTRY: dlg.hEntry, KAM_H_ID: dlg.hId, "reason": notify}); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not send warning msg to kamailio: %v", err))
+	}
+}
+
+// Remove session from sessin list
+func (sm *KamailioSessionManager) RemoveSession(s *Session) {
+	sm.dialogsMux.Lock()
+	delete(sm.dialogs, s.uuid)
+	sm.dialogsMux.Unlock()
+	sm.sessions.Remove(s)
+}
+
+// Replies kamailio's suspended transaction with the maximum session time, -1 for unlimited
+func (sm *KamailioSessionManager) OnAuthRequest(ev KamEvent) {
+	reply := map[string]string{KAM_EVENT: KAM_AUTH_REPLY, KAM_TR_INDEX: ev[KAM_TR_INDEX], KAM_TR_LABEL: ev[KAM_TR_LABEL],
+		"cgr_maxsessiontime": "0"}
+	defer func() {
+		if err := sm.send(reply); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not send authorization reply to kamailio: %v", err))
+		}
+	}()
+	// counted in the limits from now on, the session taking its place once answered
+	notify, prepaidCalls := sm.sessions.Reserve(ev.GetUUID(), ev.GetReqType(), ev.GetTenant(), ev.GetAccount(), cfg.SMMaxCallsAccount, cfg.SMMaxCallsTenant)
+	if notify != "" {
+		engine.Logger.Info(fmt.Sprintf("Concurrent calls limit reached, not authorizing the call %s.", ev.GetUUID()))
+		reply["cgr_notify"] = notify
+		return
+	}
+	// only prepaid calls are limited
+	if ev.GetReqType() != utils.PREPAID {
+		reply["cgr_maxsessiontime"] = "-1"
+		reply["cgr_notify"] = AUTH_OK
+		return
+	}
+	if ev.MissingParameter() {
+		engine.Logger.Err(fmt.Sprintf("Missing parameter for %s", ev.GetUUID()))
+		sm.sessions.Release(ev.GetUUID())
+		reply["cgr_notify"] = MISSING_PARAMETER
+		return
+	}
+	startTime, err := ev.GetStartTime(PARK_TIME)
+	if err != nil {
+		engine.Logger.Err("Error parsing authorization event setup time, using time.Now!")
+		startTime = time.Now()
+	}
+	cd := engine.CallDescriptor{
+		Direction:       ev.GetDirection(),
+		Tenant:          ev.GetTenant(),
+		TOR:             ev.GetTOR(),
+		Subject:         ev.GetSubject(),
+		Account:         ev.GetAccount(),
+		Destination:     ev.GetDestination(),
+		Amount:          sm.debitPeriod.Seconds(),
+		TimeStart:       startTime,
+		FallbackSubject: ev.GetFallbackSubj()}
+	var remainingSeconds float64
+	if err = sm.connector.GetMaxSessionTime(cd, &remainingSeconds); err != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not get max session time for %s: %v", ev.GetUUID(), err))
+		sm.sessions.Release(ev.GetUUID())
+		reply["cgr_notify"] = SYSTEM_ERROR
+		return
+	}
+	remainingSeconds = splitSessionTime(remainingSeconds, prepaidCalls)
+	engine.Logger.Info(fmt.Sprintf("Remaining seconds: %v", remainingSeconds))
+	if remainingSeconds == 0 {
+		engine.Logger.Info(fmt.Sprintf("Not enough credit for authorizing the call %s for %s.", ev.GetUUID(), cd.GetKey()))
+		sm.sessions.Release(ev.GetUUID())
+		reply["cgr_notify"] = INSUFFICIENT_FUNDS
+		return
+	}
+	reply["cgr_maxsessiontime"] = strconv.Itoa(int(remainingSeconds))
+	reply["cgr_notify"] = AUTH_OK
+}
+
+func (sm *KamailioSessionManager) OnCallStart(ev KamEvent) {
+	engine.Logger.Info("<SessionManager> Kamailio call start.")
+	// known before creating the session so it can be disconnected right away
+	sm.dialogsMux.Lock()
+	sm.dialogs[ev.GetUUID()] = kamDialog{ev[KAM_H_ENTRY], ev[KAM_H_ID]}
+	sm.dialogsMux.Unlock()
+	s := NewSession(ev, sm)
+	if s != nil {
+		sm.sessions.Add(s)
+	} else {
+		sm.sessions.Release(ev.GetUUID())
+		sm.dialogsMux.Lock()
+		delete(sm.dialogs, ev.GetUUID())
+		sm.dialogsMux.Unlock()
+	}
+}
+
+func (sm *KamailioSessionManager) OnCallEnd(ev KamEvent) {
+	engine.Logger.Info("<SessionManager> Kamailio call end.")
+	sm.sessions.Release(ev.GetUUID())
+	s := sm.GetSession(ev.GetUUID())
+	if s == nil { // Not handled by us
+		return
+	}
+	defer s.Close(ev) // Stop loop and save the costs deducted so far to database
+	s.settle(sm.connector, ev)
+}
+
+func (sm *KamailioSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor, index float64) {
+	debitLoopAction(sm, sm.connector, s, cd, index)
+}
+
+func (sm *KamailioSessionManager) GetDebitPeriod() time.Duration {
+	return sm.debitPeriod
+}
+
+func (sm *KamailioSessionManager) GetDbLogger() engine.DataStorage {
+	return sm.loggerDB
+}
+
+func (sm *KamailioSessionManager) Shutdown() (err error) {
+	sessions := sm.sessions.All()
+	engine.Logger.Info("Shutting down all sessions...")
+	for _, s := range sessions {
+		sm.DisconnectSession(s, MANAGER_REQUEST)
+	}
+	for guard := 0; guard < 20; guard++ {
+		if sm.sessions.Len() == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond) // wait for the call end events
+	}
+	return
+}
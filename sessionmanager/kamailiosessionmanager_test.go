@@ -0,0 +1,196 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Connector answering with fixed values and counting the debits
+type fakeConnector struct {
+	sync.Mutex
+	maxSessionTime float64
+	maxDebits      int
+	debits         int
+	smsUnits       float64
+}
+
+func (fc *fakeConnector) GetCost(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	return nil
+}
+
+func (fc *fakeConnector) Debit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.debits++
+	return nil
+}
+
+func (fc *fakeConnector) MaxDebit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.maxDebits++
+	cc.Timespans = []*engine.TimeSpan{&engine.TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeEnd}}
+	return nil
+}
+
+func (fc *fakeConnector) DebitCents(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) DebitSeconds(cd engine.CallDescriptor, reply *float64) error {
+	return nil
+}
+
+func (fc *fakeConnector) DebitSMS(cd engine.CallDescriptor, reply *float64) error {
+	fc.Lock()
+	defer fc.Unlock()
+	if fc.smsUnits < cd.Amount {
+		*reply = fc.smsUnits
+		return errors.New(utils.ERR_INSUFFICIENT_UNITS)
+	}
+	fc.smsUnits -= cd.Amount
+	*reply = fc.smsUnits
+	return nil
+}
+
+func (fc *fakeConnector) GetMaxSessionTime(cd engine.CallDescriptor, reply *float64) error {
+	fc.Lock()
+	defer fc.Unlock()
+	*reply = fc.maxSessionTime
+	return nil
+}
+
+func (fc *fakeConnector) getMaxDebits() int {
+	fc.Lock()
+	defer fc.Unlock()
+	return fc.maxDebits
+}
+
+// Plays kamailio's part of the evapi connection
+type fakeEvApi struct {
+	conn net.Conn
+	buf  *bufio.Reader
+}
+
+func (fe *fakeEvApi) send(t *testing.T, msg string) {
+	if _, err := fmt.Fprintf(fe.conn, "%d:%s,", len(msg), msg); err != nil {
+		t.Fatal("Could not send evapi message: ", err)
+	}
+}
+
+func (fe *fakeEvApi) receive(t *testing.T) map[string]string {
+	fe.conn.SetReadDeadline(time.Now().Add(time.Second))
+	body, err := readNetstring(fe.buf)
+	if err != nil {
+		t.Fatal("Could not read evapi message: ", err)
+	}
+	msg := make(map[string]string)
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		t.Fatal("Invalid evapi message: ", body)
+	}
+	return msg
+}
+
+func waitFor(condition func() bool) bool {
+	for guard := 0; guard < 100; guard++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestKamailioSessionManager(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot start fake evapi endpoint: ", err)
+	}
+	defer lsn.Close()
+	kamCfg, _ := config.NewCGRConfigBytes([]byte(fmt.Sprintf("[kamailio]\nevapi_addr = %s\n", lsn.Addr())))
+	storage, _ := engine.NewMapStorage()
+	connector := &fakeConnector{maxSessionTime: 120}
+	sm := NewKamailioSessionManager(storage, connector, 50*time.Millisecond)
+	go sm.Connect(kamCfg)
+	conn, err := lsn.Accept()
+	if err != nil {
+		t.Fatal("Session manager did not connect: ", err)
+	}
+	defer conn.Close()
+	kam := &fakeEvApi{conn, bufio.NewReader(conn)}
+
+	kam.send(t, `{"event":"CGR_AUTH_REQUEST","tr_index":"10","tr_label":"20","callid":"kam1","cgr_reqtype":"prepaid","cgr_tenant":"cgrates.org","cgr_tor":"call","cgr_account":"1001","cgr_destination":"1002","cgr_setuptime":1396985000}`)
+	if reply := kam.receive(t); reply[KAM_EVENT] != KAM_AUTH_REPLY || reply[KAM_TR_INDEX] != "10" || reply[KAM_TR_LABEL] != "20" ||
+		reply["cgr_maxsessiontime"] != "120" || reply["cgr_notify"] != AUTH_OK {
+		t.Error("Wrong authorization reply: ", reply)
+	}
+	kam.send(t, `{"event":"CGR_AUTH_REQUEST","tr_index":"11","tr_label":"21","callid":"kam2","cgr_reqtype":"prepaid","cgr_tenant":"cgrates.org","cgr_tor":"call","cgr_destination":"1002"}`)
+	if reply := kam.receive(t); reply[KAM_TR_INDEX] != "11" || reply["cgr_maxsessiontime"] != "0" || reply["cgr_notify"] != MISSING_PARAMETER {
+		t.Error("Wrong authorization reply for missing account: ", reply)
+	}
+
+	kam.send(t, `{"event":"CGR_CALL_START","callid":"kam1","h_entry":"891","h_id":"2019","cgr_reqtype":"prepaid","cgr_tenant":"cgrates.org","cgr_tor":"call","cgr_account":"1001","cgr_destination":"1002","cgr_answertime":1396985010}`)
+	if !waitFor(func() bool { return sm.GetSession("kam1") != nil && connector.getMaxDebits() > 0 }) {
+		t.Fatal("Session not started")
+	}
+	sm.DisconnectSession(sm.GetSession("kam1"), INSUFFICIENT_FUNDS)
+	if msg := kam.receive(t); msg[KAM_EVENT] != KAM_DISCONNECT || msg[KAM_H_ENTRY] != "891" || msg[KAM_H_ID] != "2019" || msg["reason"] != INSUFFICIENT_FUNDS {
+		t.Error("Wrong disconnect message: ", msg)
+	}
+	kam.send(t, `{"event":"CGR_CALL_END","callid":"kam1","h_entry":"891","h_id":"2019","cgr_reqtype":"prepaid","cgr_tenant":"cgrates.org","cgr_tor":"call","cgr_account":"1001","cgr_destination":"1002","cgr_answertime":1396985010,"cgr_duration":"5"}`)
+	if !waitFor(func() bool { return sm.GetSession("kam1") == nil }) {
+		t.Error("Session not closed on call end")
+	}
+}
+
+func TestKamailioSessionManagerInsufficientFunds(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot start fake evapi endpoint: ", err)
+	}
+	defer lsn.Close()
+	kamCfg, _ := config.NewCGRConfigBytes([]byte(fmt.Sprintf("[kamailio]\nevapi_addr = %s\n", lsn.Addr())))
+	sm := NewKamailioSessionManager(nil, &fakeConnector{}, time.Second)
+	go sm.Connect(kamCfg)
+	conn, err := lsn.Accept()
+	if err != nil {
+		t.Fatal("Session manager did not connect: ", err)
+	}
+	defer conn.Close()
+	kam := &fakeEvApi{conn, bufio.NewReader(conn)}
+	kam.send(t, `{"event":"CGR_AUTH_REQUEST","tr_index":"1","tr_label":"2","callid":"kam3","cgr_reqtype":"prepaid","cgr_tenant":"cgrates.org","cgr_tor":"call","cgr_account":"1001","cgr_destination":"1002"}`)
+	if reply := kam.receive(t); reply["cgr_maxsessiontime"] != "0" || reply["cgr_notify"] != INSUFFICIENT_FUNDS {
+		t.Error("Wrong authorization reply: ", reply)
+	}
+	kam.send(t, `{"event":"CGR_AUTH_REQUEST","tr_index":"1","tr_label":"3","callid":"kam4","cgr_reqtype":"postpaid","cgr_account":"1001","cgr_destination":"1002"}`)
+	if reply := kam.receive(t); reply["cgr_maxsessiontime"] != "-1" || reply["cgr_notify"] != AUTH_OK {
+		t.Error("Wrong authorization reply for postpaid: ", reply)
+	}
+}
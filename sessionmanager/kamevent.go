@@ -0,0 +1,144 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cgrates/cgrates/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event received over kamailio's evapi, flat json object relayed by the kamailio script
+type KamEvent map[string]string
+
+const (
+	// kamailio event proprieties names
+	KAM_EVENT       = "event"
+	KAM_TR_INDEX    = "tr_index" // suspended transaction waiting for the authorization reply
+	KAM_TR_LABEL    = "tr_label"
+	KAM_H_ENTRY     = "h_entry" // dialog identifiers, used to end the dialog
+	KAM_H_ID        = "h_id"
+	KAM_CALLID      = "callid"
+	KAM_REQTYPE     = "cgr_reqtype"
+	KAM_TENANT      = "cgr_tenant"
+	KAM_TOR         = "cgr_tor"
+	KAM_ACCOUNT     = "cgr_account"
+	KAM_SUBJECT     = "cgr_subject"
+	KAM_DESTINATION = "cgr_destination"
+	KAM_SETUP_TIME  = "cgr_setuptime"  // unix timestamp of the initial INVITE
+	KAM_ANSWER_TIME = "cgr_answertime" // unix timestamp of the dialog start
+	KAM_DURATION    = "cgr_duration"   // dialog duration in seconds
+	// kamailio event names
+	KAM_AUTH_REQUEST = "CGR_AUTH_REQUEST"
+	KAM_AUTH_REPLY   = "CGR_AUTH_REPLY"
+	KAM_CALL_START   = "CGR_CALL_START"
+	KAM_CALL_END     = "CGR_CALL_END"
+	KAM_DISCONNECT   = "CGR_SESSION_DISCONNECT"
+	KAM_WARNING      = "CGR_SESSION_WARNING"
+)
+
+// Session works with the FreeSWITCH time field names, translate them to ours
+var kamTimeFields = map[string]string{PARK_TIME: KAM_SETUP_TIME, START_TIME: KAM_ANSWER_TIME}
+
+// Nice printing for the event object.
+func (kev KamEvent) String() string {
+	out, _ := json.Marshal(kev)
+	return string(out)
+}
+
+// Loads the json body, numbers are kept in their original form
+func (kev KamEvent) New(body string) Event {
+	kev = make(KamEvent)
+	var fields map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return kev
+	}
+	for fld, val := range fields {
+		if val != nil {
+			kev[fld] = fmt.Sprint(val)
+		}
+	}
+	return kev
+}
+
+func (kev KamEvent) GetName() string {
+	return kev[KAM_EVENT]
+}
+func (kev KamEvent) GetDirection() string {
+	return "*out"
+}
+func (kev KamEvent) GetOrigId() string {
+	return kev[KAM_CALLID]
+}
+func (kev KamEvent) GetSubject() string {
+	return utils.FirstNonEmpty(kev[KAM_SUBJECT], kev[KAM_ACCOUNT])
+}
+func (kev KamEvent) GetAccount() string {
+	return kev[KAM_ACCOUNT]
+}
+func (kev KamEvent) GetDestination() string {
+	return kev[KAM_DESTINATION]
+}
+func (kev KamEvent) GetCallDestNr() string {
+	return kev[KAM_DESTINATION]
+}
+func (kev KamEvent) GetTOR() string {
+	return utils.FirstNonEmpty(kev[KAM_TOR], cfg.DefaultTOR)
+}
+func (kev KamEvent) GetUUID() string {
+	return kev[KAM_CALLID]
+}
+func (kev KamEvent) GetTenant() string {
+	return utils.FirstNonEmpty(kev[KAM_TENANT], cfg.DefaultTenant)
+}
+func (kev KamEvent) GetReqType() string {
+	return utils.FirstNonEmpty(kev[KAM_REQTYPE], cfg.DefaultReqType)
+}
+func (kev KamEvent) MissingParameter() bool {
+	return strings.TrimSpace(kev.GetAccount()) == "" ||
+		strings.TrimSpace(kev.GetDestination()) == "" ||
+		strings.TrimSpace(kev.GetTOR()) == "" ||
+		strings.TrimSpace(kev.GetUUID()) == "" ||
+		strings.TrimSpace(kev.GetTenant()) == ""
+}
+func (kev KamEvent) GetFallbackSubj() string {
+	return cfg.DefaultSubject
+}
+func (kev KamEvent) GetStartTime(field string) (t time.Time, err error) {
+	if kamFld, hasIt := kamTimeFields[field]; hasIt {
+		field = kamFld
+	}
+	st, err := strconv.ParseInt(kev[field], 0, 64)
+	t = time.Unix(st, 0)
+	return
+}
+
+func (kev KamEvent) GetEndTime() (t time.Time, err error) {
+	if t, err = kev.GetStartTime(KAM_ANSWER_TIME); err != nil {
+		return
+	}
+	dur, err := strconv.ParseInt(kev[KAM_DURATION], 0, 64)
+	t = t.Add(time.Duration(dur) * time.Second)
+	return
+}
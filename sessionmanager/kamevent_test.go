@@ -0,0 +1,50 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"github.com/cgrates/cgrates/config"
+	"testing"
+	"time"
+)
+
+func TestKamEventCreation(t *testing.T) {
+	cfg, _ = config.NewCGRConfigBytes([]byte(conf_data))
+	body := `{"event":"CGR_CALL_END","callid":"46c01a5c249b469e76333fc6bfa87f6a@0:0:0:0:0:0:0:0","from_tag":"bf71ad59","h_entry":"891","h_id":"2019","cgr_reqtype":"prepaid","cgr_account":"1001","cgr_destination":"1002","cgr_answertime":1396985020,"cgr_duration":"62"}`
+	ev := new(KamEvent).New(body)
+	if ev.GetName() != KAM_CALL_END {
+		t.Error("Event not parsed correctly: ", ev)
+	}
+	if l := len(ev.(KamEvent)); l != 10 {
+		t.Error("Incorrect number of event fields: ", l)
+	}
+	if ev.GetSubject() != "1001" || ev.GetAccount() != "1001" || ev.GetUUID() != "46c01a5c249b469e76333fc6bfa87f6a@0:0:0:0:0:0:0:0" {
+		t.Error("Wrong call data: ", ev)
+	}
+	if ev.MissingParameter() {
+		t.Error("Parameters reported missing: ", ev)
+	}
+	eStart := time.Unix(1396985020, 0)
+	if st, err := ev.GetStartTime(START_TIME); err != nil || !st.Equal(eStart) {
+		t.Error("Wrong start time: ", st, err)
+	}
+	if et, err := ev.GetEndTime(); err != nil || !et.Equal(eStart.Add(62*time.Second)) {
+		t.Error("Wrong end time: ", et, err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"strings"
+	"time"
+)
+
+// Type of record the messages are rated with, unless set on the message
+const SMS_TOR = "sms"
+
+// Charges one message: out of the account's sms balance when it covers it, otherwise (or when the account
+// has no balance record) debiting its price rated as one unit of the message's type of record.
+// Returns the cost of the message and the notification for the switch.
+func chargeMessage(connector engine.Connector, cd engine.CallDescriptor, reqType string) (cc *engine.CallCost, notify string) {
+	cd.Amount = 1
+	var left float64
+	err := connector.DebitSMS(cd, &left)
+	if err == nil {
+		return &engine.CallCost{Direction: cd.Direction, TOR: cd.TOR, Tenant: cd.Tenant, Subject: cd.Subject, Account: cd.Account, Destination: cd.Destination}, AUTH_OK
+	}
+	if err.Error() != utils.ERR_INSUFFICIENT_UNITS && !isNotFound(err) {
+		engine.Logger.Err(fmt.Sprintf("Could not debit sms units for %s: %v", cd.GetKey(), err))
+		return nil, SYSTEM_ERROR
+	}
+	cd.TimeEnd = cd.TimeStart.Add(time.Second)
+	if reqType == utils.PREPAID {
+		var maxUnits float64
+		if err := connector.GetMaxSessionTime(cd, &maxUnits); err != nil {
+			engine.Logger.Err(fmt.Sprintf("Could not get max session time for message of %s: %v", cd.GetKey(), err))
+			return nil, SYSTEM_ERROR
+		}
+		if maxUnits >= 0 && maxUnits < cd.Amount { // postpaid accounts return -1
+			return nil, INSUFFICIENT_FUNDS
+		}
+	}
+	cc = new(engine.CallCost)
+	if err := connector.Debit(cd, cc); err != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not debit message of %s: %v", cd.GetKey(), err))
+		return nil, SYSTEM_ERROR
+	}
+	return cc, AUTH_OK
+}
+
+// Storages report missing records with their own "not found" errors
+func isNotFound(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "not found")
+}
@@ -0,0 +1,67 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"errors"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"testing"
+	"time"
+)
+
+func TestChargeMessage(t *testing.T) {
+	cd := engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", TOR: SMS_TOR, Subject: "1001", Account: "1001", Destination: "1002", TimeStart: time.Now()}
+	connector := &fakeConnector{smsUnits: 1, maxSessionTime: 1}
+	if cc, notify := chargeMessage(connector, cd, utils.PREPAID); notify != AUTH_OK || cc == nil || cc.Cost != 0 || connector.debits != 0 || connector.smsUnits != 0 {
+		t.Error("Message not charged from the sms balance: ", notify, cc)
+	}
+	// no more units, rated price
+	if cc, notify := chargeMessage(connector, cd, utils.PREPAID); notify != AUTH_OK || cc == nil || connector.debits != 1 {
+		t.Error("Message not debited at the rated price: ", notify, cc)
+	}
+	connector.maxSessionTime = 0
+	if cc, notify := chargeMessage(connector, cd, utils.PREPAID); notify != INSUFFICIENT_FUNDS || cc != nil || connector.debits != 1 {
+		t.Error("Message without credit authorized: ", notify, cc)
+	}
+	if cc, notify := chargeMessage(connector, cd, utils.POSTPAID); notify != AUTH_OK || cc == nil || connector.debits != 2 {
+		t.Error("Postpaid message not debited: ", notify, cc)
+	}
+	connector.maxSessionTime = -1 // postpaid account
+	if _, notify := chargeMessage(connector, cd, utils.PREPAID); notify != AUTH_OK || connector.debits != 3 {
+		t.Error("Message of postpaid account not debited: ", notify)
+	}
+}
+
+// Account without balance record
+type noBalanceConnector struct {
+	*fakeConnector
+}
+
+func (nbc noBalanceConnector) DebitSMS(cd engine.CallDescriptor, reply *float64) error {
+	return errors.New("not found")
+}
+
+func TestChargeMessageNoBalance(t *testing.T) {
+	cd := engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", TOR: SMS_TOR, Subject: "1001", Account: "1001", Destination: "1002", TimeStart: time.Now()}
+	connector := noBalanceConnector{&fakeConnector{maxSessionTime: -1}}
+	if cc, notify := chargeMessage(connector, cd, utils.POSTPAID); notify != AUTH_OK || cc == nil || connector.debits != 1 {
+		t.Error("Message of account without balance not debited at the rated price: ", notify, cc)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const OSIPS_MI_TIMEOUT = 2 * time.Second
+
+// Dialog identifiers opensips needs to end a call
+type osipsDialog struct {
+	hEntry, hId string
+}
+
+// The opensips session manager, receives the dialog events as event_datagram
+// and controls the dialogs over the mi_datagram interface
+type OpenSIPSSessionManager struct {
+	evConn      *net.UDPConn
+	miAddr      *net.UDPAddr
+	miMux       sync.Mutex // one MI command at a time so the replies do not mix up
+	sessions    *SessionRegistry
+	dialogs     map[string]osipsDialog // session uuid to its dialog
+	dialogsMux  sync.RWMutex           // the debit loops disconnect sessions concurrently with the event handlers
+	connector   engine.Connector
+	debitPeriod time.Duration
+	loggerDB    engine.DataStorage
+}
+
+func NewOpenSIPSSessionManager(storage engine.DataStorage, connector engine.Connector, debitPeriod time.Duration) *OpenSIPSSessionManager {
+	return &OpenSIPSSessionManager{sessions: NewSessionRegistry(), loggerDB: storage, connector: connector, debitPeriod: debitPeriod, dialogs: make(map[string]osipsDialog)}
+}
+
+// Listens for the opensips event datagrams, subscribing to them if configured so
+func (sm *OpenSIPSSessionManager) Connect(cgrCfg *config.CGRConfig) (err error) {
+	cfg = cgrCfg // make config global
+	if sm.miAddr, err = net.ResolveUDPAddr("udp", cfg.OsipsMiAddr); err != nil {
+		return err
+	}
+	evAddr, err := net.ResolveUDPAddr("udp", cfg.OsipsListenUdp)
+	if err != nil {
+		return err
+	}
+	if sm.evConn, err = net.ListenUDP("udp", evAddr); err != nil {
+		return err
+	}
+	defer sm.evConn.Close()
+	if cfg.OsipsEvSubsInterval > 0 {
+		subsInterval, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.OsipsEvSubsInterval))
+		go sm.subscribeEvents(subsInterval)
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := sm.evConn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		sm.handleEvent(string(buf[:n]))
+	}
+}
+
+// Event subscriptions expire in opensips, renew them periodically
+func (sm *OpenSIPSSessionManager) subscribeEvents(interval time.Duration) {
+	expire := fmt.Sprintf("%d", int(2*interval.Seconds()))
+	for {
+		for _, evName := range []string{OSIPS_CALL_START, OSIPS_CALL_END} {
+			if _, err := sm.miCommand("event_subscribe", evName, "udp:"+sm.evConn.LocalAddr().String(), expire); err != nil {
+				engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not subscribe to OpenSIPS event %s: %v", evName, err))
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (sm *OpenSIPSSessionManager) handleEvent(body string) {
+	ev := new(OsipsEvent).New(body).(OsipsEvent)
+	switch ev.GetName() {
+	case OSIPS_CALL_START:
+		sm.OnCallStart(ev)
+	case OSIPS_CALL_END:
+		sm.OnCallEnd(ev)
+	default:
+		engine.Logger.Warning(fmt.Sprintf("<SessionManager> Unhandled OpenSIPS event: %s", body))
+	}
+}
+
+// Sends the command over mi_datagram (:command:\nparam\n...) and returns the reply body
+func (sm *OpenSIPSSessionManager) miCommand(cmd string, params ...string) (string, error) {
+	sm.miMux.Lock()
+	defer sm.miMux.Unlock()
+	conn, err := net.DialUDP("udp", nil, sm.miAddr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	req := fmt.Sprintf(":%s:\n", cmd)
+	for _, param := range params {
+		req += param + "\n"
+	}
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(OSIPS_MI_TIMEOUT))
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	reply := string(buf[:n])
+	if !strings.HasPrefix(reply, "200") {
+		return reply, errors.New(strings.TrimSpace(reply))
+	}
+	return reply, nil
+}
+
+// Returns the active sessions
+func (sm *OpenSIPSSessionManager) Sessions() []*Session {
+	return sm.sessions.All()
+}
+
+// Searches and return the session with the specifed uuid
+func (sm *OpenSIPSSessionManager) GetSession(uuid string) *Session {
+	return sm.sessions.Get(uuid)
+}
+
+// Ends the dialog of the session through the MI interface
+func (sm *OpenSIPSSessionManager) DisconnectSession(s *Session, notify string) {
+	sm.dialogsMux.RLock()
+	dlg, hasDlg := sm.dialogs[s.uuid]
+	sm.dialogsMux.RUnlock()
+	if !hasDlg {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> No OpenSIPS dialog for session %s", s.uuid))
+		return
+	}
+	sm.endDialog(dlg, notify)
+}
+
+func (sm *OpenSIPSSessionManager) endDialog(dlg osipsDialog, notify string) {
+	engine.Logger.Info(fmt.Sprintf("<SessionManager> Ending OpenSIPS dialog %s:%s, reason: %s", dlg.hEntry, dlg.hId, notify))
+	if _, err := sm.miCommand("dlg_end_dlg", dlg.hEntry, dlg.hId); err != nil {
+		engine.Logger.Err(fmt.Sprintf("could not send disconect msg to opensips: %v", err))
+	}
+}
+
+// OpenSIPS offers no in-dialog command for warnings, the session history keeps them
+func (sm *OpenSIPSSessionManager) WarnSession(s *Session, notify string) {
+	engine.Logger.Info(fmt.Sprintf("<SessionManager> Cannot warn OpenSIPS session %s (%s)", s.uuid, notify))
+}
+
+// Remove session from sessin list
+func (sm *OpenSIPSSessionManager) RemoveSession(s *Session) {
+	sm.dialogsMux.Lock()
+	delete(sm.dialogs, s.uuid)
+	sm.dialogsMux.Unlock()
+	sm.sessions.Remove(s)
+}
+
+// Dialogs are already established here so prepaid ones are authorized and ended right away if not allowed
+func (sm *OpenSIPSSessionManager) OnCallStart(ev OsipsEvent) {
+	engine.Logger.Info("<SessionManager> OpenSIPS call start.")
+	dlg := osipsDialog{ev[OSIPS_H_ENTRY], ev[OSIPS_H_ID]}
+	notify, prepaidCalls := sm.sessions.Reserve(ev.GetUUID(), ev.GetReqType(), ev.GetTenant(), ev.GetAccount(), cfg.SMMaxCallsAccount, cfg.SMMaxCallsTenant)
+	if notify != "" {
+		engine.Logger.Info(fmt.Sprintf("Concurrent calls limit reached, ending the call %s.", ev.GetUUID()))
+		sm.endDialog(dlg, notify)
+		return
+	}
+	if ev.GetReqType() == utils.PREPAID {
+		if notify := sm.authorize(ev, prepaidCalls); notify != AUTH_OK {
+			sm.sessions.Release(ev.GetUUID())
+			sm.endDialog(dlg, notify)
+			return
+		}
+	}
+	// known before creating the session so it can be disconnected right away
+	sm.dialogsMux.Lock()
+	sm.dialogs[ev.GetUUID()] = dlg
+	sm.dialogsMux.Unlock()
+	s := NewSession(ev, sm)
+	if s != nil {
+		sm.sessions.Add(s)
+	} else {
+		sm.sessions.Release(ev.GetUUID())
+		sm.dialogsMux.Lock()
+		delete(sm.dialogs, ev.GetUUID())
+		sm.dialogsMux.Unlock()
+	}
+}
+
+// Returns AUTH_OK if the account has credit for the call next to its prepaid calls, the reason to end it otherwise
+func (sm *OpenSIPSSessionManager) authorize(ev OsipsEvent, prepaidCalls int) string {
+	if ev.MissingParameter() {
+		engine.Logger.Err(fmt.Sprintf("Missing parameter for %s", ev.GetUUID()))
+		return MISSING_PARAMETER
+	}
+	startTime, err := ev.GetStartTime(START_TIME)
+	if err != nil {
+		engine.Logger.Err("Error parsing call start event answer time, using time.Now!")
+		startTime = time.Now()
+	}
+	cd := engine.CallDescriptor{
+		Direction:       ev.GetDirection(),
+		Tenant:          ev.GetTenant(),
+		TOR:             ev.GetTOR(),
+		Subject:         ev.GetSubject(),
+		Account:         ev.GetAccount(),
+		Destination:     ev.GetDestination(),
+		Amount:          sm.debitPeriod.Seconds(),
+		TimeStart:       startTime,
+		FallbackSubject: ev.GetFallbackSubj()}
+	var remainingSeconds float64
+	if err = sm.connector.GetMaxSessionTime(cd, &remainingSeconds); err != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not get max session time for %s: %v", ev.GetUUID(), err))
+		return SYSTEM_ERROR
+	}
+	remainingSeconds = splitSessionTime(remainingSeconds, prepaidCalls)
+	engine.Logger.Info(fmt.Sprintf("Remaining seconds: %v", remainingSeconds))
+	if remainingSeconds == 0 {
+		engine.Logger.Info(fmt.Sprintf("Not enough credit for the call %s for %s.", ev.GetUUID(), cd.GetKey()))
+		return INSUFFICIENT_FUNDS
+	}
+	return AUTH_OK
+}
+
+func (sm *OpenSIPSSessionManager) OnCallEnd(ev OsipsEvent) {
+	engine.Logger.Info("<SessionManager> OpenSIPS call end.")
+	s := sm.GetSession(ev.GetUUID())
+	if s == nil { // Not handled by us
+		return
+	}
+	defer s.Close(ev) // Stop loop and save the costs deducted so far to database
+	s.settle(sm.connector, ev)
+}
+
+func (sm *OpenSIPSSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor, index float64) {
+	debitLoopAction(sm, sm.connector, s, cd, index)
+}
+
+func (sm *OpenSIPSSessionManager) GetDebitPeriod() time.Duration {
+	return sm.debitPeriod
+}
+
+func (sm *OpenSIPSSessionManager) GetDbLogger() engine.DataStorage {
+	return sm.loggerDB
+}
+
+func (sm *OpenSIPSSessionManager) Shutdown() (err error) {
+	sessions := sm.sessions.All()
+	engine.Logger.Info("Shutting down all sessions...")
+	for _, s := range sessions {
+		sm.DisconnectSession(s, MANAGER_REQUEST)
+	}
+	for guard := 0; guard < 20; guard++ {
+		if sm.sessions.Len() == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond) // wait for the call end events
+	}
+	return
+}
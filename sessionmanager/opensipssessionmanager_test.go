@@ -0,0 +1,138 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Plays opensips' part: answers the MI commands and sends event datagrams
+type fakeOpenSIPS struct {
+	miConn *net.UDPConn
+	miCmds chan []string
+}
+
+func newFakeOpenSIPS(t *testing.T) *fakeOpenSIPS {
+	miConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal("Cannot start fake MI endpoint: ", err)
+	}
+	fo := &fakeOpenSIPS{miConn: miConn, miCmds: make(chan []string, 10)}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := miConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			fo.miCmds <- strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+			miConn.WriteToUDP([]byte("200 OK\n"), addr)
+		}
+	}()
+	return fo
+}
+
+func (fo *fakeOpenSIPS) receiveMiCmd(t *testing.T) []string {
+	select {
+	case cmd := <-fo.miCmds:
+		return cmd
+	case <-time.After(time.Second):
+		t.Fatal("No MI command received")
+	}
+	return nil
+}
+
+func (fo *fakeOpenSIPS) sendEvent(t *testing.T, evAddr string, ev string) {
+	conn, err := net.Dial("udp", evAddr)
+	if err != nil {
+		t.Fatal("Cannot send event datagram: ", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(ev))
+}
+
+func TestOsipsEventCreation(t *testing.T) {
+	cfg, _ = config.NewCGRConfigBytes([]byte(conf_data))
+	ev := new(OsipsEvent).New("E_CGR_CALL_END\ncallid::osips1\nh_entry::1\nh_id::2\ncgr_account::1001\ncgr_destination::1002\ncgr_answertime::1396985020\ncgr_duration::62\n\n")
+	if ev.GetName() != OSIPS_CALL_END || ev.GetUUID() != "osips1" || ev.GetSubject() != "1001" {
+		t.Error("Event not parsed correctly: ", ev)
+	}
+	if et, err := ev.GetEndTime(); err != nil || !et.Equal(time.Unix(1396985082, 0)) {
+		t.Error("Wrong end time: ", et, err)
+	}
+}
+
+func TestOpenSIPSSessionManager(t *testing.T) {
+	fo := newFakeOpenSIPS(t)
+	defer fo.miConn.Close()
+	osipsCfg, _ := config.NewCGRConfigBytes([]byte(fmt.Sprintf("[opensips]\nlisten_udp = 127.0.0.1:0\nmi_addr = %s\n", fo.miConn.LocalAddr())))
+	storage, _ := engine.NewMapStorage()
+	connector := &fakeConnector{maxSessionTime: 120}
+	sm := NewOpenSIPSSessionManager(storage, connector, 50*time.Millisecond)
+	go sm.Connect(osipsCfg)
+	var evAddr string
+	for i := 0; i < 2; i++ {
+		cmd := fo.receiveMiCmd(t)
+		if len(cmd) != 4 || cmd[0] != ":event_subscribe:" || !strings.HasPrefix(cmd[2], "udp:") || cmd[3] != "120" {
+			t.Fatal("Wrong event subscription: ", cmd)
+		}
+		evAddr = strings.TrimPrefix(cmd[2], "udp:")
+	}
+	callData := "callid::osips1\nh_entry::891\nh_id::2019\ncgr_reqtype::prepaid\ncgr_tenant::cgrates.org\ncgr_tor::call\ncgr_account::1001\ncgr_destination::1002\ncgr_answertime::1396985010\n"
+	fo.sendEvent(t, evAddr, "E_CGR_CALL_START\n"+callData)
+	if !waitFor(func() bool { return sm.GetSession("osips1") != nil && connector.getMaxDebits() > 0 }) {
+		t.Fatal("Session not started")
+	}
+	// one second left cannot be shared with the running call
+	connector.Lock()
+	connector.maxSessionTime = 1
+	connector.Unlock()
+	fo.sendEvent(t, evAddr, "E_CGR_CALL_START\n"+strings.Replace(callData, "osips1", "osips3", 1))
+	if cmd := fo.receiveMiCmd(t); len(cmd) != 3 || cmd[0] != ":dlg_end_dlg:" {
+		t.Error("Dialog not ended for the credit shared with the running call: ", cmd)
+	}
+	if sm.GetSession("osips3") != nil {
+		t.Error("Session started on the credit of the running call")
+	}
+	sm.DisconnectSession(sm.GetSession("osips1"), INSUFFICIENT_FUNDS)
+	if cmd := fo.receiveMiCmd(t); len(cmd) != 3 || cmd[0] != ":dlg_end_dlg:" || cmd[1] != "891" || cmd[2] != "2019" {
+		t.Error("Wrong dialog end command: ", cmd)
+	}
+	fo.sendEvent(t, evAddr, "E_CGR_CALL_END\n"+callData+"cgr_duration::5\n")
+	if !waitFor(func() bool { return sm.GetSession("osips1") == nil }) {
+		t.Error("Session not closed on call end")
+	}
+	// no more credit, dialog ended at start
+	connector.Lock()
+	connector.maxSessionTime = 0
+	connector.Unlock()
+	fo.sendEvent(t, evAddr, "E_CGR_CALL_START\n"+strings.Replace(callData, "osips1", "osips2", 1))
+	if cmd := fo.receiveMiCmd(t); len(cmd) != 3 || cmd[0] != ":dlg_end_dlg:" {
+		t.Error("Dialog not ended for insufficient funds: ", cmd)
+	}
+	if sm.GetSession("osips2") != nil {
+		t.Error("Session started without credit")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"github.com/cgrates/cgrates/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event received as opensips event_datagram: the event name on the first line followed by name::value lines
+type OsipsEvent map[string]string
+
+const (
+	// opensips event proprieties names, set by the script raising the event
+	OSIPS_EVENT_NAME  = "event_name" // not sent as parameter but taken out of the first line
+	OSIPS_H_ENTRY     = "h_entry"    // dialog identifiers, used to end the dialog over MI
+	OSIPS_H_ID        = "h_id"
+	OSIPS_CALLID      = "callid"
+	OSIPS_REQTYPE     = "cgr_reqtype"
+	OSIPS_TENANT      = "cgr_tenant"
+	OSIPS_TOR         = "cgr_tor"
+	OSIPS_ACCOUNT     = "cgr_account"
+	OSIPS_SUBJECT     = "cgr_subject"
+	OSIPS_DESTINATION = "cgr_destination"
+	OSIPS_SETUP_TIME  = "cgr_setuptime"  // unix timestamp of the initial INVITE
+	OSIPS_ANSWER_TIME = "cgr_answertime" // unix timestamp of the dialog start
+	OSIPS_DURATION    = "cgr_duration"   // dialog duration in seconds
+	// opensips event names
+	OSIPS_CALL_START = "E_CGR_CALL_START"
+	OSIPS_CALL_END   = "E_CGR_CALL_END"
+)
+
+// Session works with the FreeSWITCH time field names, translate them to ours
+var osipsTimeFields = map[string]string{PARK_TIME: OSIPS_SETUP_TIME, START_TIME: OSIPS_ANSWER_TIME}
+
+func (osev OsipsEvent) New(body string) Event {
+	osev = make(OsipsEvent)
+	for idx, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		line = strings.TrimSpace(line)
+		if idx == 0 {
+			osev[OSIPS_EVENT_NAME] = line
+			continue
+		}
+		if sepIdx := strings.Index(line, "::"); sepIdx != -1 {
+			osev[line[:sepIdx]] = line[sepIdx+2:]
+		}
+	}
+	return osev
+}
+
+func (osev OsipsEvent) GetName() string {
+	return osev[OSIPS_EVENT_NAME]
+}
+func (osev OsipsEvent) GetDirection() string {
+	return "*out"
+}
+func (osev OsipsEvent) GetOrigId() string {
+	return osev[OSIPS_CALLID]
+}
+func (osev OsipsEvent) GetSubject() string {
+	return utils.FirstNonEmpty(osev[OSIPS_SUBJECT], osev[OSIPS_ACCOUNT])
+}
+func (osev OsipsEvent) GetAccount() string {
+	return osev[OSIPS_ACCOUNT]
+}
+func (osev OsipsEvent) GetDestination() string {
+	return osev[OSIPS_DESTINATION]
+}
+func (osev OsipsEvent) GetCallDestNr() string {
+	return osev[OSIPS_DESTINATION]
+}
+func (osev OsipsEvent) GetTOR() string {
+	return utils.FirstNonEmpty(osev[OSIPS_TOR], cfg.DefaultTOR)
+}
+func (osev OsipsEvent) GetUUID() string {
+	return osev[OSIPS_CALLID]
+}
+func (osev OsipsEvent) GetTenant() string {
+	return utils.FirstNonEmpty(osev[OSIPS_TENANT], cfg.DefaultTenant)
+}
+func (osev OsipsEvent) GetReqType() string {
+	return utils.FirstNonEmpty(osev[OSIPS_REQTYPE], cfg.DefaultReqType)
+}
+func (osev OsipsEvent) MissingParameter() bool {
+	return strings.TrimSpace(osev.GetAccount()) == "" ||
+		strings.TrimSpace(osev.GetDestination()) == "" ||
+		strings.TrimSpace(osev.GetTOR()) == "" ||
+		strings.TrimSpace(osev.GetUUID()) == "" ||
+		strings.TrimSpace(osev.GetTenant()) == "" ||
+		strings.TrimSpace(osev[OSIPS_H_ENTRY]) == "" ||
+		strings.TrimSpace(osev[OSIPS_H_ID]) == ""
+}
+func (osev OsipsEvent) GetFallbackSubj() string {
+	return cfg.DefaultSubject
+}
+func (osev OsipsEvent) GetStartTime(field string) (t time.Time, err error) {
+	if osipsFld, hasIt := osipsTimeFields[field]; hasIt {
+		field = osipsFld
+	}
+	st, err := strconv.ParseInt(osev[field], 0, 64)
+	t = time.Unix(st, 0)
+	return
+}
+
+func (osev OsipsEvent) GetEndTime() (t time.Time, err error) {
+	if t, err = osev.GetStartTime(OSIPS_ANSWER_TIME); err != nil {
+		return
+	}
+	dur, err := strconv.ParseInt(osev[OSIPS_DURATION], 0, 64)
+	t = t.Add(time.Duration(dur) * time.Second)
+	return
+}
@@ -0,0 +1,187 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"sync"
+	"time"
+)
+
+// Time a call authorized but not answered keeps its place in the calls limits, unanswered calls
+// not being reported by all the switches
+const RESERVATION_TTL = 3 * time.Minute
+
+// Call authorized but not answered yet, the session holding only the fields checked by the calls limits
+type reservation struct {
+	session *Session
+	expires time.Time
+}
+
+// Active sessions of a session manager, safe for concurrent use and indexed
+// on uuid, account and destination
+type SessionRegistry struct {
+	sync.RWMutex
+	sessions     map[string]*Session            // indexed on uuid
+	accounts     map[string]map[string]*Session // tenant:account -> uuid -> session
+	destinations map[string]map[string]*Session // destination -> uuid -> session
+	reserved     map[string]*reservation        // uuid -> call authorized but not answered yet
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string]*Session),
+		accounts:     make(map[string]map[string]*Session),
+		destinations: make(map[string]map[string]*Session),
+		reserved:     make(map[string]*reservation)}
+}
+
+func accountIndexKey(tenant, account string) string {
+	return tenant + ":" + account
+}
+
+func addToIndex(index map[string]map[string]*Session, key string, s *Session) {
+	if _, exists := index[key]; !exists {
+		index[key] = make(map[string]*Session)
+	}
+	index[key][s.uuid] = s
+}
+
+func removeFromIndex(index map[string]map[string]*Session, key string, s *Session) {
+	if idx, exists := index[key]; exists {
+		delete(idx, s.uuid)
+		if len(idx) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+func indexValues(idx map[string]*Session) []*Session {
+	sessions := make([]*Session, 0, len(idx))
+	for _, s := range idx {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Adds the session, replacing the one with the same uuid if present
+func (sr *SessionRegistry) Add(s *Session) {
+	sr.Lock()
+	defer sr.Unlock()
+	if old, exists := sr.sessions[s.uuid]; exists {
+		sr.remove(old)
+	}
+	delete(sr.reserved, s.uuid) // answered, counted as session from now on
+	sr.sessions[s.uuid] = s
+	addToIndex(sr.accounts, accountIndexKey(s.callDescriptor.Tenant, s.callDescriptor.Account), s)
+	addToIndex(sr.destinations, s.callDescriptor.Destination, s)
+}
+
+func (sr *SessionRegistry) remove(s *Session) {
+	delete(sr.sessions, s.uuid)
+	removeFromIndex(sr.accounts, accountIndexKey(s.callDescriptor.Tenant, s.callDescriptor.Account), s)
+	removeFromIndex(sr.destinations, s.callDescriptor.Destination, s)
+}
+
+// Removes the session, returning false if it was not registered
+func (sr *SessionRegistry) Remove(s *Session) bool {
+	sr.Lock()
+	defer sr.Unlock()
+	if registered, exists := sr.sessions[s.uuid]; !exists || registered != s {
+		return false
+	}
+	sr.remove(s)
+	return true
+}
+
+// Returns the session with the uuid, nil if not registered
+func (sr *SessionRegistry) Get(uuid string) *Session {
+	sr.RLock()
+	defer sr.RUnlock()
+	return sr.sessions[uuid]
+}
+
+// Returns all the sessions, in no particular order
+func (sr *SessionRegistry) All() []*Session {
+	sr.RLock()
+	defer sr.RUnlock()
+	return indexValues(sr.sessions)
+}
+
+func (sr *SessionRegistry) Len() int {
+	sr.RLock()
+	defer sr.RUnlock()
+	return len(sr.sessions)
+}
+
+// Returns the sessions of the account
+func (sr *SessionRegistry) ByAccount(tenant, account string) []*Session {
+	sr.RLock()
+	defer sr.RUnlock()
+	return indexValues(sr.accounts[accountIndexKey(tenant, account)])
+}
+
+// Returns the number of prepaid sessions debiting the account's balance, leaving out the except one
+func (sr *SessionRegistry) PrepaidCalls(tenant, account string, except *Session) (prepaidCalls int) {
+	for _, s := range sr.ByAccount(tenant, account) {
+		if s != except && s.reqType == utils.PREPAID {
+			prepaidCalls++
+		}
+	}
+	return
+}
+
+// Returns the sessions towards the destination
+func (sr *SessionRegistry) ByDestination(destination string) []*Session {
+	sr.RLock()
+	defer sr.RUnlock()
+	return indexValues(sr.destinations[destination])
+}
+
+// Checks the concurrent calls limits for a new call counting the sessions together with the calls authorized but
+// not answered yet, and reserves the call's place if allowed. Returns the rejection notify (empty if allowed) and the
+// number of prepaid calls already running on the account's balance. The reservation ends when the call's session is
+// added, on Release or after RESERVATION_TTL.
+func (sr *SessionRegistry) Reserve(uuid, reqType, tenant, account string, maxAccount, maxTenant int) (notify string, prepaidCalls int) {
+	sr.Lock()
+	defer sr.Unlock()
+	delete(sr.reserved, uuid) // authorized again
+	now := time.Now()
+	calls := indexValues(sr.sessions)
+	for rUuid, r := range sr.reserved {
+		if now.After(r.expires) {
+			delete(sr.reserved, rUuid)
+			continue
+		}
+		calls = append(calls, r.session)
+	}
+	if notify, prepaidCalls = checkCallLimits(calls, tenant, account, maxAccount, maxTenant); notify != "" {
+		return
+	}
+	sr.reserved[uuid] = &reservation{session: &Session{uuid: uuid, reqType: reqType,
+		callDescriptor: &engine.CallDescriptor{Tenant: tenant, Account: account}}, expires: now.Add(RESERVATION_TTL)}
+	return
+}
+
+// Frees the place reserved for the call, if any
+func (sr *SessionRegistry) Release(uuid string) {
+	sr.Lock()
+	defer sr.Unlock()
+	delete(sr.reserved, uuid)
+}
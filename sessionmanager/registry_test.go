@@ -0,0 +1,134 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"fmt"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRegistryTestSession(uuid, account, destination string) *Session {
+	return &Session{uuid: uuid, callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: account, Destination: destination}}
+}
+
+func TestSessionRegistryIndexes(t *testing.T) {
+	sr := NewSessionRegistry()
+	s1 := newRegistryTestSession("1", "1001", "1002")
+	s2 := newRegistryTestSession("2", "1001", "1003")
+	s3 := newRegistryTestSession("3", "1002", "1003")
+	for _, s := range []*Session{s1, s2, s3} {
+		sr.Add(s)
+	}
+	if sr.Len() != 3 || len(sr.All()) != 3 {
+		t.Error("Unexpected number of sessions: ", sr.Len())
+	}
+	if s := sr.Get("2"); s != s2 {
+		t.Error("Wrong session by uuid: ", s)
+	}
+	if sr.Get("4") != nil {
+		t.Error("Unexpected session for unknown uuid")
+	}
+	if ss := sr.ByAccount("cgrates.org", "1001"); len(ss) != 2 {
+		t.Error("Wrong sessions by account: ", ss)
+	}
+	if ss := sr.ByAccount("itsyscom.com", "1001"); len(ss) != 0 {
+		t.Error("Sessions of another tenant returned: ", ss)
+	}
+	if ss := sr.ByDestination("1003"); len(ss) != 2 {
+		t.Error("Wrong sessions by destination: ", ss)
+	}
+	if !sr.Remove(s2) || sr.Remove(s2) {
+		t.Error("Unexpected remove result")
+	}
+	if ss := sr.ByAccount("cgrates.org", "1001"); len(ss) != 1 || ss[0] != s1 {
+		t.Error("Account index not updated on remove: ", ss)
+	}
+	if ss := sr.ByDestination("1003"); len(ss) != 1 || ss[0] != s3 {
+		t.Error("Destination index not updated on remove: ", ss)
+	}
+	// same uuid replaces the old session in all indexes
+	s4 := newRegistryTestSession("3", "1004", "1005")
+	sr.Add(s4)
+	if sr.Len() != 2 || sr.Get("3") != s4 || len(sr.ByAccount("cgrates.org", "1002")) != 0 || len(sr.ByDestination("1003")) != 0 {
+		t.Error("Session not replaced")
+	}
+	if sr.Remove(s3) {
+		t.Error("Removed a session no longer registered")
+	}
+}
+
+func TestSessionRegistryConcurrency(t *testing.T) {
+	sr := NewSessionRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := newRegistryTestSession(fmt.Sprintf("%d_%d", i, j), fmt.Sprintf("10%02d", i), "1002")
+				sr.Add(s)
+				sr.Get(s.uuid)
+				sr.ByAccount("cgrates.org", s.callDescriptor.Account)
+				sr.ByDestination("1002")
+				if j%2 == 0 {
+					sr.Remove(s)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if sr.Len() != 500 || len(sr.ByDestination("1002")) != 500 || len(sr.ByAccount("cgrates.org", "1001")) != 50 {
+		t.Error("Unexpected sessions after concurrent access: ", sr.Len())
+	}
+}
+
+func TestSessionRegistryReservations(t *testing.T) {
+	sr := NewSessionRegistry()
+	sr.Add(newRegistryTestSession("1", "1001", "1002"))
+	if notify, _ := sr.Reserve("2", utils.PREPAID, "cgrates.org", "1001", 2, 0); notify != "" {
+		t.Error("Call under the limit rejected: ", notify)
+	}
+	// the authorized call not answered yet keeps its place
+	if notify, _ := sr.Reserve("3", utils.PREPAID, "cgrates.org", "1001", 2, 0); notify != MAX_CALLS {
+		t.Error("Reserved call not counted: ", notify)
+	}
+	// authorizing the same call again does not count it twice
+	if notify, prepaidCalls := sr.Reserve("2", utils.PREPAID, "cgrates.org", "1001", 2, 0); notify != "" || prepaidCalls != 0 {
+		t.Error("Reservation counted against its own call: ", notify, prepaidCalls)
+	}
+	// answered, the session takes the reservation's place
+	sr.Add(newRegistryTestSession("2", "1001", "1002"))
+	if len(sr.reserved) != 0 {
+		t.Error("Reservation not ended on answer: ", sr.reserved)
+	}
+	sr.Remove(sr.Get("2"))
+	sr.Reserve("4", utils.POSTPAID, "cgrates.org", "1001", 2, 0)
+	sr.Release("4")
+	if notify, _ := sr.Reserve("5", utils.POSTPAID, "cgrates.org", "1001", 2, 0); notify != "" {
+		t.Error("Released reservation still counted: ", notify)
+	}
+	sr.reserved["5"].expires = time.Now().Add(-time.Second)
+	if notify, _ := sr.Reserve("6", utils.POSTPAID, "cgrates.org", "1001", 2, 0); notify != "" || sr.reserved["5"] != nil {
+		t.Error("Expired reservation still counted: ", notify)
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"github.com/cgrates/cgrates/engine"
 	"github.com/cgrates/cgrates/utils"
+	"math"
+	"sync"
 	"time"
 )
 
@@ -29,10 +31,64 @@ import (
 // actions and a channel to signal end of the debit loop.
 type Session struct {
 	uuid           string
+	reqType        string
+	node           string // switch handling the call, for managers connected to more than one
 	callDescriptor *engine.CallDescriptor
 	sessionManager SessionManager
 	stopDebit      chan bool
 	CallCosts      []*engine.CallCost
+	costsMux       sync.RWMutex // the debit loop adds costs while the api reads them
+	debitMux       sync.Mutex   // one debit at a time on sessions debited over the api
+	history        []*SessionHistoryEvent
+	historyMux     sync.RWMutex
+}
+
+// Notable event in the life of a session, eg: the low balance warning
+type SessionHistoryEvent struct {
+	Time  time.Time
+	Event string
+	Info  string
+}
+
+func (s *Session) addCallCost(cc *engine.CallCost) {
+	s.costsMux.Lock()
+	defer s.costsMux.Unlock()
+	s.CallCosts = append(s.CallCosts, cc)
+}
+
+// Returns a copy of the costs list, safe to iterate while debiting goes on
+func (s *Session) callCosts() []*engine.CallCost {
+	s.costsMux.RLock()
+	defer s.costsMux.RUnlock()
+	ccs := make([]*engine.CallCost, len(s.CallCosts))
+	copy(ccs, s.CallCosts)
+	return ccs
+}
+
+func (s *Session) addHistory(event, info string) {
+	s.historyMux.Lock()
+	defer s.historyMux.Unlock()
+	s.history = append(s.history, &SessionHistoryEvent{Time: time.Now(), Event: event, Info: info})
+}
+
+// Returns a copy of the session history
+func (s *Session) History() []*SessionHistoryEvent {
+	s.historyMux.RLock()
+	defer s.historyMux.RUnlock()
+	history := make([]*SessionHistoryEvent, len(s.history))
+	copy(history, s.history)
+	return history
+}
+
+func (s *Session) hasHistoryEvent(event string) bool {
+	s.historyMux.RLock()
+	defer s.historyMux.RUnlock()
+	for _, he := range s.history {
+		if he.Event == event {
+			return true
+		}
+	}
+	return false
 }
 
 // Creates a new session and starts the debit loop
@@ -56,6 +112,7 @@ func NewSession(ev Event, sm SessionManager) (s *Session) {
 		Destination: ev.GetDestination(),
 		TimeStart:   startTime}
 	s = &Session{uuid: ev.GetUUID(),
+		reqType:        ev.GetReqType(),
 		callDescriptor: cd,
 		stopDebit:      make(chan bool, 2)} //buffer it for multiple close signals
 	s.sessionManager = sm
@@ -74,8 +131,25 @@ func NewSession(ev Event, sm SessionManager) (s *Session) {
 
 // the debit loop method (to be stoped by sending somenthing on stopDebit channel)
 func (s *Session) startDebitLoop() {
+	s.debitLoop(*s.callDescriptor, 0, time.Time{})
+}
+
+// Continues the debit loop of a recovered session from the end of the last debit,
+// catching up without sleeping on the periods passed till now
+func (s *Session) resumeDebitLoop() {
 	nextCd := *s.callDescriptor
-	index := 0.0
+	ccs := s.callCosts()
+	if len(ccs) != 0 {
+		lastCC := ccs[len(ccs)-1]
+		if len(lastCC.Timespans) != 0 {
+			nextCd.TimeEnd = lastCC.Timespans[len(lastCC.Timespans)-1].TimeEnd
+			nextCd.CallDuration = nextCd.TimeEnd.Sub(nextCd.TimeStart)
+		}
+	}
+	s.debitLoop(nextCd, float64(len(ccs)), time.Now())
+}
+
+func (s *Session) debitLoop(nextCd engine.CallDescriptor, index float64, catchUpTill time.Time) {
 	for {
 		select {
 		case <-s.stopDebit:
@@ -86,8 +160,14 @@ func (s *Session) startDebitLoop() {
 			nextCd.TimeStart = nextCd.TimeEnd
 		}
 		nextCd.TimeEnd = nextCd.TimeStart.Add(s.sessionManager.GetDebitPeriod())
+		debited := len(s.callCosts())
 		s.sessionManager.LoopAction(s, &nextCd, index)
-		time.Sleep(s.sessionManager.GetDebitPeriod())
+		if len(s.callCosts()) == debited { // nothing granted, no reason to hurry anymore
+			catchUpTill = time.Time{}
+		}
+		if !nextCd.TimeEnd.Before(catchUpTill) {
+			time.Sleep(s.sessionManager.GetDebitPeriod())
+		}
 		index++
 	}
 }
@@ -127,11 +207,15 @@ func (s *Session) String() string {
 //
 func (s *Session) SaveOperations() {
 	go func() {
-		if s == nil || len(s.CallCosts) == 0 {
+		if s == nil {
 			return
 		}
-		firstCC := s.CallCosts[0]
-		for _, cc := range s.CallCosts[1:] {
+		ccs := s.callCosts()
+		if len(ccs) == 0 {
+			return
+		}
+		firstCC := ccs[0]
+		for _, cc := range ccs[1:] {
 			firstCC.Merge(cc)
 		}
 		if s.sessionManager.GetDbLogger() == nil {
@@ -141,3 +225,216 @@ func (s *Session) SaveOperations() {
 		engine.Logger.Debug(fmt.Sprintf("<SessionManager> End of call, having costs: %v", firstCC.String()))
 	}()
 }
+
+// Makes the single debit of postpaid calls and refunds prepaid ones for the unused part of the last debit
+func (s *Session) settle(connector engine.Connector, ev Event) {
+	if ev.GetReqType() == utils.POSTPAID {
+		startTime, err := ev.GetStartTime(START_TIME)
+		if err != nil {
+			engine.Logger.Crit("Error parsing postpaid call start time from event")
+			return
+		}
+		endTime, err := ev.GetEndTime()
+		if err != nil {
+			engine.Logger.Crit("Error parsing postpaid call start time from event")
+			return
+		}
+		cd := engine.CallDescriptor{
+			Direction:       ev.GetDirection(),
+			Tenant:          ev.GetTenant(),
+			TOR:             ev.GetTOR(),
+			Subject:         ev.GetSubject(),
+			Account:         ev.GetAccount(),
+			LoopIndex:       0,
+			CallDuration:    endTime.Sub(startTime),
+			Destination:     ev.GetDestination(),
+			TimeStart:       startTime,
+			TimeEnd:         endTime,
+			FallbackSubject: ev.GetFallbackSubj(),
+		}
+		cc := &engine.CallCost{}
+		err = connector.Debit(cd, cc)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("Error making the general debit for postpaid call: %v", ev.GetUUID()))
+			return
+		}
+		s.addCallCost(cc)
+		return
+	}
+
+	if s == nil {
+		return
+	}
+	s.costsMux.Lock() // the refund changes the last cost in place
+	defer s.costsMux.Unlock()
+	if len(s.CallCosts) == 0 {
+		return // why would we have 0 callcosts
+	}
+	lastCC := s.CallCosts[len(s.CallCosts)-1]
+	// put credit back
+	var hangupTime time.Time
+	var err error
+	if hangupTime, err = ev.GetEndTime(); err != nil {
+		engine.Logger.Err("Error parsing answer event hangup time, using time.Now!")
+		hangupTime = time.Now()
+	}
+	end := lastCC.Timespans[len(lastCC.Timespans)-1].TimeEnd
+	refoundDuration := end.Sub(hangupTime).Seconds()
+	cost := 0.0
+	seconds := 0.0
+	engine.Logger.Info(fmt.Sprintf("Refund duration: %v", refoundDuration))
+	for i := len(lastCC.Timespans) - 1; i >= 0; i-- {
+		ts := lastCC.Timespans[i]
+		tsDuration := ts.GetDuration().Seconds()
+		if refoundDuration <= tsDuration {
+			// find procentage
+			procentage := (refoundDuration * 100) / tsDuration
+			tmpCost := (procentage * ts.Cost) / 100
+			ts.Cost -= tmpCost
+			cost += tmpCost
+			if ts.MinuteInfo != nil {
+				// DestinationPrefix and Price take from lastCC and above caclulus
+				seconds += (procentage * ts.MinuteInfo.Quantity) / 100
+			}
+			// set the end time to now
+			ts.TimeEnd = hangupTime
+			break // do not go to other timespans
+		} else {
+			cost += ts.Cost
+			if ts.MinuteInfo != nil {
+				seconds += ts.MinuteInfo.Quantity
+			}
+			// remove the timestamp entirely
+			lastCC.Timespans = lastCC.Timespans[:i]
+			// continue to the next timespan with what is left to refound
+			refoundDuration -= tsDuration
+		}
+	}
+	if cost > 0 {
+		cd := &engine.CallDescriptor{
+			Direction:   lastCC.Direction,
+			Tenant:      lastCC.Tenant,
+			TOR:         lastCC.TOR,
+			Subject:     lastCC.Subject,
+			Account:     lastCC.Account,
+			Destination: lastCC.Destination,
+			Amount:      -cost,
+			// FallbackSubject: lastCC.FallbackSubject, // ToDo: check how to best add it
+		}
+		var response float64
+		err := connector.DebitCents(*cd, &response)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("Debit cents failed: %v", err))
+		}
+	}
+	if seconds > 0 {
+		cd := &engine.CallDescriptor{
+			Direction:   lastCC.Direction,
+			TOR:         lastCC.TOR,
+			Tenant:      lastCC.Tenant,
+			Subject:     lastCC.Subject,
+			Account:     lastCC.Account,
+			Destination: lastCC.Destination,
+			Amount:      -seconds,
+			// FallbackSubject: lastCC.FallbackSubject, // ToDo: check how to best add it
+		}
+		var response float64
+		err := connector.DebitSeconds(*cd, &response)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("Debit seconds failed: %v", err))
+		}
+	}
+	lastCC.Cost -= cost
+	engine.Logger.Info(fmt.Sprintf("Rambursed %v cents, %v seconds", cost, seconds))
+
+}
+
+// Event ending the session at the specified time, used to close it when the switch does not report the hangup
+func (s *Session) endEvent(end time.Time) Event {
+	cd := s.callDescriptor
+	if end.Before(cd.TimeStart) {
+		end = cd.TimeStart
+	}
+	return sessionEventV1{SessionId: s.uuid, ReqType: s.reqType, Direction: cd.Direction, Tenant: cd.Tenant, TOR: cd.TOR,
+		Account: cd.Account, Subject: cd.Subject, Destination: cd.Destination, AnswerTime: cd.TimeStart, Usage: engine.DurationUsage(cd.TOR, end.Sub(cd.TimeStart))}
+}
+
+// Checks the concurrent calls limits for a new session of the account, returning the rejection notify
+// (empty if allowed) and the number of prepaid sessions already running on the account's balance
+func checkCallLimits(sessions []*Session, tenant, account string, maxAccount, maxTenant int) (notify string, prepaidCalls int) {
+	accountCalls, tenantCalls := 0, 0
+	for _, s := range sessions {
+		if s.callDescriptor.Tenant != tenant {
+			continue
+		}
+		tenantCalls++
+		if s.callDescriptor.Account == account {
+			accountCalls++
+			if s.reqType == utils.PREPAID {
+				prepaidCalls++
+			}
+		}
+	}
+	if (maxAccount > 0 && accountCalls >= maxAccount) || (maxTenant > 0 && tenantCalls >= maxTenant) {
+		notify = MAX_CALLS
+	}
+	return
+}
+
+// Shares the remaining session time between the new session and the ones already debiting the same balance
+func splitSessionTime(remainingSeconds float64, prepaidCalls int) float64 {
+	if remainingSeconds <= 0 { // nothing left or unlimited
+		return remainingSeconds
+	}
+	return math.Floor(remainingSeconds / float64(prepaidCalls+1))
+}
+
+// One iteration of the debit loop, shared by the session managers. Returns the granted cost, nil if the debit failed.
+func debitLoopAction(sm SessionManager, connector engine.Connector, s *Session, cd *engine.CallDescriptor, index float64) *engine.CallCost {
+	cc := &engine.CallCost{}
+	cd.LoopIndex = index
+	cd.Amount = sm.GetDebitPeriod().Seconds()
+	cd.CallDuration += time.Duration(cd.Amount) * time.Second
+	err := connector.MaxDebit(*cd, cc)
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not complete debit opperation: %v", err))
+		// disconnect session
+		s.sessionManager.DisconnectSession(s, SYSTEM_ERROR)
+	}
+	nbts := len(cc.Timespans)
+	remainingSeconds := 0.0
+	engine.Logger.Debug(fmt.Sprintf("Result of MaxDebit call: %v", cc))
+	if nbts > 0 {
+		remainingSeconds = cc.Timespans[nbts-1].TimeEnd.Sub(cc.Timespans[0].TimeStart).Seconds()
+	}
+	if remainingSeconds == 0 || err != nil {
+		engine.Logger.Info(fmt.Sprintf("No credit left: Disconnect %v", s))
+		sm.DisconnectSession(s, INSUFFICIENT_FUNDS)
+		return nil
+	}
+	s.addCallCost(cc)
+	warnLowBalance(sm, connector, s, cd, float64(cfg.SMLowBalanceThreshold))
+	return cc
+}
+
+// Warns the session once when the balance left after the current debit covers less than threshold seconds
+func warnLowBalance(sm SessionManager, connector engine.Connector, s *Session, cd *engine.CallDescriptor, threshold float64) {
+	if threshold <= 0 || s.hasHistoryEvent(LOW_BALANCE) {
+		return
+	}
+	nextCd := *cd
+	nextCd.TimeStart = cd.TimeEnd
+	nextCd.TimeEnd = time.Time{}
+	nextCd.Amount = threshold
+	var remainingSeconds float64
+	if err := connector.GetMaxSessionTime(nextCd, &remainingSeconds); err != nil {
+		engine.Logger.Err(fmt.Sprintf("Could not get max session time for %s: %v", s.uuid, err))
+		return
+	}
+	if remainingSeconds < 0 || remainingSeconds >= threshold { // postpaid accounts return -1
+		return
+	}
+	engine.Logger.Info(fmt.Sprintf("<SessionManager> Low balance for session %s, %v seconds left", s.uuid, remainingSeconds))
+	s.addHistory(LOW_BALANCE, fmt.Sprintf("%v seconds left", remainingSeconds))
+	sm.WarnSession(s, LOW_BALANCE)
+}
@@ -20,7 +20,10 @@ package sessionmanager
 
 import (
 	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
 	"testing"
+	"time"
 )
 
 var (
@@ -82,3 +85,69 @@ func TestSessionNilSession(t *testing.T) {
 		t.Error("no account and it still created session.")
 	}
 }
+
+func TestSessionCheckCallLimits(t *testing.T) {
+	sessions := []*Session{
+		&Session{uuid: "1", reqType: utils.PREPAID, callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: "1001"}},
+		&Session{uuid: "2", reqType: utils.POSTPAID, callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: "1001"}},
+		&Session{uuid: "3", reqType: utils.PREPAID, callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: "1002"}},
+		&Session{uuid: "4", reqType: utils.PREPAID, callDescriptor: &engine.CallDescriptor{Tenant: "itsyscom.com", Account: "1001"}},
+	}
+	if notify, prepaidCalls := checkCallLimits(sessions, "cgrates.org", "1001", 0, 0); notify != "" || prepaidCalls != 1 {
+		t.Error("Unexpected limits result: ", notify, prepaidCalls)
+	}
+	if notify, _ := checkCallLimits(sessions, "cgrates.org", "1001", 2, 0); notify != MAX_CALLS {
+		t.Error("Account limit not enforced: ", notify)
+	}
+	if notify, _ := checkCallLimits(sessions, "cgrates.org", "1002", 2, 0); notify != "" {
+		t.Error("Account limit enforced on the wrong account: ", notify)
+	}
+	if notify, _ := checkCallLimits(sessions, "cgrates.org", "1003", 0, 3); notify != MAX_CALLS {
+		t.Error("Tenant limit not enforced: ", notify)
+	}
+	if notify, _ := checkCallLimits(sessions, "itsyscom.com", "1002", 0, 3); notify != "" {
+		t.Error("Tenant limit enforced on the wrong tenant: ", notify)
+	}
+}
+
+func TestSessionSplitSessionTime(t *testing.T) {
+	if secs := splitSessionTime(100, 0); secs != 100 {
+		t.Error("Wrong time for a single session: ", secs)
+	}
+	if secs := splitSessionTime(100, 2); secs != 33 {
+		t.Error("Wrong shared time: ", secs)
+	}
+	if secs := splitSessionTime(-1, 2); secs != -1 {
+		t.Error("Unlimited time should not be split: ", secs)
+	}
+}
+
+func TestSessionWarnLowBalance(t *testing.T) {
+	fsm := &fakeSessionManager{disconnected: make(map[string]string)}
+	connector := &fakeConnector{maxSessionTime: 60}
+	s := &Session{uuid: "uuid1", reqType: utils.PREPAID, sessionManager: fsm}
+	cd := &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: "cgrates.org", Account: "1001", Destination: "1002",
+		TimeStart: time.Now(), TimeEnd: time.Now().Add(10 * time.Second)}
+	warnLowBalance(fsm, connector, s, cd, 30)
+	if len(fsm.warned) != 0 || len(s.History()) != 0 {
+		t.Error("Warned with enough balance: ", fsm.warned)
+	}
+	connector.maxSessionTime = -1 // postpaid account
+	warnLowBalance(fsm, connector, s, cd, 30)
+	if len(fsm.warned) != 0 {
+		t.Error("Warned postpaid account: ", fsm.warned)
+	}
+	connector.maxSessionTime = 20
+	warnLowBalance(fsm, connector, s, cd, 30)
+	warnLowBalance(fsm, connector, s, cd, 30)
+	if len(fsm.warned) != 1 || fsm.warned[0] != "uuid1" {
+		t.Error("Session not warned once: ", fsm.warned)
+	}
+	if history := s.History(); len(history) != 1 || history[0].Event != LOW_BALANCE || history[0].Info != "20 seconds left" {
+		t.Errorf("Warning not in session history: %+v", history)
+	}
+	warnLowBalance(fsm, connector, &Session{uuid: "uuid2"}, cd, 0)
+	if len(fsm.warned) != 1 {
+		t.Error("Warned with threshold disabled: ", fsm.warned)
+	}
+}
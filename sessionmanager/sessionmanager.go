@@ -27,7 +27,9 @@ import (
 type SessionManager interface {
 	Connect(*config.CGRConfig) error
 	DisconnectSession(*Session, string)
+	WarnSession(*Session, string)
 	RemoveSession(*Session)
+	Sessions() []*Session
 	LoopAction(*Session, *engine.CallDescriptor, float64)
 	GetDebitPeriod() time.Duration
 	GetDbLogger() engine.DataStorage
@@ -0,0 +1,316 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session data received over the SessionManagerV1 api
+type AttrSession struct {
+	SessionId   string // returned by InitiateSession, mandatory on UpdateSession and TerminateSession
+	ReqType     string // <prepaid|postpaid>
+	Direction   string
+	Tenant      string
+	TOR         string
+	Account     string
+	Subject     string
+	Destination string
+	SetupTime   time.Time // used on authorization
+	AnswerTime  time.Time // start of the session
+	Usage       float64   // seconds (bytes for data TOR), to be debited on UpdateSession, total session usage on TerminateSession
+}
+
+// Adapts the api data to the Event used by the shared session logic
+type sessionEventV1 AttrSession
+
+func (ev sessionEventV1) New(string) Event {
+	return ev
+}
+func (ev sessionEventV1) GetName() string {
+	return ""
+}
+func (ev sessionEventV1) GetDirection() string {
+	return utils.FirstNonEmpty(ev.Direction, engine.OUTBOUND)
+}
+func (ev sessionEventV1) GetOrigId() string {
+	return ev.SessionId
+}
+func (ev sessionEventV1) GetSubject() string {
+	return utils.FirstNonEmpty(ev.Subject, ev.Account)
+}
+func (ev sessionEventV1) GetAccount() string {
+	return ev.Account
+}
+func (ev sessionEventV1) GetDestination() string {
+	return ev.Destination
+}
+func (ev sessionEventV1) GetCallDestNr() string {
+	return ev.Destination
+}
+func (ev sessionEventV1) GetTOR() string {
+	return utils.FirstNonEmpty(ev.TOR, cfg.DefaultTOR)
+}
+func (ev sessionEventV1) GetUUID() string {
+	return ev.SessionId
+}
+func (ev sessionEventV1) GetTenant() string {
+	return utils.FirstNonEmpty(ev.Tenant, cfg.DefaultTenant)
+}
+func (ev sessionEventV1) GetReqType() string {
+	return utils.FirstNonEmpty(ev.ReqType, cfg.DefaultReqType)
+}
+func (ev sessionEventV1) GetStartTime(string) (time.Time, error) {
+	if ev.AnswerTime.IsZero() {
+		return ev.AnswerTime, errors.New("missing AnswerTime")
+	}
+	return ev.AnswerTime, nil
+}
+func (ev sessionEventV1) GetEndTime() (time.Time, error) {
+	return ev.AnswerTime.Add(engine.UsageDuration(ev.GetTOR(), ev.Usage)), nil
+}
+func (ev sessionEventV1) GetFallbackSubj() string {
+	return cfg.DefaultSubject
+}
+func (ev sessionEventV1) MissingParameter() bool {
+	return strings.TrimSpace(ev.GetAccount()) == "" ||
+		strings.TrimSpace(ev.GetDestination()) == "" ||
+		strings.TrimSpace(ev.GetTOR()) == "" ||
+		strings.TrimSpace(ev.GetTenant()) == ""
+}
+
+// Switch agnostic session management over RPC, the client drives the debits by updating the session
+type SessionManagerV1 struct {
+	sessions    *SessionRegistry
+	attrs       map[string]AttrSession // data the sessions were started with, indexed on session id
+	sync.Mutex                         // one operation on the sessions at a time
+	connector   engine.Connector
+	debitPeriod time.Duration // used when updates come without usage
+	loggerDB    engine.DataStorage
+}
+
+func NewSessionManagerV1(storage engine.DataStorage, connector engine.Connector, debitPeriod time.Duration) *SessionManagerV1 {
+	return &SessionManagerV1{sessions: NewSessionRegistry(), attrs: make(map[string]AttrSession), loggerDB: storage, connector: connector, debitPeriod: debitPeriod}
+}
+
+func (self *SessionManagerV1) callDescriptor(ev sessionEventV1, start time.Time) *engine.CallDescriptor {
+	return &engine.CallDescriptor{
+		Direction:       ev.GetDirection(),
+		Tenant:          ev.GetTenant(),
+		TOR:             ev.GetTOR(),
+		Subject:         ev.GetSubject(),
+		Account:         ev.GetAccount(),
+		Destination:     ev.GetDestination(),
+		TimeStart:       start,
+		FallbackSubject: ev.GetFallbackSubj()}
+}
+
+// Returns the maximum usage the account can afford, up to the requested Usage for data sessions.
+// The balance is shared with the prepaid sessions already running on the account.
+func (self *SessionManagerV1) AuthorizeSession(attrs AttrSession, reply *float64) error {
+	ev := sessionEventV1(attrs)
+	if ev.MissingParameter() {
+		return errors.New(utils.ERR_MANDATORY_IE_MISSING)
+	}
+	setupTime := attrs.SetupTime
+	if setupTime.IsZero() {
+		setupTime = time.Now()
+	}
+	cd := self.callDescriptor(ev, setupTime)
+	cd.Amount = self.debitPeriod.Seconds()
+	if cd.TOR == engine.DATA_TOR {
+		if attrs.Usage <= 0 { // there is no debit interval for volumes
+			return fmt.Errorf("%s:Usage", utils.ERR_MANDATORY_IE_MISSING)
+		}
+		cd.Amount = attrs.Usage
+	}
+	if err := self.connector.GetMaxSessionTime(*cd, reply); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = splitSessionTime(*reply, self.sessions.PrepaidCalls(cd.Tenant, cd.Account, nil))
+	return nil
+}
+
+// Starts a new session, prepaid ones get their first debit of Usage (debit interval if missing for calls).
+// Replies with the id of the session.
+func (self *SessionManagerV1) InitiateSession(attrs AttrSession, reply *string) error {
+	attrs.SessionId = utils.GenUUID()
+	if attrs.AnswerTime.IsZero() {
+		attrs.AnswerTime = time.Now()
+	}
+	ev := sessionEventV1(attrs)
+	if ev.MissingParameter() {
+		return errors.New(utils.ERR_MANDATORY_IE_MISSING)
+	}
+	if ev.GetReqType() != utils.PREPAID && ev.GetReqType() != utils.POSTPAID {
+		return fmt.Errorf("%s:ReqType", utils.ERR_INVALID_IE)
+	}
+	s := &Session{uuid: attrs.SessionId,
+		reqType:        ev.GetReqType(),
+		callDescriptor: self.callDescriptor(ev, attrs.AnswerTime),
+		sessionManager: self,
+		stopDebit:      make(chan bool, 2)}
+	// not registered yet, nobody else can debit it
+	if ev.GetReqType() == utils.PREPAID {
+		if granted, err := self.debit(s, attrs.Usage); err != nil {
+			return err
+		} else if granted == 0 {
+			return errors.New(INSUFFICIENT_FUNDS)
+		}
+	}
+	self.Lock()
+	defer self.Unlock()
+	self.attrs[attrs.SessionId] = attrs
+	self.sessions.Add(s)
+	*reply = attrs.SessionId
+	return nil
+}
+
+// Debits the next Usage seconds (debit interval if missing) or bytes for data sessions.
+// Replies with the usage granted, 0 meaning there is no credit left.
+func (self *SessionManagerV1) UpdateSession(attrs AttrSession, reply *float64) error {
+	self.Lock()
+	s := self.sessions.Get(attrs.SessionId)
+	self.Unlock()
+	if s == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	if s.reqType != utils.PREPAID { // debited at once on terminate
+		*reply = attrs.Usage
+		return nil
+	}
+	s.debitMux.Lock()
+	defer s.debitMux.Unlock()
+	if self.sessionRemoved(s) { // terminated while waiting for the previous debit
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	granted, err := self.debit(s, attrs.Usage)
+	if err != nil {
+		return err
+	}
+	*reply = granted
+	return nil
+}
+
+// Ends the session having lasted Usage seconds: postpaid ones are debited, prepaid ones refunded
+// for what was debited and not used. The costs are logged under the session id.
+func (self *SessionManagerV1) TerminateSession(attrs AttrSession, reply *string) error {
+	self.Lock()
+	s := self.sessions.Get(attrs.SessionId)
+	endAttrs, exists := self.attrs[attrs.SessionId]
+	// Claim the session so concurrent terminates or updates cannot settle it a second time
+	if s == nil || !exists || !self.sessions.Remove(s) {
+		self.Unlock()
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	delete(self.attrs, attrs.SessionId)
+	self.Unlock()
+	s.debitMux.Lock() // let the debit in progress finish before settling
+	defer s.debitMux.Unlock()
+	endAttrs.Usage = attrs.Usage
+	ev := sessionEventV1(endAttrs)
+	s.settle(self.connector, ev)
+	s.Close(ev)
+	*reply = "OK"
+	return nil
+}
+
+func (self *SessionManagerV1) sessionRemoved(s *Session) bool {
+	self.Lock()
+	defer self.Unlock()
+	return self.sessions.Get(s.uuid) != s
+}
+
+// Debits the usage following the last one, returns the usage granted
+func (self *SessionManagerV1) debit(s *Session, usage float64) (float64, error) {
+	if usage <= 0 {
+		if s.callDescriptor.TOR == engine.DATA_TOR {
+			return 0, fmt.Errorf("%s:Usage", utils.ERR_MANDATORY_IE_MISSING)
+		}
+		usage = self.debitPeriod.Seconds()
+	}
+	cd := *s.callDescriptor
+	if ccs := s.callCosts(); len(ccs) != 0 {
+		nbCCs := len(ccs)
+		lastTs := ccs[nbCCs-1].Timespans
+		cd.TimeStart = lastTs[len(lastTs)-1].TimeEnd
+		cd.LoopIndex = float64(nbCCs)
+	}
+	cd.Amount = usage
+	cd.TimeEnd = cd.TimeStart.Add(engine.UsageDuration(cd.TOR, usage))
+	cd.CallDuration = cd.TimeEnd.Sub(s.callDescriptor.TimeStart)
+	cc := &engine.CallCost{}
+	if err := self.connector.MaxDebit(cd, cc); err != nil {
+		return 0, fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if len(cc.Timespans) == 0 {
+		return 0, nil
+	}
+	s.addCallCost(cc)
+	return engine.DurationUsage(cd.TOR, cc.Timespans[len(cc.Timespans)-1].TimeEnd.Sub(cc.Timespans[0].TimeStart)), nil
+}
+
+// Nothing to connect to, the sessions come over the api
+func (self *SessionManagerV1) Connect(cgrCfg *config.CGRConfig) error {
+	cfg = cgrCfg
+	return nil
+}
+
+// The api clients are not reachable, they find out on their next update
+func (self *SessionManagerV1) DisconnectSession(s *Session, notify string) {
+	engine.Logger.Info(fmt.Sprintf("<SessionManagerV1> Cannot disconnect session %s (%s), left to the client", s.uuid, notify))
+}
+
+func (self *SessionManagerV1) WarnSession(s *Session, notify string) {
+	engine.Logger.Info(fmt.Sprintf("<SessionManagerV1> Cannot warn session %s (%s), left to the client", s.uuid, notify))
+}
+
+func (self *SessionManagerV1) RemoveSession(s *Session) {
+	self.Lock()
+	defer self.Unlock()
+	delete(self.attrs, s.uuid)
+	self.sessions.Remove(s)
+}
+
+func (self *SessionManagerV1) Sessions() []*Session {
+	return self.sessions.All()
+}
+
+// Debits are driven by UpdateSession, there is no debit loop
+func (self *SessionManagerV1) LoopAction(s *Session, cd *engine.CallDescriptor, index float64) {
+}
+
+func (self *SessionManagerV1) GetDebitPeriod() time.Duration {
+	return self.debitPeriod
+}
+
+func (self *SessionManagerV1) GetDbLogger() engine.DataStorage {
+	return self.loggerDB
+}
+
+func (self *SessionManagerV1) Shutdown() error {
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"testing"
+	"time"
+)
+
+func TestSessionManagerV1(t *testing.T) {
+	mapStorage, _ := engine.NewMapStorage()
+	storage := &lockedLogger{DataStorage: mapStorage}
+	connector := &fakeConnector{maxSessionTime: 120}
+	smv1 := NewSessionManagerV1(storage, connector, 10*time.Second)
+	smCfg, _ := config.NewCGRConfigBytes([]byte(""))
+	smv1.Connect(smCfg)
+	attrs := AttrSession{ReqType: utils.PREPAID, Tenant: "cgrates.org", TOR: "call", Account: "1001", Destination: "1002",
+		AnswerTime: time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)}
+
+	var maxUsage float64
+	if err := smv1.AuthorizeSession(attrs, &maxUsage); err != nil {
+		t.Error("Unexpected error: ", err)
+	} else if maxUsage != 120 {
+		t.Error("Wrong max usage: ", maxUsage)
+	}
+	if err := smv1.AuthorizeSession(AttrSession{Destination: "1002"}, &maxUsage); err == nil || err.Error() != utils.ERR_MANDATORY_IE_MISSING {
+		t.Error("Expecting missing parameter error, got: ", err)
+	}
+
+	var sessionId string
+	if err := smv1.InitiateSession(attrs, &sessionId); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	} else if sessionId == "" {
+		t.Fatal("No session id received")
+	}
+	if connector.getMaxDebits() != 1 {
+		t.Error("First interval not debited: ", connector.getMaxDebits())
+	}
+	// the balance is shared with the running prepaid session
+	if err := smv1.AuthorizeSession(attrs, &maxUsage); err != nil {
+		t.Error("Unexpected error: ", err)
+	} else if maxUsage != 60 {
+		t.Error("Max usage not split with the running session: ", maxUsage)
+	}
+	var granted float64
+	if err := smv1.UpdateSession(AttrSession{SessionId: sessionId, Usage: 30}, &granted); err != nil {
+		t.Error("Unexpected error: ", err)
+	} else if granted != 30 {
+		t.Error("Wrong usage granted: ", granted)
+	}
+	s := smv1.sessions.Get(sessionId)
+	if len(s.CallCosts) != 2 || !s.CallCosts[1].Timespans[0].TimeStart.Equal(attrs.AnswerTime.Add(10*time.Second)) {
+		t.Error("Update not debiting the next interval: ", s.CallCosts)
+	}
+	if err := smv1.UpdateSession(AttrSession{SessionId: "unknown"}, &granted); err == nil || err.Error() != utils.ERR_NOT_FOUND {
+		t.Error("Expecting not found error, got: ", err)
+	}
+
+	var reply string
+	if err := smv1.TerminateSession(AttrSession{SessionId: sessionId, Usage: 25}, &reply); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+	if smv1.sessions.Get(sessionId) != nil {
+		t.Error("Session not removed on terminate")
+	}
+	if !waitFor(func() bool {
+		cc, err := storage.GetCallCostLog(sessionId, engine.SESSION_MANAGER_SOURCE)
+		return err == nil && cc != nil
+	}) {
+		t.Error("Session costs not logged")
+	}
+	if err := smv1.TerminateSession(AttrSession{SessionId: sessionId}, &reply); err == nil || err.Error() != utils.ERR_NOT_FOUND {
+		t.Error("Expecting not found error, got: ", err)
+	}
+}
+
+func TestSessionManagerV1Postpaid(t *testing.T) {
+	storage, _ := engine.NewMapStorage()
+	connector := &fakeConnector{}
+	smv1 := NewSessionManagerV1(storage, connector, 10*time.Second)
+	smCfg, _ := config.NewCGRConfigBytes([]byte(""))
+	smv1.Connect(smCfg)
+	var sessionId string
+	if err := smv1.InitiateSession(AttrSession{ReqType: utils.POSTPAID, Tenant: "cgrates.org", TOR: "call", Account: "1001", Destination: "1002"}, &sessionId); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var granted float64
+	if err := smv1.UpdateSession(AttrSession{SessionId: sessionId, Usage: 30}, &granted); err != nil || granted != 30 {
+		t.Error("Unexpected update result: ", granted, err)
+	}
+	var reply string
+	if err := smv1.TerminateSession(AttrSession{SessionId: sessionId, Usage: 65}, &reply); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+	if connector.getMaxDebits() != 0 || connector.debits != 1 {
+		t.Errorf("Postpaid session should be debited once on terminate, got %d max debits and %d debits", connector.getMaxDebits(), connector.debits)
+	}
+}
+
+func TestSessionManagerV1ConcurrentTerminate(t *testing.T) {
+	storage, _ := engine.NewMapStorage()
+	connector := &fakeConnector{}
+	smv1 := NewSessionManagerV1(storage, connector, 10*time.Second)
+	smCfg, _ := config.NewCGRConfigBytes([]byte(""))
+	smv1.Connect(smCfg)
+	var sessionId string
+	if err := smv1.InitiateSession(AttrSession{ReqType: utils.POSTPAID, Tenant: "cgrates.org", TOR: "call", Account: "1001", Destination: "1002"}, &sessionId); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			var reply string
+			errs <- smv1.TerminateSession(AttrSession{SessionId: sessionId, Usage: 65}, &reply)
+		}()
+	}
+	terminated := 0
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err == nil {
+			terminated++
+		} else if err.Error() != utils.ERR_NOT_FOUND {
+			t.Error("Unexpected error: ", err)
+		}
+	}
+	connector.Lock()
+	defer connector.Unlock()
+	if terminated != 1 || connector.debits != 1 {
+		t.Errorf("Session should be settled once, terminated %d times with %d debits", terminated, connector.debits)
+	}
+}
+
+func TestSessionManagerV1ConcurrentUpdate(t *testing.T) {
+	storage, _ := engine.NewMapStorage()
+	connector := &fakeConnector{}
+	smv1 := NewSessionManagerV1(storage, connector, 10*time.Second)
+	smCfg, _ := config.NewCGRConfigBytes([]byte(""))
+	smv1.Connect(smCfg)
+	answerTime := time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)
+	var sessionId string
+	if err := smv1.InitiateSession(AttrSession{ReqType: utils.PREPAID, Tenant: "cgrates.org", TOR: "call", Account: "1001", Destination: "1002",
+		AnswerTime: answerTime}, &sessionId); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			var granted float64
+			errs <- smv1.UpdateSession(AttrSession{SessionId: sessionId, Usage: 10}, &granted)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Error("Unexpected error: ", err)
+		}
+	}
+	// each debit continues where the previous one ended
+	for idx, cc := range smv1.sessions.Get(sessionId).callCosts() {
+		if expected := answerTime.Add(time.Duration(idx*10) * time.Second); !cc.Timespans[0].TimeStart.Equal(expected) {
+			t.Errorf("Debit %d starting at %v instead of %v", idx, cc.Timespans[0].TimeStart, expected)
+		}
+	}
+}
+
+func TestSessionManagerV1Data(t *testing.T) {
+	mapStorage, _ := engine.NewMapStorage()
+	connector := &fakeConnector{maxSessionTime: 1048576}
+	smv1 := NewSessionManagerV1(&lockedLogger{DataStorage: mapStorage}, connector, 10*time.Second)
+	smCfg, _ := config.NewCGRConfigBytes([]byte(""))
+	smv1.Connect(smCfg)
+	attrs := AttrSession{ReqType: utils.PREPAID, Tenant: "cgrates.org", TOR: engine.DATA_TOR, Account: "1001", Destination: "internet",
+		AnswerTime: time.Date(2014, 4, 8, 19, 0, 0, 0, time.UTC)}
+	var maxUsage float64
+	if err := smv1.AuthorizeSession(attrs, &maxUsage); err == nil {
+		t.Error("Data authorized without usage")
+	}
+	attrs.Usage = 1048576
+	if err := smv1.AuthorizeSession(attrs, &maxUsage); err != nil || maxUsage != 1048576 {
+		t.Error("Wrong max usage: ", maxUsage, err)
+	}
+	var sessionId string
+	if err := smv1.InitiateSession(attrs, &sessionId); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	var granted float64
+	if err := smv1.UpdateSession(AttrSession{SessionId: sessionId, Usage: 524288}, &granted); err != nil || granted != 524288 {
+		t.Error("Wrong volume granted: ", granted, err)
+	}
+	s := smv1.sessions.Get(sessionId)
+	if ccs := s.callCosts(); len(ccs) != 2 || !ccs[1].Timespans[0].TimeStart.Equal(attrs.AnswerTime.Add(1048576)) {
+		t.Error("Update not debiting the next volume: ", ccs)
+	}
+	if err := smv1.UpdateSession(AttrSession{SessionId: sessionId}, &granted); err == nil {
+		t.Error("Data debited without usage")
+	}
+	if end, _ := sessionEventV1(attrs).GetEndTime(); !end.Equal(attrs.AnswerTime.Add(1048576)) {
+		t.Error("Wrong data session end: ", end)
+	}
+	if ev := s.endEvent(attrs.AnswerTime.Add(1572864)).(sessionEventV1); ev.Usage != 1572864 {
+		t.Error("Wrong usage in end event: ", ev.Usage)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"sync"
+	"time"
+)
+
+// Active session as reported over the api
+type ActiveSession struct {
+	Uuid             string
+	ReqType          string
+	Direction        string
+	Tenant           string
+	Account          string
+	Subject          string
+	Destination      string
+	StartTime        time.Time
+	Cost             float64 // debited so far
+	RemainingSeconds float64 // covered by the debits made so far, -1 for postpaid sessions
+	History          []*SessionHistoryEvent
+}
+
+func newActiveSession(s *Session, now time.Time) *ActiveSession {
+	cd := s.callDescriptor
+	as := &ActiveSession{Uuid: s.uuid, ReqType: s.reqType, Direction: cd.Direction, Tenant: cd.Tenant, Account: cd.Account,
+		Subject: cd.Subject, Destination: cd.Destination, StartTime: cd.TimeStart, RemainingSeconds: -1, History: s.History()}
+	var lastEnd time.Time
+	s.costsMux.RLock()
+	defer s.costsMux.RUnlock()
+	for _, cc := range s.CallCosts {
+		as.Cost += cc.Cost + cc.ConnectFee
+		if len(cc.Timespans) != 0 {
+			lastEnd = cc.Timespans[len(cc.Timespans)-1].TimeEnd
+		}
+	}
+	if s.reqType == utils.PREPAID {
+		as.RemainingSeconds = 0
+		if lastEnd.After(now) {
+			as.RemainingSeconds = lastEnd.Sub(now).Seconds()
+		}
+	}
+	return as
+}
+
+// Api over the sessions of all running session managers
+type SessionsV1 struct {
+	managers []SessionManager
+	sync.RWMutex
+}
+
+func NewSessionsV1() *SessionsV1 {
+	return &SessionsV1{}
+}
+
+// Adds the session manager to the ones queried by the api
+func (self *SessionsV1) AddSessionManager(sm SessionManager) {
+	self.Lock()
+	defer self.Unlock()
+	self.managers = append(self.managers, sm)
+}
+
+// Calls f for each active session, stopping when it returns false
+func (self *SessionsV1) forEachSession(f func(SessionManager, *Session) bool) {
+	self.RLock()
+	defer self.RUnlock()
+	for _, sm := range self.managers {
+		for _, s := range sm.Sessions() {
+			if !f(sm, s) {
+				return
+			}
+		}
+	}
+}
+
+type AttrActiveSessions struct {
+	Tenant  string // optional filter
+	Account string // optional filter
+}
+
+// Lists the active sessions, optionally only the ones of an account
+func (self *SessionsV1) ActiveSessions(attrs AttrActiveSessions, reply *[]*ActiveSession) error {
+	now := time.Now()
+	sessions := make([]*ActiveSession, 0)
+	self.forEachSession(func(sm SessionManager, s *Session) bool {
+		if (attrs.Tenant == "" || s.callDescriptor.Tenant == attrs.Tenant) && (attrs.Account == "" || s.callDescriptor.Account == attrs.Account) {
+			sessions = append(sessions, newActiveSession(s, now))
+		}
+		return true
+	})
+	*reply = sessions
+	return nil
+}
+
+type AttrDisconnectSession struct {
+	Uuid string
+}
+
+// Forces the disconnect of one session
+func (self *SessionsV1) DisconnectSession(attrs AttrDisconnectSession, reply *string) error {
+	if attrs.Uuid == "" {
+		return fmt.Errorf("%s:Uuid", utils.ERR_MANDATORY_IE_MISSING)
+	}
+	var found bool
+	self.forEachSession(func(sm SessionManager, s *Session) bool {
+		if s.uuid != attrs.Uuid {
+			return true
+		}
+		found = true
+		engine.Logger.Info(fmt.Sprintf("<SessionsV1> Disconnecting session %s on request", s.uuid))
+		sm.DisconnectSession(s, MANAGER_REQUEST)
+		return false
+	})
+	if !found {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	*reply = "OK"
+	return nil
+}
+
+type AttrDisconnectAccountSessions struct {
+	Tenant  string
+	Account string
+}
+
+// Forces the disconnect of all the sessions of an account, replying with the number of sessions disconnected
+func (self *SessionsV1) DisconnectAccountSessions(attrs AttrDisconnectAccountSessions, reply *int) error {
+	if missing := utils.MissingStructFields(&attrs, []string{"Tenant", "Account"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	disconnected := 0
+	self.forEachSession(func(sm SessionManager, s *Session) bool {
+		if s.callDescriptor.Tenant == attrs.Tenant && s.callDescriptor.Account == attrs.Account {
+			engine.Logger.Info(fmt.Sprintf("<SessionsV1> Disconnecting session %s on request", s.uuid))
+			sm.DisconnectSession(s, MANAGER_REQUEST)
+			disconnected++
+		}
+		return true
+	})
+	*reply = disconnected
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+	"testing"
+	"time"
+)
+
+// Session manager holding fixed sessions and recording the disconnects
+type fakeSessionManager struct {
+	sessions     []*Session
+	disconnected map[string]string
+	warned       []string
+}
+
+func (fsm *fakeSessionManager) Connect(*config.CGRConfig) error { return nil }
+func (fsm *fakeSessionManager) DisconnectSession(s *Session, notify string) {
+	fsm.disconnected[s.uuid] = notify
+}
+func (fsm *fakeSessionManager) RemoveSession(*Session) {}
+func (fsm *fakeSessionManager) Sessions() []*Session   { return fsm.sessions }
+func (fsm *fakeSessionManager) WarnSession(s *Session, notify string) {
+	fsm.warned = append(fsm.warned, s.uuid)
+}
+func (fsm *fakeSessionManager) LoopAction(*Session, *engine.CallDescriptor, float64) {}
+func (fsm *fakeSessionManager) GetDebitPeriod() time.Duration                        { return 0 }
+func (fsm *fakeSessionManager) GetDbLogger() engine.DataStorage                      { return nil }
+func (fsm *fakeSessionManager) Shutdown() error                                      { return nil }
+
+func TestSessionsV1(t *testing.T) {
+	now := time.Now()
+	fsm := &fakeSessionManager{disconnected: make(map[string]string)}
+	fsm.sessions = []*Session{
+		&Session{uuid: "1", reqType: utils.PREPAID, sessionManager: fsm,
+			callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: "1001", Destination: "1002", TimeStart: now.Add(-time.Minute)},
+			CallCosts: []*engine.CallCost{&engine.CallCost{Cost: 1, ConnectFee: 0.5},
+				&engine.CallCost{Cost: 1, Timespans: []*engine.TimeSpan{&engine.TimeSpan{TimeStart: now.Add(-5 * time.Second), TimeEnd: now.Add(5 * time.Second)}}}}},
+		&Session{uuid: "2", reqType: utils.POSTPAID, sessionManager: fsm,
+			callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: "1001", Destination: "1003", TimeStart: now}},
+		&Session{uuid: "3", reqType: utils.PREPAID, sessionManager: fsm,
+			callDescriptor: &engine.CallDescriptor{Tenant: "cgrates.org", Account: "1002", Destination: "1001", TimeStart: now}},
+	}
+	smApi := NewSessionsV1()
+	smApi.AddSessionManager(fsm)
+	var sessions []*ActiveSession
+	if err := smApi.ActiveSessions(AttrActiveSessions{}, &sessions); err != nil || len(sessions) != 3 {
+		t.Fatal("Unexpected active sessions: ", sessions, err)
+	}
+	if as := sessions[0]; as.Uuid != "1" || as.Account != "1001" || as.Destination != "1002" || !as.StartTime.Equal(now.Add(-time.Minute)) ||
+		as.Cost != 2.5 || as.RemainingSeconds <= 4 || as.RemainingSeconds > 5 {
+		t.Errorf("Unexpected active session: %+v", as)
+	}
+	if sessions[1].RemainingSeconds != -1 || sessions[2].RemainingSeconds != 0 {
+		t.Errorf("Unexpected remaining seconds: %v, %v", sessions[1].RemainingSeconds, sessions[2].RemainingSeconds)
+	}
+	if err := smApi.ActiveSessions(AttrActiveSessions{Tenant: "cgrates.org", Account: "1002"}, &sessions); err != nil || len(sessions) != 1 || sessions[0].Uuid != "3" {
+		t.Error("Unexpected account sessions: ", sessions, err)
+	}
+	var reply string
+	if err := smApi.DisconnectSession(AttrDisconnectSession{Uuid: "3"}, &reply); err != nil || reply != "OK" {
+		t.Error("Could not disconnect session: ", reply, err)
+	} else if fsm.disconnected["3"] != MANAGER_REQUEST {
+		t.Error("Session not disconnected: ", fsm.disconnected)
+	}
+	if err := smApi.DisconnectSession(AttrDisconnectSession{Uuid: "4"}, &reply); err == nil || err.Error() != utils.ERR_NOT_FOUND {
+		t.Error("Expected not found, got: ", err)
+	}
+	var disconnected int
+	if err := smApi.DisconnectAccountSessions(AttrDisconnectAccountSessions{Tenant: "cgrates.org", Account: "1001"}, &disconnected); err != nil || disconnected != 2 {
+		t.Error("Unexpected account disconnect: ", disconnected, err)
+	} else if len(fsm.disconnected) != 3 {
+		t.Error("Account sessions not disconnected: ", fsm.disconnected)
+	}
+	if err := smApi.DisconnectAccountSessions(AttrDisconnectAccountSessions{Tenant: "cgrates.org"}, &disconnected); err == nil {
+		t.Error("Expected missing account error")
+	}
+}
@@ -87,3 +87,120 @@ func (gcdr GenericCdr) GetFallbackSubj() string {
 func (gcdr GenericCdr) GetExtraFields() map[string]string {
 	return nil
 }
+
+// Concrete CDR with all fields resolved, safe to be sent over json or gob rpc
+type StoredCdr struct {
+	CgrId        string
+	AccId        string
+	CdrHost      string
+	Direction    string
+	OrigId       string
+	Subject      string
+	Account      string
+	Destination  string
+	TOR          string
+	Tenant       string
+	ReqType      string
+	AnswerTime   time.Time
+	Duration     int64
+	FallbackSubj string
+	ExtraFields  map[string]string
+}
+
+// Resolves the fields of any CDR implementation into a StoredCdr
+func NewStoredCdr(cdr CDR) (*StoredCdr, error) {
+	aTime, err := cdr.GetAnswerTime()
+	if err != nil {
+		return nil, err
+	}
+	return &StoredCdr{
+		CgrId:        cdr.GetCgrId(),
+		AccId:        cdr.GetAccId(),
+		CdrHost:      cdr.GetCdrHost(),
+		Direction:    cdr.GetDirection(),
+		OrigId:       cdr.GetOrigId(),
+		Subject:      cdr.GetSubject(),
+		Account:      cdr.GetAccount(),
+		Destination:  cdr.GetDestination(),
+		TOR:          cdr.GetTOR(),
+		Tenant:       cdr.GetTenant(),
+		ReqType:      cdr.GetReqType(),
+		AnswerTime:   aTime,
+		Duration:     cdr.GetDuration(),
+		FallbackSubj: cdr.GetFallbackSubj(),
+		ExtraFields:  cdr.GetExtraFields(),
+	}, nil
+}
+
+func (scdr *StoredCdr) GetCgrId() string {
+	return scdr.CgrId
+}
+func (scdr *StoredCdr) GetAccId() string {
+	return scdr.AccId
+}
+func (scdr *StoredCdr) GetCdrHost() string {
+	return scdr.CdrHost
+}
+func (scdr *StoredCdr) GetDirection() string {
+	return scdr.Direction
+}
+func (scdr *StoredCdr) GetOrigId() string {
+	return scdr.OrigId
+}
+func (scdr *StoredCdr) GetSubject() string {
+	return scdr.Subject
+}
+func (scdr *StoredCdr) GetAccount() string {
+	return scdr.Account
+}
+func (scdr *StoredCdr) GetDestination() string {
+	return scdr.Destination
+}
+func (scdr *StoredCdr) GetTOR() string {
+	return scdr.TOR
+}
+func (scdr *StoredCdr) GetTenant() string {
+	return scdr.Tenant
+}
+func (scdr *StoredCdr) GetReqType() string {
+	return scdr.ReqType
+}
+func (scdr *StoredCdr) GetAnswerTime() (time.Time, error) {
+	return scdr.AnswerTime, nil
+}
+func (scdr *StoredCdr) GetDuration() int64 {
+	return scdr.Duration
+}
+func (scdr *StoredCdr) GetFallbackSubj() string {
+	return scdr.FallbackSubj
+}
+func (scdr *StoredCdr) GetExtraFields() map[string]string {
+	return scdr.ExtraFields
+}
+
+// Stored CDR together with the cost calculated by the mediator
+type RatedCdr struct {
+	StoredCdr
+	RunId     string  // Subject of the mediation run the cost belongs to, empty when not mediated yet
+	Cost      float64 // -1 when mediation failed or did not run yet
+	ExtraInfo string  // Mediation error, if any
+}
+
+// Selects stored CDRs, empty values match all
+type CdrsFilter struct {
+	AnswerTimeStart   time.Time // Inclusive
+	AnswerTimeEnd     time.Time // Exclusive
+	Tenant            string
+	Account           string
+	ReqType           string
+	DestinationPrefix string
+	RatedStatus       string   // <""|*rated|*failed>, *failed matches also the CDRs not mediated yet
+	RunId             string   // Mediation run, identified in rated_cdrs by its subject, defaults to the CDR subject
+	MinCost           *float64 // Inclusive
+	MaxCost           *float64 // Exclusive
+	NotExported       bool     // Only the CDRs not exported yet
+	OrderBy           string   // <""|answer_time|duration|cost|account|destination>
+	OrderDesc         bool
+	Limit             int // 0 for no limit
+	Offset            int
+}
@@ -9,6 +9,18 @@ const (
 	LOCALHOST                = "127.0.0.1"
 	FSCDR_FILE_CSV           = "freeswitch_file_csv"
 	FSCDR_HTTP_JSON          = "freeswitch_http_json"
+	CDRC_CSV                 = "csv"
+	CDRC_FWV                 = "fwv"
+	CDRE_CSV                 = "csv"
+	CDRE_FWV                 = "fwv"
+	CDRE_JSONL               = "jsonl"
+	CDR_RATED                = "*rated"
+	CDR_FAILED               = "*failed"
+	CDR_ORDER_ANSWER_TIME    = "answer_time"
+	CDR_ORDER_DURATION       = "duration"
+	CDR_ORDER_COST           = "cost"
+	CDR_ORDER_ACCOUNT        = "account"
+	CDR_ORDER_DESTINATION    = "destination"
 	NOT_IMPLEMENTED          = "not implemented"
 	PREPAID                  = "prepaid"
 	POSTPAID                 = "postpaid"
@@ -19,6 +31,8 @@ const (
 	ERR_NOT_FOUND            = "NOT_FOUND"
 	ERR_MANDATORY_IE_MISSING = "MANDATORY_IE_MISSING"
 	ERR_DUPLICATE            = "DUPLICATE"
+	ERR_INVALID_IE           = "INVALID_IE"
+	ERR_INSUFFICIENT_UNITS   = "INSUFFICIENT_UNITS"
 	TBL_TP_TIMINGS           = "tp_timings"
 	TBL_TP_DESTINATIONS      = "tp_destinations"
 	TBL_TP_RATES             = "tp_rates"
@@ -41,7 +55,7 @@ const (
 	ACTION_TIMINGS_CSV       = "ActionTimings.csv"
 	ACTION_TRIGGERS_CSV      = "ActionTriggers.csv"
 	ACCOUNT_ACTIONS_CSV      = "AccountActions.csv"
-	TIMINGS_NRCOLS           = 6
+	TIMINGS_NRCOLS           = 6 // plus the optional cron expression
 	DESTINATIONS_NRCOLS      = 2
 	RATES_NRCOLS             = 9
 	DESTINATION_RATES_NRCOLS = 3
@@ -121,6 +121,31 @@ func ParseDate(date string) (expDate time.Time, err error) {
 	return expDate, err
 }
 
+// Parses the rate units and increments: durations (eg: 60s) or data volumes (eg: 1MB, 512KB, 1024B)
+// carried as one nanosecond per byte, with 1KB = 1024 bytes. Volumes need their unit so a number
+// missing the time unit is not taken for bytes.
+func ParseRateDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	volume, multiplier := s, int64(0)
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(volume, unit.suffix) {
+			volume, multiplier = strings.TrimSuffix(volume, unit.suffix), unit.bytes
+			break
+		}
+	}
+	bytes, err := strconv.ParseInt(volume, 10, 64)
+	if err != nil || multiplier == 0 {
+		return 0, fmt.Errorf("invalid duration or volume: %s", s)
+	}
+	return time.Duration(bytes * multiplier), nil
+}
+
 func RoundToMinute(seconds float64) float64 {
 	if math.Mod(seconds, 60) == 0 {
 		return seconds
@@ -0,0 +1,234 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+
+var cronWeekDayNames = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+
+// Weekday occurence within the month, nth is -1 for the last one
+type cronNthWeekDay struct {
+	weekDay time.Weekday
+	nth     int
+}
+
+// Parsed cron expression: [second] minute hour day-of-month month day-of-week.
+// Besides the usual *, lists, ranges and steps it supports L on day-of-month for the last day,
+// and on day-of-week the nth (MON#1) and last (5L) weekday of the month.
+// List items can be separated by ; as well so the expression fits in a csv column.
+type CronExpr struct {
+	seconds, minutes, hours, monthDays, months, weekDays uint64 // bit sets of the allowed values
+	lastMonthDay                                         bool
+	nthWeekDays                                          []cronNthWeekDay
+	anyMonthDay, anyWeekDay                              bool // restrictions on both days match on either one
+}
+
+func ParseCronExpr(expr string) (ce *CronExpr, err error) {
+	expr = strings.TrimSpace(expr)
+	if descr, found := cronDescriptors[strings.ToLower(expr)]; found {
+		expr = descr
+	}
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression <%s> should have 5 or 6 fields", expr)
+	}
+	ce = new(CronExpr)
+	if ce.seconds, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if ce.minutes, err = parseCronField(fields[1], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if ce.hours, err = parseCronField(fields[2], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if ce.months, err = parseCronField(fields[4], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	ce.anyMonthDay = fields[3] == "*" || fields[3] == "?"
+	var items []string
+	for _, item := range splitCronList(fields[3]) {
+		if strings.ToUpper(item) == "L" {
+			ce.lastMonthDay = true
+		} else {
+			items = append(items, item)
+		}
+	}
+	if ce.monthDays, err = parseCronField(strings.Join(items, ","), 1, 31, nil); err != nil {
+		return nil, err
+	}
+	ce.anyWeekDay = fields[5] == "*" || fields[5] == "?"
+	items = nil
+	for _, item := range splitCronList(fields[5]) {
+		item = strings.ToUpper(item)
+		if idx := strings.Index(item, "#"); idx != -1 {
+			nth, err := strconv.Atoi(item[idx+1:])
+			if err != nil || nth < 1 || nth > 5 {
+				return nil, fmt.Errorf("invalid weekday occurence in <%s>", item)
+			}
+			wd, err := parseCronValue(item[:idx], 0, 7, cronWeekDayNames)
+			if err != nil {
+				return nil, err
+			}
+			ce.nthWeekDays = append(ce.nthWeekDays, cronNthWeekDay{time.Weekday(wd % 7), nth})
+		} else if len(item) > 1 && strings.HasSuffix(item, "L") {
+			wd, err := parseCronValue(item[:len(item)-1], 0, 7, cronWeekDayNames)
+			if err != nil {
+				return nil, err
+			}
+			ce.nthWeekDays = append(ce.nthWeekDays, cronNthWeekDay{time.Weekday(wd % 7), -1})
+		} else {
+			items = append(items, item)
+		}
+	}
+	if ce.weekDays, err = parseCronField(strings.Join(items, ","), 0, 7, cronWeekDayNames); err != nil {
+		return nil, err
+	}
+	if ce.weekDays&(1<<7) != 0 { // 7 is also Sunday
+		ce.weekDays |= 1
+	}
+	return ce, nil
+}
+
+func splitCronList(field string) []string {
+	return strings.FieldsFunc(field, func(r rune) bool { return r == ',' || r == ';' })
+}
+
+// Returns the bit set of the values allowed by the field, empty field allows none
+func parseCronField(field string, min, max int, names map[string]int) (bits uint64, err error) {
+	for _, item := range splitCronList(field) {
+		step := 1
+		if idx := strings.Index(item, "/"); idx != -1 {
+			if step, err = strconv.Atoi(item[idx+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in <%s>", item)
+			}
+			item = item[:idx]
+		}
+		start, end := min, max
+		if item != "*" && item != "?" {
+			if idx := strings.Index(item, "-"); idx != -1 {
+				if start, err = parseCronValue(item[:idx], min, max, names); err != nil {
+					return 0, err
+				}
+				if end, err = parseCronValue(item[idx+1:], min, max, names); err != nil {
+					return 0, err
+				}
+			} else {
+				if start, err = parseCronValue(item, min, max, names); err != nil {
+					return 0, err
+				}
+				if step == 1 {
+					end = start
+				}
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("invalid range in <%s>", item)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if i, found := names[strings.ToUpper(value)]; found {
+		return i, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cron value <%s>", value)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("cron value %d out of range [%d, %d]", i, min, max)
+	}
+	return i, nil
+}
+
+// Returns the first matching moment strictly after t, zero time if there is none within five years
+func (ce *CronExpr) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())) // next whole second
+	yearLimit := t.Year() + 5
+	for t.Year() <= yearLimit {
+		if ce.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !ce.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if ce.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if ce.minutes&(1<<uint(t.Minute())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+			continue
+		}
+		if ce.seconds&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (ce *CronExpr) matchesDay(t time.Time) bool {
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	monthDay := ce.monthDays&(1<<uint(t.Day())) != 0 || (ce.lastMonthDay && t.Day() == daysInMonth)
+	weekDay := ce.weekDays&(1<<uint(t.Weekday())) != 0
+	for _, nwd := range ce.nthWeekDays {
+		if t.Weekday() != nwd.weekDay {
+			continue
+		}
+		if (nwd.nth == -1 && t.Day()+7 > daysInMonth) || (t.Day()-1)/7+1 == nwd.nth {
+			weekDay = true
+		}
+	}
+	if ce.anyMonthDay || ce.anyWeekDay {
+		return (ce.anyMonthDay || monthDay) && (ce.anyWeekDay || weekDay)
+	}
+	return monthDay || weekDay
+}
@@ -227,3 +227,59 @@ func TestRoundToMinute(t *testing.T) {
 		t.Errorf("Error rounding to minute5: expected %v was %v", expected, result)
 	}
 }
+
+func TestCronExprNext(t *testing.T) {
+	now := time.Date(2013, time.November, 21, 10, 7, 30, 0, time.UTC) // thursday
+	for expr, eNext := range map[string]time.Time{
+		"*/15 * * * *":     time.Date(2013, time.November, 21, 10, 15, 0, 0, time.UTC),
+		"0;30 10 * * *":    time.Date(2013, time.November, 21, 10, 30, 0, 0, time.UTC),
+		"0 0 L * *":        time.Date(2013, time.November, 30, 0, 0, 0, 0, time.UTC),
+		"0 8 * * MON#1":    time.Date(2013, time.December, 2, 8, 0, 0, 0, time.UTC),
+		"0 8 * * 5L":       time.Date(2013, time.November, 29, 8, 0, 0, 0, time.UTC),
+		"0 0 1 JAN *":      time.Date(2014, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"@monthly":         time.Date(2013, time.December, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":       time.Date(2013, time.November, 22, 0, 0, 0, 0, time.UTC), // either day restriction matches
+		"45 7 10 * * *":    time.Date(2013, time.November, 21, 10, 7, 45, 0, time.UTC),
+		"0 0 29 FEB *":     time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":       time.Time{},
+		"0-10/5 10 21 * *": time.Date(2013, time.November, 21, 10, 10, 0, 0, time.UTC),
+	} {
+		ce, err := ParseCronExpr(expr)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %v", expr, err)
+			continue
+		}
+		if next := ce.Next(now); !next.Equal(eNext) {
+			t.Errorf("Expected %v for %s, received: %v", eNext, expr, next)
+		}
+	}
+}
+
+func TestCronExprInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * 13 *", "* * * * MON#6", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCronExpr(expr); err == nil {
+			t.Error("Parsed invalid cron expression: ", expr)
+		}
+	}
+}
+
+func TestParseRateDuration(t *testing.T) {
+	for s, eD := range map[string]time.Duration{
+		"60s":   time.Minute,
+		"0":     0,
+		"1024B": 1024,
+		"512B":  512,
+		"100KB": 102400,
+		"1MB":   1048576,
+		"2GB":   2147483648,
+	} {
+		if d, err := ParseRateDuration(s); err != nil || d != eD {
+			t.Errorf("Expected %v for %s, received: %v (%v)", eD, s, d, err)
+		}
+	}
+	for _, s := range []string{"", "60", "1024", "MB", "1TB", "1.5MB"} {
+		if _, err := ParseRateDuration(s); err == nil {
+			t.Error("Parsed invalid rate duration: ", s)
+		}
+	}
+}